@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 
+	"github.com/tobiassundman/go-demo-app/pkg/database"
 	"github.com/tobiassundman/go-demo-app/pkg/environment"
 	"github.com/tobiassundman/go-demo-app/pkg/logging"
 	"github.com/tobiassundman/go-demo-app/pkg/test"
@@ -15,6 +16,12 @@ var (
 	dbHost     = environment.GetEnvOrDefault("DB_HOST", "localhost")
 	dbPort     = environment.GetEnvOrDefault("DB_PORT", "5432")
 	dbName     = environment.GetEnvOrDefault("DB_NAME", "demo_db")
+	// dbSSLMode is one of disable, require, verify-ca and verify-full, verify-ca and verify-full need dbSSLRootCert
+	dbSSLMode     = environment.GetEnvOrDefault("DB_SSL_MODE", "disable")
+	dbSSLRootCert = environment.GetEnvOrDefault("DB_SSL_ROOT_CERT", "")
+	// dbSSLCert and dbSSLKey are the client certificate and key to authenticate to the database with
+	dbSSLCert = environment.GetEnvOrDefault("DB_SSL_CERT", "")
+	dbSSLKey  = environment.GetEnvOrDefault("DB_SSL_KEY", "")
 )
 
 func main() {
@@ -24,8 +31,18 @@ func main() {
 	}
 	defer logger.Sync()
 
+	tlsConfig := database.TLSConfig{
+		SSLMode:      dbSSLMode,
+		RootCertFile: dbSSLRootCert,
+		CertFile:     dbSSLCert,
+		KeyFile:      dbSSLKey,
+	}
+	if _, err := database.LoadCertificates(tlsConfig); err != nil {
+		logger.Fatal("Invalid database TLS configuration", zap.Error(err))
+	}
+
 	migrationsDir := "db/migrations"
-	err = test.Migrate(dbUser, dbPassword, dbHost, dbPort, dbName, migrationsDir)
+	err = test.Migrate(dbUser, dbPassword, dbHost, dbPort, dbName, migrationsDir, tlsConfig)
 	if err != nil {
 		logger.Fatal("Failed to apply migrations", zap.Error(err))
 	}
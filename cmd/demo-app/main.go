@@ -4,34 +4,108 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	ginzap "github.com/gin-contrib/zap"
 	"github.com/gin-gonic/gin"
-	"github.com/jmoiron/sqlx"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/tobiassundman/go-demo-app/internal/app/controller"
+	"github.com/tobiassundman/go-demo-app/internal/app/outbox"
 	"github.com/tobiassundman/go-demo-app/internal/app/repository"
 	"github.com/tobiassundman/go-demo-app/internal/app/service"
+	"github.com/tobiassundman/go-demo-app/pkg/actor"
+	"github.com/tobiassundman/go-demo-app/pkg/breaker"
 	"github.com/tobiassundman/go-demo-app/pkg/database"
 	"github.com/tobiassundman/go-demo-app/pkg/environment"
 	"github.com/tobiassundman/go-demo-app/pkg/logging"
+	"github.com/tobiassundman/go-demo-app/pkg/retry"
+	"github.com/tobiassundman/go-demo-app/pkg/webhook"
 	ginprometheus "github.com/zsais/go-gin-prometheus"
 	"go.uber.org/zap"
 )
 
 var (
-	serverPort   = environment.GetEnvOrDefault("SERVER_PORT", "8080")
-	dbUser       = environment.GetEnvOrDefault("DB_USER", "demo_user")
-	dbPassword   = environment.GetEnvOrDefault("DB_PASSWORD", "demo_password")
+	serverPort = environment.GetEnvOrDefault("SERVER_PORT", "8080")
+	// dbUser is the role the app connects as, which must be subject to row level security unlike a superuser or the owner of the tables
+	dbUser       = environment.GetEnvOrDefault("DB_USER", "demo_app")
+	dbPassword   = environment.GetEnvOrDefault("DB_PASSWORD", "demo_app_password")
 	dbHost       = environment.GetEnvOrDefault("DB_HOST", "localhost")
 	dbPort       = environment.GetEnvOrDefault("DB_PORT", "5432")
 	dbName       = environment.GetEnvOrDefault("DB_NAME", "demo_db")
 	queryTimeout = environment.GetEnvOrDefault("QUERY_TIMEOUT", "5s")
+	// deletedUserRetention is how long deleted users can be restored before they are purged
+	deletedUserRetention = environment.GetEnvOrDefault("DELETED_USER_RETENTION", "720h")
+	purgeInterval        = environment.GetEnvOrDefault("PURGE_INTERVAL", "1h")
+	// dbReplicaHosts is a comma separated list of host:port of read replicas of the database
+	dbReplicaHosts         = environment.GetEnvOrDefault("DB_REPLICA_HOSTS", "")
+	dbReplicaMaxLag        = environment.GetEnvOrDefault("DB_REPLICA_MAX_LAG", "10s")
+	dbReplicaCheckInterval = environment.GetEnvOrDefault("DB_REPLICA_CHECK_INTERVAL", "5s")
+	// dbMaxConns is the most connections opened to each database, every instance of the app together must stay below max_connections of Postgres
+	dbMaxConns = environment.GetEnvOrDefault("DB_MAX_CONNS", "10")
+	// dbMinConns is how many connections to each database are kept open while idle, idle connections above it are closed after dbMaxConnIdleTime
+	dbMinConns        = environment.GetEnvOrDefault("DB_MIN_CONNS", "0")
+	dbMaxConnLifetime = environment.GetEnvOrDefault("DB_MAX_CONN_LIFETIME", "1h")
+	dbMaxConnIdleTime = environment.GetEnvOrDefault("DB_MAX_CONN_IDLE_TIME", "30m")
+	// dbPoolWaitWarnThreshold is how long acquiring a connection may wait on average before a warning is logged, 0 disables the warning
+	dbPoolWaitWarnThreshold = environment.GetEnvOrDefault("DB_POOL_WAIT_WARN_THRESHOLD", "100ms")
+	dbPoolCheckInterval     = environment.GetEnvOrDefault("DB_POOL_CHECK_INTERVAL", "10s")
+	// dbSSLMode is one of disable, require, verify-ca and verify-full, verify-ca and verify-full need dbSSLRootCert
+	dbSSLMode     = environment.GetEnvOrDefault("DB_SSL_MODE", "disable")
+	dbSSLRootCert = environment.GetEnvOrDefault("DB_SSL_ROOT_CERT", "")
+	// dbSSLCert and dbSSLKey are the client certificate and key to authenticate to the database with
+	dbSSLCert = environment.GetEnvOrDefault("DB_SSL_CERT", "")
+	dbSSLKey  = environment.GetEnvOrDefault("DB_SSL_KEY", "")
+	// dbSSLReloadInterval is how often the certificate files are checked for changes
+	dbSSLReloadInterval = environment.GetEnvOrDefault("DB_SSL_RELOAD_INTERVAL", "1m")
+	// dbMaxRetries is how many times a user operation failing with a transient database error is retried, 0 disables retries
+	dbMaxRetries   = environment.GetEnvOrDefault("DB_MAX_RETRIES", "3")
+	dbRetryTimeout = environment.GetEnvOrDefault("DB_RETRY_TIMEOUT", "5s")
+	// dbRetryBudgetRatio is how many retries per user operation are allowed across all operations, in bursts of at most dbRetryBudgetBurst retries
+	dbRetryBudgetRatio = environment.GetEnvOrDefault("DB_RETRY_BUDGET_RATIO", "0.1")
+	dbRetryBudgetBurst = environment.GetEnvOrDefault("DB_RETRY_BUDGET_BURST", "10")
+	// dbBreakerFailureThreshold is how many consecutive user operations failing because the database is unavailable open the circuit, 0 disables the circuit breaker
+	dbBreakerFailureThreshold = environment.GetEnvOrDefault("DB_BREAKER_FAILURE_THRESHOLD", "5")
+	// dbBreakerOpenTimeout is how long user operations fail fast before dbBreakerHalfOpenCalls trial operations are let through to the database
+	dbBreakerOpenTimeout   = environment.GetEnvOrDefault("DB_BREAKER_OPEN_TIMEOUT", "10s")
+	dbBreakerHalfOpenCalls = environment.GetEnvOrDefault("DB_BREAKER_HALF_OPEN_CALLS", "3")
+	// readYourWritesWindow is how long reads of a client session go to the primary after it changed a user
+	readYourWritesWindow = environment.GetEnvOrDefault("READ_YOUR_WRITES_WINDOW", "5s")
+	// storageBackend is either postgres or memory, memory needs no database but loses every user on restart
+	storageBackend = environment.GetEnvOrDefault("STORAGE_BACKEND", "postgres")
+	// userCacheSize is how many users got by id are cached, 0 disables the cache
+	userCacheSize = environment.GetEnvOrDefault("USER_CACHE_SIZE", "10000")
+	userCacheTTL  = environment.GetEnvOrDefault("USER_CACHE_TTL", "30s")
+	// userCacheServeStale serves cached users past their TTL while they cannot be read from the database
+	userCacheServeStale = environment.GetEnvOrDefault("USER_CACHE_SERVE_STALE", "false")
+	// userCacheLoadTimeout bounds reading a user that is not cached, the read is shared by concurrent requests and outlives the request that started it
+	userCacheLoadTimeout = environment.GetEnvOrDefault("USER_CACHE_LOAD_TIMEOUT", "5s")
+	// outboxPublisher is either log or http, http posts every user event to outboxHTTPURL
+	outboxPublisher      = environment.GetEnvOrDefault("OUTBOX_PUBLISHER", "log")
+	outboxHTTPURL        = environment.GetEnvOrDefault("OUTBOX_HTTP_URL", "")
+	outboxBatchSize      = environment.GetEnvOrDefault("OUTBOX_BATCH_SIZE", "100")
+	outboxRelayInterval  = environment.GetEnvOrDefault("OUTBOX_RELAY_INTERVAL", "1s")
+	outboxPublishTimeout = environment.GetEnvOrDefault("OUTBOX_PUBLISH_TIMEOUT", "30s")
+	// outboxLease is how long a batch of events is claimed while it is published, events of a batch that takes longer may be published twice
+	outboxLease = environment.GetEnvOrDefault("OUTBOX_LEASE", "5m")
+	// outboxBatchTimeout bounds publishing a batch of events including the retries of webhook deliveries, it must be shorter than outboxLease
+	outboxBatchTimeout = environment.GetEnvOrDefault("OUTBOX_BATCH_TIMEOUT", "4m")
+	// webhookRequestTimeout is how long a single delivery attempt to a webhook may take
+	webhookRequestTimeout = environment.GetEnvOrDefault("WEBHOOK_REQUEST_TIMEOUT", "10s")
+	// webhookRetryTimeout is how long failed deliveries to a webhook are retried before they are recorded as failed
+	webhookRetryTimeout = environment.GetEnvOrDefault("WEBHOOK_RETRY_TIMEOUT", "30s")
+	// idempotencyKeyTTL is how long the response to a POST request with an Idempotency-Key header is replayed for retries
+	idempotencyKeyTTL = environment.GetEnvOrDefault("IDEMPOTENCY_KEY_TTL", "24h")
+	// idempotencyLockTimeout is how long a key is locked by a request in flight before a retry can take it over
+	idempotencyLockTimeout = environment.GetEnvOrDefault("IDEMPOTENCY_LOCK_TIMEOUT", "1m")
 )
 
 func main() {
@@ -41,42 +115,543 @@ func main() {
 	}
 	defer logger.Sync()
 
-	logger.Info("Starting demo app", zap.String("port", serverPort), zap.String("dbHost", dbHost), zap.String("dbPort", dbPort))
-	db, err := database.UserDatabaseConnection(dbHost, dbPort, dbUser, dbPassword, dbName)
-	if err != nil {
-		logger.Fatal("Failed to connect to database", zap.Error(err))
-	}
+	logger.Info("Starting demo app", zap.String("port", serverPort), zap.String("storageBackend", storageBackend))
 
 	parsedQueryTimeout, err := time.ParseDuration(queryTimeout)
 	if err != nil {
 		logger.Fatal("Failed to parse query timeout", zap.Error(err))
 	}
 
-	userRepository := repository.NewPostgresUserRepository(db, parsedQueryTimeout)
-	userService := service.NewUserService(userRepository)
+	parsedDeletedUserRetention, err := time.ParseDuration(deletedUserRetention)
+	if err != nil {
+		logger.Fatal("Failed to parse deleted user retention", zap.Error(err))
+	}
+
+	parsedPurgeInterval, err := time.ParseDuration(purgeInterval)
+	if err != nil {
+		logger.Fatal("Failed to parse purge interval", zap.Error(err))
+	}
+
+	backgroundContext, cancelBackground := context.WithCancel(context.Background())
+	backgroundWaitGroup := sync.WaitGroup{}
+
+	storage := createStorage(backgroundContext, &backgroundWaitGroup, parsedQueryTimeout, logger)
+	userService := createUserService(storage, logger)
 	userController := controller.NewUserController(userService, logger)
+	webhookService := createWebhookService(storage.webhookRepository, logger)
+	webhookController := controller.NewWebhookController(webhookService, logger)
+	idempotencyService := createIdempotencyService(storage.idempotencyRepository, logger)
 
 	router := createRouter(logger)
-	userController.ConfigureRoutes(router)
+	// Idempotency keys belong to the tenant of the request, so the middleware runs after the tenant middleware of the routes
+	idempotencyMiddleware := controller.NewIdempotencyMiddleware(idempotencyService, logger)
+	userController.ConfigureRoutes(router, idempotencyMiddleware)
+	webhookController.ConfigureRoutes(router, idempotencyMiddleware)
 
 	p := ginprometheus.NewPrometheus("gin")
 
 	p.Use(router)
 
 	router.GET("/liveness", liveness)
-	router.GET("/readiness", readiness(db))
+	router.GET("/readiness", readiness(storage.ping, storage.circuit))
+
+	backgroundWaitGroup.Add(1)
+	go func() {
+		defer backgroundWaitGroup.Done()
+		// The context carries no tenant, so that the deleted users of every tenant are purged
+		runPurger(actor.NewContext(backgroundContext, "purger"), userService, parsedPurgeInterval, parsedDeletedUserRetention, logger)
+	}()
+
+	backgroundWaitGroup.Add(1)
+	go func() {
+		defer backgroundWaitGroup.Done()
+		runIdempotencyKeyPurger(backgroundContext, idempotencyService, parsedPurgeInterval, logger)
+	}()
+
+	relay := createOutboxRelay(storage.outbox, webhookService, logger)
+	backgroundWaitGroup.Add(1)
+	go func() {
+		defer backgroundWaitGroup.Done()
+		relay.Run(backgroundContext)
+	}()
 
 	runServer(router, logger.Sugar())
+
+	cancelBackground()
+	backgroundWaitGroup.Wait()
+}
+
+// storage is the repositories of a storage backend together with a check of its availability
+type storage struct {
+	userRepository repository.UserRepository
+	txManager      repository.TxManager
+	outbox         repository.OutboxRepository
+	// webhookRepository always uses the primary database, deliveries must see webhooks as soon as they are created
+	webhookRepository     repository.WebhookRepository
+	idempotencyRepository repository.IdempotencyRepository
+	ping                  func() error
+	// circuit is the circuit breaker around the user repository, nil if there is none
+	circuit *breaker.Breaker
+	// readsFromPrimary returns true if the user reads of a context go to the primary to read its own writes, nil if reads never lag behind writes
+	readsFromPrimary func(ctx context.Context) bool
+}
+
+// createStorage creates the repositories of the configured storage backend.
+// Background work of the repositories runs until the context is cancelled and is tracked by the wait group
+func createStorage(ctx context.Context, waitGroup *sync.WaitGroup, queryTimeout time.Duration, logger *zap.Logger) *storage {
+	switch storageBackend {
+	case "postgres":
+		parsedCheckInterval, err := time.ParseDuration(dbReplicaCheckInterval)
+		if err != nil {
+			logger.Fatal("Failed to parse replica check interval", zap.Error(err))
+		}
+		parsedOutboxLease, err := time.ParseDuration(outboxLease)
+		if err != nil {
+			logger.Fatal("Failed to parse outbox lease", zap.Error(err))
+		}
+		poolConfig := createPoolConfig(logger)
+		certificates := loadCertificates(ctx, waitGroup, logger)
+		primary := connectPrimary(poolConfig, certificates, logger)
+		checkRowLevelSecurity(ctx, primary, logger)
+		replicas := connectReplicas(poolConfig, certificates, logger)
+		router := createReplicaRouter(primary, replicas, logger)
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			router.RunHealthChecks(ctx, parsedCheckInterval)
+		}()
+		monitorPools(ctx, waitGroup, primary, replicas, logger)
+		postgresUserRepository := repository.NewReplicatedPostgresUserRepository(router, queryTimeout).WithRetryPolicy(createRetryPolicy(logger))
+		var userRepository repository.UserRepository = postgresUserRepository
+		txManager := repository.NewPostgresTxManager(router.Primary(), queryTimeout)
+		circuit := createCircuitBreaker(logger)
+		if circuit != nil {
+			userRepository = repository.NewCircuitBreakingUserRepository(userRepository, circuit, prometheus.DefaultRegisterer)
+			txManager = txManager.WithCircuitBreaker(circuit)
+		}
+		return &storage{
+			userRepository:        userRepository,
+			txManager:             txManager,
+			outbox:                repository.NewPostgresOutboxRepository(router.Primary(), queryTimeout, parsedOutboxLease),
+			webhookRepository:     repository.NewPostgresWebhookRepository(router.Primary(), queryTimeout),
+			idempotencyRepository: repository.NewPostgresIdempotencyRepository(router.Primary(), queryTimeout),
+			ping:                  func() error { return primary.Ping(context.Background()) },
+			circuit:               circuit,
+			readsFromPrimary:      postgresUserRepository.ReadsFromPrimary,
+		}
+	case "memory":
+		logger.Warn("Using in-memory storage, users are lost on restart")
+		userRepository := repository.NewInMemoryUserRepository()
+		return &storage{
+			userRepository:        userRepository,
+			txManager:             repository.NewInMemoryTxManager(),
+			outbox:                userRepository,
+			webhookRepository:     repository.NewInMemoryWebhookRepository(),
+			idempotencyRepository: repository.NewInMemoryIdempotencyRepository(),
+			ping:                  func() error { return nil },
+		}
+	default:
+		logger.Fatal("Unknown storage backend", zap.String("storageBackend", storageBackend))
+		return nil
+	}
+}
+
+// createRetryPolicy creates the policy retrying user operations that fail with a transient database error, nil if retries are disabled
+func createRetryPolicy(logger *zap.Logger) *repository.RetryPolicy {
+	parsedMaxRetries, err := strconv.Atoi(dbMaxRetries)
+	if err != nil {
+		logger.Fatal("Failed to parse db max retries", zap.Error(err))
+	}
+	if parsedMaxRetries <= 0 {
+		return nil
+	}
+	parsedRetryTimeout, err := time.ParseDuration(dbRetryTimeout)
+	if err != nil {
+		logger.Fatal("Failed to parse db retry timeout", zap.Error(err))
+	}
+	parsedBudgetRatio, err := strconv.ParseFloat(dbRetryBudgetRatio, 64)
+	if err != nil {
+		logger.Fatal("Failed to parse db retry budget ratio", zap.Error(err))
+	}
+	parsedBudgetBurst, err := strconv.Atoi(dbRetryBudgetBurst)
+	if err != nil {
+		logger.Fatal("Failed to parse db retry budget burst", zap.Error(err))
+	}
+
+	logger.Info("Retrying transient database errors", zap.Int("maxRetries", parsedMaxRetries), zap.Duration("timeout", parsedRetryTimeout))
+	return repository.NewRetryPolicy(repository.RetryPolicyConfig{
+		Timeout:    parsedRetryTimeout,
+		MaxRetries: parsedMaxRetries,
+		Budget:     retry.NewBudget(parsedBudgetRatio, parsedBudgetBurst),
+		Logger:     logger,
+		Registerer: prometheus.DefaultRegisterer,
+	})
+}
+
+// createCircuitBreaker creates the circuit breaker failing user operations fast while the database is unavailable, nil if it is disabled
+func createCircuitBreaker(logger *zap.Logger) *breaker.Breaker {
+	parsedFailureThreshold, err := strconv.Atoi(dbBreakerFailureThreshold)
+	if err != nil {
+		logger.Fatal("Failed to parse db breaker failure threshold", zap.Error(err))
+	}
+	if parsedFailureThreshold <= 0 {
+		return nil
+	}
+	parsedOpenTimeout, err := time.ParseDuration(dbBreakerOpenTimeout)
+	if err != nil {
+		logger.Fatal("Failed to parse db breaker open timeout", zap.Error(err))
+	}
+	parsedHalfOpenCalls, err := strconv.Atoi(dbBreakerHalfOpenCalls)
+	if err != nil {
+		logger.Fatal("Failed to parse db breaker half-open calls", zap.Error(err))
+	}
+
+	return breaker.New(breaker.Config{
+		FailureThreshold: parsedFailureThreshold,
+		OpenTimeout:      parsedOpenTimeout,
+		HalfOpenMaxCalls: parsedHalfOpenCalls,
+		OnStateChange: func(from, to breaker.State) {
+			logger.Warn("Database circuit breaker changed state", zap.Stringer("from", from), zap.Stringer("to", to))
+		},
+	})
+}
+
+// createWebhookService creates the webhook service delivering user events to webhooks
+func createWebhookService(webhookRepository repository.WebhookRepository, logger *zap.Logger) service.WebhookService {
+	parsedRequestTimeout, err := time.ParseDuration(webhookRequestTimeout)
+	if err != nil {
+		logger.Fatal("Failed to parse webhook request timeout", zap.Error(err))
+	}
+	parsedRetryTimeout, err := time.ParseDuration(webhookRetryTimeout)
+	if err != nil {
+		logger.Fatal("Failed to parse webhook retry timeout", zap.Error(err))
+	}
+
+	sender := webhook.NewSender(webhook.NewClient(parsedRequestTimeout), parsedRetryTimeout)
+	return service.NewWebhookService(webhookRepository, sender)
+}
+
+// createIdempotencyService creates the service storing the responses to POST requests with an Idempotency-Key header
+func createIdempotencyService(idempotencyRepository repository.IdempotencyRepository, logger *zap.Logger) service.IdempotencyService {
+	parsedTTL, err := time.ParseDuration(idempotencyKeyTTL)
+	if err != nil {
+		logger.Fatal("Failed to parse idempotency key TTL", zap.Error(err))
+	}
+	parsedLockTimeout, err := time.ParseDuration(idempotencyLockTimeout)
+	if err != nil {
+		logger.Fatal("Failed to parse idempotency lock timeout", zap.Error(err))
+	}
+
+	return service.NewIdempotencyService(idempotencyRepository, service.IdempotencyServiceConfig{
+		TTL:         parsedTTL,
+		LockTimeout: parsedLockTimeout,
+	})
+}
+
+// createOutboxRelay creates the relay publishing the user events of the outbox with the configured publisher and to the webhooks
+func createOutboxRelay(outboxRepository repository.OutboxRepository, webhookService service.WebhookService, logger *zap.Logger) *outbox.Relay {
+	parsedBatchSize, err := strconv.Atoi(outboxBatchSize)
+	if err != nil {
+		logger.Fatal("Failed to parse outbox batch size", zap.Error(err))
+	}
+	parsedRelayInterval, err := time.ParseDuration(outboxRelayInterval)
+	if err != nil {
+		logger.Fatal("Failed to parse outbox relay interval", zap.Error(err))
+	}
+	parsedBatchTimeout, err := time.ParseDuration(outboxBatchTimeout)
+	if err != nil {
+		logger.Fatal("Failed to parse outbox batch timeout", zap.Error(err))
+	}
+	parsedLease, err := time.ParseDuration(outboxLease)
+	if err != nil {
+		logger.Fatal("Failed to parse outbox lease", zap.Error(err))
+	}
+	if parsedBatchTimeout <= 0 || parsedBatchTimeout >= parsedLease {
+		logger.Fatal("OUTBOX_BATCH_TIMEOUT must be positive and shorter than OUTBOX_LEASE",
+			zap.Duration("outboxBatchTimeout", parsedBatchTimeout), zap.Duration("outboxLease", parsedLease))
+	}
+
+	var publisher outbox.Publisher
+	switch outboxPublisher {
+	case "log":
+		publisher = outbox.NewLoggingPublisher(logger)
+	case "http":
+		parsedPublishTimeout, err := time.ParseDuration(outboxPublishTimeout)
+		if err != nil {
+			logger.Fatal("Failed to parse outbox publish timeout", zap.Error(err))
+		}
+		if outboxHTTPURL == "" {
+			logger.Fatal("OUTBOX_HTTP_URL must be set to publish user events over http")
+		}
+		publisher = outbox.NewHTTPPublisher(outbox.HTTPPublisherConfig{
+			URL:          outboxHTTPURL,
+			RetryTimeout: parsedPublishTimeout,
+		})
+	default:
+		logger.Fatal("Unknown outbox publisher", zap.String("outboxPublisher", outboxPublisher))
+	}
+
+	logger.Info("Publishing user events", zap.String("outboxPublisher", outboxPublisher))
+	publisher = outbox.MultiPublisher{publisher, outbox.NewWebhookPublisher(webhookService)}
+	return outbox.NewRelay(outboxRepository, publisher, outbox.RelayConfig{
+		BatchSize:    parsedBatchSize,
+		Interval:     parsedRelayInterval,
+		BatchTimeout: parsedBatchTimeout,
+		Logger:       logger,
+	})
+}
+
+// createUserService creates the user service of the storage, caching users got by id unless the cache is disabled
+func createUserService(storage *storage, logger *zap.Logger) service.UserService {
+	userService := service.NewUserService(storage.userRepository, storage.txManager)
+
+	parsedCacheSize, err := strconv.Atoi(userCacheSize)
+	if err != nil {
+		logger.Fatal("Failed to parse user cache size", zap.Error(err))
+	}
+	if parsedCacheSize <= 0 {
+		return userService
+	}
+	parsedCacheTTL, err := time.ParseDuration(userCacheTTL)
+	if err != nil {
+		logger.Fatal("Failed to parse user cache TTL", zap.Error(err))
+	}
+	parsedServeStale, err := strconv.ParseBool(userCacheServeStale)
+	if err != nil {
+		logger.Fatal("Failed to parse user cache serve stale", zap.Error(err))
+	}
+	parsedLoadTimeout, err := time.ParseDuration(userCacheLoadTimeout)
+	if err != nil {
+		logger.Fatal("Failed to parse user cache load timeout", zap.Error(err))
+	}
+
+	logger.Info("Caching users", zap.Int("size", parsedCacheSize), zap.Duration("ttl", parsedCacheTTL), zap.Bool("serveStale", parsedServeStale))
+	return service.NewCachingUserService(userService, service.CachingUserServiceConfig{
+		Size:             parsedCacheSize,
+		TTL:              parsedCacheTTL,
+		ServeStale:       parsedServeStale,
+		LoadTimeout:      parsedLoadTimeout,
+		ReadsFromPrimary: storage.readsFromPrimary,
+		Registerer:       prometheus.DefaultRegisterer,
+	})
+}
+
+// createPoolConfig creates the configuration of the connection pool of every database
+func createPoolConfig(logger *zap.Logger) database.PoolConfig {
+	parsedMaxConns, err := strconv.ParseInt(dbMaxConns, 10, 32)
+	if err != nil {
+		logger.Fatal("Failed to parse db max conns", zap.Error(err))
+	}
+	parsedMinConns, err := strconv.ParseInt(dbMinConns, 10, 32)
+	if err != nil {
+		logger.Fatal("Failed to parse db min conns", zap.Error(err))
+	}
+	parsedMaxConnLifetime, err := time.ParseDuration(dbMaxConnLifetime)
+	if err != nil {
+		logger.Fatal("Failed to parse db max conn lifetime", zap.Error(err))
+	}
+	parsedMaxConnIdleTime, err := time.ParseDuration(dbMaxConnIdleTime)
+	if err != nil {
+		logger.Fatal("Failed to parse db max conn idle time", zap.Error(err))
+	}
+
+	poolConfig := database.PoolConfig{
+		MaxConns:        int32(parsedMaxConns),
+		MinConns:        int32(parsedMinConns),
+		MaxConnLifetime: parsedMaxConnLifetime,
+		MaxConnIdleTime: parsedMaxConnIdleTime,
+	}
+	if err := poolConfig.Validate(); err != nil {
+		logger.Fatal("Invalid database pool configuration", zap.Error(err))
+	}
+	return poolConfig
+}
+
+// loadCertificates loads the certificates of the connections to every database and reloads them when their files change until the context is cancelled
+func loadCertificates(ctx context.Context, waitGroup *sync.WaitGroup, logger *zap.Logger) *database.Certificates {
+	tlsConfig := database.TLSConfig{
+		SSLMode:      dbSSLMode,
+		RootCertFile: dbSSLRootCert,
+		CertFile:     dbSSLCert,
+		KeyFile:      dbSSLKey,
+	}
+	certificates, err := database.LoadCertificates(tlsConfig)
+	if err != nil {
+		logger.Fatal("Invalid database TLS configuration", zap.Error(err))
+	}
+	if tlsConfig.SSLMode == database.SSLModeDisable {
+		logger.Warn("Connecting to the database without TLS")
+		return certificates
+	}
+
+	parsedReloadInterval, err := time.ParseDuration(dbSSLReloadInterval)
+	if err != nil {
+		logger.Fatal("Failed to parse db ssl reload interval", zap.Error(err))
+	}
+	waitGroup.Add(1)
+	go func() {
+		defer waitGroup.Done()
+		certificates.RunReloads(ctx, parsedReloadInterval, logger)
+	}()
+	return certificates
+}
+
+// connectPrimary connects to the primary database
+func connectPrimary(poolConfig database.PoolConfig, certificates *database.Certificates, logger *zap.Logger) *pgxpool.Pool {
+	logger.Info("Connecting to database", zap.String("dbHost", dbHost), zap.String("dbPort", dbPort), zap.String("sslMode", dbSSLMode),
+		zap.Int32("maxConns", poolConfig.MaxConns), zap.Int32("minConns", poolConfig.MinConns))
+	primary, err := database.UserDatabaseConnection(dbHost, dbPort, dbUser, dbPassword, dbName, poolConfig, certificates)
+	if err != nil {
+		logger.Fatal("Failed to connect to database", zap.Error(err))
+	}
+	return primary
+}
+
+// bypassesRowLevelSecurityQuery returns whether the connected role is not subject to the row level security policies of the config tables
+const bypassesRowLevelSecurityQuery = `SELECT rolsuper OR rolbypassrls OR EXISTS (SELECT 1 FROM pg_tables WHERE schemaname = 'config' AND tableowner = current_user)
+FROM pg_roles WHERE rolname = current_user`
+
+// checkRowLevelSecurity warns if the app connects as a role that sees the rows of every tenant
+func checkRowLevelSecurity(ctx context.Context, primary *pgxpool.Pool, logger *zap.Logger) {
+	var bypasses bool
+	if err := primary.QueryRow(ctx, bypassesRowLevelSecurityQuery).Scan(&bypasses); err != nil {
+		logger.Warn("Failed to check row level security of database user", zap.String("dbUser", dbUser), zap.Error(err))
+		return
+	}
+	if bypasses {
+		logger.Warn("Database user is not subject to row level security, tenants are only isolated by the queries of the app", zap.String("dbUser", dbUser))
+	}
+}
+
+// connectReplicas connects to the read replicas of the primary database
+func connectReplicas(poolConfig database.PoolConfig, certificates *database.Certificates, logger *zap.Logger) []*pgxpool.Pool {
+	replicas := []*pgxpool.Pool{}
+	for _, replicaHost := range strings.Split(dbReplicaHosts, ",") {
+		if replicaHost == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(replicaHost)
+		if err != nil {
+			logger.Fatal("Failed to parse replica host", zap.String("replicaHost", replicaHost), zap.Error(err))
+		}
+		logger.Info("Connecting to database replica", zap.String("dbHost", host), zap.String("dbPort", port))
+		replica, err := database.UserDatabaseConnection(host, port, dbUser, dbPassword, dbName, poolConfig, certificates)
+		if err != nil {
+			logger.Fatal("Failed to connect to database replica", zap.Error(err))
+		}
+		replicas = append(replicas, replica)
+	}
+	return replicas
+}
+
+// createReplicaRouter routes reads between the primary database and its replicas
+func createReplicaRouter(primary *pgxpool.Pool, replicas []*pgxpool.Pool, logger *zap.Logger) *database.ReplicaRouter {
+	parsedMaxLag, err := time.ParseDuration(dbReplicaMaxLag)
+	if err != nil {
+		logger.Fatal("Failed to parse replica max lag", zap.Error(err))
+	}
+	parsedReadYourWritesWindow, err := time.ParseDuration(readYourWritesWindow)
+	if err != nil {
+		logger.Fatal("Failed to parse read your writes window", zap.Error(err))
+	}
+
+	replicaPools := make([]database.Pool, len(replicas))
+	for i, replica := range replicas {
+		replicaPools[i] = replica
+	}
+	return database.NewReplicaRouter(primary, replicaPools, database.ReplicaRouterConfig{
+		MaxLag:               parsedMaxLag,
+		ReadYourWritesWindow: parsedReadYourWritesWindow,
+		Logger:               logger,
+	})
+}
+
+// monitorPools publishes the statistics of the connection pools and warns about long waits for connections until the context is cancelled
+func monitorPools(ctx context.Context, waitGroup *sync.WaitGroup, primary *pgxpool.Pool, replicas []*pgxpool.Pool, logger *zap.Logger) {
+	pools := map[string]*pgxpool.Pool{"primary": primary}
+	for i, replica := range replicas {
+		pools[fmt.Sprintf("replica%d", i)] = replica
+	}
+	prometheus.DefaultRegisterer.MustRegister(database.NewPoolStatsCollector(pools))
+
+	parsedWaitWarnThreshold, err := time.ParseDuration(dbPoolWaitWarnThreshold)
+	if err != nil {
+		logger.Fatal("Failed to parse db pool wait warn threshold", zap.Error(err))
+	}
+	if parsedWaitWarnThreshold <= 0 {
+		return
+	}
+	parsedCheckInterval, err := time.ParseDuration(dbPoolCheckInterval)
+	if err != nil {
+		logger.Fatal("Failed to parse db pool check interval", zap.Error(err))
+	}
+	monitor := database.NewPoolWaitMonitor(pools, parsedWaitWarnThreshold, logger)
+	waitGroup.Add(1)
+	go func() {
+		defer waitGroup.Done()
+		monitor.Run(ctx, parsedCheckInterval)
+	}()
+}
+
+// runPurger periodically purges deleted users older than the retention until the context is cancelled
+func runPurger(ctx context.Context, userService service.UserService, interval, retention time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := userService.PurgeDeleted(ctx, retention)
+		if err != nil {
+			logger.Error("Failed to purge deleted users", zap.Error(err))
+		} else if purged > 0 {
+			logger.Info("Purged deleted users", zap.Int64("count", purged))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runIdempotencyKeyPurger periodically purges expired idempotency keys until the context is cancelled
+func runIdempotencyKeyPurger(ctx context.Context, idempotencyService service.IdempotencyService, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := idempotencyService.PurgeExpired(ctx)
+		if err != nil {
+			logger.Error("Failed to purge expired idempotency keys", zap.Error(err))
+		} else if purged > 0 {
+			logger.Info("Purged expired idempotency keys", zap.Int64("count", purged))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 // createRouter creates a new gin router with middleware
 func createRouter(logger *zap.Logger) *gin.Engine {
 	router := gin.New()
 	router.Use(ginzap.Ginzap(logger, time.RFC3339, true))
-	router.Use(ginzap.RecoveryWithZap(logger, true))
+	router.Use(ginzap.CustomRecoveryWithZap(logger, true, handleRecovery))
 	return router
 }
 
+// handleRecovery answers a request whose handler panicked with 500, unless the handler panicked with http.ErrAbortHandler
+// to abort a response it has already started, which is passed on to the server so that it aborts the connection
+func handleRecovery(ctx *gin.Context, err any) {
+	if err == http.ErrAbortHandler {
+		panic(err)
+	}
+	ctx.AbortWithStatus(http.StatusInternalServerError)
+}
+
 // runServer starts the http server and handles graceful shutdown
 func runServer(router *gin.Engine, logger *zap.SugaredLogger) {
 	server := &http.Server{
@@ -112,7 +687,7 @@ func runServer(router *gin.Engine, logger *zap.SugaredLogger) {
 		defer shutdownWaitGroup.Done()
 
 		logger.Info("Starting http server")
-		if err := server.ListenAndServe(); err != nil {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			logger.Fatal("Failed to start http server", err)
 		}
 	}()
@@ -125,13 +700,18 @@ func liveness(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
-// readiness checks if the application is ready to accept requests
-func readiness(db *sqlx.DB) func(c *gin.Context) {
+// readiness checks if the application is ready to accept requests and reports the state of the circuit breaker around the database.
+// The application is not ready while the circuit is open, without pinging the database that is failing
+func readiness(ping func() error, circuit *breaker.Breaker) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		if db.Ping() != nil {
-			c.Status(http.StatusServiceUnavailable)
-			return
+		state := breaker.StateClosed
+		if circuit != nil {
+			state = circuit.State()
+		}
+		status := http.StatusOK
+		if state == breaker.StateOpen || ping() != nil {
+			status = http.StatusServiceUnavailable
 		}
-		c.Status(http.StatusOK)
+		c.JSON(status, gin.H{"circuit_breaker": state.String()})
 	}
 }
@@ -0,0 +1,4 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS users_name_trgm_idx ON config.users USING GIN (name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS users_email_trgm_idx ON config.users USING GIN (email gin_trgm_ops);
@@ -0,0 +1,7 @@
+DELETE FROM config.users WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS config.users_deleted_at_idx;
+DROP INDEX IF EXISTS config.config_email_unique;
+ALTER TABLE config.users ADD CONSTRAINT config_email_unique UNIQUE (email);
+
+ALTER TABLE config.users DROP COLUMN IF EXISTS deleted_at;
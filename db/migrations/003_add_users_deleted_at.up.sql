@@ -0,0 +1,7 @@
+ALTER TABLE config.users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+-- Emails only have to be unique among users that are not deleted, so that a deleted user's email can be reused
+ALTER TABLE config.users DROP CONSTRAINT IF EXISTS config_email_unique;
+CREATE UNIQUE INDEX IF NOT EXISTS config_email_unique ON config.users (email) WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON config.users (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE config.users DROP COLUMN IF EXISTS version;
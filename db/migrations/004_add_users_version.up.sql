@@ -0,0 +1 @@
+ALTER TABLE config.users ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,12 @@
+-- There is intentionally no foreign key to config.users so that the history survives the user being purged
+CREATE TABLE IF NOT EXISTS config.user_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    operation VARCHAR(16) NOT NULL,
+    changed_by VARCHAR(255) NOT NULL,
+    changed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    before JSONB,
+    after JSONB
+);
+
+CREATE INDEX IF NOT EXISTS user_history_user_id_idx ON config.user_history (user_id, id);
@@ -0,0 +1,8 @@
+-- Events are written in the same transaction as the change of the user and removed once they have been published
+CREATE TABLE IF NOT EXISTS config.outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(32) NOT NULL,
+    user_id INTEGER NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS config.webhook_deliveries;
+DROP TABLE IF EXISTS config.webhooks;
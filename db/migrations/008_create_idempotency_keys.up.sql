@@ -0,0 +1,13 @@
+-- A key is locked by the request that first uses it until its response is stored, the response is then replayed for retries of the request.
+-- status_code is NULL while the request is in flight, and a key can be used again once it has expired
+CREATE TABLE IF NOT EXISTS config.idempotency_keys (
+    key VARCHAR(255) PRIMARY KEY,
+    fingerprint CHAR(64) NOT NULL,
+    status_code INTEGER,
+    content_type VARCHAR(255),
+    body BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON config.idempotency_keys (expires_at);
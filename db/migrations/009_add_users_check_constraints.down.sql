@@ -0,0 +1,2 @@
+ALTER TABLE config.users DROP CONSTRAINT IF EXISTS users_name_not_blank;
+ALTER TABLE config.users DROP CONSTRAINT IF EXISTS users_age_not_negative;
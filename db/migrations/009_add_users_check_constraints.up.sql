@@ -0,0 +1,4 @@
+-- The service validates users before they are written, the constraints keep users written by other clients valid too.
+-- NOT VALID leaves existing users unchecked
+ALTER TABLE config.users ADD CONSTRAINT users_age_not_negative CHECK (age >= 0) NOT VALID;
+ALTER TABLE config.users ADD CONSTRAINT users_name_not_blank CHECK (btrim(name) <> '') NOT VALID;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS config.users_updated_at_idx;
+ALTER TABLE config.users DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE config.users ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'UTC';
@@ -0,0 +1,10 @@
+-- created_at was stored without a time zone in the time zone of the server, which is UTC
+ALTER TABLE config.users ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'UTC';
+
+-- updated_at is set by every change of a user, existing users were last changed when they were created as far as is known
+ALTER TABLE config.users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+UPDATE config.users SET updated_at = created_at WHERE updated_at IS NULL;
+ALTER TABLE config.users ALTER COLUMN updated_at SET DEFAULT NOW();
+ALTER TABLE config.users ALTER COLUMN updated_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS users_updated_at_idx ON config.users (updated_at);
@@ -0,0 +1,16 @@
+DROP POLICY IF EXISTS user_history_tenant_isolation ON config.user_history;
+ALTER TABLE config.user_history NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE config.user_history DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS users_tenant_isolation ON config.users;
+ALTER TABLE config.users NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE config.users DISABLE ROW LEVEL SECURITY;
+
+DROP INDEX IF EXISTS config.users_tenant_id_idx;
+
+-- Fails if an email is used by more than one tenant
+DROP INDEX IF EXISTS config.config_email_unique;
+CREATE UNIQUE INDEX IF NOT EXISTS config_email_unique ON config.users (email) WHERE deleted_at IS NULL;
+
+ALTER TABLE config.user_history DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE config.users DROP COLUMN IF EXISTS tenant_id;
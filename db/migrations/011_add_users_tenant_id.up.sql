@@ -0,0 +1,27 @@
+-- Users and their history created before tenants existed belong to the default tenant
+ALTER TABLE config.users ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64);
+UPDATE config.users SET tenant_id = 'default' WHERE tenant_id IS NULL;
+ALTER TABLE config.users ALTER COLUMN tenant_id SET NOT NULL;
+
+ALTER TABLE config.user_history ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64);
+UPDATE config.user_history SET tenant_id = 'default' WHERE tenant_id IS NULL;
+ALTER TABLE config.user_history ALTER COLUMN tenant_id SET NOT NULL;
+
+-- Emails only have to be unique within a tenant
+DROP INDEX IF EXISTS config.config_email_unique;
+CREATE UNIQUE INDEX IF NOT EXISTS config_email_unique ON config.users (tenant_id, email) WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS users_tenant_id_idx ON config.users (tenant_id, id);
+
+-- Every query of the repository is scoped by tenant, the policies are a second line of defense that hide the rows of other tenants.
+-- The repository sets app.tenant_id for each transaction, app.all_tenants is only set by the purge of deleted users of every tenant.
+-- FORCE applies the policies to the owner of the tables too, only superusers and roles with BYPASSRLS are not subject to them
+ALTER TABLE config.users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE config.users FORCE ROW LEVEL SECURITY;
+CREATE POLICY users_tenant_isolation ON config.users
+    USING (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.all_tenants', true) = 'on');
+
+ALTER TABLE config.user_history ENABLE ROW LEVEL SECURITY;
+ALTER TABLE config.user_history FORCE ROW LEVEL SECURITY;
+CREATE POLICY user_history_tenant_isolation ON config.user_history
+    USING (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.all_tenants', true) = 'on');
@@ -0,0 +1 @@
+ALTER TABLE config.outbox DROP COLUMN IF EXISTS locked_until;
@@ -0,0 +1,3 @@
+-- A relay claims events until locked_until and publishes them outside of any transaction,
+-- events claimed by a relay that stopped before publishing them are claimed again once the lease has run out
+ALTER TABLE config.outbox ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ;
@@ -0,0 +1,18 @@
+DROP FUNCTION IF EXISTS config.purge_deleted_users(DOUBLE PRECISION, VARCHAR, VARCHAR);
+
+ALTER TABLE config.user_history FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS user_history_tenant_isolation ON config.user_history;
+CREATE POLICY user_history_tenant_isolation ON config.user_history
+    USING (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.all_tenants', true) = 'on');
+
+ALTER TABLE config.users FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS users_tenant_isolation ON config.users;
+CREATE POLICY users_tenant_isolation ON config.users
+    USING (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.all_tenants', true) = 'on');
+
+-- The role is kept, it may have members and privileges outside of the config schema
+ALTER DEFAULT PRIVILEGES IN SCHEMA config REVOKE USAGE ON SEQUENCES FROM demo_app;
+ALTER DEFAULT PRIVILEGES IN SCHEMA config REVOKE SELECT, INSERT, UPDATE, DELETE ON TABLES FROM demo_app;
+REVOKE USAGE ON ALL SEQUENCES IN SCHEMA config FROM demo_app;
+REVOKE SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA config FROM demo_app;
+REVOKE USAGE ON SCHEMA config FROM demo_app;
@@ -0,0 +1,39 @@
+-- The app connects as demo_app or a member of it, which is neither a superuser nor the owner of the tables, so that row level security applies to it.
+-- Any role can set app.* settings, so the policies only admit the tenant the transaction is scoped to.
+-- Maintenance of every tenant runs in SECURITY DEFINER functions of the owner of the tables, which is not subject to the policies
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'demo_app') THEN
+        CREATE ROLE demo_app NOLOGIN NOSUPERUSER NOBYPASSRLS;
+    END IF;
+END
+$$;
+
+GRANT USAGE ON SCHEMA config TO demo_app;
+GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA config TO demo_app;
+GRANT USAGE ON ALL SEQUENCES IN SCHEMA config TO demo_app;
+ALTER DEFAULT PRIVILEGES IN SCHEMA config GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO demo_app;
+ALTER DEFAULT PRIVILEGES IN SCHEMA config GRANT USAGE ON SEQUENCES TO demo_app;
+
+DROP POLICY IF EXISTS users_tenant_isolation ON config.users;
+CREATE POLICY users_tenant_isolation ON config.users
+    USING (tenant_id = current_setting('app.tenant_id', true));
+ALTER TABLE config.users NO FORCE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS user_history_tenant_isolation ON config.user_history;
+CREATE POLICY user_history_tenant_isolation ON config.user_history
+    USING (tenant_id = current_setting('app.tenant_id', true));
+ALTER TABLE config.user_history NO FORCE ROW LEVEL SECURITY;
+
+-- purge_deleted_users purges the users of every tenant deleted longer than $1 seconds ago, recording each purge in the user history
+-- as operation $2 by $3, and returns how many users were purged
+CREATE OR REPLACE FUNCTION config.purge_deleted_users(DOUBLE PRECISION, VARCHAR, VARCHAR) RETURNS BIGINT
+    LANGUAGE sql SECURITY DEFINER SET search_path = pg_catalog, pg_temp
+AS $$
+    WITH purged AS (DELETE FROM config.users WHERE deleted_at < NOW() - make_interval(secs => $1) RETURNING tenant_id, id, name, email, age, version),
+    history AS (INSERT INTO config.user_history (tenant_id, user_id, operation, changed_by, before)
+        SELECT tenant_id, id, $2, $3, jsonb_build_object('id', id, 'name', name, 'email', email, 'age', age, 'version', version) FROM purged)
+    SELECT count(*) FROM purged
+$$;
+REVOKE ALL ON FUNCTION config.purge_deleted_users(DOUBLE PRECISION, VARCHAR, VARCHAR) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION config.purge_deleted_users(DOUBLE PRECISION, VARCHAR, VARCHAR) TO demo_app;
@@ -0,0 +1,9 @@
+DROP FUNCTION IF EXISTS config.delete_expired_idempotency_keys();
+
+DROP POLICY IF EXISTS idempotency_keys_tenant_isolation ON config.idempotency_keys;
+ALTER TABLE config.idempotency_keys DISABLE ROW LEVEL SECURITY;
+
+-- Fails if a key is used by more than one tenant
+ALTER TABLE config.idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE config.idempotency_keys ADD PRIMARY KEY (key);
+ALTER TABLE config.idempotency_keys DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,21 @@
+-- Keys only have to be unique within a tenant, so that a tenant never gets the stored response of another tenant.
+-- Keys used before tenants belong to the default tenant
+ALTER TABLE config.idempotency_keys ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64);
+UPDATE config.idempotency_keys SET tenant_id = 'default' WHERE tenant_id IS NULL;
+ALTER TABLE config.idempotency_keys ALTER COLUMN tenant_id SET NOT NULL;
+ALTER TABLE config.idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE config.idempotency_keys ADD PRIMARY KEY (tenant_id, key);
+
+ALTER TABLE config.idempotency_keys ENABLE ROW LEVEL SECURITY;
+CREATE POLICY idempotency_keys_tenant_isolation ON config.idempotency_keys
+    USING (tenant_id = current_setting('app.tenant_id', true));
+
+-- delete_expired_idempotency_keys deletes the expired keys of every tenant and returns how many were deleted
+CREATE OR REPLACE FUNCTION config.delete_expired_idempotency_keys() RETURNS BIGINT
+    LANGUAGE sql SECURITY DEFINER SET search_path = pg_catalog, pg_temp
+AS $$
+    WITH deleted AS (DELETE FROM config.idempotency_keys WHERE expires_at <= NOW() RETURNING 1)
+    SELECT count(*) FROM deleted
+$$;
+REVOKE ALL ON FUNCTION config.delete_expired_idempotency_keys() FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION config.delete_expired_idempotency_keys() TO demo_app;
@@ -0,0 +1,20 @@
+DROP FUNCTION IF EXISTS config.release_outbox_events(BIGINT[]);
+DROP FUNCTION IF EXISTS config.delete_outbox_events(BIGINT[]);
+DROP FUNCTION IF EXISTS config.claim_outbox_events(INTEGER, DOUBLE PRECISION);
+
+DROP POLICY IF EXISTS webhook_deliveries_tenant_isolation ON config.webhook_deliveries;
+ALTER TABLE config.webhook_deliveries DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS webhooks_tenant_isolation ON config.webhooks;
+ALTER TABLE config.webhooks DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS outbox_tenant_isolation ON config.outbox;
+ALTER TABLE config.outbox DISABLE ROW LEVEL SECURITY;
+
+ALTER TABLE config.webhook_deliveries DROP CONSTRAINT IF EXISTS webhook_deliveries_webhook_fkey;
+ALTER TABLE config.webhook_deliveries ADD CONSTRAINT webhook_deliveries_webhook_id_fkey
+    FOREIGN KEY (webhook_id) REFERENCES config.webhooks (id) ON DELETE CASCADE;
+ALTER TABLE config.webhook_deliveries DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE config.webhooks DROP CONSTRAINT IF EXISTS webhooks_tenant_id_id_unique;
+ALTER TABLE config.webhooks DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE config.outbox DROP COLUMN IF EXISTS tenant_id;
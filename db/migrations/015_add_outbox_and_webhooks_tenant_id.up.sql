@@ -0,0 +1,64 @@
+-- Events are published to the webhooks of the tenant of the user they are about.
+-- Waiting events belong to the tenant of their user, events of users that were purged since and webhooks created before tenants belong to the default tenant
+ALTER TABLE config.outbox ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64);
+UPDATE config.outbox SET tenant_id = users.tenant_id FROM config.users WHERE config.outbox.tenant_id IS NULL AND users.id = config.outbox.user_id;
+UPDATE config.outbox SET tenant_id = 'default' WHERE tenant_id IS NULL;
+ALTER TABLE config.outbox ALTER COLUMN tenant_id SET NOT NULL;
+
+ALTER TABLE config.webhooks ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64);
+UPDATE config.webhooks SET tenant_id = 'default' WHERE tenant_id IS NULL;
+ALTER TABLE config.webhooks ALTER COLUMN tenant_id SET NOT NULL;
+ALTER TABLE config.webhooks ADD CONSTRAINT webhooks_tenant_id_id_unique UNIQUE (tenant_id, id);
+
+-- A delivery belongs to the tenant of its webhook, the foreign key includes the tenant so that it cannot refer to a webhook of another tenant
+ALTER TABLE config.webhook_deliveries ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64);
+UPDATE config.webhook_deliveries SET tenant_id = webhooks.tenant_id FROM config.webhooks WHERE webhooks.id = config.webhook_deliveries.webhook_id;
+ALTER TABLE config.webhook_deliveries ALTER COLUMN tenant_id SET NOT NULL;
+ALTER TABLE config.webhook_deliveries DROP CONSTRAINT IF EXISTS webhook_deliveries_webhook_id_fkey;
+ALTER TABLE config.webhook_deliveries ADD CONSTRAINT webhook_deliveries_webhook_fkey
+    FOREIGN KEY (tenant_id, webhook_id) REFERENCES config.webhooks (tenant_id, id) ON DELETE CASCADE;
+
+ALTER TABLE config.outbox ENABLE ROW LEVEL SECURITY;
+CREATE POLICY outbox_tenant_isolation ON config.outbox
+    USING (tenant_id = current_setting('app.tenant_id', true));
+
+ALTER TABLE config.webhooks ENABLE ROW LEVEL SECURITY;
+CREATE POLICY webhooks_tenant_isolation ON config.webhooks
+    USING (tenant_id = current_setting('app.tenant_id', true));
+
+ALTER TABLE config.webhook_deliveries ENABLE ROW LEVEL SECURITY;
+CREATE POLICY webhook_deliveries_tenant_isolation ON config.webhook_deliveries
+    USING (tenant_id = current_setting('app.tenant_id', true));
+
+-- The relay publishes the events of every tenant, it claims, deletes and releases them with functions that are not subject to the policies.
+-- claim_outbox_events leases up to $1 of the oldest events that are not leased for $2 seconds,
+-- skipping the events being claimed by other relays at the same time so that each event is claimed by one relay
+CREATE OR REPLACE FUNCTION config.claim_outbox_events(INTEGER, DOUBLE PRECISION)
+    RETURNS TABLE (id BIGINT, tenant_id VARCHAR, event_type VARCHAR, user_id INTEGER, payload JSONB, created_at TIMESTAMP)
+    LANGUAGE sql SECURITY DEFINER SET search_path = pg_catalog, pg_temp
+AS $$
+    UPDATE config.outbox SET locked_until = NOW() + make_interval(secs => $2)
+    WHERE outbox.id IN (SELECT outbox.id FROM config.outbox WHERE locked_until IS NULL OR locked_until < NOW() ORDER BY outbox.id LIMIT $1 FOR UPDATE SKIP LOCKED)
+    RETURNING outbox.id, outbox.tenant_id, outbox.event_type, outbox.user_id, outbox.payload, outbox.created_at
+$$;
+
+-- delete_outbox_events deletes the events with the ids in $1
+CREATE OR REPLACE FUNCTION config.delete_outbox_events(BIGINT[]) RETURNS VOID
+    LANGUAGE sql SECURITY DEFINER SET search_path = pg_catalog, pg_temp
+AS $$
+    DELETE FROM config.outbox WHERE id = ANY($1)
+$$;
+
+-- release_outbox_events releases the lease of the events with the ids in $1
+CREATE OR REPLACE FUNCTION config.release_outbox_events(BIGINT[]) RETURNS VOID
+    LANGUAGE sql SECURITY DEFINER SET search_path = pg_catalog, pg_temp
+AS $$
+    UPDATE config.outbox SET locked_until = NULL WHERE id = ANY($1)
+$$;
+
+REVOKE ALL ON FUNCTION config.claim_outbox_events(INTEGER, DOUBLE PRECISION) FROM PUBLIC;
+REVOKE ALL ON FUNCTION config.delete_outbox_events(BIGINT[]) FROM PUBLIC;
+REVOKE ALL ON FUNCTION config.release_outbox_events(BIGINT[]) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION config.claim_outbox_events(INTEGER, DOUBLE PRECISION) TO demo_app;
+GRANT EXECUTE ON FUNCTION config.delete_outbox_events(BIGINT[]) TO demo_app;
+GRANT EXECUTE ON FUNCTION config.release_outbox_events(BIGINT[]) TO demo_app;
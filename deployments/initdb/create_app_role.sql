@@ -0,0 +1,3 @@
+-- The app connects as demo_app, which is subject to the row level security policies unlike the superuser demo_user that runs the migrations.
+-- The migrations grant demo_app access to the tables
+CREATE ROLE demo_app LOGIN PASSWORD 'demo_app_password' NOSUPERUSER NOBYPASSRLS;
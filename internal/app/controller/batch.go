@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/tobiassundman/go-demo-app/internal/app/service"
+)
+
+const (
+	// batchModeAtomic creates either every user of a batch or none, it is the default.
+	batchModeAtomic = "atomic"
+	// batchModePartial creates every user of a batch that can be created.
+	batchModePartial = "partial"
+	// batchAction is the custom method of POST /v1/users:batch.
+	batchAction = ":batch"
+)
+
+// errInvalidBatchSize is returned when a batch has no users or more users than the service accepts.
+var errInvalidBatchSize = newInvalidFieldError("users", fmt.Sprintf("must contain between 1 and %d users", service.MaxBatchSize))
+
+// bindBatchUser decodes and validates one user of a batch the same way a single user is bound.
+func bindBatchUser(rawUser json.RawMessage) (*User, error) {
+	user := &User{}
+	if err := json.Unmarshal(rawUser, user); err != nil {
+		return nil, err
+	}
+	if err := binding.Validator.ValidateStruct(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// abortBatch sets ErrBatchAborted as the result of every user of an atomic batch that has no result.
+func abortBatch(results []*BatchCreateUserResult) []*BatchCreateUserResult {
+	for i, result := range results {
+		if result == nil {
+			results[i] = &BatchCreateUserResult{Status: ErrBatchAborted.Status, Error: ErrBatchAborted}
+		}
+	}
+	return results
+}
+
+// atomicBatchStatus returns the status of an atomic batch, which is the status of the first user that failed.
+func atomicBatchStatus(results []*BatchCreateUserResult) int {
+	for _, result := range results {
+		if result.Error != nil && result.Error != ErrBatchAborted {
+			return result.Status
+		}
+	}
+	return http.StatusCreated
+}
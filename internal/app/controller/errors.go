@@ -1,9 +1,14 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/tobiassundman/go-demo-app/internal/app/service"
 )
 
@@ -11,6 +16,9 @@ type APIError struct {
 	ErrorCode string `json:"error_code"`
 	Message   string `json:"error_message"`
 	Status    int    `json:"status"`
+	Field     string `json:"field,omitempty"`
+	// RetryAfter is sent in the Retry-After header, rounded up to whole seconds. 0 sends no header.
+	RetryAfter time.Duration `json:"-"`
 }
 
 func (e *APIError) Error() string {
@@ -43,15 +51,144 @@ var (
 		Message:   "invalid id",
 		Status:    http.StatusBadRequest,
 	}
+	ErrInvalidLimit = &APIError{
+		ErrorCode: "ErrInvalidLimit",
+		Message:   "invalid limit",
+		Status:    http.StatusBadRequest,
+	}
+	ErrPreconditionFailed = &APIError{
+		ErrorCode: "ErrPreconditionFailed",
+		Message:   "user has been modified",
+		Status:    http.StatusPreconditionFailed,
+	}
+	ErrPreconditionRequired = &APIError{
+		ErrorCode: "ErrPreconditionRequired",
+		Message:   "If-Match header is required",
+		Status:    http.StatusPreconditionRequired,
+	}
+	ErrInvalidCursor = &APIError{
+		ErrorCode: "ErrInvalidCursor",
+		Message:   "invalid cursor",
+		Status:    http.StatusBadRequest,
+	}
+	ErrBatchAborted = &APIError{
+		ErrorCode: "ErrBatchAborted",
+		Message:   "user not created because another user in the batch failed",
+		Status:    http.StatusFailedDependency,
+	}
+	ErrInvalidPatch = &APIError{
+		ErrorCode: "ErrInvalidPatch",
+		Message:   "invalid patch document",
+		Status:    http.StatusBadRequest,
+	}
+	ErrPatchConflict = &APIError{
+		ErrorCode: "ErrPatchConflict",
+		Message:   "patch cannot be applied to the current user",
+		Status:    http.StatusConflict,
+	}
+	ErrUnsupportedMediaType = &APIError{
+		ErrorCode: "ErrUnsupportedMediaType",
+		Message:   "unsupported media type",
+		Status:    http.StatusUnsupportedMediaType,
+	}
+	ErrWebhookNotFound = &APIError{
+		ErrorCode: "ErrWebhookNotFound",
+		Message:   "webhook not found",
+		Status:    http.StatusNotFound,
+	}
+	ErrDeliveryNotFound = &APIError{
+		ErrorCode: "ErrDeliveryNotFound",
+		Message:   "webhook delivery not found",
+		Status:    http.StatusNotFound,
+	}
+	ErrIdempotencyKeyInUse = &APIError{
+		ErrorCode:  "ErrIdempotencyKeyInUse",
+		Message:    "a request with the same idempotency key is in progress",
+		Status:     http.StatusConflict,
+		RetryAfter: time.Second,
+	}
+	ErrIdempotencyKeyReused = &APIError{
+		ErrorCode: "ErrIdempotencyKeyReused",
+		Message:   "idempotency key was used for a different request",
+		Status:    http.StatusUnprocessableEntity,
+	}
+	ErrTenantRequired = &APIError{
+		ErrorCode: "ErrTenantRequired",
+		Message:   "X-Tenant-ID header is required",
+		Status:    http.StatusBadRequest,
+	}
+	ErrInvalidTenant = &APIError{
+		ErrorCode: "ErrInvalidTenant",
+		Message:   "invalid tenant",
+		Status:    http.StatusBadRequest,
+	}
+	ErrTenantMismatch = &APIError{
+		ErrorCode: "ErrTenantMismatch",
+		Message:   "X-Tenant-ID header does not match the authenticated tenant",
+		Status:    http.StatusForbidden,
+	}
+	ErrServiceUnavailable = &APIError{
+		ErrorCode: "ErrServiceUnavailable",
+		Message:   "service temporarily unavailable",
+		Status:    http.StatusServiceUnavailable,
+	}
 )
 
+// newInvalidFieldError creates an API error for an invalid value of a specific field.
+func newInvalidFieldError(field, message string) *APIError {
+	return &APIError{
+		ErrorCode: "ErrInvalidField",
+		Message:   message,
+		Status:    http.StatusBadRequest,
+		Field:     field,
+	}
+}
+
+// newServiceUnavailableError creates an API error for a request that failed fast, which can be retried after the given duration.
+func newServiceUnavailableError(retryAfter time.Duration) *APIError {
+	apiError := *ErrServiceUnavailable
+	apiError.RetryAfter = retryAfter
+	return &apiError
+}
+
+// writeAPIError responds with the API error, setting the Retry-After header if the request can be retried after a delay.
+func writeAPIError(ctx *gin.Context, apiError *APIError) {
+	if apiError.RetryAfter > 0 {
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(apiError.RetryAfter.Seconds()))))
+	}
+	ctx.JSON(apiError.Status, apiError)
+}
+
 // apiErrorFromServiceError converts service errors to API errors.
 func apiErrorFromServiceError(err error) *APIError {
+	var fieldError *service.FieldError
+	if errors.As(err, &fieldError) {
+		return newInvalidFieldError(fieldError.Field, fieldError.Message)
+	}
+	var unavailableError *service.UnavailableError
+	if errors.As(err, &unavailableError) {
+		return newServiceUnavailableError(unavailableError.RetryAfter)
+	}
+
 	switch err {
 	case service.ErrUserNotFound:
 		return ErrUserNotFound
 	case service.ErrUserAlreadyExists:
 		return ErrUserAlreadyExists
+	case service.ErrInvalidPageSize:
+		return ErrInvalidLimit
+	case service.ErrVersionConflict:
+		return ErrPreconditionFailed
+	case service.ErrBatchAborted:
+		return ErrBatchAborted
+	case service.ErrWebhookNotFound:
+		return ErrWebhookNotFound
+	case service.ErrDeliveryNotFound:
+		return ErrDeliveryNotFound
+	case service.ErrIdempotencyKeyInUse:
+		return ErrIdempotencyKeyInUse
+	case service.ErrIdempotencyKeyReused:
+		return ErrIdempotencyKeyReused
 	default:
 		return ErrInternalServer
 	}
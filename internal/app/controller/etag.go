@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tobiassundman/go-demo-app/internal/app/service"
+)
+
+const (
+	etagHeader    = "ETag"
+	ifMatchHeader = "If-Match"
+)
+
+// formatETag formats a user version as a strong entity tag.
+func formatETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ifMatchCondition is the condition of the If-Match header of a request.
+type ifMatchCondition struct {
+	// any is true for "*", which matches any current version.
+	any bool
+	// versions are the versions of the strong entity tags in the header.
+	versions []int
+}
+
+// versionMatch returns the condition as the service VersionMatch that the current version of the user is compared with.
+func (c *ifMatchCondition) versionMatch() service.VersionMatch {
+	return service.VersionMatch{Any: c.any, Versions: c.versions}
+}
+
+// parseIfMatch parses the If-Match header of a request as specified by RFC 9110, "*" or a comma separated list of entity tags.
+// Requests without the header are rejected so that clients cannot overwrite changes they have not seen.
+// If-Match uses the strong comparison, so weak entity tags and tags that are not a version written by formatETag never match,
+// and a header without any other tag, or that is not a valid list of entity tags, fails the precondition.
+func parseIfMatch(ctx *gin.Context) (*ifMatchCondition, *APIError) {
+	// A header sent on several lines is one comma separated list
+	value := strings.TrimSpace(strings.Join(ctx.Request.Header.Values(ifMatchHeader), ","))
+	if value == "" {
+		return nil, ErrPreconditionRequired
+	}
+	if value == "*" {
+		return &ifMatchCondition{any: true}, nil
+	}
+
+	condition := &ifMatchCondition{}
+	for {
+		// Lists may contain empty elements and whitespace around their elements
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			break
+		}
+		weak := strings.HasPrefix(value, "W/")
+		value = strings.TrimPrefix(value, "W/")
+		// The opaque tag is quoted without escapes and may contain commas
+		if !strings.HasPrefix(value, `"`) {
+			return nil, ErrPreconditionFailed
+		}
+		end := strings.IndexByte(value[1:], '"') + 1
+		if end == 0 {
+			return nil, ErrPreconditionFailed
+		}
+		opaque := value[1:end]
+		value = strings.TrimLeft(value[end+1:], " \t")
+		if value != "" && value[0] != ',' {
+			return nil, ErrPreconditionFailed
+		}
+		if version, err := strconv.Atoi(opaque); err == nil && !weak {
+			condition.versions = append(condition.versions, version)
+		}
+	}
+	if len(condition.versions) == 0 {
+		return nil, ErrPreconditionFailed
+	}
+	return condition, nil
+}
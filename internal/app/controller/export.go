@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	formatQueryParameter = "format"
+	exportFormatCSV      = "csv"
+	exportFormatNDJSON   = "ndjson"
+	// exportFlushInterval is the number of users written between flushes of the response.
+	exportFlushInterval = 100
+)
+
+// userExportEncoder writes exported users to a response in a specific format.
+type userExportEncoder interface {
+	// ContentType is the content type of the export.
+	ContentType() string
+	// Begin writes anything that comes before the first user.
+	Begin() error
+	// Encode writes a user, it may be buffered until Flush.
+	Encode(user *User) error
+	// Flush sends everything written so far to the client.
+	Flush() error
+}
+
+// newUserExportEncoder creates the encoder for the given export format.
+func newUserExportEncoder(format string, writer gin.ResponseWriter) (userExportEncoder, *APIError) {
+	switch format {
+	case exportFormatCSV:
+		return &csvUserExportEncoder{writer: writer, csvWriter: csv.NewWriter(writer)}, nil
+	case exportFormatNDJSON:
+		return &ndjsonUserExportEncoder{writer: writer, jsonEncoder: json.NewEncoder(writer)}, nil
+	default:
+		return nil, newInvalidFieldError(formatQueryParameter, "must be csv or ndjson")
+	}
+}
+
+// csvUserExportEncoder writes users as CSV with a header row.
+type csvUserExportEncoder struct {
+	writer    gin.ResponseWriter
+	csvWriter *csv.Writer
+}
+
+func (e *csvUserExportEncoder) ContentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (e *csvUserExportEncoder) Begin() error {
+	return e.csvWriter.Write([]string{"id", "name", "email", "age", "created_at", "updated_at"})
+}
+
+func (e *csvUserExportEncoder) Encode(user *User) error {
+	return e.csvWriter.Write([]string{strconv.Itoa(user.ID), user.Name, user.Email, strconv.Itoa(user.Age), user.CreatedAt, user.UpdatedAt})
+}
+
+func (e *csvUserExportEncoder) Flush() error {
+	e.csvWriter.Flush()
+	if err := e.csvWriter.Error(); err != nil {
+		return err
+	}
+	e.writer.Flush()
+	return nil
+}
+
+// ndjsonUserExportEncoder writes users as newline delimited JSON, one user per line.
+type ndjsonUserExportEncoder struct {
+	writer      gin.ResponseWriter
+	jsonEncoder *json.Encoder
+}
+
+func (e *ndjsonUserExportEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (e *ndjsonUserExportEncoder) Begin() error {
+	return nil
+}
+
+func (e *ndjsonUserExportEncoder) Encode(user *User) error {
+	return e.jsonEncoder.Encode(user)
+}
+
+func (e *ndjsonUserExportEncoder) Flush() error {
+	e.writer.Flush()
+	return nil
+}
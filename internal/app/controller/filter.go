@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tobiassundman/go-demo-app/internal/app/service"
+)
+
+const (
+	emailDomainQueryParameter  = "email_domain"
+	namePrefixQueryParameter   = "name_prefix"
+	minAgeQueryParameter       = "min_age"
+	maxAgeQueryParameter       = "max_age"
+	updatedSinceQueryParameter = "updated_since"
+	sortQueryParameter         = "sort"
+	searchQueryParameter       = "q"
+)
+
+// parseUserFilter parses the user filter query parameters of a request, returning nil if there are none.
+func parseUserFilter(ctx *gin.Context) (*service.UserFilter, *APIError) {
+	filter := &service.UserFilter{}
+	hasFilter := false
+
+	if emailDomain, ok := ctx.GetQuery(emailDomainQueryParameter); ok {
+		filter.EmailDomain = emailDomain
+		hasFilter = true
+	}
+	if namePrefix, ok := ctx.GetQuery(namePrefixQueryParameter); ok {
+		filter.NamePrefix = namePrefix
+		hasFilter = true
+	}
+	// The ages are parsed in a fixed order, so that a request with two invalid ages always reports the same one
+	ages := []struct {
+		parameter string
+		target    **int
+	}{
+		{minAgeQueryParameter, &filter.MinAge},
+		{maxAgeQueryParameter, &filter.MaxAge},
+	}
+	for _, age := range ages {
+		value, ok := ctx.GetQuery(age.parameter)
+		if !ok {
+			continue
+		}
+		parsedAge, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, newInvalidFieldError(age.parameter, "must be an integer")
+		}
+		*age.target = &parsedAge
+		hasFilter = true
+	}
+	if value, ok := ctx.GetQuery(updatedSinceQueryParameter); ok {
+		updatedSince, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, newInvalidFieldError(updatedSinceQueryParameter, "must be an RFC 3339 timestamp")
+		}
+		filter.UpdatedSince = &updatedSince
+		hasFilter = true
+	}
+
+	if !hasFilter {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+// parseUserSort parses the sort query parameter of a request, e.g. sort=name,-age sorts by name ascending and then age descending.
+func parseUserSort(ctx *gin.Context) ([]service.UserSort, *APIError) {
+	value, ok := ctx.GetQuery(sortQueryParameter)
+	if !ok {
+		return nil, nil
+	}
+
+	fields := strings.Split(value, ",")
+	sort := make([]service.UserSort, len(fields))
+	for i, field := range fields {
+		field, descending := strings.CutPrefix(strings.TrimSpace(field), "-")
+		if field == "" {
+			return nil, newInvalidFieldError(sortQueryParameter, "must be a comma separated list of fields")
+		}
+		sort[i] = service.UserSort{
+			Field:      service.UserSortField(field),
+			Descending: descending,
+		}
+	}
+	return sort, nil
+}
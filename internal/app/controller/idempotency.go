@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tobiassundman/go-demo-app/internal/app/service"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
+	"go.uber.org/zap"
+)
+
+const (
+	// idempotencyKeyHeader is the request header with the key that makes retries of a POST request get the response of the first attempt.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader is set on responses replayed for a retry.
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	// idempotencyStoreTimeout bounds storing the response after the request, which may have been cancelled by then.
+	idempotencyStoreTimeout = time.Second * 5
+)
+
+// recordingResponseWriter writes the response body both to the client and to a buffer, so that it can be stored.
+type recordingResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingResponseWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingResponseWriter) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// NewIdempotencyMiddleware creates a middleware that makes retries of a POST request with an Idempotency-Key header get the response of the first attempt.
+// A request is identified by its fingerprint, so a key used again for a different request is rejected, as is a retry while the first attempt is in flight.
+// Server errors are not stored, the request can be retried with the same key.
+// Keys belong to the tenant of the request, so the middleware must run after the tenant is put into the request context.
+func NewIdempotencyMiddleware(idempotencyService service.IdempotencyService, logger *zap.Logger) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		keys, ok := ctx.Request.Header[idempotencyKeyHeader]
+		if ctx.Request.Method != http.MethodPost || !ok {
+			ctx.Next()
+			return
+		}
+		key := keys[0]
+
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			logger.Warn("Failed to read request body", zap.Error(err))
+			ctx.AbortWithStatusJSON(ErrValidationFailed.Status, ErrValidationFailed)
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(ctx.Request, body)
+
+		stored, err := idempotencyService.Begin(ctx.Request.Context(), key, fingerprint)
+		if err != nil {
+			apiError := apiErrorFromServiceError(err)
+			if apiError.Status >= http.StatusInternalServerError {
+				logger.Error("Failed to begin idempotent request", zap.Error(err), zap.String("key", key))
+			}
+			writeAPIError(ctx, apiError)
+			ctx.Abort()
+			return
+		}
+		if stored != nil {
+			ctx.Header(idempotentReplayedHeader, "true")
+			ctx.Data(stored.StatusCode, stored.ContentType, stored.Body)
+			ctx.Abort()
+			return
+		}
+
+		writer := &recordingResponseWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = writer
+		completed := false
+		defer func() {
+			// The response of a request that panicked or failed is not stored, so that it can be retried
+			if completed {
+				return
+			}
+			storeCtx, cancel := storeContext(ctx.Request.Context())
+			defer cancel()
+			if err := idempotencyService.Abort(storeCtx, key, fingerprint); err != nil {
+				logger.Error("Failed to abort idempotent request", zap.Error(err), zap.String("key", key))
+			}
+		}()
+
+		ctx.Next()
+
+		if writer.Status() >= http.StatusInternalServerError {
+			return
+		}
+		completed = true
+		storeCtx, cancel := storeContext(ctx.Request.Context())
+		defer cancel()
+		err = idempotencyService.Complete(storeCtx, key, fingerprint, &service.IdempotentResponse{
+			StatusCode:  writer.Status(),
+			ContentType: writer.Header().Get("Content-Type"),
+			Body:        writer.body.Bytes(),
+		})
+		if err != nil && !errors.Is(err, service.ErrIdempotencyKeyInUse) {
+			logger.Error("Failed to store idempotent response", zap.Error(err), zap.String("key", key))
+		}
+	}
+}
+
+// storeContext returns a context for storing the outcome of the request, which is not canceled with the request but keeps its tenant.
+func storeContext(requestCtx context.Context) (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if tenantID, ok := tenant.FromContext(requestCtx); ok {
+		ctx = tenant.NewContext(ctx, tenantID)
+	}
+	return context.WithTimeout(ctx, idempotencyStoreTimeout)
+}
+
+// requestFingerprint identifies a request by its method, path, query, actor, tenant and body.
+func requestFingerprint(request *http.Request, body []byte) string {
+	tenantID, _ := requestTenant(request)
+	hash := sha256.New()
+	for _, part := range []string{request.Method, request.URL.Path, request.URL.RawQuery, request.Header.Get(actorHeader), tenantID} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
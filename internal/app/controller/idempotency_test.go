@@ -0,0 +1,254 @@
+package controller_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appleboy/gofight/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/controller"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/internal/app/service"
+	"go.uber.org/zap"
+)
+
+// newIdempotentRouter creates a router for the user controller behind the idempotency middleware, with keys kept in memory.
+func newIdempotentRouter(userService service.UserService) *gin.Engine {
+	idempotencyService := service.NewIdempotencyService(repository.NewInMemoryIdempotencyRepository(), service.IdempotencyServiceConfig{
+		TTL:         time.Hour,
+		LockTimeout: time.Minute,
+	})
+	router := gin.Default()
+	controller.NewUserController(userService, zap.NewNop()).ConfigureRoutes(router, controller.NewIdempotencyMiddleware(idempotencyService, zap.NewNop()))
+	return router
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	t.Parallel()
+
+	t.Run("replays response of completed request", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		created := 0
+		serviceMock := &userServiceMock{
+			CreateFunc: func(ctx context.Context, user *service.User) (*service.User, error) {
+				created++
+				return &service.User{ID: created, Name: user.Name, Email: user.Email, Age: user.Age, Version: 1}, nil
+			},
+		}
+		router := newIdempotentRouter(serviceMock)
+		r := gofight.New()
+		request := gofight.D{"name": "Name Name 1", "email": "email1@email.com", "age": 37}
+		var firstBody string
+		r.POST("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "Idempotency-Key": "key-1"}).
+			SetJSON(request).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusCreated, r.Code)
+				assert.Empty(t, r.HeaderMap.Get("Idempotent-Replayed"))
+				firstBody = r.Body.String()
+			})
+
+		// Act
+		r.POST("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "Idempotency-Key": "key-1"}).
+			SetJSON(request).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusCreated, r.Code)
+				assert.Equal(t, "true", r.HeaderMap.Get("Idempotent-Replayed"))
+				assert.Equal(t, "application/json; charset=utf-8", r.HeaderMap.Get("Content-Type"))
+				assert.JSONEq(t, firstBody, r.Body.String())
+				assert.Equal(t, 1, created)
+			})
+	})
+
+	t.Run("rejects key reused with different payload", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			CreateFunc: func(ctx context.Context, user *service.User) (*service.User, error) {
+				return &service.User{ID: 1, Name: user.Name, Email: user.Email, Age: user.Age, Version: 1}, nil
+			},
+		}
+		router := newIdempotentRouter(serviceMock)
+		r := gofight.New()
+		r.POST("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "Idempotency-Key": "key-1"}).
+			SetJSON(gofight.D{"name": "Name Name 1", "email": "email1@email.com", "age": 37}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusCreated, r.Code)
+			})
+
+		// Act
+		r.POST("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "Idempotency-Key": "key-1"}).
+			SetJSON(gofight.D{"name": "Name Name 2", "email": "email2@email.com", "age": 38}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusUnprocessableEntity, r.Code)
+				assert.JSONEq(t, `{
+					"error_code": "ErrIdempotencyKeyReused",
+					"error_message": "idempotency key was used for a different request",
+					"status": 422
+				}`, r.Body.String())
+			})
+	})
+
+	t.Run("does not replay response to another tenant", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		created := 0
+		serviceMock := &userServiceMock{
+			CreateFunc: func(ctx context.Context, user *service.User) (*service.User, error) {
+				created++
+				return &service.User{ID: created, Name: user.Name, Email: user.Email, Age: user.Age, Version: 1}, nil
+			},
+		}
+		router := newIdempotentRouter(serviceMock)
+		r := gofight.New()
+		user := gofight.D{"name": "Name Name 1", "email": "email1@email.com", "age": 37}
+		r.POST("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "Idempotency-Key": "key-1"}).
+			SetJSON(user).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusCreated, r.Code)
+			})
+
+		// Act
+		r.POST("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": "tenant2", "Idempotency-Key": "key-1"}).
+			SetJSON(user).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusCreated, r.Code)
+				assert.Empty(t, r.HeaderMap.Get("Idempotent-Replayed"))
+				assert.Equal(t, 2, created)
+			})
+	})
+
+	t.Run("rejects retry while request is in flight", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		body := `{"name": "Name Name 1", "email": "email1@email.com", "age": 37}`
+		var router *gin.Engine
+		retry := httptest.NewRecorder()
+		serviceMock := &userServiceMock{
+			CreateFunc: func(ctx context.Context, user *service.User) (*service.User, error) {
+				// The client retries before the first attempt has responded
+				request := httptest.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(body))
+				request.Header.Set("Content-Type", "application/json")
+				request.Header.Set("Idempotency-Key", "key-1")
+				request.Header.Set("X-Tenant-ID", TENANT)
+				router.ServeHTTP(retry, request)
+				return &service.User{ID: 1, Name: user.Name, Email: user.Email, Age: user.Age, Version: 1}, nil
+			},
+		}
+		router = newIdempotentRouter(serviceMock)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "Idempotency-Key": "key-1"}).
+			SetBody(body).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusCreated, r.Code)
+				require.Equal(t, http.StatusConflict, retry.Code)
+				assert.Equal(t, "1", retry.Header().Get("Retry-After"))
+				assert.Contains(t, retry.Body.String(), "ErrIdempotencyKeyInUse")
+			})
+	})
+
+	t.Run("does not store server error", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		attempts := 0
+		serviceMock := &userServiceMock{
+			CreateFunc: func(ctx context.Context, user *service.User) (*service.User, error) {
+				attempts++
+				if attempts == 1 {
+					return nil, errors.New("connection refused")
+				}
+				return &service.User{ID: 1, Name: user.Name, Email: user.Email, Age: user.Age, Version: 1}, nil
+			},
+		}
+		router := newIdempotentRouter(serviceMock)
+		r := gofight.New()
+		request := gofight.D{"name": "Name Name 1", "email": "email1@email.com", "age": 37}
+		r.POST("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "Idempotency-Key": "key-1"}).
+			SetJSON(request).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusInternalServerError, r.Code)
+			})
+
+		// Act
+		r.POST("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "Idempotency-Key": "key-1"}).
+			SetJSON(request).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusCreated, r.Code)
+				assert.Empty(t, r.HeaderMap.Get("Idempotent-Replayed"))
+				assert.Equal(t, 2, attempts)
+			})
+	})
+
+	t.Run("ignores requests without key", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		created := 0
+		serviceMock := &userServiceMock{
+			CreateFunc: func(ctx context.Context, user *service.User) (*service.User, error) {
+				created++
+				return &service.User{ID: created, Name: user.Name, Email: user.Email, Age: user.Age, Version: 1}, nil
+			},
+		}
+		router := newIdempotentRouter(serviceMock)
+		r := gofight.New()
+		request := gofight.D{"name": "Name Name 1", "email": "email1@email.com", "age": 37}
+
+		// Act
+		for i := 0; i < 2; i++ {
+			r.POST("/v1/users").
+				SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+				SetJSON(request).
+				Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+					require.Equal(t, http.StatusCreated, r.Code)
+				})
+		}
+
+		// Assert
+		assert.Equal(t, 2, created)
+	})
+
+	t.Run("returns bad request for invalid key", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		router := newIdempotentRouter(&userServiceMock{})
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "Idempotency-Key": strings.Repeat("k", 256)}).
+			SetJSON(gofight.D{"name": "Name Name 1", "email": "email1@email.com", "age": 37}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusBadRequest, r.Code)
+				assert.JSONEq(t, `{
+					"error_code": "ErrInvalidField",
+					"error_message": "must be between 1 and 255 characters",
+					"status": 400,
+					"field": "Idempotency-Key"
+				}`, r.Body.String())
+			})
+	})
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tobiassundman/go-demo-app/pkg/actor"
+	"github.com/tobiassundman/go-demo-app/pkg/session"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
+)
+
+const (
+	// actorHeader is the request header naming who performs the request.
+	actorHeader = "X-Actor"
+	// tenantHeader is the request header with the id of the tenant the request is made for.
+	tenantHeader = "X-Tenant-ID"
+	// sessionHeader is the request and response header with the id of the session of the client, whose reads see its own writes.
+	sessionHeader = "X-Session-ID"
+)
+
+// actorMiddleware puts the actor from the X-Actor header into the request context, so that changes can be attributed to it.
+func actorMiddleware(ctx *gin.Context) {
+	if name := ctx.GetHeader(actorHeader); name != "" {
+		ctx.Request = ctx.Request.WithContext(actor.NewContext(ctx.Request.Context(), name))
+	}
+	ctx.Next()
+}
+
+// tenantMiddleware puts the tenant of the request into the request context and rejects requests without a valid tenant.
+// A tenant already in the request context, put there by an earlier middleware from a verified auth claim, takes precedence over the X-Tenant-ID header,
+// which must then name the same tenant.
+func tenantMiddleware(ctx *gin.Context) {
+	tenantID, fromClaim := requestTenant(ctx.Request)
+	switch {
+	case tenantID == "":
+		writeAPIError(ctx, ErrTenantRequired)
+		ctx.Abort()
+		return
+	case !tenant.IsValid(tenantID):
+		writeAPIError(ctx, ErrInvalidTenant)
+		ctx.Abort()
+		return
+	case fromClaim:
+		if header := ctx.GetHeader(tenantHeader); header != "" && header != tenantID {
+			writeAPIError(ctx, ErrTenantMismatch)
+			ctx.Abort()
+			return
+		}
+	default:
+		ctx.Request = ctx.Request.WithContext(tenant.NewContext(ctx.Request.Context(), tenantID))
+	}
+	ctx.Next()
+}
+
+// requestTenant returns the tenant of the request and whether it was resolved from an auth claim rather than the X-Tenant-ID header.
+func requestTenant(request *http.Request) (string, bool) {
+	if tenantID, ok := tenant.FromContext(request.Context()); ok {
+		return tenantID, true
+	}
+	return request.Header.Get(tenantHeader), false
+}
+
+// sessionMiddleware puts the session from the X-Session-ID header into the request context, so that reads of the session see its own writes
+// on a lagging replica. A request without a valid session gets a new session that only lasts for the request, its id is returned in the
+// X-Session-ID response header for the client to send with its next requests.
+func sessionMiddleware(ctx *gin.Context) {
+	id := ctx.GetHeader(sessionHeader)
+	if !session.IsValid(id) {
+		var err error
+		if id, err = session.NewID(); err != nil {
+			writeAPIError(ctx, ErrInternalServer)
+			ctx.Abort()
+			return
+		}
+	}
+	ctx.Header(sessionHeader, id)
+	ctx.Request = ctx.Request.WithContext(session.NewContext(ctx.Request.Context(), id))
+	ctx.Next()
+}
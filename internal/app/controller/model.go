@@ -1,6 +1,12 @@
 package controller
 
-import "github.com/tobiassundman/go-demo-app/internal/app/service"
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/tobiassundman/go-demo-app/internal/app/service"
+)
 
 // User is the user model for the controller layer.
 type User struct {
@@ -8,6 +14,9 @@ type User struct {
 	Name  string `json:"name" binding:"required"`
 	Email string `json:"email" binding:"required,email"`
 	Age   int    `json:"age" binding:"required"`
+	// CreatedAt and UpdatedAt are RFC 3339 timestamps in UTC set by the server, they are omitted for users in the history.
+	CreatedAt string `json:"created_at,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
 }
 
 // UpdateUserRequest is the request model for updating a user.
@@ -18,9 +27,98 @@ type UpdateUserRequest struct {
 	Age   int    `json:"age" binding:"required"`
 }
 
-// GetUsersResponse is the response model when getting all users.
+// CreateUsersBatchRequest is the request model for creating a batch of users.
+type CreateUsersBatchRequest struct {
+	// Mode is atomic to create either every user or none, or partial to create every user that can be created.
+	Mode string `json:"mode" binding:"omitempty,oneof=atomic partial"`
+	// Users are decoded and validated one by one so that an invalid user only fails itself in partial mode.
+	Users []json.RawMessage `json:"users" binding:"required"`
+}
+
+// BatchCreateUserResult is the result of creating one user of a batch.
+type BatchCreateUserResult struct {
+	Status int       `json:"status"`
+	User   *User     `json:"user,omitempty"`
+	Error  *APIError `json:"error,omitempty"`
+}
+
+// CreateUsersBatchResponse is the response model when creating a batch of users, with a result for every user in the request order.
+type CreateUsersBatchResponse struct {
+	Results []*BatchCreateUserResult `json:"results"`
+}
+
+// GetUsersResponse is the response model when getting a page of users.
 type GetUsersResponse struct {
-	Users []*User `json:"users"`
+	Users      []*User `json:"users"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// UserSearchResult is a user matching a search together with its relevance.
+type UserSearchResult struct {
+	*User
+	Score float64 `json:"score"`
+}
+
+// SearchUsersResponse is the response model when searching for users.
+type SearchUsersResponse struct {
+	Results []*UserSearchResult `json:"results"`
+}
+
+// UserHistoryEntry is a recorded change of a user.
+type UserHistoryEntry struct {
+	ID        int    `json:"id"`
+	Operation string `json:"operation"`
+	ChangedBy string `json:"changed_by"`
+	ChangedAt string `json:"changed_at"`
+	Before    *User  `json:"before"`
+	After     *User  `json:"after"`
+}
+
+// GetUserHistoryResponse is the response model when getting the history of a user.
+type GetUserHistoryResponse struct {
+	Entries    []*UserHistoryEntry `json:"entries"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+// WebhookRequest is the request model for creating or updating a webhook.
+type WebhookRequest struct {
+	URL string `json:"url" binding:"required"`
+	// Secret is the key the deliveries are signed with, generated if empty on create and kept if empty on update.
+	Secret     string   `json:"secret"`
+	EventTypes []string `json:"event_types" binding:"required"`
+}
+
+// Webhook is the webhook model for the controller layer, the secret is only included when the webhook is created.
+type Webhook struct {
+	ID         int      `json:"id"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret,omitempty"`
+	EventTypes []string `json:"event_types"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+// GetWebhooksResponse is the response model when getting all webhooks.
+type GetWebhooksResponse struct {
+	Webhooks []*Webhook `json:"webhooks"`
+}
+
+// WebhookDelivery is a recorded delivery of an event to a webhook.
+type WebhookDelivery struct {
+	ID          int             `json:"id"`
+	EventID     int64           `json:"event_id"`
+	EventType   string          `json:"event_type"`
+	Payload     json.RawMessage `json:"payload"`
+	StatusCode  int             `json:"status_code"`
+	Attempts    int             `json:"attempts"`
+	Succeeded   bool            `json:"succeeded"`
+	Error       string          `json:"error,omitempty"`
+	DeliveredAt string          `json:"delivered_at"`
+}
+
+// GetWebhookDeliveriesResponse is the response model when getting a page of the deliveries to a webhook.
+type GetWebhookDeliveriesResponse struct {
+	Deliveries []*WebhookDelivery `json:"deliveries"`
+	NextCursor string             `json:"next_cursor,omitempty"`
 }
 
 // updateUserRequestToServiceUser converts a controller UpdateUserRequest to a service User.
@@ -43,12 +141,83 @@ func createUserRequestToServiceUser(user *User) *service.User {
 	}
 }
 
+// serviceBatchResultToControllerBatchResult converts a service BatchCreateResult to a controller BatchCreateUserResult.
+func serviceBatchResultToControllerBatchResult(result *service.BatchCreateResult) *BatchCreateUserResult {
+	if result.Err != nil {
+		apiError := apiErrorFromServiceError(result.Err)
+		return &BatchCreateUserResult{Status: apiError.Status, Error: apiError}
+	}
+	return &BatchCreateUserResult{Status: http.StatusCreated, User: serviceUserToControllerUser(result.User)}
+}
+
 // serviceUserToControllerUser converts a service User to a controller User.
 func serviceUserToControllerUser(user *service.User) *User {
 	return &User{
-		ID:    user.ID,
-		Name:  user.Name,
-		Email: user.Email,
-		Age:   user.Age,
+		ID:        user.ID,
+		Name:      user.Name,
+		Email:     user.Email,
+		Age:       user.Age,
+		CreatedAt: formatTimestamp(user.CreatedAt),
+		UpdatedAt: formatTimestamp(user.UpdatedAt),
+	}
+}
+
+// formatTimestamp formats a time as an RFC 3339 timestamp in UTC, the zero time is formatted as an empty string.
+func formatTimestamp(timestamp time.Time) string {
+	if timestamp.IsZero() {
+		return ""
+	}
+	return timestamp.UTC().Format(time.RFC3339)
+}
+
+// serviceHistoryEntryToControllerHistoryEntry converts a service UserHistoryEntry to a controller UserHistoryEntry.
+func serviceHistoryEntryToControllerHistoryEntry(entry *service.UserHistoryEntry) *UserHistoryEntry {
+	controllerEntry := &UserHistoryEntry{
+		ID:        entry.ID,
+		Operation: entry.Operation,
+		ChangedBy: entry.ChangedBy,
+		ChangedAt: entry.ChangedAt.UTC().Format(time.RFC3339),
+	}
+	if entry.Before != nil {
+		controllerEntry.Before = serviceUserToControllerUser(entry.Before)
+	}
+	if entry.After != nil {
+		controllerEntry.After = serviceUserToControllerUser(entry.After)
+	}
+	return controllerEntry
+}
+
+// webhookRequestToServiceWebhook converts a controller WebhookRequest to a service Webhook.
+func webhookRequestToServiceWebhook(id int, webhook *WebhookRequest) *service.Webhook {
+	return &service.Webhook{
+		ID:         id,
+		URL:        webhook.URL,
+		Secret:     webhook.Secret,
+		EventTypes: webhook.EventTypes,
+	}
+}
+
+// serviceWebhookToControllerWebhook converts a service Webhook to a controller Webhook without its secret.
+func serviceWebhookToControllerWebhook(webhook *service.Webhook) *Webhook {
+	return &Webhook{
+		ID:         webhook.ID,
+		URL:        webhook.URL,
+		EventTypes: webhook.EventTypes,
+		CreatedAt:  webhook.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// serviceDeliveryToControllerDelivery converts a service WebhookDelivery to a controller WebhookDelivery.
+func serviceDeliveryToControllerDelivery(delivery *service.WebhookDelivery) *WebhookDelivery {
+	return &WebhookDelivery{
+		ID:          delivery.ID,
+		EventID:     delivery.EventID,
+		EventType:   delivery.EventType,
+		Payload:     json.RawMessage(delivery.Payload),
+		StatusCode:  delivery.StatusCode,
+		Attempts:    delivery.Attempts,
+		Succeeded:   delivery.Error == "",
+		Error:       delivery.Error,
+		DeliveredAt: delivery.DeliveredAt.UTC().Format(time.RFC3339),
 	}
 }
@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tobiassundman/go-demo-app/internal/app/service"
+)
+
+const (
+	limitQueryParameter  = "limit"
+	cursorQueryParameter = "cursor"
+	cursorPrefix         = "id:"
+)
+
+// encodeCursor encodes the id of the last seen item into an opaque cursor.
+func encodeCursor(lastID int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(lastID)))
+}
+
+// decodeCursor decodes an opaque cursor into the id of the last seen item.
+func decodeCursor(cursor string) (int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, found := strings.CutPrefix(string(decoded), cursorPrefix)
+	if !found {
+		return 0, fmt.Errorf("cursor is missing prefix %q", cursorPrefix)
+	}
+	parsedID, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, err
+	}
+	if parsedID < 0 {
+		return 0, fmt.Errorf("cursor id %d is negative", parsedID)
+	}
+	return parsedID, nil
+}
+
+// userCursor is the opaque cursor of a page of users, the sort it was created for and the values of the sorted fields of the last user.
+// Only the sorted fields are set, so that a cursor does not carry more of a user than is needed to continue after it.
+type userCursor struct {
+	Sort  string  `json:"sort,omitempty"`
+	ID    int     `json:"id"`
+	Name  *string `json:"name,omitempty"`
+	Email *string `json:"email,omitempty"`
+	Age   *int    `json:"age,omitempty"`
+}
+
+// encodeUserCursor encodes the cursor of the last user of a page in the given sort into an opaque cursor.
+func encodeUserCursor(cursor *service.UserCursor, sort []service.UserSort) string {
+	encoded := userCursor{Sort: formatUserSort(sort), ID: cursor.ID}
+	for _, s := range sort {
+		switch s.Field {
+		case service.UserSortFieldName:
+			encoded.Name = &cursor.Name
+		case service.UserSortFieldEmail:
+			encoded.Email = &cursor.Email
+		case service.UserSortFieldAge:
+			encoded.Age = &cursor.Age
+		}
+	}
+	value, _ := json.Marshal(encoded)
+	return base64.RawURLEncoding.EncodeToString(value)
+}
+
+// decodeUserCursor decodes an opaque cursor of a page of users in the given sort.
+// A cursor created for another sort is rejected, since it does not carry the values of the fields to continue after.
+func decodeUserCursor(cursor string, sort []service.UserSort) (*service.UserCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	var encoded userCursor
+	if err := json.Unmarshal(decoded, &encoded); err != nil {
+		return nil, err
+	}
+	if encoded.Sort != formatUserSort(sort) {
+		return nil, fmt.Errorf("cursor of sort %q used with sort %q", encoded.Sort, formatUserSort(sort))
+	}
+	if encoded.ID < 1 {
+		return nil, fmt.Errorf("cursor id %d is not positive", encoded.ID)
+	}
+
+	decodedCursor := &service.UserCursor{ID: encoded.ID}
+	for _, s := range sort {
+		switch s.Field {
+		case service.UserSortFieldName:
+			if encoded.Name == nil {
+				return nil, fmt.Errorf("cursor is missing the value of sort field %q", s.Field)
+			}
+			decodedCursor.Name = *encoded.Name
+		case service.UserSortFieldEmail:
+			if encoded.Email == nil {
+				return nil, fmt.Errorf("cursor is missing the value of sort field %q", s.Field)
+			}
+			decodedCursor.Email = *encoded.Email
+		case service.UserSortFieldAge:
+			if encoded.Age == nil {
+				return nil, fmt.Errorf("cursor is missing the value of sort field %q", s.Field)
+			}
+			decodedCursor.Age = *encoded.Age
+		}
+	}
+	return decodedCursor, nil
+}
+
+// formatUserSort formats a sort the way it is given in the sort query parameter.
+func formatUserSort(sort []service.UserSort) string {
+	fields := make([]string, len(sort))
+	for i, s := range sort {
+		fields[i] = string(s.Field)
+		if s.Descending {
+			fields[i] = "-" + fields[i]
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// parseLimit parses the limit query parameter of a request.
+func parseLimit(ctx *gin.Context) (int, *APIError) {
+	limit := service.DefaultPageSize
+	if limitParameter, ok := ctx.GetQuery(limitQueryParameter); ok {
+		parsedLimit, err := strconv.Atoi(limitParameter)
+		if err != nil || parsedLimit < 1 || parsedLimit > service.MaxPageSize {
+			return 0, ErrInvalidLimit
+		}
+		limit = parsedLimit
+	}
+	return limit, nil
+}
+
+// parsePagination parses the limit and cursor query parameters of a request into a limit and the id to continue after.
+func parsePagination(ctx *gin.Context) (int, int, *APIError) {
+	limit, apiError := parseLimit(ctx)
+	if apiError != nil {
+		return 0, 0, apiError
+	}
+
+	afterID := 0
+	if cursor, ok := ctx.GetQuery(cursorQueryParameter); ok {
+		decodedID, err := decodeCursor(cursor)
+		if err != nil {
+			return 0, 0, ErrInvalidCursor
+		}
+		afterID = decodedID
+	}
+
+	return limit, afterID, nil
+}
+
+// parsePageQuery parses the limit and cursor query parameters of a request for a page of users in the given sort.
+func parsePageQuery(ctx *gin.Context, sort []service.UserSort) (*service.UserPageQuery, *APIError) {
+	limit, apiError := parseLimit(ctx)
+	if apiError != nil {
+		return nil, apiError
+	}
+
+	pageQuery := &service.UserPageQuery{
+		Limit: limit,
+		Sort:  sort,
+	}
+	if cursor, ok := ctx.GetQuery(cursorQueryParameter); ok {
+		after, err := decodeUserCursor(cursor, sort)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		pageQuery.After = after
+	}
+	return pageQuery, nil
+}
+
+// nextPageLink creates an RFC 8288 Link header value pointing to the next page of the current request.
+func nextPageLink(ctx *gin.Context, nextCursor string, limit int) string {
+	nextURL := *ctx.Request.URL
+	query := nextURL.Query()
+	query.Set(cursorQueryParameter, nextCursor)
+	query.Set(limitQueryParameter, strconv.Itoa(limit))
+	nextURL.RawQuery = query.Encode()
+	nextURL.Scheme = ""
+	nextURL.Host = ""
+	return fmt.Sprintf(`<%s>; rel="next"`, nextURL.String())
+}
@@ -0,0 +1,132 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/tobiassundman/go-demo-app/internal/app/service"
+	"github.com/tobiassundman/go-demo-app/pkg/jsonpatch"
+)
+
+const (
+	// mergePatchContentType is the media type of a JSON Merge Patch (RFC 7386).
+	mergePatchContentType = "application/merge-patch+json"
+	// jsonPatchContentType is the media type of a JSON Patch (RFC 6902).
+	jsonPatchContentType = "application/json-patch+json"
+	// acceptPatchHeader lists the patch media types a resource accepts (RFC 5789).
+	acceptPatchHeader = "Accept-Patch"
+)
+
+// patchableUserFields are the fields of a user that a patch must leave in place.
+var patchableUserFields = []string{"name", "email", "age"}
+
+// timestampUserFields are the fields of a user set by the server, a patch cannot change them.
+var timestampUserFields = []string{"created_at", "updated_at"}
+
+// parseMergePatch parses a JSON Merge Patch of the user with the given id into a service UserPatch of the fields present in it.
+func parseMergePatch(id int, body []byte) (*service.UserPatch, *APIError) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, ErrInvalidPatch
+	}
+	return userPatchFromFields(id, fields)
+}
+
+// applyJSONPatch applies a JSON Patch to the current user and returns a service UserPatch of the fields the patch changed.
+func applyJSONPatch(current *service.User, body []byte) (*service.UserPatch, *APIError) {
+	patch, err := jsonpatch.Decode(body)
+	if err != nil {
+		return nil, ErrInvalidPatch
+	}
+	document, err := json.Marshal(serviceUserToControllerUser(current))
+	if err != nil {
+		return nil, ErrInternalServer
+	}
+	patched, err := patch.Apply(document)
+	if errors.Is(err, jsonpatch.ErrTestFailed) || errors.Is(err, jsonpatch.ErrPathNotFound) {
+		return nil, ErrPatchConflict
+	}
+	if err != nil {
+		return nil, ErrInvalidPatch
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(patched, &fields); err != nil {
+		return nil, ErrInvalidPatch
+	}
+	for _, field := range patchableUserFields {
+		if _, ok := fields[field]; !ok {
+			return nil, newInvalidFieldError(field, "cannot be removed")
+		}
+	}
+	// The document has the timestamps of the current user, a patch that leaves them as they are does not change them
+	original := map[string]json.RawMessage{}
+	if err := json.Unmarshal(document, &original); err != nil {
+		return nil, ErrInternalServer
+	}
+	for _, field := range timestampUserFields {
+		if value, ok := fields[field]; ok && bytes.Equal(value, original[field]) {
+			delete(fields, field)
+		}
+	}
+	userPatch, apiError := userPatchFromFields(current.ID, fields)
+	if apiError != nil {
+		return nil, apiError
+	}
+
+	// The patched document has every field, only those that changed are patched
+	if userPatch.Name != nil && *userPatch.Name == current.Name {
+		userPatch.Name = nil
+	}
+	if userPatch.Email != nil && *userPatch.Email == current.Email {
+		userPatch.Email = nil
+	}
+	if userPatch.Age != nil && *userPatch.Age == current.Age {
+		userPatch.Age = nil
+	}
+	return userPatch, nil
+}
+
+// userPatchFromFields converts the fields of a patched user to a service UserPatch.
+// Fields are checked in name order, so that the same invalid patch always fails on the same field.
+func userPatchFromFields(id int, fields map[string]json.RawMessage) (*service.UserPatch, *APIError) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	patch := &service.UserPatch{ID: id}
+	for _, name := range names {
+		value := fields[name]
+		if string(value) == "null" {
+			return nil, newInvalidFieldError(name, "cannot be removed")
+		}
+		switch name {
+		case "id":
+			var patchedID int
+			if err := json.Unmarshal(value, &patchedID); err != nil || patchedID != id {
+				return nil, newInvalidFieldError(name, "cannot be changed")
+			}
+		case "name":
+			if err := json.Unmarshal(value, &patch.Name); err != nil {
+				return nil, newInvalidFieldError(name, "must be a string")
+			}
+		case "email":
+			if err := json.Unmarshal(value, &patch.Email); err != nil {
+				return nil, newInvalidFieldError(name, "must be a string")
+			}
+		case "age":
+			if err := json.Unmarshal(value, &patch.Age); err != nil {
+				return nil, newInvalidFieldError(name, "must be an integer")
+			}
+		case "created_at", "updated_at":
+			return nil, newInvalidFieldError(name, "cannot be changed")
+		default:
+			return nil, newInvalidFieldError(name, "unknown field")
+		}
+	}
+	return patch, nil
+}
@@ -1,6 +1,9 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 
@@ -23,37 +26,165 @@ func NewUserController(service service.UserService, logger *zap.Logger) *UserCon
 }
 
 // ConfigureRoutes configures the routes for the user resource.
-func (c *UserController) ConfigureRoutes(router *gin.Engine) {
-	userGroup := router.Group("/v1")
+// The middlewares run after the tenant of the request is put into the request context.
+func (c *UserController) ConfigureRoutes(router *gin.Engine, middlewares ...gin.HandlerFunc) {
+	userGroup := router.Group("/v1", append([]gin.HandlerFunc{actorMiddleware, tenantMiddleware, sessionMiddleware}, middlewares...)...)
 	userGroup.GET("/users", c.getUsers)
+	userGroup.GET("/users/search", c.searchUsers)
+	userGroup.GET("/users/export", c.exportUsers)
 	userGroup.GET("/users/:id", c.getUser)
 	userGroup.POST("/users", c.createUser)
+	// gin cannot route a literal colon, so custom methods of the collection are matched by userCollectionAction
+	userGroup.POST("/users:action", c.userCollectionAction)
 	userGroup.PUT("/users", c.updateUser)
+	userGroup.PATCH("/users/:id", c.patchUser)
 	userGroup.DELETE("/users/:id", c.deleteUser)
+	userGroup.POST("/users/:id/restore", c.restoreUser)
+	userGroup.GET("/users/:id/history", c.getUserHistory)
 }
 
-// getUsers returns all users.
+// getUsers returns a page of users.
 func (c *UserController) getUsers(ctx *gin.Context) {
-	users, err := c.userService.GetAll()
+	// The sort is parsed first, as the cursor carries the values of the sorted fields
+	sort, apiError := parseUserSort(ctx)
+	if apiError != nil {
+		c.logger.Warn("Failed to parse user sort", zap.String("query", ctx.Request.URL.RawQuery))
+		writeAPIError(ctx, apiError)
+		return
+	}
+	pageQuery, apiError := parsePageQuery(ctx, sort)
+	if apiError != nil {
+		c.logger.Warn("Failed to parse page query", zap.String("query", ctx.Request.URL.RawQuery))
+		writeAPIError(ctx, apiError)
+		return
+	}
+	pageQuery.Filter, apiError = parseUserFilter(ctx)
+	if apiError != nil {
+		c.logger.Warn("Failed to parse user filter", zap.String("query", ctx.Request.URL.RawQuery))
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	page, err := c.userService.GetPage(ctx.Request.Context(), pageQuery)
 	if err != nil {
 		c.logger.Error("Failed to get users", zap.Error(err))
 		apiError := apiErrorFromServiceError(err)
-		ctx.JSON(apiError.Status, apiError)
+		writeAPIError(ctx, apiError)
 		return
 	}
 
-	usersInResponse := make([]*User, len(users))
-	for i, user := range users {
+	usersInResponse := make([]*User, len(page.Users))
+	for i, user := range page.Users {
 		usersInResponse[i] = serviceUserToControllerUser(user)
 	}
 
 	reponse := GetUsersResponse{
 		Users: usersInResponse,
 	}
+	if page.Next != nil {
+		reponse.NextCursor = encodeUserCursor(page.Next, pageQuery.Sort)
+		ctx.Header("Link", nextPageLink(ctx, reponse.NextCursor, pageQuery.Limit))
+	}
 
 	ctx.JSON(http.StatusOK, reponse)
 }
 
+// searchUsers returns the users whose name or email best match the q query parameter.
+func (c *UserController) searchUsers(ctx *gin.Context) {
+	limit := service.DefaultPageSize
+	if limitParameter, ok := ctx.GetQuery(limitQueryParameter); ok {
+		parsedLimit, err := strconv.Atoi(limitParameter)
+		if err != nil {
+			c.logger.Warn("Failed to parse limit", zap.Error(err))
+			ctx.JSON(ErrInvalidLimit.Status, ErrInvalidLimit)
+			return
+		}
+		limit = parsedLimit
+	}
+
+	results, err := c.userService.Search(ctx.Request.Context(), ctx.Query(searchQueryParameter), limit)
+	if err != nil {
+		apiError := apiErrorFromServiceError(err)
+		if apiError.Status >= http.StatusInternalServerError {
+			c.logger.Error("Failed to search users", zap.Error(err))
+		}
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	response := SearchUsersResponse{
+		Results: make([]*UserSearchResult, len(results)),
+	}
+	for i, result := range results {
+		response.Results[i] = &UserSearchResult{
+			User:  serviceUserToControllerUser(result.User),
+			Score: result.Score,
+		}
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// exportUsers streams every user matching the filter query parameters as CSV or NDJSON, ordered by id.
+func (c *UserController) exportUsers(ctx *gin.Context) {
+	format := ctx.DefaultQuery(formatQueryParameter, exportFormatCSV)
+	encoder, apiError := newUserExportEncoder(format, ctx.Writer)
+	if apiError != nil {
+		writeAPIError(ctx, apiError)
+		return
+	}
+	filter, apiError := parseUserFilter(ctx)
+	if apiError != nil {
+		c.logger.Warn("Failed to parse user filter", zap.String("query", ctx.Request.URL.RawQuery))
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	// The response is only started with the first user, so that failures before it can still be reported as an API error
+	started := false
+	begin := func() error {
+		started = true
+		ctx.Header("Content-Type", encoder.ContentType())
+		ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="users.%s"`, format))
+		ctx.Status(http.StatusOK)
+		return encoder.Begin()
+	}
+	exported := 0
+	err := c.userService.Export(ctx.Request.Context(), filter, func(user *service.User) error {
+		if !started {
+			if err := begin(); err != nil {
+				return err
+			}
+		}
+		if err := encoder.Encode(serviceUserToControllerUser(user)); err != nil {
+			return err
+		}
+		exported++
+		if exported%exportFlushInterval == 0 {
+			return encoder.Flush()
+		}
+		return nil
+	})
+	if err == nil && !started {
+		err = begin()
+	}
+	if err == nil {
+		err = encoder.Flush()
+	}
+	if err != nil {
+		if started {
+			// The status has already been sent, so the connection is aborted for the client to tell a failed export from a complete one
+			c.logger.Error("Failed to export users", zap.Error(err), zap.Int("exported", exported))
+			panic(http.ErrAbortHandler)
+		}
+		apiError := apiErrorFromServiceError(err)
+		if apiError.Status >= http.StatusInternalServerError {
+			c.logger.Error("Failed to export users", zap.Error(err))
+		}
+		writeAPIError(ctx, apiError)
+	}
+}
+
 // getUser returns a single user by id.
 func (c *UserController) getUser(ctx *gin.Context) {
 	id := ctx.Param("id")
@@ -64,16 +195,17 @@ func (c *UserController) getUser(ctx *gin.Context) {
 		return
 	}
 
-	user, err := c.userService.Get(parsedID)
+	user, err := c.userService.Get(ctx.Request.Context(), parsedID)
 	if err != nil {
 		apiError := apiErrorFromServiceError(err)
 		if apiError != ErrUserNotFound {
 			c.logger.Warn("Failed to get user", zap.Error(err), zap.Int("id", parsedID))
 		}
-		ctx.JSON(apiError.Status, apiError)
+		writeAPIError(ctx, apiError)
 		return
 	}
 
+	ctx.Header(etagHeader, formatETag(user.Version))
 	ctx.JSON(http.StatusOK, serviceUserToControllerUser(user))
 }
 
@@ -86,18 +218,88 @@ func (c *UserController) createUser(ctx *gin.Context) {
 		ctx.JSON(ErrValidationFailed.Status, ErrValidationFailed)
 		return
 	}
-	newUser, err := c.userService.Create(createUserRequestToServiceUser(&inputUser))
+	newUser, err := c.userService.Create(ctx.Request.Context(), createUserRequestToServiceUser(&inputUser))
 	if err != nil {
 		c.logger.Warn("Failed to create user", zap.Error(err), zap.Any("user", inputUser))
 		apiError := apiErrorFromServiceError(err)
-		ctx.JSON(apiError.Status, apiError)
+		writeAPIError(ctx, apiError)
 		return
 	}
 
+	ctx.Header(etagHeader, formatETag(newUser.Version))
 	ctx.JSON(http.StatusCreated, serviceUserToControllerUser(newUser))
 }
 
-// updateUser updates an existing user by id.
+// userCollectionAction dispatches POST /v1/users:<action> to the handler of the action.
+func (c *UserController) userCollectionAction(ctx *gin.Context) {
+	// The parameter starts right after /users, so it includes the colon
+	switch ctx.Param("action") {
+	case batchAction:
+		c.createUsersBatch(ctx)
+	default:
+		ctx.Status(http.StatusNotFound)
+	}
+}
+
+// createUsersBatch creates a batch of users, either all or nothing or every user that can be created.
+func (c *UserController) createUsersBatch(ctx *gin.Context) {
+	request := CreateUsersBatchRequest{}
+	err := ctx.BindJSON(&request)
+	if err != nil {
+		c.logger.Warn("Failed to parse batch", zap.Error(err))
+		ctx.JSON(ErrValidationFailed.Status, ErrValidationFailed)
+		return
+	}
+	if len(request.Users) == 0 || len(request.Users) > service.MaxBatchSize {
+		ctx.JSON(errInvalidBatchSize.Status, errInvalidBatchSize)
+		return
+	}
+	atomic := request.Mode != batchModePartial
+
+	results := make([]*BatchCreateUserResult, len(request.Users))
+	users := make([]*service.User, 0, len(request.Users))
+	// positions maps the index of each valid user to its index in the request
+	positions := make([]int, 0, len(request.Users))
+	for i, rawUser := range request.Users {
+		user, err := bindBatchUser(rawUser)
+		if err != nil {
+			results[i] = &BatchCreateUserResult{Status: ErrValidationFailed.Status, Error: ErrValidationFailed}
+			continue
+		}
+		users = append(users, createUserRequestToServiceUser(user))
+		positions = append(positions, i)
+	}
+
+	if atomic && len(users) < len(request.Users) {
+		c.logger.Warn("Failed to validate batch", zap.Int("invalid", len(request.Users)-len(users)))
+		results = abortBatch(results)
+		ctx.JSON(atomicBatchStatus(results), CreateUsersBatchResponse{Results: results})
+		return
+	}
+
+	if len(users) > 0 {
+		serviceResults, err := c.userService.CreateBatch(ctx.Request.Context(), users, atomic)
+		if err != nil {
+			apiError := apiErrorFromServiceError(err)
+			if apiError.Status >= http.StatusInternalServerError {
+				c.logger.Error("Failed to create batch", zap.Error(err), zap.Int("users", len(users)))
+			}
+			writeAPIError(ctx, apiError)
+			return
+		}
+		for i, result := range serviceResults {
+			results[positions[i]] = serviceBatchResultToControllerBatchResult(result)
+		}
+	}
+
+	status := http.StatusMultiStatus
+	if atomic {
+		status = atomicBatchStatus(results)
+	}
+	ctx.JSON(status, CreateUsersBatchResponse{Results: results})
+}
+
+// updateUser updates an existing user by id if the If-Match header matches its current version.
 func (c *UserController) updateUser(ctx *gin.Context) {
 	inputUser := UpdateUserRequest{}
 	err := ctx.BindJSON(&inputUser)
@@ -107,20 +309,92 @@ func (c *UserController) updateUser(ctx *gin.Context) {
 		return
 	}
 
-	err = c.userService.Update(updateUserRequestToServiceUser(&inputUser))
+	condition, apiError := parseIfMatch(ctx)
+	if apiError != nil {
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	user := updateUserRequestToServiceUser(&inputUser)
+	err = c.userService.Update(ctx.Request.Context(), user, condition.versionMatch())
 	if err != nil {
 		apiError := apiErrorFromServiceError(err)
-		if apiError != ErrUserNotFound {
+		if apiError != ErrUserNotFound && apiError != ErrPreconditionFailed {
 			c.logger.Warn("Failed to update user", zap.Error(err), zap.Any("user", inputUser))
 		}
-		ctx.JSON(apiError.Status, apiError)
+		writeAPIError(ctx, apiError)
 		return
 	}
 
 	ctx.Status(http.StatusOK)
 }
 
-// deleteUser deletes an existing user by id.
+// patchUser changes the fields of a user given in a JSON Merge Patch or a JSON Patch if the If-Match header matches its current version.
+func (c *UserController) patchUser(ctx *gin.Context) {
+	id := ctx.Param("id")
+	parsedID, err := strconv.Atoi(id)
+	if err != nil {
+		c.logger.Warn("Failed to parse id", zap.Error(err), zap.String("id", id))
+		ctx.JSON(ErrInvalidID.Status, ErrInvalidID)
+		return
+	}
+
+	contentType := ctx.ContentType()
+	if contentType != mergePatchContentType && contentType != jsonPatchContentType {
+		ctx.Header(acceptPatchHeader, mergePatchContentType+", "+jsonPatchContentType)
+		ctx.JSON(ErrUnsupportedMediaType.Status, ErrUnsupportedMediaType)
+		return
+	}
+
+	condition, apiError := parseIfMatch(ctx)
+	if apiError != nil {
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		c.logger.Warn("Failed to read patch", zap.Error(err))
+		ctx.JSON(ErrInvalidPatch.Status, ErrInvalidPatch)
+		return
+	}
+
+	var user *service.User
+	if contentType == mergePatchContentType {
+		patch, apiError := parseMergePatch(parsedID, body)
+		if apiError != nil {
+			writeAPIError(ctx, apiError)
+			return
+		}
+		user, err = c.userService.Patch(ctx.Request.Context(), patch, condition.versionMatch())
+	} else {
+		// A JSON Patch is applied to the current user, which the service reads in the transaction that patches it
+		user, err = c.userService.PatchCurrent(ctx.Request.Context(), parsedID, condition.versionMatch(), func(current *service.User) (*service.UserPatch, error) {
+			patch, apiError := applyJSONPatch(current, body)
+			if apiError != nil {
+				return nil, apiError
+			}
+			return patch, nil
+		})
+	}
+	if errors.As(err, &apiError) {
+		writeAPIError(ctx, apiError)
+		return
+	}
+	if err != nil {
+		apiError := apiErrorFromServiceError(err)
+		if apiError.Status >= http.StatusInternalServerError {
+			c.logger.Error("Failed to patch user", zap.Error(err), zap.Int("id", parsedID))
+		}
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	ctx.Header(etagHeader, formatETag(user.Version))
+	ctx.JSON(http.StatusOK, serviceUserToControllerUser(user))
+}
+
+// deleteUser deletes an existing user by id if the If-Match header matches its current version.
 func (c *UserController) deleteUser(ctx *gin.Context) {
 	id := ctx.Param("id")
 	parsedID, err := strconv.Atoi(id)
@@ -130,15 +404,87 @@ func (c *UserController) deleteUser(ctx *gin.Context) {
 		return
 	}
 
-	err = c.userService.Delete(parsedID)
+	condition, apiError := parseIfMatch(ctx)
+	if apiError != nil {
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	err = c.userService.Delete(ctx.Request.Context(), parsedID, condition.versionMatch())
 	if err != nil {
 		apiError := apiErrorFromServiceError(err)
-		if apiError != ErrUserNotFound {
+		if apiError != ErrUserNotFound && apiError != ErrPreconditionFailed {
 			c.logger.Warn("Failed to delete user", zap.Error(err), zap.Int("id", parsedID))
 		}
-		ctx.JSON(apiError.Status, apiError)
+		writeAPIError(ctx, apiError)
 		return
 	}
 
 	ctx.Status(http.StatusOK)
 }
+
+// restoreUser restores a deleted user by id.
+func (c *UserController) restoreUser(ctx *gin.Context) {
+	id := ctx.Param("id")
+	parsedID, err := strconv.Atoi(id)
+	if err != nil {
+		c.logger.Warn("Failed to parse id", zap.Error(err), zap.String("id", id))
+		ctx.JSON(ErrInvalidID.Status, ErrInvalidID)
+		return
+	}
+
+	err = c.userService.Restore(ctx.Request.Context(), parsedID)
+	if err != nil {
+		apiError := apiErrorFromServiceError(err)
+		if apiError != ErrUserNotFound {
+			c.logger.Warn("Failed to restore user", zap.Error(err), zap.Int("id", parsedID))
+		}
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+// getUserHistory returns a page of the changes made to a user, oldest change first.
+func (c *UserController) getUserHistory(ctx *gin.Context) {
+	id := ctx.Param("id")
+	parsedID, err := strconv.Atoi(id)
+	if err != nil {
+		c.logger.Warn("Failed to parse id", zap.Error(err), zap.String("id", id))
+		ctx.JSON(ErrInvalidID.Status, ErrInvalidID)
+		return
+	}
+
+	limit, afterID, apiError := parsePagination(ctx)
+	if apiError != nil {
+		c.logger.Warn("Failed to parse page query", zap.String("query", ctx.Request.URL.RawQuery))
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	page, err := c.userService.GetHistory(ctx.Request.Context(), &service.UserHistoryPageQuery{
+		UserID:  parsedID,
+		AfterID: afterID,
+		Limit:   limit,
+	})
+	if err != nil {
+		c.logger.Error("Failed to get user history", zap.Error(err), zap.Int("id", parsedID))
+		apiError := apiErrorFromServiceError(err)
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	response := GetUserHistoryResponse{
+		Entries: make([]*UserHistoryEntry, len(page.Entries)),
+	}
+	for i, entry := range page.Entries {
+		response.Entries[i] = serviceHistoryEntryToControllerHistoryEntry(entry)
+	}
+	if page.NextAfterID != 0 {
+		response.NextCursor = encodeCursor(page.NextAfterID)
+		ctx.Header("Link", nextPageLink(ctx, response.NextCursor, limit))
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
@@ -1,9 +1,16 @@
 package controller_test
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/appleboy/gofight/v2"
 	"github.com/gin-gonic/gin"
@@ -11,46 +18,114 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/tobiassundman/go-demo-app/internal/app/controller"
 	"github.com/tobiassundman/go-demo-app/internal/app/service"
+	"github.com/tobiassundman/go-demo-app/pkg/actor"
+	"github.com/tobiassundman/go-demo-app/pkg/session"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
 	"go.uber.org/zap"
 )
 
+// TENANT is the tenant requests are made for in tests.
+const TENANT = "tenant1"
+
 var _ service.UserService = &userServiceMock{}
 
 type userServiceMock struct {
-	GetAllFunc func() ([]*service.User, error)
-	GetFunc    func(id int) (*service.User, error)
-	CreateFunc func(user *service.User) (*service.User, error)
-	UpdateFunc func(user *service.User) error
-	DeleteFunc func(id int) error
+	GetAllFunc  func(ctx context.Context) ([]*service.User, error)
+	GetPageFunc func(ctx context.Context, query *service.UserPageQuery) (*service.UserPage, error)
+	SearchFunc  func(ctx context.Context, query string, limit int) ([]*service.UserSearchResult, error)
+	GetFunc     func(ctx context.Context, id int) (*service.User, error)
+	CreateFunc  func(ctx context.Context, user *service.User) (*service.User, error)
+	UpdateFunc  func(ctx context.Context, user *service.User, match service.VersionMatch) error
+	PatchFunc   func(ctx context.Context, patch *service.UserPatch, match service.VersionMatch) (*service.User, error)
+	DeleteFunc  func(ctx context.Context, id int, match service.VersionMatch) error
+
+	PatchCurrentFunc func(ctx context.Context, id int, match service.VersionMatch, fn func(current *service.User) (*service.UserPatch, error)) (*service.User, error)
+
+	RestoreFunc      func(ctx context.Context, id int) error
+	PurgeDeletedFunc func(ctx context.Context, retention time.Duration) (int64, error)
+	GetHistoryFunc   func(ctx context.Context, query *service.UserHistoryPageQuery) (*service.UserHistoryPage, error)
+	CreateBatchFunc  func(ctx context.Context, users []*service.User, atomic bool) ([]*service.BatchCreateResult, error)
+	ExportFunc       func(ctx context.Context, filter *service.UserFilter, fn func(user *service.User) error) error
+}
+
+func (m *userServiceMock) GetAll(ctx context.Context) ([]*service.User, error) {
+	return m.GetAllFunc(ctx)
+}
+
+func (m *userServiceMock) GetPage(ctx context.Context, query *service.UserPageQuery) (*service.UserPage, error) {
+	return m.GetPageFunc(ctx, query)
+}
+
+func (m *userServiceMock) Search(ctx context.Context, query string, limit int) ([]*service.UserSearchResult, error) {
+	return m.SearchFunc(ctx, query, limit)
+}
+
+func (m *userServiceMock) Get(ctx context.Context, id int) (*service.User, error) {
+	return m.GetFunc(ctx, id)
+}
+
+func (m *userServiceMock) Create(ctx context.Context, user *service.User) (*service.User, error) {
+	return m.CreateFunc(ctx, user)
+}
+
+func (m *userServiceMock) CreateBatch(ctx context.Context, users []*service.User, atomic bool) ([]*service.BatchCreateResult, error) {
+	return m.CreateBatchFunc(ctx, users, atomic)
+}
+
+func (m *userServiceMock) Export(ctx context.Context, filter *service.UserFilter, fn func(user *service.User) error) error {
+	return m.ExportFunc(ctx, filter, fn)
+}
+
+func (m *userServiceMock) Update(ctx context.Context, user *service.User, match service.VersionMatch) error {
+	return m.UpdateFunc(ctx, user, match)
+}
+
+func (m *userServiceMock) Patch(ctx context.Context, patch *service.UserPatch, match service.VersionMatch) (*service.User, error) {
+	return m.PatchFunc(ctx, patch, match)
+}
+
+func (m *userServiceMock) PatchCurrent(ctx context.Context, id int, match service.VersionMatch, fn func(current *service.User) (*service.UserPatch, error)) (*service.User, error) {
+	return m.PatchCurrentFunc(ctx, id, match, fn)
 }
 
-func (m *userServiceMock) GetAll() ([]*service.User, error) {
-	return m.GetAllFunc()
+func (m *userServiceMock) Delete(ctx context.Context, id int, match service.VersionMatch) error {
+	return m.DeleteFunc(ctx, id, match)
 }
 
-func (m *userServiceMock) Get(id int) (*service.User, error) {
-	return m.GetFunc(id)
+// patchCurrentFunc returns a PatchCurrentFunc that makes the patch from the current user if its version matches and passes it to patchFunc.
+func patchCurrentFunc(current *service.User, patchFunc func(patch *service.UserPatch) (*service.User, error)) func(ctx context.Context, id int, match service.VersionMatch, fn func(current *service.User) (*service.UserPatch, error)) (*service.User, error) {
+	return func(ctx context.Context, id int, match service.VersionMatch, fn func(current *service.User) (*service.UserPatch, error)) (*service.User, error) {
+		if !match.Matches(current.Version) {
+			return nil, service.ErrVersionConflict
+		}
+		patch, err := fn(current)
+		if err != nil {
+			return nil, err
+		}
+		return patchFunc(patch)
+	}
 }
 
-func (m *userServiceMock) Create(user *service.User) (*service.User, error) {
-	return m.CreateFunc(user)
+func (m *userServiceMock) Restore(ctx context.Context, id int) error {
+	return m.RestoreFunc(ctx, id)
 }
 
-func (m *userServiceMock) Update(user *service.User) error {
-	return m.UpdateFunc(user)
+func (m *userServiceMock) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	return m.PurgeDeletedFunc(ctx, retention)
 }
 
-func (m *userServiceMock) Delete(id int) error {
-	return m.DeleteFunc(id)
+func (m *userServiceMock) GetHistory(ctx context.Context, query *service.UserHistoryPageQuery) (*service.UserHistoryPage, error) {
+	return m.GetHistoryFunc(ctx, query)
 }
 
-func TestGetAll(t *testing.T) {
-	t.Run("returns all users", func(t *testing.T) {
+func TestGetPage(t *testing.T) {
+	t.Run("returns first page of users", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{
-			GetAllFunc: func() ([]*service.User, error) {
-				return []*service.User{
+			GetPageFunc: func(ctx context.Context, query *service.UserPageQuery) (*service.UserPage, error) {
+				assert.Equal(t, &service.UserPageQuery{Limit: service.DefaultPageSize}, query)
+				return &service.UserPage{Users: []*service.User{
 					{
 						ID:    1,
 						Name:  "Name Name 1",
@@ -63,7 +138,7 @@ func TestGetAll(t *testing.T) {
 						Email: "email2@email.com",
 						Age:   102,
 					},
-				}, nil
+				}}, nil
 			},
 		}
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
@@ -75,6 +150,7 @@ func TestGetAll(t *testing.T) {
 
 		// Act
 		r.GET("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 				require.Equal(t, http.StatusOK, r.Code)
 
@@ -104,8 +180,8 @@ func TestGetAll(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{
-			GetAllFunc: func() ([]*service.User, error) {
-				return []*service.User{}, nil
+			GetPageFunc: func(ctx context.Context, query *service.UserPageQuery) (*service.UserPage, error) {
+				return &service.UserPage{Users: []*service.User{}}, nil
 			},
 		}
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
@@ -117,6 +193,7 @@ func TestGetAll(t *testing.T) {
 
 		// Act
 		r.GET("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 				require.Equal(t, http.StatusOK, r.Code)
 
@@ -133,7 +210,7 @@ func TestGetAll(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{
-			GetAllFunc: func() ([]*service.User, error) {
+			GetPageFunc: func(ctx context.Context, query *service.UserPageQuery) (*service.UserPage, error) {
 				return nil, errors.New("error")
 			},
 		}
@@ -146,6 +223,7 @@ func TestGetAll(t *testing.T) {
 
 		// Act
 		r.GET("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 				require.Equal(t, http.StatusInternalServerError, r.Code)
 				assert.JSONEq(t,
@@ -158,20 +236,23 @@ func TestGetAll(t *testing.T) {
 				)
 			})
 	})
-}
 
-func TestGet(t *testing.T) {
-	t.Run("returns user", func(t *testing.T) {
+	t.Run("returns next cursor and link when there are more users", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{
-			GetFunc: func(id int) (*service.User, error) {
-				assert.Equal(t, 1, id)
-				return &service.User{
-					ID:    1,
-					Name:  "Name Name 1",
-					Email: "email1@email.com",
-					Age:   37,
+			GetPageFunc: func(ctx context.Context, query *service.UserPageQuery) (*service.UserPage, error) {
+				assert.Equal(t, 1, query.Limit)
+				return &service.UserPage{
+					Users: []*service.User{
+						{
+							ID:    1,
+							Name:  "Name Name 1",
+							Email: "email1@email.com",
+							Age:   37,
+						},
+					},
+					Next: &service.UserCursor{ID: 1, Name: "Name Name 1", Email: "email1@email.com", Age: 37},
 				}, nil
 			},
 		}
@@ -179,87 +260,93 @@ func TestGet(t *testing.T) {
 
 		router := gin.Default()
 		controller.ConfigureRoutes(router)
+
 		r := gofight.New()
 
 		// Act
-		r.GET("/v1/users/1").
+		r.GET("/v1/users?limit=1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 				require.Equal(t, http.StatusOK, r.Code)
 
 				assert.JSONEq(t,
 					`{
-						"id": 1,
-						"name": "Name Name 1",
-						"email": "email1@email.com",
-						"age": 37
+						"users": [
+							{
+								"id": 1,
+								"name": "Name Name 1",
+								"email": "email1@email.com",
+								"age": 37
+							}
+						],
+						"next_cursor": "eyJpZCI6MX0"
 					}`,
 					r.Body.String(),
 				)
+				assert.Equal(t, `</v1/users?cursor=eyJpZCI6MX0&limit=1>; rel="next"`, r.HeaderMap.Get("Link"))
 			})
 	})
 
-	t.Run("returns 404 when user not found", func(t *testing.T) {
+	t.Run("returns next cursor with values of sorted fields", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{
-			GetFunc: func(id int) (*service.User, error) {
-				assert.Equal(t, 1, id)
-				return nil, service.ErrUserNotFound
+			GetPageFunc: func(ctx context.Context, query *service.UserPageQuery) (*service.UserPage, error) {
+				return &service.UserPage{
+					Users: []*service.User{{ID: 1, Name: "Name Name 1", Email: "email1@email.com", Age: 37}},
+					Next:  &service.UserCursor{ID: 1, Name: "Name Name 1", Email: "email1@email.com", Age: 37},
+				}, nil
 			},
 		}
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
 
 		router := gin.Default()
 		controller.ConfigureRoutes(router)
+
 		r := gofight.New()
 
 		// Act
-		r.GET("/v1/users/1").
+		r.GET("/v1/users?limit=1&sort=name,-age").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
-				require.Equal(t, http.StatusNotFound, r.Code)
-				require.JSONEq(
-					t,
-					`{
-						"error_code": "ErrUserNotFound", 
-						"error_message": "user not found", 
-						"status": 404
-					}`,
-					r.Body.String(),
-				)
+				require.Equal(t, http.StatusOK, r.Code)
+				var response struct {
+					NextCursor string `json:"next_cursor"`
+				}
+				require.NoError(t, json.Unmarshal(r.Body.Bytes(), &response))
+				cursor, err := base64.RawURLEncoding.DecodeString(response.NextCursor)
+				require.NoError(t, err)
+				assert.JSONEq(t, `{"sort": "name,-age", "id": 1, "name": "Name Name 1", "age": 37}`, string(cursor))
+				assert.Equal(t, `</v1/users?cursor=`+response.NextCursor+`&limit=1&sort=name%2C-age>; rel="next"`, r.HeaderMap.Get("Link"))
 			})
 	})
 
-	t.Run("returns 500 when error", func(t *testing.T) {
+	t.Run("passes cursor to service", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{
-			GetFunc: func(id int) (*service.User, error) {
-				return nil, errors.New("error")
+			GetPageFunc: func(ctx context.Context, query *service.UserPageQuery) (*service.UserPage, error) {
+				assert.Equal(t, &service.UserPageQuery{After: &service.UserCursor{ID: 1}, Limit: 1}, query)
+				return &service.UserPage{Users: []*service.User{}}, nil
 			},
 		}
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
 
 		router := gin.Default()
 		controller.ConfigureRoutes(router)
+
 		r := gofight.New()
 
 		// Act
-		r.GET("/v1/users/1").
+		r.GET("/v1/users?limit=1&cursor=eyJpZCI6MX0").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
-				require.Equal(t, http.StatusInternalServerError, r.Code)
-				require.JSONEq(
-					t,
-					`{
-						"error_code": "ErrInternalServer",
-						"error_message": "internal server error",
-						"status": 500
-					}`,
-					r.Body.String(),
-				)
+				require.Equal(t, http.StatusOK, r.Code)
+				assert.Empty(t, r.HeaderMap.Get("Link"))
 			})
 	})
 
-	t.Run("returns 400 when invalid id", func(t *testing.T) {
+	t.Run("returns 400 when limit is too large", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{}
@@ -267,138 +354,155 @@ func TestGet(t *testing.T) {
 
 		router := gin.Default()
 		controller.ConfigureRoutes(router)
+
 		r := gofight.New()
 
 		// Act
-		r.GET("/v1/users/invalid").
+		r.GET("/v1/users?limit=1000").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 				require.Equal(t, http.StatusBadRequest, r.Code)
-				require.JSONEq(
-					t,
+				assert.JSONEq(t,
 					`{
-						"error_code": "ErrInvalidID",
-						"error_message": "invalid id",
+						"error_code": "ErrInvalidLimit",
+						"error_message": "invalid limit",
 						"status": 400
 					}`,
 					r.Body.String(),
 				)
 			})
 	})
-}
 
-func TestCreate(t *testing.T) {
-	t.Run("creates user", func(t *testing.T) {
+	t.Run("passes filter and sort to service", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
+		minAge := 18
+		maxAge := 65
+		updatedSince := time.Date(2023, 3, 1, 11, 0, 0, 0, time.UTC)
 		serviceMock := &userServiceMock{
-			CreateFunc: func(user *service.User) (*service.User, error) {
-				return &service.User{
-					ID:    1,
-					Name:  "Name Name 1",
-					Email: "email1@email.com",
-					Age:   37,
-				}, nil
+			GetPageFunc: func(ctx context.Context, query *service.UserPageQuery) (*service.UserPage, error) {
+				require.NotNil(t, query.Filter)
+				require.NotNil(t, query.Filter.UpdatedSince)
+				assert.True(t, updatedSince.Equal(*query.Filter.UpdatedSince))
+				query.Filter.UpdatedSince = nil
+				assert.Equal(t, &service.UserFilter{
+					EmailDomain: "email.com",
+					NamePrefix:  "Name",
+					MinAge:      &minAge,
+					MaxAge:      &maxAge,
+				}, query.Filter)
+				assert.Equal(t, []service.UserSort{
+					{Field: service.UserSortFieldName},
+					{Field: service.UserSortFieldAge, Descending: true},
+				}, query.Sort)
+				return &service.UserPage{Users: []*service.User{}}, nil
 			},
 		}
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
 
 		router := gin.Default()
 		controller.ConfigureRoutes(router)
+
 		r := gofight.New()
 
 		// Act
-		r.POST("/v1/users").
-			SetJSON(gofight.D{
-				"name":  "Name Name 1",
-				"email": "email1@email.com",
-				"age":   37,
-			}).
+		r.GET("/v1/users?email_domain=email.com&name_prefix=Name&min_age=18&max_age=65&updated_since=2023-03-01T12:00:00%2B01:00&sort=name,-age").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
-				require.Equal(t, http.StatusCreated, r.Code)
+				require.Equal(t, http.StatusOK, r.Code)
+			})
+	})
+
+	t.Run("returns 400 with field when age is not a number", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
 
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+
+		r := gofight.New()
+
+		// Act
+		// Both ages are invalid, min_age is always the one reported
+		r.GET("/v1/users?min_age=old&max_age=young").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusBadRequest, r.Code)
 				assert.JSONEq(t,
 					`{
-						"id": 1,
-						"name": "Name Name 1",
-						"email": "email1@email.com",
-						"age": 37
+						"error_code": "ErrInvalidField",
+						"error_message": "must be an integer",
+						"status": 400,
+						"field": "min_age"
 					}`,
 					r.Body.String(),
 				)
 			})
 	})
 
-	t.Run("returns conflict when user already exists", func(t *testing.T) {
+	t.Run("returns 400 with field when updated_since is not a timestamp", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
-		serviceMock := &userServiceMock{
-			CreateFunc: func(user *service.User) (*service.User, error) {
-				return nil, service.ErrUserAlreadyExists
-			},
-		}
+		serviceMock := &userServiceMock{}
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
 
 		router := gin.Default()
 		controller.ConfigureRoutes(router)
+
 		r := gofight.New()
 
 		// Act
-		r.POST("/v1/users").
-			SetJSON(gofight.D{
-				"name":  "Name Name 1",
-				"email": "email1@email.com",
-				"age":   37,
-			}).
+		r.GET("/v1/users?updated_since=2023-03-01").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
-				require.Equal(t, http.StatusConflict, r.Code)
-				require.JSONEq(
-					t,
+				require.Equal(t, http.StatusBadRequest, r.Code)
+				assert.JSONEq(t,
 					`{
-						"error_code": "ErrUserAlreadyExists", 
-						"error_message": "user already exists", 
-						"status": 409
+						"error_code": "ErrInvalidField",
+						"error_message": "must be an RFC 3339 timestamp",
+						"status": 400,
+						"field": "updated_since"
 					}`,
 					r.Body.String(),
 				)
 			})
 	})
 
-	t.Run("returns 500 when error", func(t *testing.T) {
+	t.Run("returns 400 with field when service rejects filter", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{
-			CreateFunc: func(user *service.User) (*service.User, error) {
-				return nil, errors.New("error")
+			GetPageFunc: func(ctx context.Context, query *service.UserPageQuery) (*service.UserPage, error) {
+				return nil, &service.FieldError{Field: "sort", Message: `cannot sort by "password"`}
 			},
 		}
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
 
 		router := gin.Default()
 		controller.ConfigureRoutes(router)
+
 		r := gofight.New()
 
 		// Act
-		r.POST("/v1/users").
-			SetJSON(gofight.D{
-				"name":  "Name Name 1",
-				"email": "email1@email.com",
-				"age":   37,
-			}).
+		r.GET("/v1/users?sort=password").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
-				require.Equal(t, http.StatusInternalServerError, r.Code)
-				require.JSONEq(
-					t,
+				require.Equal(t, http.StatusBadRequest, r.Code)
+				assert.JSONEq(t,
 					`{
-						"error_code": "ErrInternalServer",
-						"error_message": "internal server error",
-						"status": 500
+						"error_code": "ErrInvalidField",
+						"error_message": "cannot sort by \"password\"",
+						"status": 400,
+						"field": "sort"
 					}`,
 					r.Body.String(),
 				)
 			})
 	})
 
-	t.Run("returns 400 when invalid email", func(t *testing.T) {
+	t.Run("returns 400 when cursor is invalid", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{}
@@ -406,22 +510,18 @@ func TestCreate(t *testing.T) {
 
 		router := gin.Default()
 		controller.ConfigureRoutes(router)
+
 		r := gofight.New()
 
 		// Act
-		r.POST("/v1/users").
-			SetJSON(gofight.D{
-				"name":  "Name Name 1",
-				"email": "invalid",
-				"age":   37,
-			}).
+		r.GET("/v1/users?cursor=invalid").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 				require.Equal(t, http.StatusBadRequest, r.Code)
-				require.JSONEq(
-					t,
+				assert.JSONEq(t,
 					`{
-						"error_code": "ErrValidationFailed",
-						"error_message": "validation failed",
+						"error_code": "ErrInvalidCursor",
+						"error_message": "invalid cursor",
 						"status": 400
 					}`,
 					r.Body.String(),
@@ -429,86 +529,70 @@ func TestCreate(t *testing.T) {
 			})
 	})
 
-	t.Run("additional fields are ignored", func(t *testing.T) {
+	t.Run("passes values of sorted fields in cursor to service", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{
-			CreateFunc: func(user *service.User) (*service.User, error) {
-				return &service.User{
-					ID:    1,
-					Name:  "Name Name 1",
-					Email: "email1@email.com",
-					Age:   37,
-				}, nil
+			GetPageFunc: func(ctx context.Context, query *service.UserPageQuery) (*service.UserPage, error) {
+				assert.Equal(t, &service.UserCursor{ID: 1, Name: "Name Name 1", Age: 37}, query.After)
+				return &service.UserPage{Users: []*service.User{}}, nil
 			},
 		}
-
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
 
 		router := gin.Default()
 		controller.ConfigureRoutes(router)
+
 		r := gofight.New()
 
 		// Act
-		r.POST("/v1/users").
-			SetJSON(gofight.D{
-				"name":  "Name Name 1",
-				"email": "email1@email.com",
-				"age":   37,
-				"foo":   "bar",
-			}).
+		r.GET("/v1/users?sort=name,-age&cursor=eyJzb3J0IjoibmFtZSwtYWdlIiwiaWQiOjEsIm5hbWUiOiJOYW1lIE5hbWUgMSIsImFnZSI6Mzd9").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
-				require.Equal(t, http.StatusCreated, r.Code)
-
-				assert.JSONEq(t,
-					`{
-						"id": 1,
-						"name": "Name Name 1",
-						"email": "email1@email.com",
-						"age": 37
-					}`,
-					r.Body.String(),
-				)
+				require.Equal(t, http.StatusOK, r.Code)
 			})
 	})
 
-}
-
-func TestUpdate(t *testing.T) {
-	t.Run("updates user", func(t *testing.T) {
+	t.Run("returns 400 when cursor is of another sort", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
-		serviceMock := &userServiceMock{
-			UpdateFunc: func(user *service.User) error {
-				assert.Equal(t, 1, user.ID)
-				return nil
-			},
-		}
+		serviceMock := &userServiceMock{}
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
 
 		router := gin.Default()
 		controller.ConfigureRoutes(router)
+
 		r := gofight.New()
 
 		// Act
-		r.PUT("/v1/users").
-			SetJSON(gofight.D{
-				"id":    1,
-				"name":  "Name Name 1",
-				"email": "email1@email.com",
-				"age":   37,
-			}).
+		r.GET("/v1/users?sort=age&cursor=eyJzb3J0IjoibmFtZSIsImlkIjoxfQ").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
-				require.Equal(t, http.StatusOK, r.Code)
+				require.Equal(t, http.StatusBadRequest, r.Code)
+				assert.Contains(t, r.Body.String(), "ErrInvalidCursor")
 			})
 	})
+}
 
-	t.Run("returns 404 when user not found", func(t *testing.T) {
+func TestSearch(t *testing.T) {
+	t.Run("returns search results with score", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{
-			UpdateFunc: func(user *service.User) error {
-				return service.ErrUserNotFound
+			SearchFunc: func(ctx context.Context, query string, limit int) ([]*service.UserSearchResult, error) {
+				assert.Equal(t, "jon smth", query)
+				assert.Equal(t, service.DefaultPageSize, limit)
+				return []*service.UserSearchResult{
+					{
+						User: &service.User{
+							ID:    1,
+							Name:  "John Smith",
+							Email: "john@email.com",
+							Age:   37,
+						},
+						Score: 0.5,
+					},
+				}, nil
 			},
 		}
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
@@ -518,33 +602,34 @@ func TestUpdate(t *testing.T) {
 		r := gofight.New()
 
 		// Act
-		r.PUT("/v1/users").
-			SetJSON(gofight.D{
-				"id":    1,
-				"name":  "Name Name 1",
-				"email": "email1@email.com",
-				"age":   37,
-			}).
+		r.GET("/v1/users/search?q=jon+smth").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
-				require.Equal(t, http.StatusNotFound, r.Code)
-				require.JSONEq(
-					t,
+				require.Equal(t, http.StatusOK, r.Code)
+
+				assert.JSONEq(t,
 					`{
-						"error_code": "ErrUserNotFound", 
-						"error_message": "user not found", 
-						"status": 404
+						"results": [
+							{
+								"id": 1,
+								"name": "John Smith",
+								"email": "john@email.com",
+								"age": 37,
+								"score": 0.5
+							}
+						]
 					}`,
 					r.Body.String(),
 				)
 			})
 	})
 
-	t.Run("returns 500 when error", func(t *testing.T) {
+	t.Run("returns 400 with field when query is empty", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{
-			UpdateFunc: func(user *service.User) error {
-				return errors.New("error")
+			SearchFunc: func(ctx context.Context, query string, limit int) ([]*service.UserSearchResult, error) {
+				return nil, &service.FieldError{Field: "q", Message: "must not be empty"}
 			},
 		}
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
@@ -554,13 +639,143 @@ func TestUpdate(t *testing.T) {
 		r := gofight.New()
 
 		// Act
-		r.PUT("/v1/users").
-			SetJSON(gofight.D{
-				"id":    1,
-				"name":  "Name Name 1",
-				"email": "email1@email.com",
-				"age":   37,
-			}).
+		r.GET("/v1/users/search").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusBadRequest, r.Code)
+				assert.JSONEq(t,
+					`{
+						"error_code": "ErrInvalidField",
+						"error_message": "must not be empty",
+						"status": 400,
+						"field": "q"
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 400 when limit is invalid", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/search?q=name&limit=many").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusBadRequest, r.Code)
+				assert.JSONEq(t,
+					`{
+						"error_code": "ErrInvalidLimit",
+						"error_message": "invalid limit",
+						"status": 400
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+}
+
+func TestGet(t *testing.T) {
+	t.Run("returns user", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			GetFunc: func(ctx context.Context, id int) (*service.User, error) {
+				assert.Equal(t, 1, id)
+				return &service.User{
+					ID:        1,
+					Name:      "Name Name 1",
+					Email:     "email1@email.com",
+					Age:       37,
+					Version:   3,
+					CreatedAt: time.Date(2023, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600)),
+					UpdatedAt: time.Date(2023, 3, 2, 12, 30, 15, 500, time.UTC),
+				}, nil
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusOK, r.Code)
+				assert.Equal(t, `"3"`, r.HeaderMap.Get("ETag"))
+
+				assert.JSONEq(t,
+					`{
+						"id": 1,
+						"name": "Name Name 1",
+						"email": "email1@email.com",
+						"age": 37,
+						"created_at": "2023-03-01T11:00:00Z",
+						"updated_at": "2023-03-02T12:30:15Z"
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 404 when user not found", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			GetFunc: func(ctx context.Context, id int) (*service.User, error) {
+				assert.Equal(t, 1, id)
+				return nil, service.ErrUserNotFound
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusNotFound, r.Code)
+				require.JSONEq(
+					t,
+					`{
+						"error_code": "ErrUserNotFound", 
+						"error_message": "user not found", 
+						"status": 404
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 500 when error", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			GetFunc: func(ctx context.Context, id int) (*service.User, error) {
+				return nil, errors.New("error")
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 				require.Equal(t, http.StatusInternalServerError, r.Code)
 				require.JSONEq(
@@ -575,7 +790,39 @@ func TestUpdate(t *testing.T) {
 			})
 	})
 
-	t.Run("returns 400 when invalid email", func(t *testing.T) {
+	t.Run("returns 503 with Retry-After when circuit is open", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			GetFunc: func(ctx context.Context, id int) (*service.User, error) {
+				return nil, fmt.Errorf("failed to get user: %w", &service.UnavailableError{RetryAfter: time.Millisecond * 4500})
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusServiceUnavailable, r.Code)
+				assert.Equal(t, "5", r.HeaderMap.Get("Retry-After"))
+				require.JSONEq(
+					t,
+					`{
+						"error_code": "ErrServiceUnavailable",
+						"error_message": "service temporarily unavailable",
+						"status": 503
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 400 when invalid id", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{}
@@ -586,36 +833,37 @@ func TestUpdate(t *testing.T) {
 		r := gofight.New()
 
 		// Act
-		r.PUT("/v1/users").
-			SetJSON(gofight.D{
-				"id":    1,
-				"name":  "Name Name 1",
-				"email": "invalid",
-				"age":   37,
-			}).
+		r.GET("/v1/users/invalid").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 				require.Equal(t, http.StatusBadRequest, r.Code)
 				require.JSONEq(
 					t,
 					`{
-						"error_code": "ErrValidationFailed",
-						"error_message": "validation failed",
+						"error_code": "ErrInvalidID",
+						"error_message": "invalid id",
 						"status": 400
 					}`,
 					r.Body.String(),
 				)
 			})
 	})
+}
 
-	t.Run("additional fields are ignored", func(t *testing.T) {
+func TestCreate(t *testing.T) {
+	t.Run("creates user", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{
-			UpdateFunc: func(user *service.User) error {
-				return nil
+			CreateFunc: func(ctx context.Context, user *service.User) (*service.User, error) {
+				return &service.User{
+					ID:    1,
+					Name:  "Name Name 1",
+					Email: "email1@email.com",
+					Age:   37,
+				}, nil
 			},
 		}
-
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
 
 		router := gin.Default()
@@ -623,28 +871,36 @@ func TestUpdate(t *testing.T) {
 		r := gofight.New()
 
 		// Act
-		r.PUT("/v1/users").
+		r.POST("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			SetJSON(gofight.D{
-				"id":    1,
 				"name":  "Name Name 1",
 				"email": "email1@email.com",
 				"age":   37,
-				"foo":   "bar",
 			}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
-				require.Equal(t, http.StatusOK, r.Code)
+				require.Equal(t, http.StatusCreated, r.Code)
+
+				assert.JSONEq(t,
+					`{
+						"id": 1,
+						"name": "Name Name 1",
+						"email": "email1@email.com",
+						"age": 37
+					}`,
+					r.Body.String(),
+				)
 			})
 	})
 
-	t.Run("conflict with other user", func(t *testing.T) {
+	t.Run("returns conflict when user already exists", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{
-			UpdateFunc: func(user *service.User) error {
-				return service.ErrUserAlreadyExists
+			CreateFunc: func(ctx context.Context, user *service.User) (*service.User, error) {
+				return nil, service.ErrUserAlreadyExists
 			},
 		}
-
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
 
 		router := gin.Default()
@@ -652,9 +908,9 @@ func TestUpdate(t *testing.T) {
 		r := gofight.New()
 
 		// Act
-		r.PUT("/v1/users").
+		r.POST("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
 			SetJSON(gofight.D{
-				"id":    1,
 				"name":  "Name Name 1",
 				"email": "email1@email.com",
 				"age":   37,
@@ -672,16 +928,13 @@ func TestUpdate(t *testing.T) {
 				)
 			})
 	})
-}
 
-func TestDelete(t *testing.T) {
-	t.Run("deletes user", func(t *testing.T) {
+	t.Run("returns 500 when error", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{
-			DeleteFunc: func(id int) error {
-				assert.Equal(t, 1, id)
-				return nil
+			CreateFunc: func(ctx context.Context, user *service.User) (*service.User, error) {
+				return nil, errors.New("error")
 			},
 		}
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
@@ -691,20 +944,31 @@ func TestDelete(t *testing.T) {
 		r := gofight.New()
 
 		// Act
-		r.DELETE("/v1/users/1").
+		r.POST("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			SetJSON(gofight.D{
+				"name":  "Name Name 1",
+				"email": "email1@email.com",
+				"age":   37,
+			}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
-				require.Equal(t, http.StatusOK, r.Code)
+				require.Equal(t, http.StatusInternalServerError, r.Code)
+				require.JSONEq(
+					t,
+					`{
+						"error_code": "ErrInternalServer",
+						"error_message": "internal server error",
+						"status": 500
+					}`,
+					r.Body.String(),
+				)
 			})
 	})
 
-	t.Run("returns 404 when user not found", func(t *testing.T) {
+	t.Run("returns 400 when invalid email", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
-		serviceMock := &userServiceMock{
-			DeleteFunc: func(id int) error {
-				return service.ErrUserNotFound
-			},
-		}
+		serviceMock := &userServiceMock{}
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
 
 		router := gin.Default()
@@ -712,29 +976,41 @@ func TestDelete(t *testing.T) {
 		r := gofight.New()
 
 		// Act
-		r.DELETE("/v1/users/1").
+		r.POST("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			SetJSON(gofight.D{
+				"name":  "Name Name 1",
+				"email": "invalid",
+				"age":   37,
+			}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
-				require.Equal(t, http.StatusNotFound, r.Code)
+				require.Equal(t, http.StatusBadRequest, r.Code)
 				require.JSONEq(
 					t,
 					`{
-						"error_code": "ErrUserNotFound", 
-						"error_message": "user not found", 
-						"status": 404
+						"error_code": "ErrValidationFailed",
+						"error_message": "validation failed",
+						"status": 400
 					}`,
 					r.Body.String(),
 				)
 			})
 	})
 
-	t.Run("returns 500 when error", func(t *testing.T) {
+	t.Run("additional fields are ignored", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		serviceMock := &userServiceMock{
-			DeleteFunc: func(id int) error {
-				return errors.New("error")
+			CreateFunc: func(ctx context.Context, user *service.User) (*service.User, error) {
+				return &service.User{
+					ID:    1,
+					Name:  "Name Name 1",
+					Email: "email1@email.com",
+					Age:   37,
+				}, nil
 			},
 		}
+
 		controller := controller.NewUserController(serviceMock, zap.NewNop())
 
 		router := gin.Default()
@@ -742,18 +1018,1588 @@ func TestDelete(t *testing.T) {
 		r := gofight.New()
 
 		// Act
-		r.DELETE("/v1/users/1").
+		r.POST("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			SetJSON(gofight.D{
+				"name":  "Name Name 1",
+				"email": "email1@email.com",
+				"age":   37,
+				"foo":   "bar",
+			}).
 			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
-				require.Equal(t, http.StatusInternalServerError, r.Code)
-				require.JSONEq(
-					t,
+				require.Equal(t, http.StatusCreated, r.Code)
+
+				assert.JSONEq(t,
 					`{
-						"error_code": "ErrInternalServer",
-						"error_message": "internal server error",
+						"id": 1,
+						"name": "Name Name 1",
+						"email": "email1@email.com",
+						"age": 37
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+}
+
+func TestUpdate(t *testing.T) {
+	t.Run("updates user", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			UpdateFunc: func(ctx context.Context, user *service.User, match service.VersionMatch) error {
+				assert.Equal(t, 1, user.ID)
+				assert.Equal(t, service.MatchVersion(1), match)
+				return nil
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PUT("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"1"`}).
+			SetJSON(gofight.D{
+				"id":    1,
+				"name":  "Name Name 1",
+				"email": "email1@email.com",
+				"age":   37,
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusOK, r.Code)
+			})
+	})
+
+	t.Run("returns 404 when user not found", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			UpdateFunc: func(ctx context.Context, user *service.User, match service.VersionMatch) error {
+				return service.ErrUserNotFound
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PUT("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"1"`}).
+			SetJSON(gofight.D{
+				"id":    1,
+				"name":  "Name Name 1",
+				"email": "email1@email.com",
+				"age":   37,
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusNotFound, r.Code)
+				require.JSONEq(
+					t,
+					`{
+						"error_code": "ErrUserNotFound", 
+						"error_message": "user not found", 
+						"status": 404
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 500 when error", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			UpdateFunc: func(ctx context.Context, user *service.User, match service.VersionMatch) error {
+				return errors.New("error")
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PUT("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"1"`}).
+			SetJSON(gofight.D{
+				"id":    1,
+				"name":  "Name Name 1",
+				"email": "email1@email.com",
+				"age":   37,
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusInternalServerError, r.Code)
+				require.JSONEq(
+					t,
+					`{
+						"error_code": "ErrInternalServer",
+						"error_message": "internal server error",
 						"status": 500
 					}`,
 					r.Body.String(),
 				)
 			})
 	})
+
+	t.Run("returns 400 when invalid email", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PUT("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"1"`}).
+			SetJSON(gofight.D{
+				"id":    1,
+				"name":  "Name Name 1",
+				"email": "invalid",
+				"age":   37,
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusBadRequest, r.Code)
+				require.JSONEq(
+					t,
+					`{
+						"error_code": "ErrValidationFailed",
+						"error_message": "validation failed",
+						"status": 400
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("additional fields are ignored", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			UpdateFunc: func(ctx context.Context, user *service.User, match service.VersionMatch) error {
+				return nil
+			},
+		}
+
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PUT("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"1"`}).
+			SetJSON(gofight.D{
+				"id":    1,
+				"name":  "Name Name 1",
+				"email": "email1@email.com",
+				"age":   37,
+				"foo":   "bar",
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusOK, r.Code)
+			})
+	})
+
+	t.Run("conflict with other user", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			UpdateFunc: func(ctx context.Context, user *service.User, match service.VersionMatch) error {
+				return service.ErrUserAlreadyExists
+			},
+		}
+
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PUT("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"1"`}).
+			SetJSON(gofight.D{
+				"id":    1,
+				"name":  "Name Name 1",
+				"email": "email1@email.com",
+				"age":   37,
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusConflict, r.Code)
+				require.JSONEq(
+					t,
+					`{
+						"error_code": "ErrUserAlreadyExists", 
+						"error_message": "user already exists", 
+						"status": 409
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 428 when If-Match is missing", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PUT("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			SetJSON(gofight.D{
+				"id":    1,
+				"name":  "Name Name 1",
+				"email": "email1@email.com",
+				"age":   37,
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusPreconditionRequired, r.Code)
+				require.JSONEq(
+					t,
+					`{
+						"error_code": "ErrPreconditionRequired",
+						"error_message": "If-Match header is required",
+						"status": 428
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 412 when version does not match", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			UpdateFunc: func(ctx context.Context, user *service.User, match service.VersionMatch) error {
+				return service.ErrVersionConflict
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PUT("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"1"`}).
+			SetJSON(gofight.D{
+				"id":    1,
+				"name":  "Name Name 1",
+				"email": "email1@email.com",
+				"age":   37,
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusPreconditionFailed, r.Code)
+				require.JSONEq(
+					t,
+					`{
+						"error_code": "ErrPreconditionFailed",
+						"error_message": "user has been modified",
+						"status": 412
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("updates current version when If-Match is any version", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			UpdateFunc: func(ctx context.Context, user *service.User, match service.VersionMatch) error {
+				assert.Equal(t, 1, user.ID)
+				assert.Equal(t, service.VersionMatch{Any: true}, match)
+				return nil
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PUT("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `*`}).
+			SetJSON(gofight.D{
+				"id":    1,
+				"name":  "Name Name 1",
+				"email": "email1@email.com",
+				"age":   37,
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusOK, r.Code)
+			})
+	})
+
+	t.Run("returns 412 when If-Match is not a version", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PUT("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `W/"1"`}).
+			SetJSON(gofight.D{
+				"id":    1,
+				"name":  "Name Name 1",
+				"email": "email1@email.com",
+				"age":   37,
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusPreconditionFailed, r.Code)
+			})
+	})
+}
+
+func TestPatch(t *testing.T) {
+	t.Run("applies merge patch to present fields", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			PatchFunc: func(ctx context.Context, patch *service.UserPatch, match service.VersionMatch) (*service.User, error) {
+				require.NotNil(t, patch.Age)
+				assert.Equal(t, 1, patch.ID)
+				assert.Equal(t, service.MatchVersion(3), match)
+				assert.Nil(t, patch.Name)
+				assert.Nil(t, patch.Email)
+				assert.Equal(t, 38, *patch.Age)
+				return &service.User{ID: 1, Name: "Name Name 1", Email: "email1@email.com", Age: 38, Version: 4}, nil
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PATCH("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"3"`, "Content-Type": "application/merge-patch+json"}).
+			SetBody(`{"age": 38}`).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusOK, r.Code)
+				assert.Equal(t, `"4"`, r.HeaderMap.Get("ETag"))
+				assert.JSONEq(t,
+					`{
+						"id": 1,
+						"name": "Name Name 1",
+						"email": "email1@email.com",
+						"age": 38
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("rejects merge patch removing field", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		controller := controller.NewUserController(&userServiceMock{}, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PATCH("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"1"`, "Content-Type": "application/merge-patch+json"}).
+			SetBody(`{"name": "Name", "email": null}`).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusBadRequest, r.Code)
+				assert.JSONEq(t,
+					`{
+						"error_code": "ErrInvalidField",
+						"error_message": "cannot be removed",
+						"status": 400,
+						"field": "email"
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("applies json patch and patches only changed fields", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			PatchCurrentFunc: patchCurrentFunc(&service.User{
+				ID:        1,
+				Name:      "Name Name 1",
+				Email:     "email1@email.com",
+				Age:       37,
+				Version:   2,
+				CreatedAt: time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC),
+				UpdatedAt: time.Date(2023, 3, 2, 12, 0, 0, 0, time.UTC),
+			}, func(patch *service.UserPatch) (*service.User, error) {
+				require.NotNil(t, patch.Name)
+				assert.Equal(t, "Renamed", *patch.Name)
+				assert.Nil(t, patch.Email)
+				assert.Nil(t, patch.Age)
+				return &service.User{ID: 1, Name: "Renamed", Email: "email1@email.com", Age: 37, Version: 3}, nil
+			}),
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PATCH("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"2"`, "Content-Type": "application/json-patch+json"}).
+			SetBody(`[
+				{"op": "test", "path": "/age", "value": 37},
+				{"op": "replace", "path": "/name", "value": "Renamed"},
+				{"op": "replace", "path": "/email", "value": "email1@email.com"}
+			]`).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusOK, r.Code)
+				assert.Equal(t, `"3"`, r.HeaderMap.Get("ETag"))
+			})
+	})
+
+	t.Run("applies json patch when If-Match list contains current version", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			PatchCurrentFunc: patchCurrentFunc(&service.User{ID: 1, Name: "Name Name 1", Email: "email1@email.com", Age: 37, Version: 2}, func(patch *service.UserPatch) (*service.User, error) {
+				return &service.User{ID: 1, Name: "Renamed", Email: "email1@email.com", Age: 37, Version: 3}, nil
+			}),
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PATCH("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"1", W/"3", "2"`, "Content-Type": "application/json-patch+json"}).
+			SetBody(`[{"op": "replace", "path": "/name", "value": "Renamed"}]`).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusOK, r.Code)
+				assert.Equal(t, `"3"`, r.HeaderMap.Get("ETag"))
+			})
+	})
+
+	t.Run("returns 400 when json patch changes timestamp", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			PatchCurrentFunc: patchCurrentFunc(&service.User{
+				ID:        1,
+				Name:      "Name Name 1",
+				Email:     "email1@email.com",
+				Age:       37,
+				Version:   2,
+				CreatedAt: time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC),
+				UpdatedAt: time.Date(2023, 3, 2, 12, 0, 0, 0, time.UTC),
+			}, nil),
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PATCH("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"2"`, "Content-Type": "application/json-patch+json"}).
+			SetBody(`[{"op": "replace", "path": "/updated_at", "value": "2020-01-01T00:00:00Z"}]`).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusBadRequest, r.Code)
+				assert.JSONEq(t,
+					`{
+						"error_code": "ErrInvalidField",
+						"error_message": "cannot be changed",
+						"status": 400,
+						"field": "updated_at"
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 409 when json patch test fails", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			PatchCurrentFunc: patchCurrentFunc(&service.User{ID: 1, Name: "Name Name 1", Email: "email1@email.com", Age: 37, Version: 2}, nil),
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PATCH("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"2"`, "Content-Type": "application/json-patch+json"}).
+			SetBody(`[{"op": "test", "path": "/age", "value": 40}, {"op": "replace", "path": "/age", "value": 41}]`).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusConflict, r.Code)
+				assert.Contains(t, r.Body.String(), "ErrPatchConflict")
+			})
+	})
+
+	t.Run("returns 412 when json patch is based on stale version", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			PatchCurrentFunc: patchCurrentFunc(&service.User{ID: 1, Name: "Name Name 1", Email: "email1@email.com", Age: 37, Version: 3}, nil),
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PATCH("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"2"`, "Content-Type": "application/json-patch+json"}).
+			SetBody(`[{"op": "replace", "path": "/age", "value": 41}]`).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusPreconditionFailed, r.Code)
+			})
+	})
+
+	t.Run("returns 409 when email is taken", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			PatchFunc: func(ctx context.Context, patch *service.UserPatch, match service.VersionMatch) (*service.User, error) {
+				return nil, service.ErrUserAlreadyExists
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PATCH("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"1"`, "Content-Type": "application/merge-patch+json"}).
+			SetBody(`{"email": "email2@email.com"}`).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusConflict, r.Code)
+				assert.Contains(t, r.Body.String(), "ErrUserAlreadyExists")
+			})
+	})
+
+	t.Run("returns 415 with accepted patch types for other content types", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		controller := controller.NewUserController(&userServiceMock{}, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PATCH("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"1"`}).
+			SetJSON(gofight.D{"age": 38}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusUnsupportedMediaType, r.Code)
+				assert.Equal(t, "application/merge-patch+json, application/json-patch+json", r.HeaderMap.Get("Accept-Patch"))
+			})
+	})
+
+	t.Run("returns 428 without If-Match", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		controller := controller.NewUserController(&userServiceMock{}, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.PATCH("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "Content-Type": "application/merge-patch+json"}).
+			SetBody(`{"age": 38}`).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusPreconditionRequired, r.Code)
+			})
+	})
+}
+
+func TestDelete(t *testing.T) {
+	t.Run("matches If-Match with current version", func(t *testing.T) {
+		t.Parallel()
+
+		tests := map[string]struct {
+			ifMatch string
+			status  int
+		}{
+			"any version":               {`*`, http.StatusOK},
+			"list with current version": {`"1", "2"`, http.StatusOK},
+			"list with empty elements":  {`, "2" ,`, http.StatusOK},
+			"list without current":      {`"1","3"`, http.StatusPreconditionFailed},
+			"weak current version":      {`W/"2"`, http.StatusPreconditionFailed},
+			"weak and strong version":   {`W/"2", "2"`, http.StatusOK},
+			"weak current and strong":   {`W/"2", "1"`, http.StatusPreconditionFailed},
+			"tag containing comma":      {`"2,3"`, http.StatusPreconditionFailed},
+			"tags without comma":        {`"1" "2"`, http.StatusPreconditionFailed},
+			"unquoted version":          {`2`, http.StatusPreconditionFailed},
+		}
+		for name, testCase := range tests {
+			testCase := testCase
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				// Arrange
+				serviceMock := &userServiceMock{
+					DeleteFunc: func(ctx context.Context, id int, match service.VersionMatch) error {
+						if !match.Matches(2) {
+							return service.ErrVersionConflict
+						}
+						return nil
+					},
+				}
+				controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+				router := gin.Default()
+				controller.ConfigureRoutes(router)
+				r := gofight.New()
+
+				// Act
+				r.DELETE("/v1/users/1").
+					SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": testCase.ifMatch}).
+					Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+						// Assert
+						assert.Equal(t, testCase.status, r.Code)
+					})
+			})
+		}
+	})
+
+	t.Run("returns 404 for any version of user not found", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			DeleteFunc: func(ctx context.Context, id int, match service.VersionMatch) error {
+				assert.Equal(t, service.VersionMatch{Any: true}, match)
+				return service.ErrUserNotFound
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.DELETE("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `*`}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				assert.Equal(t, http.StatusNotFound, r.Code)
+			})
+	})
+
+	t.Run("deletes user", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			DeleteFunc: func(ctx context.Context, id int, match service.VersionMatch) error {
+				assert.Equal(t, 1, id)
+				assert.Equal(t, service.MatchVersion(1), match)
+				return nil
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.DELETE("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"1"`}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusOK, r.Code)
+			})
+	})
+
+	t.Run("returns 404 when user not found", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			DeleteFunc: func(ctx context.Context, id int, match service.VersionMatch) error {
+				return service.ErrUserNotFound
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.DELETE("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"1"`}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusNotFound, r.Code)
+				require.JSONEq(
+					t,
+					`{
+						"error_code": "ErrUserNotFound", 
+						"error_message": "user not found", 
+						"status": 404
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 500 when error", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			DeleteFunc: func(ctx context.Context, id int, match service.VersionMatch) error {
+				return errors.New("error")
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.DELETE("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"1"`}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusInternalServerError, r.Code)
+				require.JSONEq(
+					t,
+					`{
+						"error_code": "ErrInternalServer",
+						"error_message": "internal server error",
+						"status": 500
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 428 when If-Match is missing", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.DELETE("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusPreconditionRequired, r.Code)
+			})
+	})
+
+	t.Run("returns 412 when version does not match", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			DeleteFunc: func(ctx context.Context, id int, match service.VersionMatch) error {
+				return service.ErrVersionConflict
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.DELETE("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "If-Match": `"1"`}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusPreconditionFailed, r.Code)
+			})
+	})
+}
+
+func TestRestore(t *testing.T) {
+	t.Run("restores user", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			RestoreFunc: func(ctx context.Context, id int) error {
+				assert.Equal(t, 1, id)
+				return nil
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/users/1/restore").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusOK, r.Code)
+			})
+	})
+
+	t.Run("returns 404 when user not found", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			RestoreFunc: func(ctx context.Context, id int) error {
+				return service.ErrUserNotFound
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/users/1/restore").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusNotFound, r.Code)
+				require.JSONEq(
+					t,
+					`{
+						"error_code": "ErrUserNotFound",
+						"error_message": "user not found",
+						"status": 404
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns conflict when email is taken", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			RestoreFunc: func(ctx context.Context, id int) error {
+				return service.ErrUserAlreadyExists
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/users/1/restore").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusConflict, r.Code)
+				require.JSONEq(
+					t,
+					`{
+						"error_code": "ErrUserAlreadyExists",
+						"error_message": "user already exists",
+						"status": 409
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 400 when invalid id", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/users/invalid/restore").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusBadRequest, r.Code)
+			})
+	})
+}
+
+func TestGetHistory(t *testing.T) {
+	t.Run("returns history", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		changedAt := time.Date(2023, 4, 1, 14, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+		serviceMock := &userServiceMock{
+			GetHistoryFunc: func(ctx context.Context, query *service.UserHistoryPageQuery) (*service.UserHistoryPage, error) {
+				assert.Equal(t, &service.UserHistoryPageQuery{UserID: 1, AfterID: 0, Limit: 1}, query)
+				return &service.UserHistoryPage{
+					Entries: []*service.UserHistoryEntry{
+						{
+							ID:        10,
+							UserID:    1,
+							Operation: "update",
+							ChangedBy: "admin",
+							ChangedAt: changedAt,
+							Before:    &service.User{ID: 1, Name: "Before", Email: "email1@email.com", Age: 37, Version: 1},
+							After:     &service.User{ID: 1, Name: "After", Email: "email1@email.com", Age: 37, Version: 2},
+						},
+					},
+					NextAfterID: 10,
+				}, nil
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/1/history?limit=1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusOK, r.Code)
+				assert.JSONEq(t,
+					`{
+						"entries": [
+							{
+								"id": 10,
+								"operation": "update",
+								"changed_by": "admin",
+								"changed_at": "2023-04-01T12:00:00Z",
+								"before": {"id": 1, "name": "Before", "email": "email1@email.com", "age": 37},
+								"after": {"id": 1, "name": "After", "email": "email1@email.com", "age": 37}
+							}
+						],
+						"next_cursor": "aWQ6MTA"
+					}`,
+					r.Body.String(),
+				)
+				assert.Equal(t, `</v1/users/1/history?cursor=aWQ6MTA&limit=1>; rel="next"`, r.HeaderMap.Get("Link"))
+			})
+	})
+
+	t.Run("returns 400 when invalid id", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/invalid/history").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusBadRequest, r.Code)
+			})
+	})
+}
+
+func TestActor(t *testing.T) {
+	t.Run("passes actor header to service", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			RestoreFunc: func(ctx context.Context, id int) error {
+				assert.Equal(t, "admin@email.com", actor.FromContext(ctx))
+				return nil
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/users/1/restore").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "X-Actor": "admin@email.com"}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusOK, r.Code)
+			})
+	})
+}
+
+func TestTenant(t *testing.T) {
+	t.Run("passes tenant header to service", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			GetFunc: func(ctx context.Context, id int) (*service.User, error) {
+				tenantID, ok := tenant.FromContext(ctx)
+				assert.True(t, ok)
+				assert.Equal(t, TENANT, tenantID)
+				return &service.User{ID: id, Version: 1}, nil
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusOK, r.Code)
+			})
+	})
+
+	t.Run("returns 400 without tenant", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		controller := controller.NewUserController(&userServiceMock{}, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/1").
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusBadRequest, r.Code)
+				assert.JSONEq(t,
+					`{
+						"error_code": "ErrTenantRequired",
+						"error_message": "X-Tenant-ID header is required",
+						"status": 400
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 400 with invalid tenant", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		controller := controller.NewUserController(&userServiceMock{}, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users").
+			SetHeader(gofight.H{"X-Tenant-ID": "tenant/1"}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusBadRequest, r.Code)
+				assert.Contains(t, r.Body.String(), "ErrInvalidTenant")
+			})
+	})
+
+	t.Run("prefers tenant of auth claim and rejects other header", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			GetFunc: func(ctx context.Context, id int) (*service.User, error) {
+				tenantID, _ := tenant.FromContext(ctx)
+				assert.Equal(t, "claimed", tenantID)
+				return &service.User{ID: id, Version: 1}, nil
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		// Stands in for an authentication middleware that puts the tenant claim of a verified token into the context
+		router.Use(func(ctx *gin.Context) {
+			ctx.Request = ctx.Request.WithContext(tenant.NewContext(ctx.Request.Context(), "claimed"))
+		})
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/1").
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusOK, r.Code)
+			})
+		r.GET("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusForbidden, r.Code)
+				assert.Contains(t, r.Body.String(), "ErrTenantMismatch")
+			})
+	})
+}
+
+func TestSession(t *testing.T) {
+	t.Run("passes session header to service and returns it", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			GetFunc: func(ctx context.Context, id int) (*service.User, error) {
+				sessionID, ok := session.FromContext(ctx)
+				assert.True(t, ok)
+				assert.Equal(t, "session1", sessionID)
+				return &service.User{ID: id, Version: 1}, nil
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "X-Session-ID": "session1"}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusOK, r.Code)
+				assert.Equal(t, "session1", r.HeaderMap.Get("X-Session-ID"))
+			})
+	})
+
+	t.Run("starts a new session without a valid session header", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		var sessionID string
+		serviceMock := &userServiceMock{
+			GetFunc: func(ctx context.Context, id int) (*service.User, error) {
+				sessionID, _ = session.FromContext(ctx)
+				return &service.User{ID: id, Version: 1}, nil
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT, "X-Session-ID": "session/1"}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusOK, r.Code)
+				assert.True(t, session.IsValid(sessionID))
+				assert.Equal(t, sessionID, r.HeaderMap.Get("X-Session-ID"))
+			})
+	})
+}
+
+func TestCreateBatch(t *testing.T) {
+	t.Run("creates every user that can be created in partial mode", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			CreateBatchFunc: func(ctx context.Context, users []*service.User, atomic bool) ([]*service.BatchCreateResult, error) {
+				assert.False(t, atomic)
+				require.Len(t, users, 2)
+				assert.Equal(t, "email1@email.com", users[0].Email)
+				assert.Equal(t, "email2@email.com", users[1].Email)
+				return []*service.BatchCreateResult{
+					{User: &service.User{ID: 1, Name: "Name Name 1", Email: "email1@email.com", Age: 37, Version: 1}},
+					{Err: service.ErrUserAlreadyExists},
+				}, nil
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/users:batch").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			SetJSON(gofight.D{
+				"mode": "partial",
+				"users": []gofight.D{
+					{"name": "Name Name 1", "email": "email1@email.com", "age": 37},
+					{"name": "Name Name 2", "email": "not an email", "age": 37},
+					{"name": "Name Name 2", "email": "email2@email.com", "age": 37},
+				},
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusMultiStatus, r.Code)
+
+				assert.JSONEq(t,
+					`{
+						"results": [
+							{"status": 201, "user": {"id": 1, "name": "Name Name 1", "email": "email1@email.com", "age": 37}},
+							{"status": 400, "error": {"error_code": "ErrValidationFailed", "error_message": "validation failed", "status": 400}},
+							{"status": 409, "error": {"error_code": "ErrUserAlreadyExists", "error_message": "user already exists", "status": 409}}
+						]
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("creates all users in atomic mode", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			CreateBatchFunc: func(ctx context.Context, users []*service.User, atomic bool) ([]*service.BatchCreateResult, error) {
+				assert.True(t, atomic)
+				return []*service.BatchCreateResult{
+					{User: &service.User{ID: 1, Name: "Name Name 1", Email: "email1@email.com", Age: 37, Version: 1}},
+				}, nil
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/users:batch").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			SetJSON(gofight.D{
+				"users": []gofight.D{
+					{"name": "Name Name 1", "email": "email1@email.com", "age": 37},
+				},
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusCreated, r.Code)
+			})
+	})
+
+	t.Run("returns status of failed user in atomic mode", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			CreateBatchFunc: func(ctx context.Context, users []*service.User, atomic bool) ([]*service.BatchCreateResult, error) {
+				return []*service.BatchCreateResult{
+					{Err: service.ErrBatchAborted},
+					{Err: service.ErrUserAlreadyExists},
+				}, nil
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/users:batch").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			SetJSON(gofight.D{
+				"mode": "atomic",
+				"users": []gofight.D{
+					{"name": "Name Name 1", "email": "email1@email.com", "age": 37},
+					{"name": "Name Name 2", "email": "email2@email.com", "age": 37},
+				},
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusConflict, r.Code)
+				assert.Contains(t, r.Body.String(), "ErrBatchAborted")
+			})
+	})
+
+	t.Run("aborts atomic batch with invalid user without calling service", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/users:batch").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			SetJSON(gofight.D{
+				"users": []any{
+					gofight.D{"name": "Name Name 1", "email": "email1@email.com", "age": 37},
+					"not a user",
+				},
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusBadRequest, r.Code)
+
+				assert.JSONEq(t,
+					`{
+						"results": [
+							{"status": 424, "error": {"error_code": "ErrBatchAborted", "error_message": "user not created because another user in the batch failed", "status": 424}},
+							{"status": 400, "error": {"error_code": "ErrValidationFailed", "error_message": "validation failed", "status": 400}}
+						]
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 400 when batch is empty", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/users:batch").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			SetJSON(gofight.D{"users": []gofight.D{}}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusBadRequest, r.Code)
+				assert.Contains(t, r.Body.String(), `"field":"users"`)
+			})
+	})
+
+	t.Run("returns 400 when mode is unknown", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/users:batch").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			SetJSON(gofight.D{"mode": "sometimes", "users": []gofight.D{{"name": "Name Name 1", "email": "email1@email.com", "age": 37}}}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusBadRequest, r.Code)
+			})
+	})
+
+	t.Run("returns 404 for unknown action", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/users:import").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusNotFound, r.Code)
+			})
+	})
+}
+
+func exportUsersFunc(users ...*service.User) func(ctx context.Context, filter *service.UserFilter, fn func(user *service.User) error) error {
+	return func(ctx context.Context, filter *service.UserFilter, fn func(user *service.User) error) error {
+		for _, user := range users {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestExport(t *testing.T) {
+	t.Run("exports csv by default", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			ExportFunc: exportUsersFunc(
+				&service.User{
+					ID:        1,
+					Name:      "Name Name 1",
+					Email:     "email1@email.com",
+					Age:       37,
+					CreatedAt: time.Date(2023, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600)),
+					UpdatedAt: time.Date(2023, 3, 2, 12, 0, 0, 0, time.UTC),
+				},
+				&service.User{ID: 2, Name: "Name, Name 2", Email: "email2@email.com", Age: 102},
+			),
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/export").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusOK, r.Code)
+				assert.Equal(t, "text/csv; charset=utf-8", r.HeaderMap.Get("Content-Type"))
+				assert.Equal(t, `attachment; filename="users.csv"`, r.HeaderMap.Get("Content-Disposition"))
+				assert.Equal(t, "id,name,email,age,created_at,updated_at\n"+
+					"1,Name Name 1,email1@email.com,37,2023-03-01T11:00:00Z,2023-03-02T12:00:00Z\n"+
+					"2,\"Name, Name 2\",email2@email.com,102,,\n", r.Body.String())
+			})
+	})
+
+	t.Run("exports ndjson with filter", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			ExportFunc: func(ctx context.Context, filter *service.UserFilter, fn func(user *service.User) error) error {
+				assert.Equal(t, &service.UserFilter{EmailDomain: "email.com"}, filter)
+				return exportUsersFunc(
+					&service.User{ID: 1, Name: "Name Name 1", Email: "email1@email.com", Age: 37},
+				)(ctx, filter, fn)
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/export?format=ndjson&email_domain=email.com").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusOK, r.Code)
+				assert.Equal(t, "application/x-ndjson", r.HeaderMap.Get("Content-Type"))
+				assert.Equal(t, `{"id":1,"name":"Name Name 1","email":"email1@email.com","age":37}`+"\n", r.Body.String())
+			})
+	})
+
+	t.Run("exports csv header when there are no users", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			ExportFunc: exportUsersFunc(),
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/export?format=csv").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusOK, r.Code)
+				assert.Equal(t, "id,name,email,age,created_at,updated_at\n", r.Body.String())
+			})
+	})
+
+	t.Run("returns 400 when format is unknown", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/export?format=xml").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusBadRequest, r.Code)
+				assert.Contains(t, r.Body.String(), `"field":"format"`)
+			})
+	})
+
+	t.Run("returns error when export fails before the first user", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			ExportFunc: func(ctx context.Context, filter *service.UserFilter, fn func(user *service.User) error) error {
+				return errors.New("connection refused")
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		router := gin.Default()
+		controller.ConfigureRoutes(router)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/users/export").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				require.Equal(t, http.StatusInternalServerError, r.Code)
+				assert.Equal(t, "application/json; charset=utf-8", r.HeaderMap.Get("Content-Type"))
+			})
+	})
+
+	t.Run("aborts connection when export fails after the first user", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &userServiceMock{
+			ExportFunc: func(ctx context.Context, filter *service.UserFilter, fn func(user *service.User) error) error {
+				// Enough users for the response to be flushed before the export fails
+				for id := 1; id <= 100; id++ {
+					if err := fn(&service.User{ID: id, Name: "Name", Email: fmt.Sprintf("email%d@email.com", id), Age: 37}); err != nil {
+						return err
+					}
+				}
+				return errors.New("connection reset")
+			},
+		}
+		controller := controller.NewUserController(serviceMock, zap.NewNop())
+
+		// A server is needed to observe the aborted connection, the router has no recovery so the panic reaches the server
+		router := gin.New()
+		controller.ConfigureRoutes(router)
+		server := httptest.NewServer(router)
+		defer server.Close()
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/v1/users/export", nil)
+		require.NoError(t, err)
+		request.Header.Set("X-Tenant-ID", TENANT)
+
+		// Act
+		response, err := server.Client().Do(request)
+		require.NoError(t, err)
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+
+		// Assert
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.Contains(t, string(body), "email1@email.com")
+	})
 }
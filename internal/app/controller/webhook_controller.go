@@ -0,0 +1,227 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tobiassundman/go-demo-app/internal/app/service"
+	"go.uber.org/zap"
+)
+
+// WebhookController is the controller for the webhook resource.
+type WebhookController struct {
+	logger         *zap.Logger
+	webhookService service.WebhookService
+}
+
+func NewWebhookController(service service.WebhookService, logger *zap.Logger) *WebhookController {
+	return &WebhookController{
+		logger:         logger,
+		webhookService: service,
+	}
+}
+
+// ConfigureRoutes configures the routes for the webhook resource.
+// The middlewares run after the tenant of the request is put into the request context.
+func (c *WebhookController) ConfigureRoutes(router *gin.Engine, middlewares ...gin.HandlerFunc) {
+	webhookGroup := router.Group("/v1", append([]gin.HandlerFunc{actorMiddleware, tenantMiddleware, sessionMiddleware}, middlewares...)...)
+	webhookGroup.GET("/webhooks", c.getWebhooks)
+	webhookGroup.GET("/webhooks/:id", c.getWebhook)
+	webhookGroup.POST("/webhooks", c.createWebhook)
+	webhookGroup.PUT("/webhooks/:id", c.updateWebhook)
+	webhookGroup.DELETE("/webhooks/:id", c.deleteWebhook)
+	webhookGroup.GET("/webhooks/:id/deliveries", c.getDeliveries)
+	webhookGroup.POST("/webhooks/:id/deliveries/:deliveryId/redeliver", c.redeliver)
+}
+
+// getWebhooks returns all webhooks.
+func (c *WebhookController) getWebhooks(ctx *gin.Context) {
+	webhooks, err := c.webhookService.GetAll(ctx.Request.Context())
+	if err != nil {
+		c.logger.Error("Failed to get webhooks", zap.Error(err))
+		apiError := apiErrorFromServiceError(err)
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	response := GetWebhooksResponse{
+		Webhooks: make([]*Webhook, len(webhooks)),
+	}
+	for i, webhook := range webhooks {
+		response.Webhooks[i] = serviceWebhookToControllerWebhook(webhook)
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// getWebhook returns a single webhook by id.
+func (c *WebhookController) getWebhook(ctx *gin.Context) {
+	id, ok := c.parseID(ctx, "id")
+	if !ok {
+		return
+	}
+
+	webhook, err := c.webhookService.Get(ctx.Request.Context(), id)
+	if err != nil {
+		apiError := apiErrorFromServiceError(err)
+		if apiError != ErrWebhookNotFound {
+			c.logger.Warn("Failed to get webhook", zap.Error(err), zap.Int("id", id))
+		}
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, serviceWebhookToControllerWebhook(webhook))
+}
+
+// createWebhook creates a new webhook and returns it together with its secret.
+func (c *WebhookController) createWebhook(ctx *gin.Context) {
+	request := WebhookRequest{}
+	err := ctx.BindJSON(&request)
+	if err != nil {
+		c.logger.Warn("Failed to parse webhook", zap.Error(err))
+		ctx.JSON(ErrValidationFailed.Status, ErrValidationFailed)
+		return
+	}
+
+	webhook, err := c.webhookService.Create(ctx.Request.Context(), webhookRequestToServiceWebhook(0, &request))
+	if err != nil {
+		apiError := apiErrorFromServiceError(err)
+		if apiError.Status >= http.StatusInternalServerError {
+			c.logger.Error("Failed to create webhook", zap.Error(err), zap.String("url", request.URL))
+		}
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	// The secret is only returned once, the receiver needs it to verify the deliveries
+	response := serviceWebhookToControllerWebhook(webhook)
+	response.Secret = webhook.Secret
+	ctx.JSON(http.StatusCreated, response)
+}
+
+// updateWebhook updates an existing webhook by id.
+func (c *WebhookController) updateWebhook(ctx *gin.Context) {
+	id, ok := c.parseID(ctx, "id")
+	if !ok {
+		return
+	}
+	request := WebhookRequest{}
+	err := ctx.BindJSON(&request)
+	if err != nil {
+		c.logger.Warn("Failed to parse webhook", zap.Error(err))
+		ctx.JSON(ErrValidationFailed.Status, ErrValidationFailed)
+		return
+	}
+
+	err = c.webhookService.Update(ctx.Request.Context(), webhookRequestToServiceWebhook(id, &request))
+	if err != nil {
+		apiError := apiErrorFromServiceError(err)
+		if apiError.Status >= http.StatusInternalServerError {
+			c.logger.Error("Failed to update webhook", zap.Error(err), zap.Int("id", id))
+		}
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+// deleteWebhook deletes an existing webhook by id together with its deliveries.
+func (c *WebhookController) deleteWebhook(ctx *gin.Context) {
+	id, ok := c.parseID(ctx, "id")
+	if !ok {
+		return
+	}
+
+	err := c.webhookService.Delete(ctx.Request.Context(), id)
+	if err != nil {
+		apiError := apiErrorFromServiceError(err)
+		if apiError != ErrWebhookNotFound {
+			c.logger.Warn("Failed to delete webhook", zap.Error(err), zap.Int("id", id))
+		}
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+// getDeliveries returns a page of the deliveries to a webhook, oldest delivery first.
+func (c *WebhookController) getDeliveries(ctx *gin.Context) {
+	id, ok := c.parseID(ctx, "id")
+	if !ok {
+		return
+	}
+	limit, afterID, apiError := parsePagination(ctx)
+	if apiError != nil {
+		c.logger.Warn("Failed to parse page query", zap.String("query", ctx.Request.URL.RawQuery))
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	page, err := c.webhookService.GetDeliveries(ctx.Request.Context(), &service.WebhookDeliveryPageQuery{
+		WebhookID: id,
+		AfterID:   afterID,
+		Limit:     limit,
+	})
+	if err != nil {
+		apiError := apiErrorFromServiceError(err)
+		if apiError.Status >= http.StatusInternalServerError {
+			c.logger.Error("Failed to get webhook deliveries", zap.Error(err), zap.Int("id", id))
+		}
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	response := GetWebhookDeliveriesResponse{
+		Deliveries: make([]*WebhookDelivery, len(page.Deliveries)),
+	}
+	for i, delivery := range page.Deliveries {
+		response.Deliveries[i] = serviceDeliveryToControllerDelivery(delivery)
+	}
+	if page.NextAfterID != 0 {
+		response.NextCursor = encodeCursor(page.NextAfterID)
+		ctx.Header("Link", nextPageLink(ctx, response.NextCursor, limit))
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// redeliver delivers the event of a delivery to its webhook again and returns the new delivery.
+// The response is 200 even if the new delivery failed, the delivery tells whether it succeeded.
+func (c *WebhookController) redeliver(ctx *gin.Context) {
+	id, ok := c.parseID(ctx, "id")
+	if !ok {
+		return
+	}
+	deliveryID, ok := c.parseID(ctx, "deliveryId")
+	if !ok {
+		return
+	}
+
+	delivery, err := c.webhookService.Redeliver(ctx.Request.Context(), id, deliveryID)
+	if err != nil {
+		apiError := apiErrorFromServiceError(err)
+		if apiError.Status >= http.StatusInternalServerError {
+			c.logger.Error("Failed to redeliver webhook delivery", zap.Error(err), zap.Int("id", id), zap.Int("deliveryId", deliveryID))
+		}
+		writeAPIError(ctx, apiError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, serviceDeliveryToControllerDelivery(delivery))
+}
+
+// parseID parses an id path parameter, responding with ErrInvalidID if it is not a number.
+func (c *WebhookController) parseID(ctx *gin.Context, parameter string) (int, bool) {
+	id := ctx.Param(parameter)
+	parsedID, err := strconv.Atoi(id)
+	if err != nil {
+		c.logger.Warn("Failed to parse id", zap.Error(err), zap.String(parameter, id))
+		ctx.JSON(ErrInvalidID.Status, ErrInvalidID)
+		return 0, false
+	}
+	return parsedID, true
+}
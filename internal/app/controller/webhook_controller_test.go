@@ -0,0 +1,387 @@
+package controller_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/appleboy/gofight/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/controller"
+	"github.com/tobiassundman/go-demo-app/internal/app/service"
+	"go.uber.org/zap"
+)
+
+var _ service.WebhookService = &webhookServiceMock{}
+
+type webhookServiceMock struct {
+	GetAllFunc        func(ctx context.Context) ([]*service.Webhook, error)
+	GetFunc           func(ctx context.Context, id int) (*service.Webhook, error)
+	CreateFunc        func(ctx context.Context, webhook *service.Webhook) (*service.Webhook, error)
+	UpdateFunc        func(ctx context.Context, webhook *service.Webhook) error
+	DeleteFunc        func(ctx context.Context, id int) error
+	GetDeliveriesFunc func(ctx context.Context, query *service.WebhookDeliveryPageQuery) (*service.WebhookDeliveryPage, error)
+	RedeliverFunc     func(ctx context.Context, webhookID, deliveryID int) (*service.WebhookDelivery, error)
+	DeliverFunc       func(ctx context.Context, event *service.WebhookEvent) error
+}
+
+func (m *webhookServiceMock) GetAll(ctx context.Context) ([]*service.Webhook, error) {
+	return m.GetAllFunc(ctx)
+}
+
+func (m *webhookServiceMock) Get(ctx context.Context, id int) (*service.Webhook, error) {
+	return m.GetFunc(ctx, id)
+}
+
+func (m *webhookServiceMock) Create(ctx context.Context, webhook *service.Webhook) (*service.Webhook, error) {
+	return m.CreateFunc(ctx, webhook)
+}
+
+func (m *webhookServiceMock) Update(ctx context.Context, webhook *service.Webhook) error {
+	return m.UpdateFunc(ctx, webhook)
+}
+
+func (m *webhookServiceMock) Delete(ctx context.Context, id int) error {
+	return m.DeleteFunc(ctx, id)
+}
+
+func (m *webhookServiceMock) GetDeliveries(ctx context.Context, query *service.WebhookDeliveryPageQuery) (*service.WebhookDeliveryPage, error) {
+	return m.GetDeliveriesFunc(ctx, query)
+}
+
+func (m *webhookServiceMock) Redeliver(ctx context.Context, webhookID, deliveryID int) (*service.WebhookDelivery, error) {
+	return m.RedeliverFunc(ctx, webhookID, deliveryID)
+}
+
+func (m *webhookServiceMock) Deliver(ctx context.Context, event *service.WebhookEvent) error {
+	return m.DeliverFunc(ctx, event)
+}
+
+var WEBHOOK_CREATED_AT = time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+
+// newWebhookRouter creates a router serving the webhook controller over the service mock.
+func newWebhookRouter(serviceMock *webhookServiceMock) *gin.Engine {
+	router := gin.Default()
+	controller.NewWebhookController(serviceMock, zap.NewNop()).ConfigureRoutes(router)
+	return router
+}
+
+func TestCreateWebhook(t *testing.T) {
+	t.Run("returns created webhook with secret", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &webhookServiceMock{
+			CreateFunc: func(ctx context.Context, webhook *service.Webhook) (*service.Webhook, error) {
+				assert.Equal(t, &service.Webhook{URL: "https://partner.example.com/hooks", EventTypes: []string{"user.created"}}, webhook)
+				return &service.Webhook{
+					ID:         1,
+					URL:        webhook.URL,
+					Secret:     "generated-secret",
+					EventTypes: webhook.EventTypes,
+					CreatedAt:  WEBHOOK_CREATED_AT,
+				}, nil
+			},
+		}
+		router := newWebhookRouter(serviceMock)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/webhooks").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			SetJSON(gofight.D{
+				"url":         "https://partner.example.com/hooks",
+				"event_types": []string{"user.created"},
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusCreated, r.Code)
+				assert.JSONEq(t,
+					`{
+						"id": 1,
+						"url": "https://partner.example.com/hooks",
+						"secret": "generated-secret",
+						"event_types": ["user.created"],
+						"created_at": "2023-04-01T12:00:00Z"
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 400 with field when service rejects webhook", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &webhookServiceMock{
+			CreateFunc: func(ctx context.Context, webhook *service.Webhook) (*service.Webhook, error) {
+				return nil, &service.FieldError{Field: "event_types", Message: `unknown event type "user.purged"`}
+			},
+		}
+		router := newWebhookRouter(serviceMock)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/webhooks").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			SetJSON(gofight.D{
+				"url":         "https://partner.example.com/hooks",
+				"event_types": []string{"user.purged"},
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusBadRequest, r.Code)
+				assert.JSONEq(t,
+					`{
+						"error_code": "ErrInvalidField",
+						"error_message": "unknown event type \"user.purged\"",
+						"status": 400,
+						"field": "event_types"
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 400 when url is missing", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		router := newWebhookRouter(&webhookServiceMock{})
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/webhooks").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			SetJSON(gofight.D{
+				"event_types": []string{"user.created"},
+			}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusBadRequest, r.Code)
+			})
+	})
+}
+
+func TestGetWebhook(t *testing.T) {
+	t.Run("returns webhook without secret", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &webhookServiceMock{
+			GetFunc: func(ctx context.Context, id int) (*service.Webhook, error) {
+				return &service.Webhook{
+					ID:         id,
+					URL:        "https://partner.example.com/hooks",
+					Secret:     "stored-secret",
+					EventTypes: []string{"user.deleted"},
+					CreatedAt:  WEBHOOK_CREATED_AT,
+				}, nil
+			},
+		}
+		router := newWebhookRouter(serviceMock)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/webhooks/4").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusOK, r.Code)
+				assert.JSONEq(t,
+					`{
+						"id": 4,
+						"url": "https://partner.example.com/hooks",
+						"event_types": ["user.deleted"],
+						"created_at": "2023-04-01T12:00:00Z"
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 404 when webhook does not exist", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &webhookServiceMock{
+			GetFunc: func(ctx context.Context, id int) (*service.Webhook, error) {
+				return nil, service.ErrWebhookNotFound
+			},
+		}
+		router := newWebhookRouter(serviceMock)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/webhooks/4").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusNotFound, r.Code)
+				assert.JSONEq(t,
+					`{
+						"error_code": "ErrWebhookNotFound",
+						"error_message": "webhook not found",
+						"status": 404
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 400 without tenant", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		router := newWebhookRouter(&webhookServiceMock{})
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/webhooks/4").
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusBadRequest, r.Code)
+				assert.JSONEq(t,
+					`{
+						"error_code": "ErrTenantRequired",
+						"error_message": "X-Tenant-ID header is required",
+						"status": 400
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+}
+
+func TestGetWebhookDeliveries(t *testing.T) {
+	t.Run("returns page of deliveries with next cursor", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &webhookServiceMock{
+			GetDeliveriesFunc: func(ctx context.Context, query *service.WebhookDeliveryPageQuery) (*service.WebhookDeliveryPage, error) {
+				assert.Equal(t, &service.WebhookDeliveryPageQuery{WebhookID: 2, AfterID: 0, Limit: 1}, query)
+				return &service.WebhookDeliveryPage{
+					Deliveries: []*service.WebhookDelivery{{
+						ID:          7,
+						WebhookID:   2,
+						EventID:     11,
+						EventType:   "user.created",
+						Payload:     `{"id": 11}`,
+						StatusCode:  500,
+						Attempts:    3,
+						Error:       "unexpected status 500",
+						DeliveredAt: WEBHOOK_CREATED_AT,
+					}},
+					NextAfterID: 7,
+				}, nil
+			},
+		}
+		router := newWebhookRouter(serviceMock)
+		r := gofight.New()
+
+		// Act
+		r.GET("/v1/webhooks/2/deliveries?limit=1").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusOK, r.Code)
+				assert.JSONEq(t,
+					`{
+						"deliveries": [{
+							"id": 7,
+							"event_id": 11,
+							"event_type": "user.created",
+							"payload": {"id": 11},
+							"status_code": 500,
+							"attempts": 3,
+							"succeeded": false,
+							"error": "unexpected status 500",
+							"delivered_at": "2023-04-01T12:00:00Z"
+						}],
+						"next_cursor": "aWQ6Nw"
+					}`,
+					r.Body.String(),
+				)
+				assert.Equal(t, `</v1/webhooks/2/deliveries?cursor=aWQ6Nw&limit=1>; rel="next"`, r.HeaderMap.Get("Link"))
+			})
+	})
+}
+
+func TestRedeliver(t *testing.T) {
+	t.Run("returns new delivery", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &webhookServiceMock{
+			RedeliverFunc: func(ctx context.Context, webhookID, deliveryID int) (*service.WebhookDelivery, error) {
+				assert.Equal(t, 2, webhookID)
+				assert.Equal(t, 7, deliveryID)
+				return &service.WebhookDelivery{
+					ID:          8,
+					WebhookID:   2,
+					EventID:     11,
+					EventType:   "user.created",
+					Payload:     `{"id": 11}`,
+					StatusCode:  200,
+					Attempts:    1,
+					DeliveredAt: WEBHOOK_CREATED_AT,
+				}, nil
+			},
+		}
+		router := newWebhookRouter(serviceMock)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/webhooks/2/deliveries/7/redeliver").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusOK, r.Code)
+				assert.JSONEq(t,
+					`{
+						"id": 8,
+						"event_id": 11,
+						"event_type": "user.created",
+						"payload": {"id": 11},
+						"status_code": 200,
+						"attempts": 1,
+						"succeeded": true,
+						"delivered_at": "2023-04-01T12:00:00Z"
+					}`,
+					r.Body.String(),
+				)
+			})
+	})
+
+	t.Run("returns 404 when delivery does not exist", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		serviceMock := &webhookServiceMock{
+			RedeliverFunc: func(ctx context.Context, webhookID, deliveryID int) (*service.WebhookDelivery, error) {
+				return nil, service.ErrDeliveryNotFound
+			},
+		}
+		router := newWebhookRouter(serviceMock)
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/webhooks/2/deliveries/7/redeliver").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusNotFound, r.Code)
+				assert.Contains(t, r.Body.String(), "ErrDeliveryNotFound")
+			})
+	})
+
+	t.Run("returns 400 when delivery id is invalid", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		router := newWebhookRouter(&webhookServiceMock{})
+		r := gofight.New()
+
+		// Act
+		r.POST("/v1/webhooks/2/deliveries/x/redeliver").
+			SetHeader(gofight.H{"X-Tenant-ID": TENANT}).
+			Run(router, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				// Assert
+				require.Equal(t, http.StatusBadRequest, r.Code)
+			})
+	})
+}
@@ -0,0 +1,92 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tobiassundman/go-demo-app/pkg/retry"
+)
+
+const (
+	// EventIDHeader carries the id of the published event, so that receivers can ignore events they already received.
+	EventIDHeader = "X-Event-Id"
+	// EventTypeHeader carries the type of the published event.
+	EventTypeHeader = "X-Event-Type"
+)
+
+// HTTPPublisherConfig configures an HTTPPublisher.
+type HTTPPublisherConfig struct {
+	// URL is where events are posted.
+	URL string
+	// Client posts the events, nil uses a client with a 10 second timeout.
+	Client *http.Client
+	// RetryTimeout is how long posting an event is retried with backoff before the event is left for the next batch.
+	RetryTimeout time.Duration
+}
+
+// HTTPPublisher publishes events by posting them as JSON to a URL, a 2xx response means the event was delivered.
+type HTTPPublisher struct {
+	url          string
+	client       *http.Client
+	retryTimeout time.Duration
+}
+
+// NewHTTPPublisher creates a new HTTPPublisher.
+func NewHTTPPublisher(config HTTPPublisherConfig) *HTTPPublisher {
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &HTTPPublisher{
+		url:          config.URL,
+		client:       client,
+		retryTimeout: config.RetryTimeout,
+	}
+}
+
+// Publish posts the event, retrying failed posts with backoff until the retry timeout.
+// Client errors other than 408 and 429 are not retried, since posting the same event again gets the same response.
+func (p *HTTPPublisher) Publish(ctx context.Context, event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return retry.RetryContext(ctx, p.retryTimeout, func() error {
+		return p.post(ctx, event, body)
+	})
+}
+
+// post posts the event once
+func (p *HTTPPublisher) post(ctx context.Context, event *Event, body []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventIDHeader, strconv.FormatInt(event.ID, 10))
+	request.Header.Set(EventTypeHeader, event.Type)
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	// Drain the body so that the connection can be reused
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("publishing event %d: unexpected status %d", event.ID, response.StatusCode)
+	if response.StatusCode >= 400 && response.StatusCode < 500 &&
+		response.StatusCode != http.StatusRequestTimeout && response.StatusCode != http.StatusTooManyRequests {
+		return retry.Permanent(err)
+	}
+	return err
+}
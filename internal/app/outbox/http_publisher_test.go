@@ -0,0 +1,140 @@
+package outbox_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/outbox"
+)
+
+var EVENT = outbox.Event{
+	ID:         7,
+	Type:       "user.created",
+	OccurredAt: time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC),
+	User: &outbox.User{
+		ID:      1,
+		Name:    "Name Name 1",
+		Email:   "email1@email.com",
+		Age:     37,
+		Version: 1,
+	},
+}
+
+func TestHTTPPublisher(t *testing.T) {
+	t.Parallel()
+	t.Run("posts event as json", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		var received outbox.Event
+		var header http.Header
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer server.Close()
+		publisher := outbox.NewHTTPPublisher(outbox.HTTPPublisherConfig{URL: server.URL, RetryTimeout: time.Second})
+
+		// Act
+		err := publisher.Publish(context.Background(), &EVENT)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, EVENT, received)
+		assert.Equal(t, "application/json", header.Get("Content-Type"))
+		assert.Equal(t, "7", header.Get(outbox.EventIDHeader))
+		assert.Equal(t, "user.created", header.Get(outbox.EventTypeHeader))
+	})
+
+	t.Run("retries server errors until delivered", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if attempts.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+		publisher := outbox.NewHTTPPublisher(outbox.HTTPPublisherConfig{URL: server.URL, RetryTimeout: 10 * time.Second})
+
+		// Act
+		err := publisher.Publish(context.Background(), &EVENT)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, int32(3), attempts.Load())
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+		publisher := outbox.NewHTTPPublisher(outbox.HTTPPublisherConfig{URL: server.URL, RetryTimeout: 10 * time.Second})
+
+		// Act
+		err := publisher.Publish(context.Background(), &EVENT)
+
+		// Assert
+		assert.ErrorContains(t, err, "unexpected status 400")
+		assert.Equal(t, int32(1), attempts.Load())
+	})
+
+	t.Run("gives up after retry timeout", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+		publisher := outbox.NewHTTPPublisher(outbox.HTTPPublisherConfig{URL: server.URL, RetryTimeout: 200 * time.Millisecond})
+
+		// Act
+		start := time.Now()
+		err := publisher.Publish(context.Background(), &EVENT)
+
+		// Assert
+		assert.ErrorContains(t, err, "unexpected status 429")
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("stops retrying when context is cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+		publisher := outbox.NewHTTPPublisher(outbox.HTTPPublisherConfig{URL: server.URL, RetryTimeout: time.Minute})
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		// Act
+		start := time.Now()
+		err := publisher.Publish(ctx, &EVENT)
+
+		// Assert
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+}
@@ -0,0 +1,31 @@
+package outbox
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// LoggingPublisher publishes events by logging them, for local development and systems without downstream consumers.
+type LoggingPublisher struct {
+	logger *zap.Logger
+}
+
+// NewLoggingPublisher creates a new LoggingPublisher.
+func NewLoggingPublisher(logger *zap.Logger) *LoggingPublisher {
+	return &LoggingPublisher{
+		logger: logger,
+	}
+}
+
+// Publish logs the event.
+func (p *LoggingPublisher) Publish(ctx context.Context, event *Event) error {
+	p.logger.Info("Published user event",
+		zap.Int64("eventId", event.ID),
+		zap.String("tenantId", event.TenantID),
+		zap.String("eventType", event.Type),
+		zap.Int("userId", event.User.ID),
+		zap.Int("userVersion", event.User.Version),
+	)
+	return nil
+}
@@ -0,0 +1,53 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+)
+
+// Publisher publishes user events to downstream systems.
+type Publisher interface {
+	// Publish publishes an event, returning an error if it may not have been delivered.
+	// Events are delivered at least once, so an event may be published again after it was delivered.
+	Publish(ctx context.Context, event *Event) error
+}
+
+// Event is a change of a user as it is published.
+type Event struct {
+	// ID identifies the event, an event that is published again has the same id.
+	ID int64 `json:"id"`
+	// TenantID is the tenant of the user.
+	TenantID string `json:"tenant_id"`
+	// Type is one of user.created, user.updated, user.deleted and user.restored.
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	// User is the user after the change, a deleted user as it was when it was deleted.
+	User *User `json:"user"`
+}
+
+// User is a user as it is published.
+type User struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Age     int    `json:"age"`
+	Version int    `json:"version"`
+}
+
+func repositoryEventToEvent(event *repository.OutboxEvent) *Event {
+	return &Event{
+		ID:         event.ID,
+		TenantID:   event.TenantID,
+		Type:       event.Type,
+		OccurredAt: event.CreatedAt.UTC(),
+		User: &User{
+			ID:      event.User.ID,
+			Name:    event.User.Name,
+			Email:   event.User.Email,
+			Age:     event.User.Age,
+			Version: event.User.Version,
+		},
+	}
+}
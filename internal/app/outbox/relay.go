@@ -0,0 +1,113 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"go.uber.org/zap"
+)
+
+// RelayConfig configures a Relay.
+type RelayConfig struct {
+	// BatchSize is the maximum number of events locked and published at a time.
+	BatchSize int
+	// Interval is how long the relay waits before looking for new events once the outbox is empty.
+	Interval time.Duration
+	// BatchTimeout bounds publishing a batch, 0 leaves it unbounded. It must be shorter than the lease of the events of a batch,
+	// so that they are not claimed and published by another relay while they are still being published.
+	BatchTimeout time.Duration
+	// Logger logs failures to publish, nil disables logging.
+	Logger *zap.Logger
+}
+
+// errBatchTimeout is returned to the outbox for the events of a batch that were not published within the batch timeout
+var errBatchTimeout = errors.New("batch timeout passed")
+
+// Relay publishes the events written to the outbox in the order they were written.
+// Several relays can publish from the same outbox, each event is then published by one of them but the order across relays is not kept.
+type Relay struct {
+	outbox       repository.OutboxRepository
+	publisher    Publisher
+	batchSize    int
+	interval     time.Duration
+	batchTimeout time.Duration
+	logger       *zap.Logger
+}
+
+// NewRelay creates a new Relay publishing the events of the outbox with the publisher.
+func NewRelay(outbox repository.OutboxRepository, publisher Publisher, config RelayConfig) *Relay {
+	logger := config.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Relay{
+		outbox:       outbox,
+		publisher:    publisher,
+		batchSize:    config.BatchSize,
+		interval:     config.Interval,
+		batchTimeout: config.BatchTimeout,
+		logger:       logger,
+	}
+}
+
+// PublishWaiting publishes batches of events until the outbox is empty or publishing fails, returning how many events were published.
+// An event that failed to publish stays in the outbox and is the first event published next time.
+// Publishing an event is cancelled when the batch timeout passes, the events of the batch that were not published are then published in the next batch.
+func (r *Relay) PublishWaiting(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		published, err := r.publishBatch(ctx)
+		total += published
+		// A batch that timed out without publishing any event would time out again
+		if errors.Is(err, errBatchTimeout) && published > 0 {
+			continue
+		}
+		if err != nil || published < r.batchSize {
+			return total, err
+		}
+	}
+}
+
+// publishBatch publishes a batch of events within the batch timeout, returning how many events were published
+func (r *Relay) publishBatch(ctx context.Context) (int, error) {
+	if r.batchTimeout <= 0 {
+		return r.outbox.PublishBatch(ctx, r.batchSize, func(ctx context.Context, event *repository.OutboxEvent) error {
+			return r.publisher.Publish(ctx, repositoryEventToEvent(event))
+		})
+	}
+	// The deadline only applies to publishing, the outbox still has to remove the published events after it has passed
+	deadline := time.Now().Add(r.batchTimeout)
+	return r.outbox.PublishBatch(ctx, r.batchSize, func(ctx context.Context, event *repository.OutboxEvent) error {
+		if !time.Now().Before(deadline) {
+			return errBatchTimeout
+		}
+		publishContext, cancel := context.WithDeadline(ctx, deadline)
+		defer cancel()
+		err := r.publisher.Publish(publishContext, repositoryEventToEvent(event))
+		if err != nil && publishContext.Err() != nil && ctx.Err() == nil {
+			return errors.Join(errBatchTimeout, err)
+		}
+		return err
+	})
+}
+
+// Run publishes the waiting events right away and then every interval until the context is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		published, err := r.PublishWaiting(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger.Error("Failed to publish user events", zap.Int("published", published), zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/outbox"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
+)
+
+var _ outbox.Publisher = &publisherMock{}
+
+type publisherMock struct {
+	PublishFunc func(ctx context.Context, event *outbox.Event) error
+}
+
+func (m *publisherMock) Publish(ctx context.Context, event *outbox.Event) error {
+	return m.PublishFunc(ctx, event)
+}
+
+// createUsers creates users of a tenant with distinct emails in the repository.
+func createUsers(t *testing.T, userRepository repository.UserRepository, count int) {
+	ctx := tenant.NewContext(context.Background(), "tenant1")
+	for i := 0; i < count; i++ {
+		_, err := userRepository.Create(ctx, &repository.User{
+			Name:  "Name",
+			Email: string(rune('a'+i)) + "@email.com",
+			Age:   20,
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestPublishWaiting(t *testing.T) {
+	t.Parallel()
+	t.Run("publishes every waiting event in order over several batches", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := repository.NewInMemoryUserRepository()
+		createUsers(t, userRepository, 5)
+		events := []*outbox.Event{}
+		relay := outbox.NewRelay(userRepository, &publisherMock{
+			PublishFunc: func(ctx context.Context, event *outbox.Event) error {
+				events = append(events, event)
+				return nil
+			},
+		}, outbox.RelayConfig{BatchSize: 2})
+
+		// Act
+		published, err := relay.PublishWaiting(context.Background())
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, 5, published)
+		require.Len(t, events, 5)
+		for i, event := range events {
+			assert.Equal(t, repository.OutboxEventUserCreated, event.Type)
+			assert.Equal(t, i+1, event.User.ID)
+		}
+	})
+
+	t.Run("publishes failed event again on next run", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := repository.NewInMemoryUserRepository()
+		createUsers(t, userRepository, 3)
+		errPublish := errors.New("downstream unavailable")
+		fail := true
+		delivered := []int64{}
+		relay := outbox.NewRelay(userRepository, &publisherMock{
+			PublishFunc: func(ctx context.Context, event *outbox.Event) error {
+				if event.User.ID == 2 && fail {
+					return errPublish
+				}
+				delivered = append(delivered, event.ID)
+				return nil
+			},
+		}, outbox.RelayConfig{BatchSize: 10})
+
+		// Act
+		failedPublished, failedErr := relay.PublishWaiting(context.Background())
+		fail = false
+		retriedPublished, retriedErr := relay.PublishWaiting(context.Background())
+
+		// Assert
+		assert.ErrorIs(t, failedErr, errPublish)
+		assert.Equal(t, 1, failedPublished)
+		require.NoError(t, retriedErr)
+		assert.Equal(t, 2, retriedPublished)
+		assert.Equal(t, []int64{1, 2, 3}, delivered)
+	})
+
+	t.Run("publishes events not published within batch timeout in next batch", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := repository.NewInMemoryUserRepository()
+		createUsers(t, userRepository, 3)
+		slow := true
+		delivered := []int{}
+		relay := outbox.NewRelay(userRepository, &publisherMock{
+			PublishFunc: func(ctx context.Context, event *outbox.Event) error {
+				if _, ok := ctx.Deadline(); !ok {
+					return errors.New("published without deadline")
+				}
+				if event.User.ID == 2 && slow {
+					slow = false
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				delivered = append(delivered, event.User.ID)
+				return nil
+			},
+		}, outbox.RelayConfig{BatchSize: 10, BatchTimeout: 50 * time.Millisecond})
+
+		// Act
+		published, err := relay.PublishWaiting(context.Background())
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, 3, published)
+		assert.Equal(t, []int{1, 2, 3}, delivered)
+	})
+
+	t.Run("returns error when no event is published within batch timeout", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := repository.NewInMemoryUserRepository()
+		createUsers(t, userRepository, 2)
+		relay := outbox.NewRelay(userRepository, &publisherMock{
+			PublishFunc: func(ctx context.Context, event *outbox.Event) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		}, outbox.RelayConfig{BatchSize: 10, BatchTimeout: 50 * time.Millisecond})
+
+		// Act
+		published, err := relay.PublishWaiting(context.Background())
+
+		// Assert
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 0, published)
+	})
+}
@@ -0,0 +1,53 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tobiassundman/go-demo-app/internal/app/service"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
+)
+
+// WebhookPublisher publishes events by delivering them to the webhooks subscribed to their type.
+type WebhookPublisher struct {
+	webhookService service.WebhookService
+}
+
+// NewWebhookPublisher creates a new WebhookPublisher.
+func NewWebhookPublisher(webhookService service.WebhookService) *WebhookPublisher {
+	return &WebhookPublisher{
+		webhookService: webhookService,
+	}
+}
+
+// Publish delivers the event to the subscribed webhooks of the tenant of the event.
+// Failed deliveries are recorded in the delivery log instead of failing the event, so one unavailable receiver does not hold back the others.
+func (p *WebhookPublisher) Publish(ctx context.Context, event *Event) error {
+	return p.webhookService.Deliver(tenant.NewContext(ctx, event.TenantID), &service.WebhookEvent{
+		ID:         event.ID,
+		Type:       event.Type,
+		OccurredAt: event.OccurredAt,
+		User: &service.User{
+			ID:      event.User.ID,
+			Name:    event.User.Name,
+			Email:   event.User.Email,
+			Age:     event.User.Age,
+			Version: event.User.Version,
+		},
+	})
+}
+
+// MultiPublisher publishes events with every one of its publishers.
+type MultiPublisher []Publisher
+
+// Publish publishes the event with every publisher, returning the errors of those that failed.
+// The event is published again with every publisher if one fails, which consumers already tolerate since events are delivered at least once.
+func (p MultiPublisher) Publish(ctx context.Context, event *Event) error {
+	errs := []error{}
+	for _, publisher := range p {
+		if err := publisher.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,98 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/outbox"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/internal/app/service"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
+	"github.com/tobiassundman/go-demo-app/pkg/webhook"
+)
+
+func TestWebhookPublisher(t *testing.T) {
+	t.Parallel()
+	t.Run("delivers relayed events to subscribed webhook of the tenant", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		const secret = "0123456789abcdef"
+		verifyErrs := make(chan error, 10)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			verifyErrs <- webhook.Verify(secret, r.Header.Get(webhook.TimestampHeader), r.Header.Get(webhook.SignatureHeader), body, time.Minute)
+		}))
+		defer server.Close()
+		otherTenantDeliveries := 0
+		otherTenantServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			otherTenantDeliveries++
+		}))
+		defer otherTenantServer.Close()
+		webhookRepository := repository.NewInMemoryWebhookRepository()
+		webhookService := service.NewWebhookService(webhookRepository, webhook.NewSender(server.Client(), time.Second))
+		ctx := tenant.NewContext(context.Background(), "tenant1")
+		// The webhooks are stored in the repository, as the service refuses the loopback address of the servers
+		webhookID, err := webhookRepository.CreateWebhook(ctx, &repository.Webhook{
+			URL:        server.URL,
+			Secret:     secret,
+			EventTypes: []string{repository.OutboxEventUserCreated},
+		})
+		require.NoError(t, err)
+		_, err = webhookRepository.CreateWebhook(tenant.NewContext(context.Background(), "tenant2"), &repository.Webhook{
+			URL:        otherTenantServer.URL,
+			Secret:     secret,
+			EventTypes: []string{repository.OutboxEventUserCreated},
+		})
+		require.NoError(t, err)
+		userRepository := repository.NewInMemoryUserRepository()
+		createUsers(t, userRepository, 2)
+		relay := outbox.NewRelay(userRepository, outbox.NewWebhookPublisher(webhookService), outbox.RelayConfig{BatchSize: 10})
+
+		// Act
+		published, err := relay.PublishWaiting(context.Background())
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, 2, published)
+		require.Len(t, verifyErrs, 2)
+		assert.NoError(t, <-verifyErrs)
+		assert.NoError(t, <-verifyErrs)
+		assert.Zero(t, otherTenantDeliveries)
+		deliveries, err := webhookRepository.GetDeliveries(ctx, &repository.WebhookDeliveryPageQuery{WebhookID: webhookID, Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, deliveries, 2)
+	})
+}
+
+func TestMultiPublisher(t *testing.T) {
+	t.Parallel()
+	t.Run("publishes with every publisher and returns their errors", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		errPublish := errors.New("downstream unavailable")
+		published := 0
+		publisher := outbox.MultiPublisher{
+			&publisherMock{PublishFunc: func(ctx context.Context, event *outbox.Event) error { return errPublish }},
+			&publisherMock{PublishFunc: func(ctx context.Context, event *outbox.Event) error {
+				published++
+				return nil
+			}},
+		}
+
+		// Act
+		err := publisher.Publish(context.Background(), &outbox.Event{ID: 1})
+
+		// Assert
+		assert.ErrorIs(t, err, errPublish)
+		assert.Equal(t, 1, published)
+	})
+}
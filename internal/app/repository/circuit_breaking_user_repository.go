@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tobiassundman/go-demo-app/pkg/breaker"
+)
+
+// circuitBreakingUserRepository calls the wrapped repository through a circuit breaker
+type circuitBreakingUserRepository struct {
+	next    UserRepository
+	breaker *breaker.Breaker
+}
+
+// NewCircuitBreakingUserRepository creates a UserRepository that calls the given repository through the circuit breaker.
+// Calls fail fast with an *UnavailableError while the circuit is open, only errors that mean the database is unavailable count as failures.
+// The state of the circuit is exported as a gauge registered with the registerer, nil leaves it unregistered.
+func NewCircuitBreakingUserRepository(next UserRepository, circuit *breaker.Breaker, registerer prometheus.Registerer) UserRepository {
+	promauto.With(registerer).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "db_circuit_breaker_state",
+		Help: "State of the circuit breaker around the user database, 0 is closed, 1 is half-open and 2 is open.",
+	}, func() float64 { return float64(circuit.State()) })
+	return &circuitBreakingUserRepository{
+		next:    next,
+		breaker: circuit,
+	}
+}
+
+// GetAll returns all users
+func (r *circuitBreakingUserRepository) GetAll(ctx context.Context) ([]*User, error) {
+	var users []*User
+	err := r.call(func() (err error) {
+		users, err = r.next.GetAll(ctx)
+		return err
+	})
+	return users, err
+}
+
+// GetPage returns up to query.Limit users matching query.Filter that come after query.After in query.Sort order
+func (r *circuitBreakingUserRepository) GetPage(ctx context.Context, query *UserPageQuery) ([]*User, error) {
+	var users []*User
+	err := r.call(func() (err error) {
+		users, err = r.next.GetPage(ctx, query)
+		return err
+	})
+	return users, err
+}
+
+// Export calls fn with every user matching the filter in id order without holding them all in memory, stopping at the first error fn returns.
+// An error of fn is not a failure of the database, even if it is a network error of the client the users are exported to
+func (r *circuitBreakingUserRepository) Export(ctx context.Context, filter *UserFilter, fn func(user *User) error) error {
+	done, err := r.breaker.Allow()
+	if err != nil {
+		return unavailableError(err)
+	}
+	failed := true
+	defer func() { done(failed) }()
+
+	var fnErr error
+	err = r.next.Export(ctx, filter, func(user *User) error {
+		fnErr = fn(user)
+		return fnErr
+	})
+	failed = (fnErr == nil || !errors.Is(err, fnErr)) && isDatabaseUnavailable(err)
+	return err
+}
+
+// Search returns up to limit users whose name or email is similar to the query, best match first
+func (r *circuitBreakingUserRepository) Search(ctx context.Context, query string, limit int) ([]*UserSearchResult, error) {
+	var results []*UserSearchResult
+	err := r.call(func() (err error) {
+		results, err = r.next.Search(ctx, query, limit)
+		return err
+	})
+	return results, err
+}
+
+// Get returns a user with the given id
+func (r *circuitBreakingUserRepository) Get(ctx context.Context, id int) (*User, error) {
+	var user *User
+	err := r.call(func() (err error) {
+		user, err = r.next.Get(ctx, id)
+		return err
+	})
+	return user, err
+}
+
+// Create creates a new user
+func (r *circuitBreakingUserRepository) Create(ctx context.Context, user *User) (int, error) {
+	var id int
+	err := r.call(func() (err error) {
+		id, err = r.next.Create(ctx, user)
+		return err
+	})
+	return id, err
+}
+
+// CreateBatch creates users with a single insert, returning the created users in the given order with nil for users whose email already exists.
+// If atomic is true and any email already exists no user is created and a *BatchConflictError is returned
+func (r *circuitBreakingUserRepository) CreateBatch(ctx context.Context, users []*User, atomic bool) ([]*User, error) {
+	var created []*User
+	err := r.call(func() (err error) {
+		created, err = r.next.CreateBatch(ctx, users, atomic)
+		return err
+	})
+	return created, err
+}
+
+// Update updates a user if its current version matches, otherwise it returns ErrVersionConflict
+func (r *circuitBreakingUserRepository) Update(ctx context.Context, user *User, match VersionMatch) error {
+	return r.call(func() error {
+		return r.next.Update(ctx, user, match)
+	})
+}
+
+// Patch updates only the columns of the non-nil fields of the patch if the current version of the user matches, returning the patched user.
+// A patch without fields changes nothing and returns the user as it is
+func (r *circuitBreakingUserRepository) Patch(ctx context.Context, patch *UserPatch, match VersionMatch) (*User, error) {
+	var user *User
+	err := r.call(func() (err error) {
+		user, err = r.next.Patch(ctx, patch, match)
+		return err
+	})
+	return user, err
+}
+
+// Delete soft deletes a user if its current version matches, it can be restored until it is purged
+func (r *circuitBreakingUserRepository) Delete(ctx context.Context, id int, match VersionMatch) error {
+	return r.call(func() error {
+		return r.next.Delete(ctx, id, match)
+	})
+}
+
+// Restore restores a soft deleted user
+func (r *circuitBreakingUserRepository) Restore(ctx context.Context, id int) error {
+	return r.call(func() error {
+		return r.next.Restore(ctx, id)
+	})
+}
+
+// PurgeDeleted permanently deletes users that were soft deleted longer ago than the retention, returning how many were purged
+func (r *circuitBreakingUserRepository) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	var purged int64
+	err := r.call(func() (err error) {
+		purged, err = r.next.PurgeDeleted(ctx, retention)
+		return err
+	})
+	return purged, err
+}
+
+// GetHistory returns up to query.Limit changes of the user with id query.UserID that were made after the change with id query.AfterID, oldest first
+func (r *circuitBreakingUserRepository) GetHistory(ctx context.Context, query *UserHistoryPageQuery) ([]*UserHistoryEntry, error) {
+	var entries []*UserHistoryEntry
+	err := r.call(func() (err error) {
+		entries, err = r.next.GetHistory(ctx, query)
+		return err
+	})
+	return entries, err
+}
+
+// call calls fn through the circuit breaker, counting its error as a failure if it means the database is unavailable
+func (r *circuitBreakingUserRepository) call(fn func() error) error {
+	done, err := r.breaker.Allow()
+	if err != nil {
+		return unavailableError(err)
+	}
+	failed := true
+	defer func() { done(failed) }()
+	err = fn()
+	failed = isDatabaseUnavailable(err)
+	return err
+}
+
+// unavailableError converts the error of a call rejected by the open circuit to an *UnavailableError, other errors are returned as they are
+func unavailableError(err error) error {
+	var openError *breaker.OpenError
+	if errors.As(err, &openError) {
+		return &UnavailableError{RetryAfter: openError.RetryAfter}
+	}
+	return err
+}
+
+// isDatabaseUnavailable returns true if the error means the database cannot be reached or is too slow to answer in time.
+// Serialization failures and deadlocks are transient too, but they are conflicts between transactions of an available database
+func isDatabaseUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (pgErr.Code == pgerrcode.SerializationFailure || pgErr.Code == pgerrcode.DeadlockDetected) {
+		return false
+	}
+	return transientErrorReason(err) != "" || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,71 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/pkg/breaker"
+)
+
+func TestCircuitBreakingUserRepository(t *testing.T) {
+	t.Parallel()
+
+	t.Run("fails fast while database is unavailable", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		registry := prometheus.NewRegistry()
+		circuit := breaker.New(breaker.Config{FailureThreshold: 2, OpenTimeout: time.Minute})
+		userRepository := repository.NewCircuitBreakingUserRepository(
+			repository.NewPostgresUserRepository(newRefusingDatabase(t), time.Second*2), circuit, registry)
+		_, err := userRepository.Get(tenantContext(), 1)
+		require.Error(t, err)
+		_, err = userRepository.Create(tenantContext(), &USER1)
+		require.Error(t, err)
+
+		// Act
+		_, err = userRepository.Get(tenantContext(), 1)
+
+		// Assert
+		var unavailableError *repository.UnavailableError
+		require.ErrorAs(t, err, &unavailableError)
+		assert.Greater(t, unavailableError.RetryAfter, time.Duration(0))
+		assert.Equal(t, breaker.StateOpen, circuit.State())
+		assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+			# HELP db_circuit_breaker_state State of the circuit breaker around the user database, 0 is closed, 1 is half-open and 2 is open.
+			# TYPE db_circuit_breaker_state gauge
+			db_circuit_breaker_state 2
+		`), "db_circuit_breaker_state"))
+	})
+
+	t.Run("does not count errors of an available database", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		circuit := breaker.New(breaker.Config{FailureThreshold: 1, OpenTimeout: time.Minute})
+		userRepository := repository.NewCircuitBreakingUserRepository(repository.NewInMemoryUserRepository(), circuit, nil)
+		_, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+
+		// Act
+		_, notFoundErr := userRepository.Get(tenantContext(), 100)
+		_, existsErr := userRepository.Create(tenantContext(), &USER1)
+		exportErr := userRepository.Export(tenantContext(), &repository.UserFilter{}, func(user *repository.User) error {
+			return context.DeadlineExceeded
+		})
+
+		// Assert
+		assert.ErrorIs(t, notFoundErr, repository.ErrUserNotFound)
+		assert.ErrorIs(t, existsErr, repository.ErrUserAlreadyExists)
+		assert.True(t, errors.Is(exportErr, context.DeadlineExceeded), "the error of fn is returned")
+		assert.Equal(t, breaker.StateClosed, circuit.State())
+	})
+}
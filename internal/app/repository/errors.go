@@ -1,8 +1,91 @@
 package repository
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 var (
 	ErrUserNotFound      = errors.New("user not found")
 	ErrUserAlreadyExists = errors.New("user already exists")
+	ErrInvalidSortColumn = errors.New("invalid sort column")
+	ErrVersionConflict   = errors.New("version conflict")
+	ErrWebhookNotFound   = errors.New("webhook not found")
+	ErrDeliveryNotFound  = errors.New("webhook delivery not found")
+	// ErrTenantRequired is returned when a user operation is called with a context that does not carry a tenant
+	ErrTenantRequired = errors.New("tenant required")
+	// ErrIdempotencyKeyNotLocked is returned when a key is no longer locked for the request that locked it
+	ErrIdempotencyKeyNotLocked = errors.New("idempotency key not locked")
+	// ErrUnavailable is matched by the errors of calls that fail fast because the database is unavailable
+	ErrUnavailable = errors.New("database unavailable")
+)
+
+// Kinds of values the database rejects, matched by a *ConstraintError
+var (
+	ErrCheckViolation   = errors.New("check constraint violated")
+	ErrNotNullViolation = errors.New("null value not allowed")
+	ErrValueTooLong     = errors.New("value too long")
+	ErrValueOutOfRange  = errors.New("value out of range")
 )
+
+// ConstraintError is returned when the database rejects a value written to a column
+type ConstraintError struct {
+	// Kind is one of ErrCheckViolation, ErrNotNullViolation, ErrValueTooLong and ErrValueOutOfRange
+	Kind error
+	// Column is the column of the rejected value, empty if the database does not report it
+	Column string
+	// Constraint is the name of the violated constraint, empty if the value violates the type of the column rather than a constraint
+	Constraint string
+	// err is the error of the database, nil if the value is rejected by the in-memory repository
+	err error
+}
+
+func (e *ConstraintError) Error() string {
+	switch {
+	case e.Column != "":
+		return fmt.Sprintf("%s: column %s", e.Kind, e.Column)
+	case e.Constraint != "":
+		return fmt.Sprintf("%s: constraint %s", e.Kind, e.Constraint)
+	default:
+		return e.Kind.Error()
+	}
+}
+
+// Unwrap makes a ConstraintError match its kind and the error of the database
+func (e *ConstraintError) Unwrap() []error {
+	if e.err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.err}
+}
+
+// BatchConflictError is returned when an atomic batch is not created because some of its emails already exist
+type BatchConflictError struct {
+	// Indexes are the positions in the batch of the users whose email already exists
+	Indexes []int
+}
+
+func (e *BatchConflictError) Error() string {
+	return fmt.Sprintf("%d users in batch already exist", len(e.Indexes))
+}
+
+// Is makes a BatchConflictError match ErrUserAlreadyExists
+func (e *BatchConflictError) Is(target error) bool {
+	return target == ErrUserAlreadyExists
+}
+
+// UnavailableError is returned when a call fails fast because the database is unavailable
+type UnavailableError struct {
+	// RetryAfter is how long until the call can be retried
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrUnavailable, e.RetryAfter)
+}
+
+// Is makes an UnavailableError match ErrUnavailable
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/tobiassundman/go-demo-app/pkg/database"
+)
+
+// Every query is scoped by the tenant in $1, in addition to the row level security policy scoped by setTenant
+const (
+	// An expired key is taken over as if it was never used
+	postgresLockIdempotencyKeyQuery = `INSERT INTO config.idempotency_keys (tenant_id, key, fingerprint, expires_at) VALUES ($1, $2, $3, NOW() + make_interval(secs => $4))
+ON CONFLICT (tenant_id, key) DO UPDATE SET fingerprint = EXCLUDED.fingerprint, status_code = NULL, content_type = NULL, body = NULL, created_at = NOW(), expires_at = EXCLUDED.expires_at
+WHERE config.idempotency_keys.expires_at <= NOW()
+RETURNING key`
+	postgresGetIdempotencyKeyQuery      = `SELECT key, fingerprint, COALESCE(status_code, 0) AS status_code, COALESCE(content_type, '') AS content_type, COALESCE(body, ''::bytea) AS body, expires_at FROM config.idempotency_keys WHERE tenant_id = $1 AND key = $2`
+	postgresCompleteIdempotencyKeyQuery = `UPDATE config.idempotency_keys SET status_code = $2, content_type = $3, body = $4, expires_at = NOW() + make_interval(secs => $5) WHERE tenant_id = $1 AND key = $6 AND fingerprint = $7 AND status_code IS NULL`
+	postgresUnlockIdempotencyKeyQuery   = `DELETE FROM config.idempotency_keys WHERE tenant_id = $1 AND key = $2 AND fingerprint = $3 AND status_code IS NULL`
+	// postgresDeleteExpiredKeysQuery deletes the expired keys of every tenant in a function that is not subject to the row level security policy
+	postgresDeleteExpiredKeysQuery = `SELECT config.delete_expired_idempotency_keys()`
+)
+
+// IdempotencyRepository is an interface for the repository of idempotency keys.
+// Keys belong to the tenant of the context they are locked with, the same key can be used by every tenant.
+// Every method except DeleteExpired fails with ErrTenantRequired if the context does not carry a tenant
+type IdempotencyRepository interface {
+	// Lock locks an unused or expired key for the request with the fingerprint until the lock timeout has passed, returning nil if the key was locked.
+	// If the key is in use it is not locked and the key as it is stored is returned
+	Lock(ctx context.Context, key, fingerprint string, lockTimeout time.Duration) (*IdempotencyKey, error)
+	// Complete stores the response of the request that locked the key and keeps it until the ttl has passed.
+	// It returns ErrIdempotencyKeyNotLocked if the key is no longer locked for the request
+	Complete(ctx context.Context, key *IdempotencyKey, ttl time.Duration) error
+	// Unlock deletes the lock of a request that has no response to store, so that the request can be retried
+	Unlock(ctx context.Context, key, fingerprint string) error
+	// DeleteExpired deletes expired keys of every tenant, returning how many were deleted
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// PostgresIdempotencyRepository is a repository for idempotency keys in a Postgres database
+type PostgresIdempotencyRepository struct {
+	db           database.Pool
+	queryTimeout time.Duration
+}
+
+// NewPostgresIdempotencyRepository creates a new PostgresIdempotencyRepository, which reads and writes the primary database.
+func NewPostgresIdempotencyRepository(db database.Pool, queryTimeout time.Duration) *PostgresIdempotencyRepository {
+	return &PostgresIdempotencyRepository{
+		db:           db,
+		queryTimeout: queryTimeout,
+	}
+}
+
+// Lock locks an unused or expired key for the request with the fingerprint until the lock timeout has passed, returning nil if the key was locked.
+// If the key is in use it is not locked and the key as it is stored is returned
+func (r *PostgresIdempotencyRepository) Lock(ctx context.Context, key, fingerprint string, lockTimeout time.Duration) (*IdempotencyKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	var stored *IdempotencyKey
+	err := runInTenantTx(ctx, r.db, func(ctx context.Context, tx pgx.Tx, tenantID string) error {
+		// The key may be unlocked between the insert and the select, the insert is then tried again
+		for {
+			var locked string
+			err := tx.QueryRow(ctx, postgresLockIdempotencyKeyQuery, tenantID, key, fingerprint, lockTimeout.Seconds()).Scan(&locked)
+			if err == nil {
+				return nil
+			}
+			if !errors.Is(err, pgx.ErrNoRows) {
+				return err
+			}
+
+			stored, err = getRow[IdempotencyKey](ctx, tx, postgresGetIdempotencyKeyQuery, tenantID, key)
+			if !errors.Is(err, pgx.ErrNoRows) {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// Complete stores the response of the request that locked the key and keeps it until the ttl has passed.
+// It returns ErrIdempotencyKeyNotLocked if the key is no longer locked for the request
+func (r *PostgresIdempotencyRepository) Complete(ctx context.Context, key *IdempotencyKey, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	return runInTenantTx(ctx, r.db, func(ctx context.Context, tx pgx.Tx, tenantID string) error {
+		result, err := tx.Exec(ctx, postgresCompleteIdempotencyKeyQuery,
+			tenantID, key.StatusCode, key.ContentType, key.Body, ttl.Seconds(), key.Key, key.Fingerprint)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return ErrIdempotencyKeyNotLocked
+		}
+		return nil
+	})
+}
+
+// Unlock deletes the lock of a request that has no response to store, so that the request can be retried
+func (r *PostgresIdempotencyRepository) Unlock(ctx context.Context, key, fingerprint string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	return runInTenantTx(ctx, r.db, func(ctx context.Context, tx pgx.Tx, tenantID string) error {
+		_, err := tx.Exec(ctx, postgresUnlockIdempotencyKeyQuery, tenantID, key, fingerprint)
+		return err
+	})
+}
+
+// DeleteExpired deletes expired keys of every tenant, returning how many were deleted
+func (r *PostgresIdempotencyRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	var deleted int64
+	err := r.db.QueryRow(ctx, postgresDeleteExpiredKeysQuery).Scan(&deleted)
+	return deleted, err
+}
@@ -0,0 +1,243 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
+	"github.com/tobiassundman/go-demo-app/pkg/test"
+)
+
+const (
+	FINGERPRINT1 = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	FINGERPRINT2 = "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752"
+)
+
+// newIdempotencyRepositoryFunc creates an empty idempotency repository for a test.
+type newIdempotencyRepositoryFunc func(t *testing.T) repository.IdempotencyRepository
+
+// newCompletedKey creates a key with a stored response for the request with the fingerprint.
+func newCompletedKey(key, fingerprint string) *repository.IdempotencyKey {
+	return &repository.IdempotencyKey{
+		Key:         key,
+		Fingerprint: fingerprint,
+		StatusCode:  201,
+		ContentType: "application/json; charset=utf-8",
+		Body:        []byte(`{"id":1}`),
+	}
+}
+
+// testIdempotencyRepository runs the conformance suite that every IdempotencyRepository implementation must pass.
+func testIdempotencyRepository(t *testing.T, newRepository newIdempotencyRepositoryFunc) {
+	t.Run("locks unused key", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyRepository := newRepository(t)
+
+		// Act
+		stored, err := idempotencyRepository.Lock(tenantContext(), "key-1", FINGERPRINT1, time.Minute)
+		require.NoError(t, err)
+		inFlight, err := idempotencyRepository.Lock(tenantContext(), "key-1", FINGERPRINT2, time.Minute)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Nil(t, stored)
+		require.NotNil(t, inFlight)
+		assert.Equal(t, "key-1", inFlight.Key)
+		assert.Equal(t, FINGERPRINT1, inFlight.Fingerprint)
+		assert.Equal(t, 0, inFlight.StatusCode)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), inFlight.ExpiresAt, time.Minute)
+	})
+
+	t.Run("returns completed key", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyRepository := newRepository(t)
+		_, err := idempotencyRepository.Lock(tenantContext(), "key-1", FINGERPRINT1, time.Minute)
+		require.NoError(t, err)
+		expected := newCompletedKey("key-1", FINGERPRINT1)
+		err = idempotencyRepository.Complete(tenantContext(), expected, time.Hour*24)
+		require.NoError(t, err)
+
+		// Act
+		stored, err := idempotencyRepository.Lock(tenantContext(), "key-1", FINGERPRINT1, time.Minute)
+		require.NoError(t, err)
+
+		// Assert
+		require.NotNil(t, stored)
+		assert.Equal(t, expected.Fingerprint, stored.Fingerprint)
+		assert.Equal(t, expected.StatusCode, stored.StatusCode)
+		assert.Equal(t, expected.ContentType, stored.ContentType)
+		assert.Equal(t, expected.Body, stored.Body)
+		assert.WithinDuration(t, time.Now().Add(time.Hour*24), stored.ExpiresAt, time.Minute)
+	})
+
+	t.Run("does not complete key locked for other request", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyRepository := newRepository(t)
+		_, err := idempotencyRepository.Lock(tenantContext(), "key-1", FINGERPRINT1, time.Minute)
+		require.NoError(t, err)
+
+		// Act
+		otherErr := idempotencyRepository.Complete(tenantContext(), newCompletedKey("key-1", FINGERPRINT2), time.Hour)
+		unknownErr := idempotencyRepository.Complete(tenantContext(), newCompletedKey("key-2", FINGERPRINT1), time.Hour)
+		err = idempotencyRepository.Complete(tenantContext(), newCompletedKey("key-1", FINGERPRINT1), time.Hour)
+		require.NoError(t, err)
+		completedErr := idempotencyRepository.Complete(tenantContext(), newCompletedKey("key-1", FINGERPRINT1), time.Hour)
+
+		// Assert
+		assert.ErrorIs(t, otherErr, repository.ErrIdempotencyKeyNotLocked)
+		assert.ErrorIs(t, unknownErr, repository.ErrIdempotencyKeyNotLocked)
+		assert.ErrorIs(t, completedErr, repository.ErrIdempotencyKeyNotLocked)
+	})
+
+	t.Run("unlocks key in flight", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyRepository := newRepository(t)
+		_, err := idempotencyRepository.Lock(tenantContext(), "key-1", FINGERPRINT1, time.Minute)
+		require.NoError(t, err)
+		_, err = idempotencyRepository.Lock(tenantContext(), "key-2", FINGERPRINT1, time.Minute)
+		require.NoError(t, err)
+		err = idempotencyRepository.Complete(tenantContext(), newCompletedKey("key-2", FINGERPRINT1), time.Hour)
+		require.NoError(t, err)
+
+		// Act
+		err = idempotencyRepository.Unlock(tenantContext(), "key-1", FINGERPRINT1)
+		require.NoError(t, err)
+		err = idempotencyRepository.Unlock(tenantContext(), "key-2", FINGERPRINT1)
+		require.NoError(t, err)
+		unlocked, err := idempotencyRepository.Lock(tenantContext(), "key-1", FINGERPRINT2, time.Minute)
+		require.NoError(t, err)
+		completed, err := idempotencyRepository.Lock(tenantContext(), "key-2", FINGERPRINT2, time.Minute)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Nil(t, unlocked)
+		require.NotNil(t, completed, "a completed key is not unlocked")
+		assert.Equal(t, FINGERPRINT1, completed.Fingerprint)
+	})
+
+	t.Run("locks expired key", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyRepository := newRepository(t)
+		_, err := idempotencyRepository.Lock(tenantContext(), "key-1", FINGERPRINT1, time.Minute)
+		require.NoError(t, err)
+		err = idempotencyRepository.Complete(tenantContext(), newCompletedKey("key-1", FINGERPRINT1), -time.Second)
+		require.NoError(t, err)
+		_, err = idempotencyRepository.Lock(tenantContext(), "key-2", FINGERPRINT1, -time.Second)
+		require.NoError(t, err)
+
+		// Act
+		expiredResponse, err := idempotencyRepository.Lock(tenantContext(), "key-1", FINGERPRINT2, time.Minute)
+		require.NoError(t, err)
+		expiredLock, err := idempotencyRepository.Lock(tenantContext(), "key-2", FINGERPRINT2, time.Minute)
+		require.NoError(t, err)
+		stored, err := idempotencyRepository.Lock(tenantContext(), "key-1", FINGERPRINT1, time.Minute)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Nil(t, expiredResponse)
+		assert.Nil(t, expiredLock)
+		require.NotNil(t, stored)
+		assert.Equal(t, FINGERPRINT2, stored.Fingerprint)
+		assert.Equal(t, 0, stored.StatusCode)
+	})
+
+	t.Run("deletes expired keys", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyRepository := newRepository(t)
+		_, err := idempotencyRepository.Lock(tenantContext(), "key-1", FINGERPRINT1, time.Minute)
+		require.NoError(t, err)
+		err = idempotencyRepository.Complete(tenantContext(), newCompletedKey("key-1", FINGERPRINT1), -time.Second)
+		require.NoError(t, err)
+		_, err = idempotencyRepository.Lock(tenant.NewContext(context.Background(), TENANT2), "key-2", FINGERPRINT1, -time.Second)
+		require.NoError(t, err)
+		_, err = idempotencyRepository.Lock(tenantContext(), "key-3", FINGERPRINT1, time.Minute)
+		require.NoError(t, err)
+
+		// Act
+		deleted, err := idempotencyRepository.DeleteExpired(context.Background())
+		require.NoError(t, err)
+		kept, err := idempotencyRepository.Lock(tenantContext(), "key-3", FINGERPRINT2, time.Minute)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, int64(2), deleted)
+		assert.NotNil(t, kept)
+	})
+
+	t.Run("keeps keys of tenants apart", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyRepository := newRepository(t)
+		otherTenant := tenant.NewContext(context.Background(), TENANT2)
+		_, err := idempotencyRepository.Lock(tenantContext(), "key-1", FINGERPRINT1, time.Minute)
+		require.NoError(t, err)
+		err = idempotencyRepository.Complete(tenantContext(), newCompletedKey("key-1", FINGERPRINT1), time.Hour)
+		require.NoError(t, err)
+
+		// Act
+		otherStored, err := idempotencyRepository.Lock(otherTenant, "key-1", FINGERPRINT2, time.Minute)
+		require.NoError(t, err)
+		otherUnlockErr := idempotencyRepository.Unlock(otherTenant, "key-1", FINGERPRINT1)
+		otherCompleteErr := idempotencyRepository.Complete(otherTenant, newCompletedKey("key-1", FINGERPRINT1), time.Hour)
+		stored, err := idempotencyRepository.Lock(tenantContext(), "key-1", FINGERPRINT1, time.Minute)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Nil(t, otherStored)
+		assert.NoError(t, otherUnlockErr)
+		assert.ErrorIs(t, otherCompleteErr, repository.ErrIdempotencyKeyNotLocked)
+		require.NotNil(t, stored)
+		assert.Equal(t, FINGERPRINT1, stored.Fingerprint)
+		assert.Equal(t, 201, stored.StatusCode)
+	})
+
+	t.Run("requires tenant", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyRepository := newRepository(t)
+
+		// Act
+		_, lockErr := idempotencyRepository.Lock(context.Background(), "key-1", FINGERPRINT1, time.Minute)
+		completeErr := idempotencyRepository.Complete(context.Background(), newCompletedKey("key-1", FINGERPRINT1), time.Hour)
+		unlockErr := idempotencyRepository.Unlock(context.Background(), "key-1", FINGERPRINT1)
+
+		// Assert
+		assert.ErrorIs(t, lockErr, repository.ErrTenantRequired)
+		assert.ErrorIs(t, completeErr, repository.ErrTenantRequired)
+		assert.ErrorIs(t, unlockErr, repository.ErrTenantRequired)
+	})
+}
+
+func TestPostgresIdempotencyRepository(t *testing.T) {
+	t.Parallel()
+	testIdempotencyRepository(t, func(t *testing.T) repository.IdempotencyRepository {
+		db := test.StartDatabase(t)
+		t.Cleanup(func() { db.Close() })
+		return repository.NewPostgresIdempotencyRepository(db, time.Second*2)
+	})
+}
+
+func TestInMemoryIdempotencyRepository(t *testing.T) {
+	t.Parallel()
+	testIdempotencyRepository(t, func(t *testing.T) repository.IdempotencyRepository {
+		return repository.NewInMemoryIdempotencyRepository()
+	})
+}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// inMemoryIdempotencyKeyID identifies a key within the keys of its tenant
+type inMemoryIdempotencyKeyID struct {
+	tenantID string
+	key      string
+}
+
+// InMemoryIdempotencyRepository is a thread-safe repository for idempotency keys kept in memory, for tests and local development.
+// Like PostgresIdempotencyRepository, keys belong to the tenant of the context they are locked with
+type InMemoryIdempotencyRepository struct {
+	mutex sync.Mutex
+	keys  map[inMemoryIdempotencyKeyID]*IdempotencyKey
+}
+
+// NewInMemoryIdempotencyRepository creates a new empty InMemoryIdempotencyRepository.
+func NewInMemoryIdempotencyRepository() *InMemoryIdempotencyRepository {
+	return &InMemoryIdempotencyRepository{
+		keys: map[inMemoryIdempotencyKeyID]*IdempotencyKey{},
+	}
+}
+
+// Lock locks an unused or expired key for the request with the fingerprint until the lock timeout has passed, returning nil if the key was locked.
+// If the key is in use it is not locked and the key as it is stored is returned
+func (r *InMemoryIdempotencyRepository) Lock(ctx context.Context, key, fingerprint string, lockTimeout time.Duration) (*IdempotencyKey, error) {
+	id, err := r.begin(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now().UTC()
+	if stored, ok := r.keys[id]; ok && stored.ExpiresAt.After(now) {
+		return copyIdempotencyKey(stored), nil
+	}
+	r.keys[id] = &IdempotencyKey{
+		Key:         key,
+		Fingerprint: fingerprint,
+		ExpiresAt:   now.Add(lockTimeout),
+	}
+	return nil, nil
+}
+
+// Complete stores the response of the request that locked the key and keeps it until the ttl has passed.
+// It returns ErrIdempotencyKeyNotLocked if the key is no longer locked for the request
+func (r *InMemoryIdempotencyRepository) Complete(ctx context.Context, key *IdempotencyKey, ttl time.Duration) error {
+	id, err := r.begin(ctx, key.Key)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stored, ok := r.keys[id]
+	if !ok || stored.Fingerprint != key.Fingerprint || stored.StatusCode != 0 {
+		return ErrIdempotencyKeyNotLocked
+	}
+	completed := copyIdempotencyKey(key)
+	completed.ExpiresAt = time.Now().UTC().Add(ttl)
+	r.keys[id] = completed
+	return nil
+}
+
+// Unlock deletes the lock of a request that has no response to store, so that the request can be retried
+func (r *InMemoryIdempotencyRepository) Unlock(ctx context.Context, key, fingerprint string) error {
+	id, err := r.begin(ctx, key)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if stored, ok := r.keys[id]; ok && stored.Fingerprint == fingerprint && stored.StatusCode == 0 {
+		delete(r.keys, id)
+	}
+	return nil
+}
+
+// DeleteExpired deletes expired keys of every tenant, returning how many were deleted
+func (r *InMemoryIdempotencyRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now().UTC()
+	var deleted int64
+	for id, stored := range r.keys {
+		if !stored.ExpiresAt.After(now) {
+			delete(r.keys, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// begin returns the id of the key within the tenant of the context, or an error if the context does not carry a tenant or is done
+func (r *InMemoryIdempotencyRepository) begin(ctx context.Context, key string) (inMemoryIdempotencyKeyID, error) {
+	if err := ctx.Err(); err != nil {
+		return inMemoryIdempotencyKeyID{}, err
+	}
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return inMemoryIdempotencyKeyID{}, err
+	}
+	return inMemoryIdempotencyKeyID{tenantID: tenantID, key: key}, nil
+}
+
+// copyIdempotencyKey copies a key, so that callers cannot change the stored response
+func copyIdempotencyKey(key *IdempotencyKey) *IdempotencyKey {
+	copied := *key
+	copied.Body = append([]byte(nil), key.Body...)
+	return &copied
+}
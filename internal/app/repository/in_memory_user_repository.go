@@ -0,0 +1,669 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tobiassundman/go-demo-app/pkg/actor"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
+)
+
+// inMemoryUser is a stored user of a tenant, deletedAt is set while the user is soft deleted
+type inMemoryUser struct {
+	tenantID  string
+	user      User
+	deletedAt *time.Time
+}
+
+// inMemoryHistoryEntry is a recorded change of a user of a tenant
+type inMemoryHistoryEntry struct {
+	tenantID string
+	entry    *UserHistoryEntry
+}
+
+// maxUserColumnLength is the length in characters of the VARCHAR(255) name and email columns in Postgres
+const maxUserColumnLength = 255
+
+// InMemoryUserRepository is a thread-safe repository for users kept in memory, for tests and local development.
+// It behaves like PostgresUserRepository, except that names and emails are sorted byte-wise like the Postgres C collation.
+// Users violating the column types and check constraints of the users table are rejected with the *ConstraintError of PostgresUserRepository.
+// Users of other tenants than the tenant of the context are not found, like with the tenant scoped queries of PostgresUserRepository.
+// It is also the OutboxRepository of the events of its users
+type InMemoryUserRepository struct {
+	mutex         sync.RWMutex
+	users         map[int]*inMemoryUser
+	history       []*inMemoryHistoryEntry
+	outbox        []*OutboxEvent
+	lastUserID    int
+	lastHistoryID int
+	lastOutboxID  int64
+	// publishMutex allows one batch of the outbox to be published at a time, without blocking changes while it is published
+	publishMutex sync.Mutex
+}
+
+// NewInMemoryUserRepository creates a new empty InMemoryUserRepository.
+func NewInMemoryUserRepository() *InMemoryUserRepository {
+	return &InMemoryUserRepository{
+		users:   map[int]*inMemoryUser{},
+		history: []*inMemoryHistoryEntry{},
+		outbox:  []*OutboxEvent{},
+	}
+}
+
+// GetAll returns all users
+func (r *InMemoryUserRepository) GetAll(ctx context.Context) ([]*User, error) {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.activeUsers(tenantID, nil, nil), nil
+}
+
+// GetPage returns up to query.Limit users matching query.Filter that come after query.After in query.Sort order
+func (r *InMemoryUserRepository) GetPage(ctx context.Context, query *UserPageQuery) ([]*User, error) {
+	columns, err := sortColumns(query.Sort)
+	if err != nil {
+		return nil, err
+	}
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	users := r.activeUsers(tenantID, query.Filter, columns)
+	if query.After != nil {
+		cursor := &User{ID: query.After.ID, Name: query.After.Name, Email: query.After.Email, Age: query.After.Age}
+		start := sort.Search(len(users), func(i int) bool {
+			return compareUsers(users[i], cursor, columns) > 0
+		})
+		users = users[start:]
+	}
+	if len(users) > query.Limit {
+		users = users[:query.Limit]
+	}
+	return users, nil
+}
+
+// Export calls fn with every user matching the filter in id order, stopping at the first error fn returns.
+// The users are copied before fn is called, so a slow fn does not block changes
+func (r *InMemoryUserRepository) Export(ctx context.Context, filter *UserFilter, fn func(user *User) error) error {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return err
+	}
+	r.mutex.RLock()
+	users := r.activeUsers(tenantID, filter, nil)
+	r.mutex.RUnlock()
+
+	for _, user := range users {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search returns up to limit users whose name or email is similar to the query, best match first
+func (r *InMemoryUserRepository) Search(ctx context.Context, query string, limit int) ([]*UserSearchResult, error) {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	queryTrigrams := trigrams(query)
+	results := []*UserSearchResult{}
+	for _, user := range r.activeUsers(tenantID, nil, nil) {
+		nameScore := similarity(trigrams(user.Name), queryTrigrams)
+		emailScore := similarity(trigrams(user.Email), queryTrigrams)
+		if nameScore < similarityThreshold && emailScore < similarityThreshold {
+			continue
+		}
+		score := nameScore
+		if emailScore > score {
+			score = emailScore
+		}
+		results = append(results, &UserSearchResult{User: *user, Score: score})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// Get returns a user with the given id
+func (r *InMemoryUserRepository) Get(ctx context.Context, id int) (*User, error) {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	stored, ok := r.find(tenantID, id)
+	if !ok || stored.deletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+	user := stored.user
+	return &user, nil
+}
+
+// Create creates a new user
+func (r *InMemoryUserRepository) Create(ctx context.Context, user *User) (int, error) {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Like a Postgres sequence, an id is used up even if the user is not created
+	r.lastUserID++
+	if err := checkUserConstraints(user); err != nil {
+		return 0, err
+	}
+	if r.emailTaken(tenantID, user.Email, 0) {
+		return 0, ErrUserAlreadyExists
+	}
+	created := r.insert(tenantID, user, r.lastUserID)
+	r.recordHistory(ctx, tenantID, created.ID, HistoryOperationCreate, nil, created)
+	r.recordEvent(tenantID, OutboxEventUserCreated, created)
+	return created.ID, nil
+}
+
+// CreateBatch creates users, returning the created users in the given order with nil for users whose email already exists.
+// If atomic is true and any email already exists no user is created and a *BatchConflictError is returned
+func (r *InMemoryUserRepository) CreateBatch(ctx context.Context, users []*User, atomic bool) ([]*User, error) {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Like the single insert of PostgresUserRepository, a user violating a constraint fails the whole batch, even if its email already exists
+	for _, user := range users {
+		if err := checkUserConstraints(user); err != nil {
+			return nil, err
+		}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ids := make([]int, len(users))
+	batchEmails := map[string]bool{}
+	conflicts := []int{}
+	for i, user := range users {
+		r.lastUserID++
+		ids[i] = r.lastUserID
+		if r.emailTaken(tenantID, user.Email, 0) || batchEmails[user.Email] {
+			conflicts = append(conflicts, i)
+			continue
+		}
+		batchEmails[user.Email] = true
+	}
+	if atomic && len(conflicts) > 0 {
+		return nil, &BatchConflictError{Indexes: conflicts}
+	}
+
+	results := make([]*User, len(users))
+	for i, user := range users {
+		if r.emailTaken(tenantID, user.Email, 0) {
+			continue
+		}
+		created := r.insert(tenantID, user, ids[i])
+		r.recordHistory(ctx, tenantID, created.ID, HistoryOperationCreate, nil, created)
+		r.recordEvent(tenantID, OutboxEventUserCreated, created)
+		results[i] = created
+	}
+	return results, nil
+}
+
+// Update updates a user if its current version matches, otherwise it returns ErrVersionConflict
+func (r *InMemoryUserRepository) Update(ctx context.Context, user *User, match VersionMatch) error {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stored, ok := r.find(tenantID, user.ID)
+	if !ok || stored.deletedAt != nil {
+		return ErrUserNotFound
+	}
+	if !match.Matches(stored.user.Version) {
+		return ErrVersionConflict
+	}
+	if err := checkUserConstraints(user); err != nil {
+		return err
+	}
+	if r.emailTaken(tenantID, user.Email, user.ID) {
+		return ErrUserAlreadyExists
+	}
+
+	before := stored.user
+	stored.user.Name = user.Name
+	stored.user.Email = user.Email
+	stored.user.Age = user.Age
+	stored.user.Version++
+	stored.user.UpdatedAt = now()
+	after := stored.user
+	r.recordHistory(ctx, tenantID, user.ID, HistoryOperationUpdate, &before, &after)
+	r.recordEvent(tenantID, OutboxEventUserUpdated, &after)
+	return nil
+}
+
+// Patch updates only the columns of the non-nil fields of the patch if the current version of the user matches, returning the patched user.
+// A patch without fields changes nothing and returns the user as it is
+func (r *InMemoryUserRepository) Patch(ctx context.Context, patch *UserPatch, match VersionMatch) (*User, error) {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stored, ok := r.find(tenantID, patch.ID)
+	if !ok || stored.deletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+	if !match.Matches(stored.user.Version) {
+		return nil, ErrVersionConflict
+	}
+	before := stored.user
+	if patch.Name == nil && patch.Email == nil && patch.Age == nil {
+		return &before, nil
+	}
+
+	after := stored.user
+	if patch.Name != nil {
+		after.Name = *patch.Name
+	}
+	if patch.Email != nil {
+		after.Email = *patch.Email
+	}
+	if patch.Age != nil {
+		after.Age = *patch.Age
+	}
+	if err := checkUserConstraints(&after); err != nil {
+		return nil, err
+	}
+	if patch.Email != nil && r.emailTaken(tenantID, *patch.Email, patch.ID) {
+		return nil, ErrUserAlreadyExists
+	}
+	after.Version++
+	after.UpdatedAt = now()
+	stored.user = after
+	r.recordHistory(ctx, tenantID, patch.ID, HistoryOperationUpdate, &before, &after)
+	r.recordEvent(tenantID, OutboxEventUserUpdated, &after)
+	return &after, nil
+}
+
+// Delete soft deletes a user if its current version matches, it can be restored until it is purged
+func (r *InMemoryUserRepository) Delete(ctx context.Context, id int, match VersionMatch) error {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stored, ok := r.find(tenantID, id)
+	if !ok || stored.deletedAt != nil {
+		return ErrUserNotFound
+	}
+	if !match.Matches(stored.user.Version) {
+		return ErrVersionConflict
+	}
+
+	before := stored.user
+	deletedAt := now()
+	stored.deletedAt = &deletedAt
+	stored.user.Version++
+	stored.user.UpdatedAt = deletedAt
+	deleted := stored.user
+	r.recordHistory(ctx, tenantID, id, HistoryOperationDelete, &before, nil)
+	r.recordEvent(tenantID, OutboxEventUserDeleted, &deleted)
+	return nil
+}
+
+// Restore restores a soft deleted user
+func (r *InMemoryUserRepository) Restore(ctx context.Context, id int) error {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stored, ok := r.find(tenantID, id)
+	if !ok || stored.deletedAt == nil {
+		return ErrUserNotFound
+	}
+	// Another user may have taken the email while this user was deleted
+	if r.emailTaken(tenantID, stored.user.Email, id) {
+		return ErrUserAlreadyExists
+	}
+
+	stored.deletedAt = nil
+	stored.user.Version++
+	stored.user.UpdatedAt = now()
+	after := stored.user
+	r.recordHistory(ctx, tenantID, id, HistoryOperationRestore, nil, &after)
+	r.recordEvent(tenantID, OutboxEventUserRestored, &after)
+	return nil
+}
+
+// PurgeDeleted permanently deletes users that were soft deleted longer ago than the retention, returning how many were purged.
+// Only the users of the tenant of the context are purged, or the users of every tenant if the context does not carry a tenant
+func (r *InMemoryUserRepository) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	tenantID, scoped := tenant.FromContext(ctx)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cutoff := time.Now().UTC().Add(-retention)
+	purged := int64(0)
+	for _, id := range r.sortedIDs() {
+		stored := r.users[id]
+		if (scoped && stored.tenantID != tenantID) || stored.deletedAt == nil || !stored.deletedAt.Before(cutoff) {
+			continue
+		}
+		before := stored.user
+		delete(r.users, id)
+		r.recordHistory(ctx, stored.tenantID, id, HistoryOperationPurge, &before, nil)
+		purged++
+	}
+	return purged, nil
+}
+
+// PublishBatch calls publish with up to limit of the oldest waiting events of every tenant in order, stopping at the first error publish returns,
+// and removes the events that were published. Calls are serialized, so an event is never published by two calls at once
+func (r *InMemoryUserRepository) PublishBatch(ctx context.Context, limit int, publish func(ctx context.Context, event *OutboxEvent) error) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.publishMutex.Lock()
+	defer r.publishMutex.Unlock()
+
+	r.mutex.RLock()
+	events := make([]*OutboxEvent, 0, limit)
+	for _, event := range r.outbox {
+		if len(events) == limit {
+			break
+		}
+		copied := *event
+		user := *event.User
+		copied.User = &user
+		events = append(events, &copied)
+	}
+	r.mutex.RUnlock()
+
+	published := 0
+	var publishErr error
+	for _, event := range events {
+		if publishErr = publish(ctx, event); publishErr != nil {
+			break
+		}
+		published++
+	}
+
+	// Only published events are removed from the front of the outbox and changes only append to it
+	r.mutex.Lock()
+	r.outbox = r.outbox[published:]
+	r.mutex.Unlock()
+	return published, publishErr
+}
+
+// GetHistory returns up to query.Limit changes of the user with id query.UserID that were made after the change with id query.AfterID, oldest first
+func (r *InMemoryUserRepository) GetHistory(ctx context.Context, query *UserHistoryPageQuery) ([]*UserHistoryEntry, error) {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	entries := []*UserHistoryEntry{}
+	for _, stored := range r.history {
+		if len(entries) == query.Limit {
+			break
+		}
+		entry := stored.entry
+		if stored.tenantID != tenantID || entry.UserID != query.UserID || entry.ID <= query.AfterID {
+			continue
+		}
+		entries = append(entries, copyHistoryEntry(entry))
+	}
+	return entries, nil
+}
+
+// begin returns the tenant of the context, or an error if the context does not carry one or is done
+func (r *InMemoryUserRepository) begin(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return tenantFromContext(ctx)
+}
+
+// find returns the stored user with the given id if it belongs to the tenant, including a deleted user.
+// The caller must hold the mutex
+func (r *InMemoryUserRepository) find(tenantID string, id int) (*inMemoryUser, bool) {
+	stored, ok := r.users[id]
+	if !ok || stored.tenantID != tenantID {
+		return nil, false
+	}
+	return stored, true
+}
+
+// activeUsers returns copies of the users of the tenant that are not deleted and match the filter, sorted by the given columns or by id if there are none.
+// The caller must hold the mutex
+func (r *InMemoryUserRepository) activeUsers(tenantID string, filter *UserFilter, columns []UserSort) []*User {
+	users := []*User{}
+	for _, id := range r.sortedIDs() {
+		stored := r.users[id]
+		if stored.tenantID != tenantID || stored.deletedAt != nil || !matchesFilter(&stored.user, filter) {
+			continue
+		}
+		user := stored.user
+		users = append(users, &user)
+	}
+	if len(columns) > 0 {
+		sort.SliceStable(users, func(i, j int) bool {
+			return compareUsers(users[i], users[j], columns) < 0
+		})
+	}
+	return users
+}
+
+// sortedIDs returns the ids of all stored users, including deleted users, in ascending order.
+// The caller must hold the mutex
+func (r *InMemoryUserRepository) sortedIDs() []int {
+	ids := make([]int, 0, len(r.users))
+	for id := range r.users {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// checkUserConstraints returns the *ConstraintError PostgresUserRepository returns for a user violating the column types or check constraints of the users table.
+// Like Postgres, values are converted to the types of the columns before the check constraints are checked, and btrim only trims spaces
+func checkUserConstraints(user *User) error {
+	if utf8.RuneCountInString(user.Name) > maxUserColumnLength || utf8.RuneCountInString(user.Email) > maxUserColumnLength {
+		// Postgres does not report the column of a value that is too long
+		return &ConstraintError{Kind: ErrValueTooLong}
+	}
+	if user.Age < 0 {
+		return &ConstraintError{Kind: ErrCheckViolation, Column: "age", Constraint: "users_age_not_negative"}
+	}
+	if strings.Trim(user.Name, " ") == "" {
+		return &ConstraintError{Kind: ErrCheckViolation, Column: "name", Constraint: "users_name_not_blank"}
+	}
+	return nil
+}
+
+// emailTaken returns true if a user of the tenant that is not deleted, other than the user with id exceptID, has the email.
+// Like the partial unique index in Postgres, deleted users do not hold on to their email.
+// The caller must hold the mutex
+func (r *InMemoryUserRepository) emailTaken(tenantID, email string, exceptID int) bool {
+	for id, stored := range r.users {
+		if id != exceptID && stored.tenantID == tenantID && stored.deletedAt == nil && stored.user.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// insert stores a new user of the tenant with the given id and returns a copy of it.
+// The caller must hold the mutex for writing
+func (r *InMemoryUserRepository) insert(tenantID string, user *User, id int) *User {
+	createdAt := now()
+	stored := &inMemoryUser{
+		tenantID: tenantID,
+		user: User{
+			ID:        id,
+			Name:      user.Name,
+			Email:     user.Email,
+			Age:       user.Age,
+			Version:   1,
+			CreatedAt: createdAt,
+			UpdatedAt: createdAt,
+		},
+	}
+	r.users[id] = stored
+	created := stored.user
+	return &created
+}
+
+// recordHistory records a change of a user of the tenant, copying the given snapshots.
+// The caller must hold the mutex for writing
+func (r *InMemoryUserRepository) recordHistory(ctx context.Context, tenantID string, userID int, operation string, before, after *User) {
+	r.lastHistoryID++
+	r.history = append(r.history, &inMemoryHistoryEntry{
+		tenantID: tenantID,
+		entry: copyHistoryEntry(&UserHistoryEntry{
+			ID:        r.lastHistoryID,
+			UserID:    userID,
+			Operation: operation,
+			ChangedBy: actor.FromContext(ctx),
+			ChangedAt: time.Now().UTC(),
+			Before:    snapshotUser(before),
+			After:     snapshotUser(after),
+		}),
+	})
+}
+
+// recordEvent writes an event of a change of a user of the tenant to the outbox, copying the given user.
+// The caller must hold the mutex for writing
+func (r *InMemoryUserRepository) recordEvent(tenantID, eventType string, user *User) {
+	r.lastOutboxID++
+	r.outbox = append(r.outbox, &OutboxEvent{
+		ID:        r.lastOutboxID,
+		TenantID:  tenantID,
+		Type:      eventType,
+		UserID:    user.ID,
+		User:      snapshotUser(user),
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+// snapshotUser returns a copy of a user without its timestamps, like the userSnapshot of the user history and the outbox, nil is returned as nil
+func snapshotUser(user *User) *User {
+	if user == nil {
+		return nil
+	}
+	return &User{
+		ID:      user.ID,
+		Name:    user.Name,
+		Email:   user.Email,
+		Age:     user.Age,
+		Version: user.Version,
+	}
+}
+
+// now returns the current time in UTC with the microsecond precision of a Postgres timestamp
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// copyHistoryEntry returns a deep copy of a history entry, so that callers cannot change stored entries
+func copyHistoryEntry(entry *UserHistoryEntry) *UserHistoryEntry {
+	copied := *entry
+	if entry.Before != nil {
+		before := *entry.Before
+		copied.Before = &before
+	}
+	if entry.After != nil {
+		after := *entry.After
+		copied.After = &after
+	}
+	return &copied
+}
+
+// matchesFilter returns true if the user matches every condition of the filter, the same way userQueryBuilder.addFilter does
+func matchesFilter(user *User, filter *UserFilter) bool {
+	if filter == nil {
+		return true
+	}
+	if filter.EmailDomain != "" {
+		// split_part returns the text between the first and second @
+		parts := strings.SplitN(user.Email, "@", 3)
+		domain := ""
+		if len(parts) > 1 {
+			domain = parts[1]
+		}
+		if strings.ToLower(domain) != strings.ToLower(filter.EmailDomain) {
+			return false
+		}
+	}
+	if filter.NamePrefix != "" && !strings.HasPrefix(strings.ToLower(user.Name), strings.ToLower(filter.NamePrefix)) {
+		return false
+	}
+	if filter.MinAge != nil && user.Age < *filter.MinAge {
+		return false
+	}
+	if filter.MaxAge != nil && user.Age > *filter.MaxAge {
+		return false
+	}
+	if filter.UpdatedSince != nil && user.UpdatedAt.Before(*filter.UpdatedSince) {
+		return false
+	}
+	return true
+}
+
+// compareUsers compares two users by the given columns, returning a negative number if a comes first and a positive number if b comes first
+func compareUsers(a, b *User, columns []UserSort) int {
+	for _, column := range columns {
+		var result int
+		switch column.Column {
+		case "id":
+			result = a.ID - b.ID
+		case "name":
+			result = strings.Compare(a.Name, b.Name)
+		case "email":
+			result = strings.Compare(a.Email, b.Email)
+		case "age":
+			result = a.Age - b.Age
+		}
+		if column.Descending {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
@@ -0,0 +1,51 @@
+package repository_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+)
+
+func TestInMemoryUserRepositoryConcurrency(t *testing.T) {
+	t.Parallel()
+	t.Run("concurrent creates get unique ids and emails", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := repository.NewInMemoryUserRepository()
+		waitGroup := sync.WaitGroup{}
+		ids := make([]int, 100)
+		errs := make([]error, 100)
+
+		// Act
+		for i := range ids {
+			waitGroup.Add(1)
+			go func(i int) {
+				defer waitGroup.Done()
+				// Every email is created twice, only one of them can succeed
+				user := &repository.User{Name: "Name", Email: fmt.Sprintf("email%d@email.com", i/2), Age: 37}
+				ids[i], errs[i] = userRepository.Create(tenantContext(), user)
+			}(i)
+		}
+		waitGroup.Wait()
+
+		// Assert
+		users, err := userRepository.GetAll(tenantContext())
+		require.NoError(t, err)
+		assert.Len(t, users, 50)
+		seenIDs := map[int]bool{}
+		for i, err := range errs {
+			if err != nil {
+				assert.Equal(t, repository.ErrUserAlreadyExists, err)
+				continue
+			}
+			assert.False(t, seenIDs[ids[i]])
+			seenIDs[ids[i]] = true
+		}
+		assert.Len(t, seenIDs, 50)
+	})
+}
@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// inMemoryWebhook is a stored webhook of a tenant, its deliveries belong to the same tenant
+type inMemoryWebhook struct {
+	tenantID string
+	webhook  *Webhook
+}
+
+// InMemoryWebhookRepository is a thread-safe repository for webhooks and their deliveries kept in memory, for tests and local development.
+// Webhooks and deliveries of other tenants than the tenant of the context are not found, like with PostgresWebhookRepository
+type InMemoryWebhookRepository struct {
+	mutex          sync.RWMutex
+	webhooks       map[int]*inMemoryWebhook
+	deliveries     []*WebhookDelivery
+	lastWebhookID  int
+	lastDeliveryID int
+}
+
+// NewInMemoryWebhookRepository creates a new empty InMemoryWebhookRepository.
+func NewInMemoryWebhookRepository() *InMemoryWebhookRepository {
+	return &InMemoryWebhookRepository{
+		webhooks:   map[int]*inMemoryWebhook{},
+		deliveries: []*WebhookDelivery{},
+	}
+}
+
+// GetWebhooks returns all webhooks ordered by id
+func (r *InMemoryWebhookRepository) GetWebhooks(ctx context.Context) ([]*Webhook, error) {
+	return r.selectWebhooks(ctx, func(webhook *Webhook) bool { return true })
+}
+
+// GetWebhook returns the webhook with the given id
+func (r *InMemoryWebhookRepository) GetWebhook(ctx context.Context, id int) (*Webhook, error) {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	webhook, ok := r.find(tenantID, id)
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	return copyWebhook(webhook), nil
+}
+
+// GetWebhooksForEvent returns the webhooks of the tenant subscribed to the event type ordered by id
+func (r *InMemoryWebhookRepository) GetWebhooksForEvent(ctx context.Context, eventType string) ([]*Webhook, error) {
+	return r.selectWebhooks(ctx, func(webhook *Webhook) bool {
+		for _, subscribed := range webhook.EventTypes {
+			if subscribed == eventType {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// CreateWebhook creates a new webhook
+func (r *InMemoryWebhookRepository) CreateWebhook(ctx context.Context, webhook *Webhook) (int, error) {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.lastWebhookID++
+	stored := copyWebhook(webhook)
+	stored.ID = r.lastWebhookID
+	stored.CreatedAt = time.Now().UTC()
+	r.webhooks[stored.ID] = &inMemoryWebhook{tenantID: tenantID, webhook: stored}
+	return stored.ID, nil
+}
+
+// UpdateWebhook updates the url, secret and event types of a webhook
+func (r *InMemoryWebhookRepository) UpdateWebhook(ctx context.Context, webhook *Webhook) error {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stored, ok := r.find(tenantID, webhook.ID)
+	if !ok {
+		return ErrWebhookNotFound
+	}
+	updated := copyWebhook(webhook)
+	updated.CreatedAt = stored.CreatedAt
+	r.webhooks[webhook.ID].webhook = updated
+	return nil
+}
+
+// DeleteWebhook deletes a webhook together with its deliveries
+func (r *InMemoryWebhookRepository) DeleteWebhook(ctx context.Context, id int) error {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.find(tenantID, id); !ok {
+		return ErrWebhookNotFound
+	}
+	delete(r.webhooks, id)
+	kept := r.deliveries[:0]
+	for _, delivery := range r.deliveries {
+		if delivery.WebhookID != id {
+			kept = append(kept, delivery)
+		}
+	}
+	r.deliveries = kept
+	return nil
+}
+
+// CreateDelivery records a delivery to a webhook
+func (r *InMemoryWebhookRepository) CreateDelivery(ctx context.Context, delivery *WebhookDelivery) (int, error) {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// The webhook may have been deleted while the event was delivered, or belong to another tenant
+	if _, ok := r.find(tenantID, delivery.WebhookID); !ok {
+		return 0, ErrWebhookNotFound
+	}
+	r.lastDeliveryID++
+	stored := *delivery
+	stored.ID = r.lastDeliveryID
+	stored.DeliveredAt = time.Now().UTC()
+	r.deliveries = append(r.deliveries, &stored)
+	return stored.ID, nil
+}
+
+// GetDelivery returns the delivery with the given id to the webhook with the given id
+func (r *InMemoryWebhookRepository) GetDelivery(ctx context.Context, webhookID, id int) (*WebhookDelivery, error) {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if _, ok := r.find(tenantID, webhookID); !ok {
+		return nil, ErrDeliveryNotFound
+	}
+	for _, delivery := range r.deliveries {
+		if delivery.ID == id && delivery.WebhookID == webhookID {
+			copied := *delivery
+			return &copied, nil
+		}
+	}
+	return nil, ErrDeliveryNotFound
+}
+
+// GetDeliveries returns up to query.Limit deliveries to the webhook with id query.WebhookID that come after the delivery with id query.AfterID, oldest first
+func (r *InMemoryWebhookRepository) GetDeliveries(ctx context.Context, query *WebhookDeliveryPageQuery) ([]*WebhookDelivery, error) {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	// Deliveries are appended in id order
+	deliveries := []*WebhookDelivery{}
+	if _, ok := r.find(tenantID, query.WebhookID); !ok {
+		return deliveries, nil
+	}
+	for _, delivery := range r.deliveries {
+		if len(deliveries) == query.Limit {
+			break
+		}
+		if delivery.WebhookID == query.WebhookID && delivery.ID > query.AfterID {
+			copied := *delivery
+			deliveries = append(deliveries, &copied)
+		}
+	}
+	return deliveries, nil
+}
+
+// selectWebhooks returns copies of the webhooks of the tenant of the context matching the predicate ordered by id
+func (r *InMemoryWebhookRepository) selectWebhooks(ctx context.Context, predicate func(webhook *Webhook) bool) ([]*Webhook, error) {
+	tenantID, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	webhooks := []*Webhook{}
+	for _, stored := range r.webhooks {
+		if stored.tenantID == tenantID && predicate(stored.webhook) {
+			webhooks = append(webhooks, copyWebhook(stored.webhook))
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+	return webhooks, nil
+}
+
+// begin returns the tenant of the context, or an error if the context does not carry one or is done
+func (r *InMemoryWebhookRepository) begin(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return tenantFromContext(ctx)
+}
+
+// find returns the stored webhook with the given id if it belongs to the tenant.
+// The caller must hold the mutex
+func (r *InMemoryWebhookRepository) find(tenantID string, id int) (*Webhook, bool) {
+	stored, ok := r.webhooks[id]
+	if !ok || stored.tenantID != tenantID {
+		return nil, false
+	}
+	return stored.webhook, true
+}
+
+// copyWebhook returns a deep copy of a webhook, so that callers cannot change stored webhooks
+func copyWebhook(webhook *Webhook) *Webhook {
+	copied := *webhook
+	copied.EventTypes = append([]string{}, webhook.EventTypes...)
+	return &copied
+}
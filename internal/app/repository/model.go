@@ -1,9 +1,189 @@
 package repository
 
+import "time"
+
 // User represents a user in the database.
 type User struct {
 	ID    int
 	Name  string
 	Email string
 	Age   int
+	// Version is incremented on every change and used for optimistic concurrency control.
+	Version int
+	// CreatedAt is when the user was created and UpdatedAt when it was last changed, both are set by the repository.
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// UserPatch changes some of the fields of a user, nil fields are left unchanged.
+type UserPatch struct {
+	ID    int
+	Name  *string
+	Email *string
+	Age   *int
+}
+
+// VersionMatch is the condition the current version of a user must meet for a change to be made
+type VersionMatch struct {
+	// Any matches every version
+	Any bool
+	// Versions are the versions that match
+	Versions []int
+}
+
+// MatchVersion returns a VersionMatch that matches only the given version
+func MatchVersion(version int) VersionMatch {
+	return VersionMatch{Versions: []int{version}}
+}
+
+// Matches returns true if the given current version of a user meets the condition
+func (m VersionMatch) Matches(version int) bool {
+	if m.Any {
+		return true
+	}
+	for _, v := range m.Versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// UserSearchResult is a user matching a search together with how well it matches.
+type UserSearchResult struct {
+	User
+	// Score is the trigram similarity between the search query and the best matching of name and email, from 0 to 1.
+	Score float64
+}
+
+// UserPageQuery describes a keyset paginated query for users.
+type UserPageQuery struct {
+	// After is the cursor of the last user of the previous page, or nil for the first page.
+	After *UserCursor
+	// Limit is the maximum number of users to return.
+	Limit int
+	// Filter restricts which users are returned, nil returns all users.
+	Filter *UserFilter
+	// Sort is the order of the users, id is always used as the final tiebreaker.
+	Sort []UserSort
+}
+
+// UserCursor is the position of a user in a sort order, the values the user had when the page was read of the columns users are sorted by.
+// Users are compared with the values rather than with the user itself, so that the pagination continues where it stopped when the user
+// has since been changed or deleted.
+type UserCursor struct {
+	ID    int
+	Name  string
+	Email string
+	Age   int
+}
+
+// NewUserCursor returns the cursor of the user.
+func NewUserCursor(user *User) *UserCursor {
+	return &UserCursor{ID: user.ID, Name: user.Name, Email: user.Email, Age: user.Age}
+}
+
+// UserFilter restricts which users are returned by a query.
+type UserFilter struct {
+	// EmailDomain matches users whose email domain equals the given domain, ignoring case.
+	EmailDomain string
+	// NamePrefix matches users whose name starts with the given prefix, ignoring case.
+	NamePrefix string
+	// MinAge matches users at least the given age.
+	MinAge *int
+	// MaxAge matches users at most the given age.
+	MaxAge *int
+	// UpdatedSince matches users last changed at or after the given time.
+	UpdatedSince *time.Time
+}
+
+// UserSort orders users by a column.
+type UserSort struct {
+	Column     string
+	Descending bool
+}
+
+// UserHistoryEntry is a recorded change of a user.
+type UserHistoryEntry struct {
+	ID     int
+	UserID int
+	// Operation is one of the HistoryOperation constants.
+	Operation string
+	// ChangedBy is the actor that made the change.
+	ChangedBy string
+	ChangedAt time.Time
+	// Before is the user before the change, nil if the change created or restored the user.
+	Before *User
+	// After is the user after the change, nil if the change deleted or purged the user.
+	After *User
+}
+
+// UserHistoryPageQuery describes a keyset paginated query for the history of a user.
+type UserHistoryPageQuery struct {
+	UserID int
+	// AfterID is the id of the last entry of the previous page, or 0 for the first page.
+	AfterID int
+	// Limit is the maximum number of entries to return.
+	Limit int
+}
+
+// OutboxEvent is a change of a user waiting in the outbox to be published.
+type OutboxEvent struct {
+	ID int64
+	// TenantID is the tenant of the user.
+	TenantID string
+	// Type is one of the OutboxEvent constants.
+	Type   string
+	UserID int
+	// User is the user after the change, or before it if the change deleted the user.
+	User      *User
+	CreatedAt time.Time
+}
+
+// Webhook is a subscription of a URL to user events.
+type Webhook struct {
+	ID  int
+	URL string
+	// Secret is the key the deliveries are signed with.
+	Secret string
+	// EventTypes are the OutboxEvent constants the webhook is subscribed to.
+	EventTypes []string
+	CreatedAt  time.Time
+}
+
+// WebhookDelivery is a recorded delivery of an event to a webhook.
+type WebhookDelivery struct {
+	ID        int    `db:"id"`
+	WebhookID int    `db:"webhook_id"`
+	EventID   int64  `db:"event_id"`
+	EventType string `db:"event_type"`
+	// Payload is the JSON body that was delivered.
+	Payload string `db:"payload"`
+	// StatusCode is the status of the last response, 0 if there was none.
+	StatusCode int `db:"status_code"`
+	Attempts   int `db:"attempts"`
+	// Error is why the delivery failed, empty if it succeeded.
+	Error       string    `db:"error"`
+	DeliveredAt time.Time `db:"delivered_at"`
+}
+
+// WebhookDeliveryPageQuery describes a keyset paginated query for the deliveries of a webhook.
+type WebhookDeliveryPageQuery struct {
+	WebhookID int
+	// AfterID is the id of the last delivery of the previous page, or 0 for the first page.
+	AfterID int
+	// Limit is the maximum number of deliveries to return.
+	Limit int
+}
+
+// IdempotencyKey is a key under which the response of a request is stored, so that retries of the request get the same response.
+type IdempotencyKey struct {
+	Key string `db:"key"`
+	// Fingerprint identifies the request the key is used for.
+	Fingerprint string `db:"fingerprint"`
+	// StatusCode is the status of the stored response, 0 while the request is in flight and the key is locked.
+	StatusCode  int       `db:"status_code"`
+	ContentType string    `db:"content_type"`
+	Body        []byte    `db:"body"`
+	ExpiresAt   time.Time `db:"expires_at"`
 }
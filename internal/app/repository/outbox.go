@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/tobiassundman/go-demo-app/pkg/database"
+)
+
+// Types of the events written to the outbox.
+const (
+	OutboxEventUserCreated  = "user.created"
+	OutboxEventUserUpdated  = "user.updated"
+	OutboxEventUserDeleted  = "user.deleted"
+	OutboxEventUserRestored = "user.restored"
+)
+
+const (
+	postgresInsertOutboxEventQuery = `INSERT INTO config.outbox (tenant_id, event_type, user_id, payload) VALUES ($1, $2, $3, $4::jsonb)`
+	// The events of every tenant are claimed, deleted and released by functions that are not subject to the row level security policy.
+	// postgresClaimOutboxEventsQuery leases the oldest events that are not leased for $2 seconds,
+	// skipping the events being claimed by other relays at the same time so that each event is claimed by one relay
+	postgresClaimOutboxEventsQuery   = `SELECT id, tenant_id, event_type, user_id, payload, created_at FROM config.claim_outbox_events($1, $2)`
+	postgresDeleteOutboxEventsQuery  = `SELECT config.delete_outbox_events($1)`
+	postgresReleaseOutboxEventsQuery = `SELECT config.release_outbox_events($1)`
+)
+
+// OutboxRepository gives access to the events of user changes that are waiting to be published
+type OutboxRepository interface {
+	// PublishBatch calls publish with up to limit of the oldest waiting events of every tenant in order, stopping at the first error publish returns,
+	// and removes the events that were published. Events that are being published by another call are skipped.
+	// It returns how many events were published together with the error of publish, an event may be published again if removing it fails
+	PublishBatch(ctx context.Context, limit int, publish func(ctx context.Context, event *OutboxEvent) error) (int, error)
+}
+
+// outboxRow is a row of config.outbox.
+type outboxRow struct {
+	ID        int64         `db:"id"`
+	TenantID  string        `db:"tenant_id"`
+	EventType string        `db:"event_type"`
+	UserID    int           `db:"user_id"`
+	Payload   *userSnapshot `db:"payload"`
+	CreatedAt time.Time     `db:"created_at"`
+}
+
+// PostgresOutboxRepository is an outbox in a Postgres database, written by PostgresUserRepository in the same transaction as each change of a user
+type PostgresOutboxRepository struct {
+	db           database.Pool
+	queryTimeout time.Duration
+	lease        time.Duration
+}
+
+// NewPostgresOutboxRepository creates a new PostgresOutboxRepository for the primary database of the user repository.
+// The query timeout applies to each statement. A batch is leased rather than locked while it is published,
+// so the lease must be longer than publishing a batch takes, or its events may be published again by another call.
+func NewPostgresOutboxRepository(db database.Pool, queryTimeout, lease time.Duration) *PostgresOutboxRepository {
+	return &PostgresOutboxRepository{
+		db:           db,
+		queryTimeout: queryTimeout,
+		lease:        lease,
+	}
+}
+
+// PublishBatch calls publish with up to limit of the oldest waiting events of every tenant in order, stopping at the first error publish returns,
+// and removes the events that were published. Events that are being published by another call are skipped.
+// It returns how many events were published together with the error of publish, an event may be published again if removing it fails.
+// The events are claimed and removed in two short transactions, no transaction or connection is held while they are published.
+// Events after the one that failed to publish are released right away, and every event of the batch once the lease runs out
+func (r *PostgresOutboxRepository) PublishBatch(ctx context.Context, limit int, publish func(ctx context.Context, event *OutboxEvent) error) (int, error) {
+	rows, err := r.claim(ctx, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	published := []int64{}
+	var publishErr error
+	for _, row := range rows {
+		publishErr = publish(ctx, &OutboxEvent{
+			ID:        row.ID,
+			TenantID:  row.TenantID,
+			Type:      row.EventType,
+			UserID:    row.UserID,
+			User:      row.Payload.user(),
+			CreatedAt: row.CreatedAt,
+		})
+		if publishErr != nil {
+			break
+		}
+		published = append(published, row.ID)
+	}
+
+	released := []int64{}
+	for _, row := range rows[len(published):] {
+		released = append(released, row.ID)
+	}
+	if err := r.finish(ctx, published, released); err != nil {
+		return 0, errors.Join(publishErr, err)
+	}
+	return len(published), publishErr
+}
+
+// claim leases up to limit of the oldest events that are not leased, returning them in order
+func (r *PostgresOutboxRepository) claim(ctx context.Context, limit int) ([]*outboxRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	var rows []*outboxRow
+	err := runInTx(ctx, r.db, pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		var err error
+		rows, err = selectRows[outboxRow](ctx, tx, postgresClaimOutboxEventsQuery, limit, r.lease.Seconds())
+		return err
+	})
+	// The rows are returned in no particular order
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].ID < rows[j].ID
+	})
+	return rows, err
+}
+
+// finish removes the published events and releases the lease of the events that were not published
+func (r *PostgresOutboxRepository) finish(ctx context.Context, published, released []int64) error {
+	if len(published) == 0 && len(released) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	return runInTx(ctx, r.db, pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		batch := &pgx.Batch{}
+		if len(published) > 0 {
+			batch.Queue(postgresDeleteOutboxEventsQuery, published)
+		}
+		if len(released) > 0 {
+			batch.Queue(postgresReleaseOutboxEventsQuery, released)
+		}
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+// queueOutboxEvent queues the write of an event of a change of a user in a batch sent in the transaction of the change,
+// so that it is only published if the change is committed
+func queueOutboxEvent(batch *pgx.Batch, tenantID, eventType string, user *User) {
+	batch.Queue(postgresInsertOutboxEventQuery, tenantID, eventType, user.ID, newUserSnapshot(user))
+}
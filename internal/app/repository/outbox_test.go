@@ -0,0 +1,305 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
+	"github.com/tobiassundman/go-demo-app/pkg/test"
+)
+
+// newOutboxRepositoriesFunc creates an empty user repository and the outbox it writes to for a test.
+type newOutboxRepositoriesFunc func(t *testing.T) (repository.UserRepository, repository.OutboxRepository)
+
+// collectEvents returns a publish function that collects the published events.
+func collectEvents(events *[]*repository.OutboxEvent) func(ctx context.Context, event *repository.OutboxEvent) error {
+	return func(ctx context.Context, event *repository.OutboxEvent) error {
+		*events = append(*events, event)
+		return nil
+	}
+}
+
+// testOutboxRepository runs the conformance suite that every OutboxRepository implementation must pass.
+func testOutboxRepository(t *testing.T, newRepositories newOutboxRepositoriesFunc) {
+	t.Run("records every change in order", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository, outboxRepository := newRepositories(t)
+		ctx := tenantContext()
+
+		id, err := userRepository.Create(ctx, &USER1)
+		require.NoError(t, err)
+		modifiedUser := USER1
+		modifiedUser.Name = "Modified Name"
+		err = userRepository.Update(ctx, &modifiedUser, repository.MatchVersion(modifiedUser.Version))
+		require.NoError(t, err)
+		err = userRepository.Delete(ctx, id, repository.MatchVersion(2))
+		require.NoError(t, err)
+		err = userRepository.Restore(ctx, id)
+		require.NoError(t, err)
+		_, err = userRepository.CreateBatch(ctx, []*repository.User{&USER2}, false)
+		require.NoError(t, err)
+
+		// Act
+		events := []*repository.OutboxEvent{}
+		published, err := outboxRepository.PublishBatch(ctx, 10, collectEvents(&events))
+		require.NoError(t, err)
+
+		// Assert
+		require.Equal(t, 5, published)
+		require.Len(t, events, 5)
+		updatedUser := modifiedUser
+		updatedUser.Version = 2
+		deletedUser := updatedUser
+		deletedUser.Version = 3
+		restoredUser := updatedUser
+		restoredUser.Version = 4
+
+		assert.Equal(t, repository.OutboxEventUserCreated, events[0].Type)
+		assert.Equal(t, &USER1, events[0].User)
+		assert.Equal(t, repository.OutboxEventUserUpdated, events[1].Type)
+		assert.Equal(t, &updatedUser, events[1].User)
+		assert.Equal(t, repository.OutboxEventUserDeleted, events[2].Type)
+		assert.Equal(t, &deletedUser, events[2].User)
+		assert.Equal(t, repository.OutboxEventUserRestored, events[3].Type)
+		assert.Equal(t, &restoredUser, events[3].User)
+		assert.Equal(t, repository.OutboxEventUserCreated, events[4].Type)
+		assert.Equal(t, &USER2, events[4].User)
+		for i, event := range events {
+			assert.Equal(t, event.User.ID, event.UserID)
+			assert.Equal(t, TENANT1, event.TenantID)
+			if i > 0 {
+				assert.Greater(t, event.ID, events[i-1].ID)
+			}
+		}
+	})
+
+	t.Run("publishes events of every tenant", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository, outboxRepository := newRepositories(t)
+		_, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		_, err = userRepository.Create(tenant.NewContext(context.Background(), TENANT2), &USER2)
+		require.NoError(t, err)
+
+		// Act
+		events := []*repository.OutboxEvent{}
+		published, err := outboxRepository.PublishBatch(context.Background(), 10, collectEvents(&events))
+		require.NoError(t, err)
+
+		// Assert
+		require.Equal(t, 2, published)
+		require.Len(t, events, 2)
+		assert.Equal(t, TENANT1, events[0].TenantID)
+		assert.Equal(t, USER1.Email, events[0].User.Email)
+		assert.Equal(t, TENANT2, events[1].TenantID)
+		assert.Equal(t, USER2.Email, events[1].User.Email)
+	})
+
+	t.Run("failed change is not recorded", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository, outboxRepository := newRepositories(t)
+		_, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		_, err = outboxRepository.PublishBatch(tenantContext(), 10, collectEvents(&[]*repository.OutboxEvent{}))
+		require.NoError(t, err)
+
+		// Act
+		staleUser := USER1
+		staleUser.Version = 5
+		err = userRepository.Update(tenantContext(), &staleUser, repository.MatchVersion(staleUser.Version))
+		require.ErrorIs(t, err, repository.ErrVersionConflict)
+		_, err = userRepository.Create(tenantContext(), &USER1)
+		require.ErrorIs(t, err, repository.ErrUserAlreadyExists)
+
+		// Assert
+		events := []*repository.OutboxEvent{}
+		published, err := outboxRepository.PublishBatch(tenantContext(), 10, collectEvents(&events))
+		require.NoError(t, err)
+		assert.Equal(t, 0, published)
+		assert.Empty(t, events)
+	})
+
+	t.Run("publishes up to limit and removes published events", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository, outboxRepository := newRepositories(t)
+		_, err := userRepository.CreateBatch(tenantContext(), []*repository.User{&USER1, &USER2}, false)
+		require.NoError(t, err)
+
+		// Act
+		firstEvents := []*repository.OutboxEvent{}
+		firstPublished, err := outboxRepository.PublishBatch(tenantContext(), 1, collectEvents(&firstEvents))
+		require.NoError(t, err)
+		secondEvents := []*repository.OutboxEvent{}
+		secondPublished, err := outboxRepository.PublishBatch(tenantContext(), 10, collectEvents(&secondEvents))
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, 1, firstPublished)
+		assert.Equal(t, 1, secondPublished)
+		require.Len(t, firstEvents, 1)
+		require.Len(t, secondEvents, 1)
+		assert.ElementsMatch(t, []string{USER1.Email, USER2.Email}, []string{firstEvents[0].User.Email, secondEvents[0].User.Email})
+	})
+
+	t.Run("keeps events from the first that failed to publish", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository, outboxRepository := newRepositories(t)
+		_, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		_, err = userRepository.Create(tenantContext(), &USER2)
+		require.NoError(t, err)
+		errPublish := errors.New("publish failed")
+
+		// Act
+		failedEvents := []*repository.OutboxEvent{}
+		failedPublished, failedErr := outboxRepository.PublishBatch(tenantContext(), 10, func(ctx context.Context, event *repository.OutboxEvent) error {
+			if event.UserID == USER2.ID {
+				return errPublish
+			}
+			failedEvents = append(failedEvents, event)
+			return nil
+		})
+		retriedEvents := []*repository.OutboxEvent{}
+		retriedPublished, retriedErr := outboxRepository.PublishBatch(tenantContext(), 10, collectEvents(&retriedEvents))
+
+		// Assert
+		assert.ErrorIs(t, failedErr, errPublish)
+		assert.Equal(t, 1, failedPublished)
+		require.Len(t, failedEvents, 1)
+		assert.Equal(t, USER1.ID, failedEvents[0].UserID)
+		require.NoError(t, retriedErr)
+		assert.Equal(t, 1, retriedPublished)
+		require.Len(t, retriedEvents, 1)
+		assert.Equal(t, USER2.ID, retriedEvents[0].UserID)
+	})
+
+	t.Run("concurrent batches publish each event once", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository, outboxRepository := newRepositories(t)
+		users := make([]*repository.User, 20)
+		for i := range users {
+			user := USER1
+			user.Email = string(rune('a'+i)) + USER1.Email
+			users[i] = &user
+		}
+		_, err := userRepository.CreateBatch(tenantContext(), users, true)
+		require.NoError(t, err)
+
+		// Act
+		mutex := sync.Mutex{}
+		published := map[int64]int{}
+		waitGroup := sync.WaitGroup{}
+		for i := 0; i < 4; i++ {
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+				for {
+					count, err := outboxRepository.PublishBatch(tenantContext(), 3, func(ctx context.Context, event *repository.OutboxEvent) error {
+						time.Sleep(time.Millisecond)
+						mutex.Lock()
+						defer mutex.Unlock()
+						published[event.ID]++
+						return nil
+					})
+					if err != nil || count == 0 {
+						return
+					}
+				}
+			}()
+		}
+		waitGroup.Wait()
+
+		// Assert
+		assert.Len(t, published, 20)
+		for id, count := range published {
+			assert.Equal(t, 1, count, "event %d", id)
+		}
+	})
+}
+
+func TestPostgresOutboxRepository(t *testing.T) {
+	t.Parallel()
+	testOutboxRepository(t, func(t *testing.T) (repository.UserRepository, repository.OutboxRepository) {
+		db := test.StartDatabase(t)
+		t.Cleanup(func() { db.Close() })
+		return repository.NewPostgresUserRepository(db, time.Second*2), repository.NewPostgresOutboxRepository(db, time.Second*2, time.Minute)
+	})
+}
+
+func TestPostgresOutboxRepositoryLease(t *testing.T) {
+	t.Parallel()
+	db := test.StartDatabase(t)
+	t.Cleanup(func() { db.Close() })
+	userRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+
+	t.Run("holds no transaction while publishing", func(t *testing.T) {
+		// Arrange
+		outboxRepository := repository.NewPostgresOutboxRepository(db, time.Second*2, time.Minute)
+		_, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+
+		// Act
+		openTransactions := -1
+		_, err = outboxRepository.PublishBatch(context.Background(), 10, func(ctx context.Context, event *repository.OutboxEvent) error {
+			return db.QueryRow(ctx, "SELECT count(*) FROM pg_stat_activity WHERE state LIKE 'idle in transaction%'").Scan(&openTransactions)
+		})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, 0, openTransactions)
+	})
+
+	t.Run("publishes events of a stopped batch again once the lease runs out", func(t *testing.T) {
+		// Arrange
+		outboxRepository := repository.NewPostgresOutboxRepository(db, time.Second*2, time.Second)
+		_, err := userRepository.Create(tenantContext(), &USER2)
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		_, err = outboxRepository.PublishBatch(ctx, 10, func(ctx context.Context, event *repository.OutboxEvent) error {
+			// The batch stops before it removes or releases its events, as if the relay had crashed
+			cancel()
+			return ctx.Err()
+		})
+		require.Error(t, err)
+
+		// Act
+		leasedEvents := []*repository.OutboxEvent{}
+		_, leasedErr := outboxRepository.PublishBatch(context.Background(), 10, collectEvents(&leasedEvents))
+		time.Sleep(time.Second * 2)
+		expiredEvents := []*repository.OutboxEvent{}
+		_, expiredErr := outboxRepository.PublishBatch(context.Background(), 10, collectEvents(&expiredEvents))
+
+		// Assert
+		require.NoError(t, leasedErr)
+		assert.Empty(t, leasedEvents)
+		require.NoError(t, expiredErr)
+		require.Len(t, expiredEvents, 1)
+		assert.Equal(t, USER2.Email, expiredEvents[0].User.Email)
+	})
+}
+
+func TestInMemoryOutboxRepository(t *testing.T) {
+	t.Parallel()
+	testOutboxRepository(t, func(t *testing.T) (repository.UserRepository, repository.OutboxRepository) {
+		userRepository := repository.NewInMemoryUserRepository()
+		return userRepository, userRepository
+	})
+}
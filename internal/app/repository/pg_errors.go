@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// constraintColumns are the columns checked by the named check constraints, Postgres reports only the name of a violated check constraint
+var constraintColumns = map[string]string{
+	"users_age_not_negative": "age",
+	"users_name_not_blank":   "name",
+}
+
+// translatePgError translates an error of the database rejecting a written value into a *ConstraintError, other errors are returned as they are.
+// Postgres does not report the column of a value that is too long or out of range, their Column is empty
+func translatePgError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	var kind error
+	switch pgErr.Code {
+	case pgerrcode.CheckViolation:
+		kind = ErrCheckViolation
+	case pgerrcode.NotNullViolation:
+		kind = ErrNotNullViolation
+	case pgerrcode.StringDataRightTruncationDataException:
+		kind = ErrValueTooLong
+	case pgerrcode.NumericValueOutOfRange:
+		kind = ErrValueOutOfRange
+	default:
+		return err
+	}
+	column := pgErr.ColumnName
+	if column == "" {
+		column = constraintColumns[pgErr.ConstraintName]
+	}
+	return &ConstraintError{
+		Kind:       kind,
+		Column:     column,
+		Constraint: pgErr.ConstraintName,
+		err:        err,
+	}
+}
+
+// isUniqueViolation returns true if the error is caused by a unique constraint, i.e. the email of another user
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation
+}
+
+// isForeignKeyViolation returns true if the error is caused by a foreign key constraint, i.e. a reference to a row that does not exist
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation
+}
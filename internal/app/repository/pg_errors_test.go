@@ -0,0 +1,84 @@
+package repository_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/pkg/test"
+)
+
+func TestPostgresConstraintErrors(t *testing.T) {
+	t.Parallel()
+	db := test.StartDatabase(t)
+	t.Cleanup(func() { db.Close() })
+	userRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+	webhookRepository := repository.NewPostgresWebhookRepository(db, time.Second*2)
+
+	t.Run("check violation reports column of constraint", func(t *testing.T) {
+		// Arrange
+		user := USER1
+		user.Age = -1
+
+		// Act
+		_, err := userRepository.Create(tenantContext(), &user)
+
+		// Assert
+		assert.ErrorIs(t, err, repository.ErrCheckViolation)
+		constraintErr := &repository.ConstraintError{}
+		require.ErrorAs(t, err, &constraintErr)
+		assert.Equal(t, "age", constraintErr.Column)
+		assert.Equal(t, "users_age_not_negative", constraintErr.Constraint)
+	})
+
+	t.Run("too long value of update", func(t *testing.T) {
+		// Arrange
+		user := USER2
+		id, err := userRepository.Create(tenantContext(), &user)
+		require.NoError(t, err)
+		user.ID = id
+		user.Name = strings.Repeat("a", 256)
+
+		// Act
+		err = userRepository.Update(tenantContext(), &user, repository.MatchVersion(user.Version))
+
+		// Assert
+		assert.ErrorIs(t, err, repository.ErrValueTooLong)
+		updated, getErr := userRepository.Get(tenantContext(), id)
+		require.NoError(t, getErr)
+		assert.Equal(t, USER2.Name, updated.Name)
+	})
+
+	t.Run("too long value of patch", func(t *testing.T) {
+		// Arrange
+		user := repository.User{Name: "Name Name 3", Email: "email3@email.com", Age: 3}
+		id, err := userRepository.Create(tenantContext(), &user)
+		require.NoError(t, err)
+		email := strings.Repeat("a", 256) + "@email.com"
+
+		// Act
+		_, err = userRepository.Patch(tenantContext(), &repository.UserPatch{ID: id, Email: &email}, repository.MatchVersion(1))
+
+		// Assert
+		assert.ErrorIs(t, err, repository.ErrValueTooLong)
+	})
+
+	t.Run("too long webhook url", func(t *testing.T) {
+		// Arrange
+		webhook := &repository.Webhook{
+			URL:        "https://example.com/" + strings.Repeat("a", 2048),
+			Secret:     "secret",
+			EventTypes: []string{repository.OutboxEventUserCreated},
+		}
+
+		// Act
+		_, err := webhookRepository.CreateWebhook(context.Background(), webhook)
+
+		// Assert
+		assert.ErrorIs(t, err, repository.ErrValueTooLong)
+	})
+}
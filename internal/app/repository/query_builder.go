@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sortableUserColumns is the allow-list of columns users can be sorted by, keyed by sort column name.
+var sortableUserColumns = map[string]string{
+	"id":    "id",
+	"name":  "name",
+	"email": "email",
+	"age":   "age",
+}
+
+// userQueryBuilder builds parameterized queries against config.users.
+type userQueryBuilder struct {
+	conditions []string
+	args       []any
+}
+
+// addArg adds a query argument and returns its placeholder.
+func (b *userQueryBuilder) addArg(value any) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+// where adds a condition that rows must match.
+func (b *userQueryBuilder) where(condition string) {
+	b.conditions = append(b.conditions, condition)
+}
+
+// whereClause returns the WHERE clause of all added conditions, or an empty string if there are none.
+func (b *userQueryBuilder) whereClause() string {
+	if len(b.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(b.conditions, " AND ")
+}
+
+// addFilter adds the conditions of the given filter, prefixing columns with the given table alias.
+func (b *userQueryBuilder) addFilter(alias string, filter *UserFilter) {
+	if filter == nil {
+		return
+	}
+	if filter.EmailDomain != "" {
+		b.where(fmt.Sprintf("lower(split_part(%s.email, '@', 2)) = lower(%s)", alias, b.addArg(filter.EmailDomain)))
+	}
+	if filter.NamePrefix != "" {
+		b.where(fmt.Sprintf("starts_with(lower(%s.name), lower(%s))", alias, b.addArg(filter.NamePrefix)))
+	}
+	if filter.MinAge != nil {
+		b.where(fmt.Sprintf("%s.age >= %s", alias, b.addArg(*filter.MinAge)))
+	}
+	if filter.MaxAge != nil {
+		b.where(fmt.Sprintf("%s.age <= %s", alias, b.addArg(*filter.MaxAge)))
+	}
+	if filter.UpdatedSince != nil {
+		b.where(fmt.Sprintf("%s.updated_at >= %s", alias, b.addArg(*filter.UpdatedSince)))
+	}
+}
+
+// sortColumns resolves the sort order against the allow-list and appends id as a tiebreaker,
+// since keyset pagination requires a total order.
+func sortColumns(sort []UserSort) ([]UserSort, error) {
+	columns := make([]UserSort, 0, len(sort)+1)
+	hasID := false
+	for _, s := range sort {
+		column, ok := sortableUserColumns[s.Column]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSortColumn, s.Column)
+		}
+		if column == "id" {
+			hasID = true
+		}
+		columns = append(columns, UserSort{Column: column, Descending: s.Descending})
+	}
+	if !hasID {
+		columns = append(columns, UserSort{Column: "id"})
+	}
+	return columns, nil
+}
+
+// addKeyset adds the condition selecting rows that come after the cursor in the given order.
+func (b *userQueryBuilder) addKeyset(columns []UserSort, cursor *UserCursor) {
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = b.addArg(cursorValue(cursor, column.Column))
+	}
+	alternatives := make([]string, len(columns))
+	for i, column := range columns {
+		parts := make([]string, 0, i+1)
+		for j, previous := range columns[:i] {
+			parts = append(parts, fmt.Sprintf("u.%s = %s", previous.Column, values[j]))
+		}
+		operator := ">"
+		if column.Descending {
+			operator = "<"
+		}
+		parts = append(parts, fmt.Sprintf("u.%s %s %s", column.Column, operator, values[i]))
+		alternatives[i] = "(" + strings.Join(parts, " AND ") + ")"
+	}
+	b.where("(" + strings.Join(alternatives, " OR ") + ")")
+}
+
+// cursorValue returns the value of the cursor for a column of sortableUserColumns.
+func cursorValue(cursor *UserCursor, column string) any {
+	switch column {
+	case "name":
+		return cursor.Name
+	case "email":
+		return cursor.Email
+	case "age":
+		return cursor.Age
+	default:
+		return cursor.ID
+	}
+}
+
+// orderByClause returns the ORDER BY expression for the given columns.
+func orderByClause(columns []UserSort) string {
+	parts := make([]string, len(columns))
+	for i, column := range columns {
+		direction := "ASC"
+		if column.Descending {
+			direction = "DESC"
+		}
+		parts[i] = fmt.Sprintf("u.%s %s", column.Column, direction)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// buildUserPageQuery builds the query for a page of users of the tenant.
+func buildUserPageQuery(tenantID string, query *UserPageQuery) (string, []any, error) {
+	columns, err := sortColumns(query.Sort)
+	if err != nil {
+		return "", nil, err
+	}
+
+	b := &userQueryBuilder{}
+	b.where("u.tenant_id = " + b.addArg(tenantID))
+	b.where("u.deleted_at IS NULL")
+	if query.After != nil {
+		b.addKeyset(columns, query.After)
+	}
+	b.addFilter("u", query.Filter)
+	limit := b.addArg(query.Limit)
+
+	statement := fmt.Sprintf(
+		"SELECT u.id, u.name, u.email, u.age, u.version, u.created_at, u.updated_at FROM config.users u%s ORDER BY %s LIMIT %s",
+		b.whereClause(), orderByClause(columns), limit,
+	)
+	return statement, b.args, nil
+}
+
+// buildUserExportQuery builds the query for every user of the tenant matching the filter in id order.
+func buildUserExportQuery(tenantID string, filter *UserFilter) (string, []any) {
+	b := &userQueryBuilder{}
+	b.where("u.tenant_id = " + b.addArg(tenantID))
+	b.where("u.deleted_at IS NULL")
+	b.addFilter("u", filter)
+
+	statement := fmt.Sprintf("SELECT u.id, u.name, u.email, u.age, u.version, u.created_at, u.updated_at FROM config.users u%s ORDER BY u.id", b.whereClause())
+	return statement, b.args
+}
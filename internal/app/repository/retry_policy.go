@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tobiassundman/go-demo-app/pkg/retry"
+	"go.uber.org/zap"
+)
+
+// RetryPolicyConfig configures a RetryPolicy.
+type RetryPolicyConfig struct {
+	// Timeout is how long an operation is retried.
+	Timeout time.Duration
+	// MaxRetries is the most times an operation is retried.
+	MaxRetries int
+	// Budget is shared by every operation retried with the policy, so that retries cannot multiply the load on a failing database. Nil does not limit the retries.
+	Budget *retry.Budget
+	// Logger logs every retry, nil does not log them.
+	Logger *zap.Logger
+	// Registerer registers the retry metrics, nil leaves them unregistered.
+	Registerer prometheus.Registerer
+}
+
+// RetryPolicy retries repository operations that fail with a transient error, such as a failover or a lost connection.
+// A nil RetryPolicy runs every operation once
+type RetryPolicy struct {
+	policy retry.Policy
+	logger *zap.Logger
+
+	retries   *prometheus.CounterVec
+	exhausted *prometheus.CounterVec
+}
+
+// NewRetryPolicy creates a RetryPolicy.
+func NewRetryPolicy(config RetryPolicyConfig) *RetryPolicy {
+	logger := config.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	factory := promauto.With(config.Registerer)
+	return &RetryPolicy{
+		policy: retry.Policy{
+			Timeout:         config.Timeout,
+			MaxRetries:      config.MaxRetries,
+			InitialInterval: time.Millisecond * 50,
+			Budget:          config.Budget,
+		},
+		logger: logger,
+		retries: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_retries_total",
+			Help: "Number of database operations retried after a transient error, by operation and reason.",
+		}, []string{"operation", "reason"}),
+		exhausted: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_retry_budget_exhausted_total",
+			Help: "Number of transient database errors that were not retried because the retry budget was exhausted, by operation.",
+		}, []string{"operation"}),
+	}
+}
+
+// run runs fn, retrying it after a transient error if the operation is idempotent or the failed attempt was never sent to the database.
+// Nothing is retried in a transaction carried by the context, since the failed statement has aborted the whole transaction
+func (p *RetryPolicy) run(ctx context.Context, operation string, idempotent bool, fn func(ctx context.Context) error) error {
+	if _, ok := txFromContext(ctx); p == nil || ok {
+		return fn(ctx)
+	}
+
+	policy := p.policy
+	policy.OnRetry = func(err error, delay time.Duration) {
+		reason := transientErrorReason(err)
+		p.retries.WithLabelValues(operation, reason).Inc()
+		p.logger.Warn("Retrying database operation after transient error",
+			zap.String("operation", operation), zap.String("reason", reason), zap.Duration("delay", delay), zap.Error(err))
+	}
+	err := retry.Do(ctx, policy, func(ctx context.Context) error {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if transientErrorReason(err) == "" || (!idempotent && !wasNotSent(err)) {
+			return retry.Permanent(err)
+		}
+		return err
+	})
+	if errors.Is(err, retry.ErrBudgetExhausted) {
+		p.exhausted.WithLabelValues(operation).Inc()
+		p.logger.Warn("Not retrying database operation, retry budget exhausted", zap.String("operation", operation), zap.Error(err))
+	}
+	return err
+}
+
+// transientErrorReason returns why the error is transient, or an empty string if the same statement would fail again
+func transientErrorReason(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return ""
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == pgerrcode.SerializationFailure,
+			pgErr.Code == pgerrcode.DeadlockDetected,
+			pgErr.Code == pgerrcode.AdminShutdown,
+			pgErr.Code == pgerrcode.CrashShutdown,
+			pgErr.Code == pgerrcode.CannotConnectNow,
+			pgerrcode.IsConnectionException(pgErr.Code):
+			return pgErr.Code
+		}
+		return ""
+	}
+
+	var netErr net.Error
+	switch {
+	case isSafeToRetry(err):
+		return "bad_connection"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection_reset"
+	case errors.As(err, &netErr):
+		return "network"
+	}
+	return ""
+}
+
+// wasNotSent returns true if the error guarantees that the failed statement never reached the database, so that retrying it cannot apply it twice.
+// That is the case when no connection could be made or pgx failed before sending anything on its connection
+func wasNotSent(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == pgerrcode.CannotConnectNow ||
+			pgErr.Code == pgerrcode.SQLClientUnableToEstablishSQLConnection ||
+			pgErr.Code == pgerrcode.SQLServerRejectedEstablishmentOfSQLConnection
+	}
+	return isSafeToRetry(err)
+}
+
+// isSafeToRetry returns true if pgx reports that the error occurred before anything was sent to the database, such as a connection that was closed or busy.
+// pgconn.SafeToRetry only checks the error itself, not the errors it wraps
+func isSafeToRetry(err error) bool {
+	var safeErr interface{ SafeToRetry() bool }
+	return errors.As(err, &safeErr) && safeErr.SafeToRetry()
+}
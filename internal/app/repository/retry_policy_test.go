@@ -0,0 +1,146 @@
+package repository_test
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/pkg/retry"
+	"github.com/tobiassundman/go-demo-app/pkg/test"
+)
+
+// openDatabase opens a database at the address without connecting to it.
+func openDatabase(t *testing.T, address string) *pgxpool.Pool {
+	host, port, err := net.SplitHostPort(address)
+	require.NoError(t, err)
+	db := test.Connect(t, fmt.Sprintf("host=%s port=%s user=demo_user password=demo_password dbname=demo_db sslmode=disable", host, port))
+	t.Cleanup(db.Close)
+	return db
+}
+
+// newRefusingDatabase opens a database on a port nobody listens on, so that every connection is refused before a statement is sent.
+func newRefusingDatabase(t *testing.T) *pgxpool.Pool {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	return openDatabase(t, address)
+}
+
+// newDroppingDatabase opens a database whose server closes every connection as soon as it is made, so that statements may have been sent.
+func newDroppingDatabase(t *testing.T) *pgxpool.Pool {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connection.Close()
+		}
+	}()
+	return openDatabase(t, listener.Addr().String())
+}
+
+// newRetryingRepository creates a user repository of the database with a retry policy registering its metrics in the registry.
+func newRetryingRepository(db *pgxpool.Pool, registry prometheus.Registerer, budget *retry.Budget) *repository.PostgresUserRepository {
+	return repository.NewPostgresUserRepository(db, time.Second*2).WithRetryPolicy(repository.NewRetryPolicy(repository.RetryPolicyConfig{
+		Timeout:    time.Second * 10,
+		MaxRetries: 2,
+		Budget:     budget,
+		Registerer: registry,
+	}))
+}
+
+func TestRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("retries read refused by database", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		registry := prometheus.NewRegistry()
+		userRepository := newRetryingRepository(newRefusingDatabase(t), registry, nil)
+
+		// Act
+		_, err := userRepository.Get(tenantContext(), 1)
+
+		// Assert
+		assert.Error(t, err)
+		assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+			# HELP db_retries_total Number of database operations retried after a transient error, by operation and reason.
+			# TYPE db_retries_total counter
+			db_retries_total{operation="get",reason="connection_refused"} 2
+		`), "db_retries_total"))
+	})
+
+	t.Run("retries change that was never sent", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		registry := prometheus.NewRegistry()
+		userRepository := newRetryingRepository(newRefusingDatabase(t), registry, nil)
+
+		// Act
+		_, err := userRepository.Create(tenantContext(), &USER1)
+
+		// Assert
+		assert.Error(t, err)
+		assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+			# HELP db_retries_total Number of database operations retried after a transient error, by operation and reason.
+			# TYPE db_retries_total counter
+			db_retries_total{operation="create",reason="connection_refused"} 2
+		`), "db_retries_total"))
+	})
+
+	t.Run("does not retry change after connection was lost", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		registry := prometheus.NewRegistry()
+		userRepository := newRetryingRepository(newDroppingDatabase(t), registry, nil)
+
+		// Act
+		_, createErr := userRepository.Create(tenantContext(), &USER1)
+		_, getErr := userRepository.Get(tenantContext(), 1)
+
+		// Assert
+		assert.Error(t, createErr)
+		assert.Error(t, getErr)
+		count, err := testutil.GatherAndCount(registry, "db_retries_total")
+		require.NoError(t, err)
+		assert.Equal(t, 1, count, "only the read is retried")
+	})
+
+	t.Run("stops retrying when budget is exhausted", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		registry := prometheus.NewRegistry()
+		userRepository := newRetryingRepository(newRefusingDatabase(t), registry, retry.NewBudget(0.1, 1))
+
+		// Act
+		_, err := userRepository.Get(tenantContext(), 1)
+
+		// Assert
+		assert.ErrorIs(t, err, retry.ErrBudgetExhausted)
+		assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+			# HELP db_retries_total Number of database operations retried after a transient error, by operation and reason.
+			# TYPE db_retries_total counter
+			db_retries_total{operation="get",reason="connection_refused"} 1
+			# HELP db_retry_budget_exhausted_total Number of transient database errors that were not retried because the retry budget was exhausted, by operation.
+			# TYPE db_retry_budget_exhausted_total counter
+			db_retry_budget_exhausted_total{operation="get"} 1
+		`), "db_retries_total", "db_retry_budget_exhausted_total"))
+	})
+}
@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/tobiassundman/go-demo-app/pkg/database"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
+)
+
+// postgresSetTenantQuery scopes the row level security policies to a tenant until the transaction ends.
+// There is no setting that lifts the policies, maintenance of every tenant runs in SECURITY DEFINER functions of the owner of the tables
+const postgresSetTenantQuery = `SELECT set_config('app.tenant_id', $1, true)`
+
+// tenantFromContext returns the tenant of the context, or ErrTenantRequired if the context does not carry one
+func tenantFromContext(ctx context.Context) (string, error) {
+	tenantID, ok := tenant.FromContext(ctx)
+	if !ok {
+		return "", ErrTenantRequired
+	}
+	return tenantID, nil
+}
+
+// setTenant scopes the row level security policies to the tenant for the rest of the transaction
+func setTenant(ctx context.Context, tx pgx.Tx, tenantID string) error {
+	_, err := tx.Exec(ctx, postgresSetTenantQuery, tenantID)
+	return err
+}
+
+// runInTenantTx runs fn in a transaction on db scoped to the tenant of the context, or in a savepoint of the transaction carried by the context.
+// It fails with ErrTenantRequired if the context does not carry a tenant
+func runInTenantTx(ctx context.Context, db database.Pool, fn func(ctx context.Context, tx pgx.Tx, tenantID string) error) error {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	return runInTx(ctx, db, pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		if err := setTenant(ctx, tx, tenantID); err != nil {
+			return err
+		}
+		return fn(ctx, tx, tenantID)
+	})
+}
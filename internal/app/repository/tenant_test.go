@@ -0,0 +1,104 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/pkg/test"
+)
+
+// inTx runs fn in a transaction that is rolled back afterwards.
+func inTx(t *testing.T, db *pgxpool.Pool, fn func(tx pgx.Tx)) {
+	tx, err := db.Begin(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback(context.Background())
+	fn(tx)
+}
+
+func TestPostgresRowLevelSecurity(t *testing.T) {
+	t.Parallel()
+	db := test.StartDatabase(t)
+	t.Cleanup(func() { db.Close() })
+	userRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+	_, err := userRepository.Create(tenantContext(), &USER1)
+	require.NoError(t, err)
+
+	t.Run("connects as role subject to row level security", func(t *testing.T) {
+		// Act
+		var superuser, bypassRLS bool
+		err := db.QueryRow(context.Background(), "SELECT rolsuper, rolbypassrls FROM pg_roles WHERE rolname = current_user").Scan(&superuser, &bypassRLS)
+
+		// Assert
+		require.NoError(t, err)
+		assert.False(t, superuser)
+		assert.False(t, bypassRLS)
+	})
+
+	t.Run("hides rows without tenant", func(t *testing.T) {
+		for _, table := range []string{"config.users", "config.user_history", "config.outbox"} {
+			inTx(t, db, func(tx pgx.Tx) {
+				// Act
+				count := -1
+				err := tx.QueryRow(context.Background(), "SELECT count(*) FROM "+table).Scan(&count)
+
+				// Assert
+				require.NoError(t, err)
+				assert.Equal(t, 0, count, table)
+			})
+		}
+	})
+
+	t.Run("shows only users of tenant", func(t *testing.T) {
+		for tenantID, expected := range map[string]int{TENANT1: 1, TENANT2: 0} {
+			inTx(t, db, func(tx pgx.Tx) {
+				// Arrange
+				_, err := tx.Exec(context.Background(), "SELECT set_config('app.tenant_id', $1, true)", tenantID)
+				require.NoError(t, err)
+
+				// Act
+				count := -1
+				err = tx.QueryRow(context.Background(), "SELECT count(*) FROM config.users").Scan(&count)
+
+				// Assert
+				require.NoError(t, err)
+				assert.Equal(t, expected, count, tenantID)
+			})
+		}
+	})
+
+	t.Run("cannot lift policies", func(t *testing.T) {
+		inTx(t, db, func(tx pgx.Tx) {
+			// Arrange
+			_, err := tx.Exec(context.Background(), "SELECT set_config('app.all_tenants', 'on', true)")
+			require.NoError(t, err)
+
+			// Act
+			count := -1
+			err = tx.QueryRow(context.Background(), "SELECT count(*) FROM config.users").Scan(&count)
+
+			// Assert
+			require.NoError(t, err)
+			assert.Equal(t, 0, count)
+		})
+	})
+
+	t.Run("rejects users written for another tenant", func(t *testing.T) {
+		inTx(t, db, func(tx pgx.Tx) {
+			// Arrange
+			_, err := tx.Exec(context.Background(), "SELECT set_config('app.tenant_id', $1, true)", TENANT1)
+			require.NoError(t, err)
+
+			// Act
+			_, err = tx.Exec(context.Background(), "INSERT INTO config.users (tenant_id, name, email, age) VALUES ($1, 'Name', 'email@email.com', 20)", TENANT2)
+
+			// Assert
+			assert.ErrorContains(t, err, "row-level security")
+		})
+	})
+}
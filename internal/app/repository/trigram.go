@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"strings"
+	"unicode"
+)
+
+// similarityThreshold is the default pg_trgm similarity threshold used by the % operator
+const similarityThreshold = 0.3
+
+// trigrams returns the set of trigrams of a text the way pg_trgm extracts them:
+// the lower cased text is split into words of letters and digits, and each word is padded with two spaces before and one after
+func trigrams(text string) map[string]bool {
+	result := map[string]bool{}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			result[string(padded[i:i+3])] = true
+		}
+	}
+	return result
+}
+
+// similarity returns the pg_trgm similarity of two trigram sets, the share of trigrams they have in common.
+// It is calculated with the same single precision as pg_trgm so that scores match Postgres
+func similarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for trigram := range a {
+		if b[trigram] {
+			common++
+		}
+	}
+	return float64(float32(common) / float32(len(a)+len(b)-common))
+}
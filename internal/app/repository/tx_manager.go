@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/tobiassundman/go-demo-app/pkg/breaker"
+	"github.com/tobiassundman/go-demo-app/pkg/database"
+)
+
+// TxManager runs functions in a transaction that repositories pick up from the context
+type TxManager interface {
+	// WithinTx runs fn in a transaction that is committed if fn succeeds and rolled back otherwise.
+	// Repository calls made with the context passed to fn take part in the transaction, a nested WithinTx uses a savepoint
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// PostgresTxManager runs transactions on a Postgres database.
+// It must be created for the primary database of the repositories that take part in its transactions.
+type PostgresTxManager struct {
+	db           database.Pool
+	queryTimeout time.Duration
+	// circuit is the circuit breaker transactions are begun through, nil if there is none
+	circuit *breaker.Breaker
+}
+
+// NewPostgresTxManager creates a new PostgresTxManager that gives up beginning a transaction after the query timeout.
+func NewPostgresTxManager(db database.Pool, queryTimeout time.Duration) *PostgresTxManager {
+	return &PostgresTxManager{
+		db:           db,
+		queryTimeout: queryTimeout,
+	}
+}
+
+// WithCircuitBreaker sets the circuit breaker transactions are begun through and returns the manager.
+// Beginning a transaction fails fast with an *UnavailableError while the circuit is open, and counts as a failure if the database is unavailable.
+func (m *PostgresTxManager) WithCircuitBreaker(circuit *breaker.Breaker) *PostgresTxManager {
+	m.circuit = circuit
+	return m
+}
+
+// WithinTx runs fn in a transaction that is committed if fn succeeds and rolled back otherwise.
+// Repository calls made with the context passed to fn take part in the transaction, a nested WithinTx uses a savepoint
+func (m *PostgresTxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	txFn := func(ctx context.Context, tx pgx.Tx) error {
+		return fn(ctx)
+	}
+	if outer, ok := txFromContext(ctx); ok {
+		return runInSavepoint(ctx, outer, txFn)
+	}
+	tx, err := m.begin(ctx)
+	if err != nil {
+		return err
+	}
+	return runTx(ctx, tx, txFn)
+}
+
+// begin begins a transaction through the circuit breaker, giving up after the query timeout
+func (m *PostgresTxManager) begin(ctx context.Context) (pgx.Tx, error) {
+	done := func(failed bool) {}
+	if m.circuit != nil {
+		var err error
+		done, err = m.circuit.Allow()
+		if err != nil {
+			return nil, unavailableError(err)
+		}
+	}
+	// The timeout only bounds beginning the transaction, the statements of fn have timeouts of their own
+	beginCtx, cancel := context.WithTimeout(ctx, m.queryTimeout)
+	defer cancel()
+	tx, err := m.db.BeginTx(beginCtx, pgx.TxOptions{})
+	done(isDatabaseUnavailable(err))
+	return tx, err
+}
+
+// InMemoryTxManager runs functions directly for the in-memory repository, which has no transactions to roll back
+type InMemoryTxManager struct{}
+
+// NewInMemoryTxManager creates a new InMemoryTxManager.
+func NewInMemoryTxManager() *InMemoryTxManager {
+	return &InMemoryTxManager{}
+}
+
+// WithinTx runs fn, changes made before fn fails are kept
+func (m *InMemoryTxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+type txContextKey struct{}
+
+// contextTx is a transaction carried by a context
+type contextTx struct {
+	tx pgx.Tx
+	// afterCommit is run once the outermost transaction has been committed
+	afterCommit *[]func()
+}
+
+// txFromContext returns the transaction carried by the context, if any
+func txFromContext(ctx context.Context) (*contextTx, bool) {
+	tx, ok := ctx.Value(txContextKey{}).(*contextTx)
+	return tx, ok
+}
+
+// runInTx runs fn in a new transaction on db, or in a savepoint of the transaction carried by the context if there is one.
+// fn gets a context carrying the transaction, the transaction is committed or the savepoint released if fn succeeds and rolled back otherwise
+func runInTx(ctx context.Context, db database.Pool, options pgx.TxOptions, fn func(ctx context.Context, tx pgx.Tx) error) error {
+	if outer, ok := txFromContext(ctx); ok {
+		return runInSavepoint(ctx, outer, fn)
+	}
+
+	tx, err := db.BeginTx(ctx, options)
+	if err != nil {
+		return err
+	}
+	return runTx(ctx, tx, fn)
+}
+
+// runTx runs fn in the transaction with a context carrying it, committing the transaction if fn succeeds and rolling it back otherwise
+func runTx(ctx context.Context, tx pgx.Tx, fn func(ctx context.Context, tx pgx.Tx) error) error {
+	current := &contextTx{tx: tx, afterCommit: &[]func(){}}
+	if err := fn(context.WithValue(ctx, txContextKey{}, current), tx); err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	for _, afterCommit := range *current.afterCommit {
+		afterCommit()
+	}
+	return nil
+}
+
+// runInSavepoint runs fn in a savepoint of the outer transaction, so that a failing fn does not abort the outer transaction.
+// The savepoint is a nested pgx transaction, which is committed by releasing the savepoint and rolled back by rolling back to it
+func runInSavepoint(ctx context.Context, outer *contextTx, fn func(ctx context.Context, tx pgx.Tx) error) error {
+	savepoint, err := outer.tx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	current := &contextTx{tx: savepoint, afterCommit: outer.afterCommit}
+	if err := fn(context.WithValue(ctx, txContextKey{}, current), savepoint); err != nil {
+		if rollbackErr := savepoint.Rollback(ctx); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+	return savepoint.Commit(ctx)
+}
+
+// runAfterCommit runs fn once the transaction carried by the context has been committed, or right away if there is none
+func runAfterCommit(ctx context.Context, fn func()) {
+	if current, ok := txFromContext(ctx); ok {
+		*current.afterCommit = append(*current.afterCommit, fn)
+		return
+	}
+	fn()
+}
@@ -0,0 +1,164 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/pkg/test"
+)
+
+func TestWithinTx(t *testing.T) {
+	t.Parallel()
+	t.Run("commits every repository call", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := test.StartDatabase(t)
+		defer db.Close()
+		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		txManager := repository.NewPostgresTxManager(db, time.Second*2)
+
+		// Act
+		err := txManager.WithinTx(tenantContext(), func(ctx context.Context) error {
+			id, err := pgRepository.Create(ctx, &USER1)
+			if err != nil {
+				return err
+			}
+			// Reads within the transaction see its uncommitted changes
+			_, err = pgRepository.Get(ctx, id)
+			if err != nil {
+				return err
+			}
+			_, err = pgRepository.Create(ctx, &USER2)
+			return err
+		})
+		require.NoError(t, err)
+
+		// Assert
+		users, err := pgRepository.GetAll(tenantContext())
+		require.NoError(t, err)
+		assert.Len(t, users, 2)
+	})
+
+	t.Run("rolls back every repository call when fn fails", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := test.StartDatabase(t)
+		defer db.Close()
+		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		txManager := repository.NewPostgresTxManager(db, time.Second*2)
+		fnErr := errors.New("fn failed")
+
+		// Act
+		err := txManager.WithinTx(tenantContext(), func(ctx context.Context) error {
+			if _, err := pgRepository.Create(ctx, &USER1); err != nil {
+				return err
+			}
+			return fnErr
+		})
+
+		// Assert
+		assert.Equal(t, fnErr, err)
+		users, err := pgRepository.GetAll(tenantContext())
+		require.NoError(t, err)
+		assert.Len(t, users, 0)
+	})
+
+	t.Run("failed repository call does not abort the transaction", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := test.StartDatabase(t)
+		defer db.Close()
+		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		txManager := repository.NewPostgresTxManager(db, time.Second*2)
+
+		// Act
+		err := txManager.WithinTx(tenantContext(), func(ctx context.Context) error {
+			if _, err := pgRepository.Create(ctx, &USER1); err != nil {
+				return err
+			}
+			if _, err := pgRepository.Create(ctx, &USER1); !errors.Is(err, repository.ErrUserAlreadyExists) {
+				return err
+			}
+			_, err := pgRepository.Create(ctx, &USER2)
+			return err
+		})
+		require.NoError(t, err)
+
+		// Assert
+		users, err := pgRepository.GetAll(tenantContext())
+		require.NoError(t, err)
+		assert.Len(t, users, 2)
+	})
+
+	t.Run("nested failure only rolls back to its savepoint", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := test.StartDatabase(t)
+		defer db.Close()
+		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		txManager := repository.NewPostgresTxManager(db, time.Second*2)
+		nestedErr := errors.New("nested failed")
+
+		// Act
+		err := txManager.WithinTx(tenantContext(), func(ctx context.Context) error {
+			if _, err := pgRepository.Create(ctx, &USER1); err != nil {
+				return err
+			}
+			err := txManager.WithinTx(ctx, func(ctx context.Context) error {
+				if _, err := pgRepository.Create(ctx, &USER2); err != nil {
+					return err
+				}
+				return nestedErr
+			})
+			assert.Equal(t, nestedErr, err)
+			return nil
+		})
+		require.NoError(t, err)
+
+		// Assert
+		users, err := pgRepository.GetAll(tenantContext())
+		require.NoError(t, err)
+		assert.Equal(t, []*repository.User{&USER1}, allWithoutTimestamps(users))
+	})
+
+	t.Run("export within a transaction can be repeated", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := test.StartDatabase(t)
+		defer db.Close()
+		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		txManager := repository.NewPostgresTxManager(db, time.Second*2)
+		exported := 0
+
+		// Act
+		err := txManager.WithinTx(tenantContext(), func(ctx context.Context) error {
+			if _, err := pgRepository.Create(ctx, &USER1); err != nil {
+				return err
+			}
+			for i := 0; i < 2; i++ {
+				err := pgRepository.Export(ctx, nil, func(user *repository.User) error {
+					exported++
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, 2, exported)
+	})
+}
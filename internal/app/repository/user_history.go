@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Operations recorded in the user history.
+const (
+	HistoryOperationCreate  = "create"
+	HistoryOperationUpdate  = "update"
+	HistoryOperationDelete  = "delete"
+	HistoryOperationRestore = "restore"
+	HistoryOperationPurge   = "purge"
+)
+
+const (
+	postgresInsertUserHistoryQuery = `INSERT INTO config.user_history (tenant_id, user_id, operation, changed_by, before, after) VALUES ($1, $2, $3, $4, $5::jsonb, $6::jsonb)`
+	postgresGetUserHistoryQuery    = `SELECT id, user_id, operation, changed_by, changed_at, before, after FROM config.user_history WHERE tenant_id = $1 AND user_id = $2 AND id > $3 ORDER BY id LIMIT $4`
+)
+
+// userSnapshot is how a user is stored in the before and after columns of the user history and the payload of the outbox.
+// It has no timestamps, the history records when each change was made. pgx encodes and decodes it as JSON, a nil snapshot is NULL.
+type userSnapshot struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Age     int    `json:"age"`
+	Version int    `json:"version"`
+}
+
+// userHistoryRow is a row of config.user_history.
+type userHistoryRow struct {
+	ID        int           `db:"id"`
+	UserID    int           `db:"user_id"`
+	Operation string        `db:"operation"`
+	ChangedBy string        `db:"changed_by"`
+	ChangedAt time.Time     `db:"changed_at"`
+	Before    *userSnapshot `db:"before"`
+	After     *userSnapshot `db:"after"`
+}
+
+// newUserSnapshot returns the snapshot of a user, nil is returned as nil.
+func newUserSnapshot(user *User) *userSnapshot {
+	if user == nil {
+		return nil
+	}
+	return &userSnapshot{
+		ID:      user.ID,
+		Name:    user.Name,
+		Email:   user.Email,
+		Age:     user.Age,
+		Version: user.Version,
+	}
+}
+
+// user returns the user of a snapshot, nil is returned as nil.
+func (s *userSnapshot) user() *User {
+	if s == nil {
+		return nil
+	}
+	return &User{
+		ID:      s.ID,
+		Name:    s.Name,
+		Email:   s.Email,
+		Age:     s.Age,
+		Version: s.Version,
+	}
+}
+
+// queueUserHistory queues the recording of a change of a user of the tenant in a batch sent in the transaction of the change,
+// so that it is only recorded if the change is committed
+func queueUserHistory(batch *pgx.Batch, tenantID string, userID int, operation, changedBy string, before, after *User) {
+	batch.Queue(postgresInsertUserHistoryQuery, tenantID, userID, operation, changedBy, newUserSnapshot(before), newUserSnapshot(after))
+}
+
+// GetHistory returns up to query.Limit changes of the user with id query.UserID that were made after the change with id query.AfterID, oldest first
+func (r *PostgresUserRepository) GetHistory(ctx context.Context, query *UserHistoryPageQuery) ([]*UserHistoryEntry, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []*userHistoryRow
+	err = r.withReadTx(ctx, tenantID, "get_history", true, r.queryTimeout, func(ctx context.Context, tx pgx.Tx) error {
+		rows, err = selectRows[userHistoryRow](ctx, tx, postgresGetUserHistoryQuery, tenantID, query.UserID, query.AfterID, query.Limit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*UserHistoryEntry, len(rows))
+	for i, row := range rows {
+		entries[i] = &UserHistoryEntry{
+			ID:        row.ID,
+			UserID:    row.UserID,
+			Operation: row.Operation,
+			ChangedBy: row.ChangedBy,
+			ChangedAt: row.ChangedAt,
+			Before:    row.Before.user(),
+			After:     row.After.user(),
+		}
+	}
+	return entries, nil
+}
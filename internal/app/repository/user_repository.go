@@ -2,66 +2,233 @@ package repository
 
 import (
 	"context"
-	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
-	"github.com/jackc/pgerrcode"
-	"github.com/jackc/pgx"
-	"github.com/jmoiron/sqlx"
+	"github.com/jackc/pgx/v5"
+	"github.com/tobiassundman/go-demo-app/pkg/actor"
+	"github.com/tobiassundman/go-demo-app/pkg/database"
+	"github.com/tobiassundman/go-demo-app/pkg/session"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
 )
 
+// Every query is scoped by the tenant in $1, in addition to the row level security policies scoped by setTenant
 const (
-	postgresGetAllUsersQuery = `SELECT id, name, email, age FROM config.users`
-	postgresGetUserQuery     = `SELECT id, name, email, age FROM config.users WHERE id = $1`
-	postgresCreateUserQuery  = `INSERT INTO config.users (name, email, age) VALUES ($1, $2, $3) RETURNING id`
-	postgresUpdateUserQuery  = `UPDATE config.users SET name = $1, email = $2, age = $3 WHERE id = $4`
-	postgresDeleteUserQuery  = `DELETE FROM config.users WHERE id = $1`
+	postgresGetAllUsersQuery     = `SELECT id, name, email, age, version, created_at, updated_at FROM config.users WHERE tenant_id = $1 AND deleted_at IS NULL`
+	postgresSearchUsersQuery     = `SELECT id, name, email, age, version, created_at, updated_at, GREATEST(similarity(name, $2), similarity(email, $2)) AS score FROM config.users WHERE tenant_id = $1 AND (name % $2 OR email % $2) AND deleted_at IS NULL ORDER BY score DESC, id LIMIT $3`
+	postgresGetUserQuery         = `SELECT id, name, email, age, version, created_at, updated_at FROM config.users WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NULL`
+	postgresLockUserQuery        = `SELECT id, name, email, age, version, created_at, updated_at FROM config.users WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NULL FOR UPDATE`
+	postgresLockDeletedUserQuery = `SELECT id, name, email, age, version, created_at, updated_at FROM config.users WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NOT NULL FOR UPDATE`
+	postgresCreateUserQuery      = `INSERT INTO config.users (tenant_id, name, email, age) VALUES ($1, $2, $3, $4) RETURNING id, name, email, age, version, created_at, updated_at`
+	postgresUpdateUserQuery      = `UPDATE config.users SET name = $2, email = $3, age = $4, version = version + 1, updated_at = NOW() WHERE tenant_id = $1 AND id = $5 RETURNING id, name, email, age, version, created_at, updated_at`
+	// postgresPatchUserQuery is formatted with the assignments of the patched columns and the placeholder of the id
+	postgresPatchUserQuery   = `UPDATE config.users SET %s, version = version + 1, updated_at = NOW() WHERE tenant_id = $1 AND id = %s RETURNING id, name, email, age, version, created_at, updated_at`
+	postgresDeleteUserQuery  = `UPDATE config.users SET deleted_at = NOW(), version = version + 1, updated_at = NOW() WHERE tenant_id = $1 AND id = $2 RETURNING id, name, email, age, version, created_at, updated_at`
+	postgresRestoreUserQuery = `UPDATE config.users SET deleted_at = NULL, version = version + 1, updated_at = NOW() WHERE tenant_id = $1 AND id = $2 RETURNING id, name, email, age, version, created_at, updated_at`
+	// postgresPurgeDeletedUsersQuery purges the users of the tenant in $1
+	postgresPurgeDeletedUsersQuery = `WITH purged AS (DELETE FROM config.users WHERE tenant_id = $1 AND deleted_at < NOW() - make_interval(secs => $2) RETURNING tenant_id, id, name, email, age, version)
+		INSERT INTO config.user_history (tenant_id, user_id, operation, changed_by, before)
+		SELECT tenant_id, id, $3, $4, jsonb_build_object('id', id, 'name', name, 'email', email, 'age', age, 'version', version) FROM purged`
+	// postgresPurgeAllDeletedUsersQuery purges the users of every tenant in a function that is not subject to the row level security policies
+	postgresPurgeAllDeletedUsersQuery = `SELECT config.purge_deleted_users($1, $2, $3)`
+	// postgresDeclareUserExportCursorQuery is formatted with the export query, the cursor is closed when the transaction ends
+	postgresDeclareUserExportCursorQuery = `DECLARE user_export NO SCROLL CURSOR FOR %s`
+	postgresFetchUserExportQuery         = `FETCH 500 FROM user_export`
+	postgresCloseUserExportCursorQuery   = `CLOSE user_export`
+	// postgresCreateUsersBatchQuery inserts the users passed as one array per column in $5 to $7, so that batches of every size share one statement in the cache of the connection.
+	// $2 and $3 are the history operation and actor and $4 is the outbox event type
+	postgresCreateUsersBatchQuery = `WITH created AS (INSERT INTO config.users (tenant_id, name, email, age)
+			SELECT $1, name, email, age FROM unnest($5::TEXT[], $6::TEXT[], $7::INTEGER[]) AS batch (name, email, age)
+			ON CONFLICT DO NOTHING RETURNING id, name, email, age, version, created_at, updated_at),
+		history AS (INSERT INTO config.user_history (tenant_id, user_id, operation, changed_by, after) SELECT $1, id, $2, $3, jsonb_build_object('id', id, 'name', name, 'email', email, 'age', age, 'version', version) FROM created),
+		outbox AS (INSERT INTO config.outbox (tenant_id, event_type, user_id, payload) SELECT $1, $4, id, jsonb_build_object('id', id, 'name', name, 'email', email, 'age', age, 'version', version) FROM created)
+		SELECT id, name, email, age, version, created_at, updated_at FROM created`
 )
 
-// UserRepository is an interface for the user repository
+// exportFetchSize is the number of users fetched from the export cursor at a time, it must match postgresFetchUserExportQuery
+const exportFetchSize = 500
+
+// UserRepository is an interface for the user repository.
+// Users belong to the tenant of the context they are created with and users of other tenants are not found.
+// Every method except PurgeDeleted fails with ErrTenantRequired if the context does not carry a tenant
 type UserRepository interface {
 	// GetAll returns all users
-	GetAll() ([]*User, error)
+	GetAll(ctx context.Context) ([]*User, error)
+	// GetPage returns up to query.Limit users matching query.Filter that come after query.After in query.Sort order
+	GetPage(ctx context.Context, query *UserPageQuery) ([]*User, error)
+	// Export calls fn with every user matching the filter in id order without holding them all in memory, stopping at the first error fn returns
+	Export(ctx context.Context, filter *UserFilter, fn func(user *User) error) error
+	// Search returns up to limit users whose name or email is similar to the query, best match first
+	Search(ctx context.Context, query string, limit int) ([]*UserSearchResult, error)
 	// Get returns a user with the given id
-	Get(id int) (*User, error)
+	Get(ctx context.Context, id int) (*User, error)
 	// Create creates a new user
-	Create(user *User) (int, error)
-	// Update updates a user
-	Update(user *User) error
-	// Delete deletes a user
-	Delete(id int) error
+	Create(ctx context.Context, user *User) (int, error)
+	// CreateBatch creates users with a single insert, returning the created users in the given order with nil for users whose email already exists.
+	// If atomic is true and any email already exists no user is created and a *BatchConflictError is returned
+	CreateBatch(ctx context.Context, users []*User, atomic bool) ([]*User, error)
+	// Update updates a user if its current version matches, otherwise it returns ErrVersionConflict
+	Update(ctx context.Context, user *User, match VersionMatch) error
+	// Patch updates only the columns of the non-nil fields of the patch if the current version of the user matches, returning the patched user.
+	// A patch without fields changes nothing and returns the user as it is
+	Patch(ctx context.Context, patch *UserPatch, match VersionMatch) (*User, error)
+	// Delete soft deletes a user if its current version matches, it can be restored until it is purged
+	Delete(ctx context.Context, id int, match VersionMatch) error
+	// Restore restores a soft deleted user
+	Restore(ctx context.Context, id int) error
+	// PurgeDeleted permanently deletes users that were soft deleted longer ago than the retention, returning how many were purged
+	PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error)
+	// GetHistory returns up to query.Limit changes of the user with id query.UserID that were made after the change with id query.AfterID, oldest first
+	GetHistory(ctx context.Context, query *UserHistoryPageQuery) ([]*UserHistoryEntry, error)
 }
 
-// PostgresUserRepository is a repository for users in a Postgres database
+// PostgresUserRepository is a repository for users in a Postgres database.
+// Every change of a user is recorded in the user history and written to the outbox in the same transaction as the change,
+// except for purges of users whose deletion was already written to the outbox. Both are sent to the database as a single batch.
+// Changes are made on the primary and reads are spread over the replicas, reads of the session of the context follow its own changes
+// for the read-your-writes window of the router.
+// Operations that fail with a transient error are retried by the retry policy, if one is set.
 type PostgresUserRepository struct {
 	queryTimeout time.Duration
-	db           *sqlx.DB
+	router       *database.ReplicaRouter
+	retryPolicy  *RetryPolicy
+}
+
+// NewPostgresUserRepository creates a new PostgresUserRepository that reads and writes the given database.
+// The query timeout is applied on top of any deadline already set on the context passed to each method.
+func NewPostgresUserRepository(db database.Pool, queryTimeout time.Duration) *PostgresUserRepository {
+	return NewReplicatedPostgresUserRepository(database.NewReplicaRouter(db, nil, database.ReplicaRouterConfig{}), queryTimeout)
 }
 
-func NewPostgresUserRepository(db *sqlx.DB, queryTimeout time.Duration) *PostgresUserRepository {
+// NewReplicatedPostgresUserRepository creates a new PostgresUserRepository that writes the primary of the router and reads its replicas.
+// The query timeout is applied on top of any deadline already set on the context passed to each method.
+func NewReplicatedPostgresUserRepository(router *database.ReplicaRouter, queryTimeout time.Duration) *PostgresUserRepository {
 	return &PostgresUserRepository{
 		queryTimeout: queryTimeout,
-		db:           db,
+		router:       router,
 	}
 }
 
+// WithRetryPolicy sets the policy that retries operations failing with a transient error and returns the repository.
+// Reads are retried after any transient error, changes only if the failed attempt was never sent to the database
+func (r *PostgresUserRepository) WithRetryPolicy(policy *RetryPolicy) *PostgresUserRepository {
+	r.retryPolicy = policy
+	return r
+}
+
 // GetAll returns all users
-func (r *PostgresUserRepository) GetAll() ([]*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), r.queryTimeout)
-	defer cancel()
-	users := []*User{}
-	err := r.db.SelectContext(ctx, &users, postgresGetAllUsersQuery)
+func (r *PostgresUserRepository) GetAll(ctx context.Context) ([]*User, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*User
+	err = r.withReadTx(ctx, tenantID, "get_all", true, r.queryTimeout, func(ctx context.Context, tx pgx.Tx) error {
+		users, err = selectRows[User](ctx, tx, postgresGetAllUsersQuery, tenantID)
+		return err
+	})
 	return users, err
 }
 
-// Get returns a user with the given id
-func (r *PostgresUserRepository) Get(id int) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), r.queryTimeout)
+// GetPage returns up to query.Limit users matching query.Filter that come after query.After in query.Sort order
+func (r *PostgresUserRepository) GetPage(ctx context.Context, query *UserPageQuery) ([]*User, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statement, args, err := buildUserPageQuery(tenantID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*User
+	err = r.withReadTx(ctx, tenantID, "get_page", true, r.queryTimeout, func(ctx context.Context, tx pgx.Tx) error {
+		users, err = selectRows[User](ctx, tx, statement, args...)
+		return err
+	})
+	return users, err
+}
+
+// Export calls fn with every user matching the filter in id order without holding them all in memory, stopping at the first error fn returns.
+// Users are read through a cursor in batches of exportFetchSize, the query timeout applies to each batch rather than the whole export.
+// Users may already have been passed to fn when an error occurs, so the export is only retried if the failed attempt was never sent
+func (r *PostgresUserRepository) Export(ctx context.Context, filter *UserFilter, fn func(user *User) error) error {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	statement, args := buildUserExportQuery(tenantID, filter)
+	return r.withReadTx(ctx, tenantID, "export", false, 0, func(ctx context.Context, tx pgx.Tx) error {
+		err := r.execWithTimeout(ctx, tx, fmt.Sprintf(postgresDeclareUserExportCursorQuery, statement), args...)
+		if err != nil {
+			return err
+		}
+
+		for {
+			users, err := r.fetchExportBatch(ctx, tx)
+			if err != nil {
+				return err
+			}
+			for _, user := range users {
+				if err := fn(user); err != nil {
+					return err
+				}
+			}
+			if len(users) < exportFetchSize {
+				// Closed explicitly since a savepoint of an outer transaction keeps the cursor open after the export
+				return r.execWithTimeout(ctx, tx, postgresCloseUserExportCursorQuery)
+			}
+		}
+	})
+}
+
+// execWithTimeout executes a statement in the transaction with the query timeout
+func (r *PostgresUserRepository) execWithTimeout(ctx context.Context, tx pgx.Tx, statement string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
 	defer cancel()
-	user := &User{}
-	err := r.db.GetContext(ctx, user, postgresGetUserQuery, id)
-	if errors.Is(err, sql.ErrNoRows) {
+	_, err := tx.Exec(ctx, statement, args...)
+	return err
+}
+
+// fetchExportBatch fetches the next batch of the export cursor with the query timeout.
+// The fetch is described every time instead of prepared once, since what it returns depends on the cursor open at the time
+func (r *PostgresUserRepository) fetchExportBatch(ctx context.Context, tx pgx.Tx) ([]*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	return selectRows[User](ctx, tx, postgresFetchUserExportQuery, pgx.QueryExecModeDescribeExec)
+}
+
+// Search returns up to limit users whose name or email is similar to the query, best match first
+func (r *PostgresUserRepository) Search(ctx context.Context, query string, limit int) ([]*UserSearchResult, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*UserSearchResult
+	err = r.withReadTx(ctx, tenantID, "search", true, r.queryTimeout, func(ctx context.Context, tx pgx.Tx) error {
+		results, err = selectRows[UserSearchResult](ctx, tx, postgresSearchUsersQuery, tenantID, query, limit)
+		return err
+	})
+	return results, err
+}
+
+// Get returns a user with the given id
+func (r *PostgresUserRepository) Get(ctx context.Context, id int) (*User, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *User
+	err = r.withReadTx(ctx, tenantID, "get", true, r.queryTimeout, func(ctx context.Context, tx pgx.Tx) error {
+		user, err = getRow[User](ctx, tx, postgresGetUserQuery, tenantID, id)
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, ErrUserNotFound
 	}
 
@@ -69,54 +236,335 @@ func (r *PostgresUserRepository) Get(id int) (*User, error) {
 }
 
 // Create creates a new user
-func (r *PostgresUserRepository) Create(user *User) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), r.queryTimeout)
-	defer cancel()
-	var id int
-	err := r.db.QueryRowContext(ctx, postgresCreateUserQuery, user.Name, user.Email, user.Age).Scan(&id)
-	switch typedErr := err.(type) {
-	case pgx.PgError:
-		if typedErr.Code == pgerrcode.UniqueViolation {
-			return 0, ErrUserAlreadyExists
+func (r *PostgresUserRepository) Create(ctx context.Context, user *User) (int, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var created *User
+	err = r.withTx(ctx, tenantID, "create", func(ctx context.Context, tx pgx.Tx) error {
+		created, err = getRow[User](ctx, tx, postgresCreateUserQuery, tenantID, user.Name, user.Email, user.Age)
+		if isUniqueViolation(err) {
+			return ErrUserAlreadyExists
+		}
+		if err != nil {
+			return err
 		}
+		batch := &pgx.Batch{}
+		queueOutboxEvent(batch, tenantID, OutboxEventUserCreated, created)
+		queueUserHistory(batch, tenantID, created.ID, HistoryOperationCreate, actor.FromContext(ctx), nil, created)
+		return tx.SendBatch(ctx, batch).Close()
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	return id, err
+	return created.ID, nil
 }
 
-// Update updates a user
-func (r *PostgresUserRepository) Update(user *User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), r.queryTimeout)
-	defer cancel()
-	result, err := r.db.ExecContext(ctx, postgresUpdateUserQuery, user.Name, user.Email, user.Age, user.ID)
-	switch typedErr := err.(type) {
-	case pgx.PgError:
-		if typedErr.Code == pgerrcode.UniqueViolation {
+// CreateBatch creates users with a single insert, returning the created users in the given order with nil for users whose email already exists.
+// If atomic is true and any email already exists no user is created and a *BatchConflictError is returned
+func (r *PostgresUserRepository) CreateBatch(ctx context.Context, users []*User, atomic bool) ([]*User, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return []*User{}, nil
+	}
+	args := createUsersBatchArgs(tenantID, users, actor.FromContext(ctx))
+
+	results := make([]*User, len(users))
+	err = r.withTx(ctx, tenantID, "create_batch", func(ctx context.Context, tx pgx.Tx) error {
+		created, err := selectRows[User](ctx, tx, postgresCreateUsersBatchQuery, args...)
+		if err != nil {
+			return err
+		}
+
+		// Rows are returned in no particular order, but the email of every created user is unique
+		createdByEmail := make(map[string]*User, len(created))
+		for _, user := range created {
+			createdByEmail[user.Email] = user
+		}
+		conflicts := []int{}
+		for i, user := range users {
+			results[i] = createdByEmail[user.Email]
+			// A repeated email is only created once
+			delete(createdByEmail, user.Email)
+			if results[i] == nil {
+				conflicts = append(conflicts, i)
+			}
+		}
+		if atomic && len(conflicts) > 0 {
+			return &BatchConflictError{Indexes: conflicts}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Update updates a user if its current version matches, otherwise it returns ErrVersionConflict.
+// The version is compared while the row is locked, so it cannot change before the update
+func (r *PostgresUserRepository) Update(ctx context.Context, user *User, match VersionMatch) error {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return r.withTx(ctx, tenantID, "update", func(ctx context.Context, tx pgx.Tx) error {
+		before, err := lockUser(ctx, tx, postgresLockUserQuery, tenantID, user.ID)
+		if err != nil {
+			return err
+		}
+		if !match.Matches(before.Version) {
+			return ErrVersionConflict
+		}
+
+		after, err := getRow[User](ctx, tx, postgresUpdateUserQuery, tenantID, user.Name, user.Email, user.Age, user.ID)
+		if isUniqueViolation(err) {
 			return ErrUserAlreadyExists
 		}
+		if err != nil {
+			return err
+		}
+		batch := &pgx.Batch{}
+		queueOutboxEvent(batch, tenantID, OutboxEventUserUpdated, after)
+		queueUserHistory(batch, tenantID, user.ID, HistoryOperationUpdate, actor.FromContext(ctx), before, after)
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+// Patch updates only the columns of the non-nil fields of the patch if the current version of the user matches, returning the patched user.
+// A patch without fields changes nothing and returns the user as it is
+func (r *PostgresUserRepository) Patch(ctx context.Context, patch *UserPatch, match VersionMatch) (*User, error) {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if noRowsAffected(result) {
-		return ErrUserNotFound
+
+	var after *User
+	err = r.withTx(ctx, tenantID, "patch", func(ctx context.Context, tx pgx.Tx) error {
+		before, err := lockUser(ctx, tx, postgresLockUserQuery, tenantID, patch.ID)
+		if err != nil {
+			return err
+		}
+		if !match.Matches(before.Version) {
+			return ErrVersionConflict
+		}
+		query, args, ok := buildPatchUserQuery(tenantID, patch)
+		if !ok {
+			after = before
+			return nil
+		}
+
+		after, err = getRow[User](ctx, tx, query, args...)
+		if isUniqueViolation(err) {
+			return ErrUserAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+		batch := &pgx.Batch{}
+		queueOutboxEvent(batch, tenantID, OutboxEventUserUpdated, after)
+		queueUserHistory(batch, tenantID, patch.ID, HistoryOperationUpdate, actor.FromContext(ctx), before, after)
+		return tx.SendBatch(ctx, batch).Close()
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return after, nil
 }
 
-// Delete deletes a user
-func (r *PostgresUserRepository) Delete(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), r.queryTimeout)
-	defer cancel()
-	result, err := r.db.ExecContext(ctx, postgresDeleteUserQuery, id)
+// Delete soft deletes a user if its current version matches, it can be restored until it is purged
+func (r *PostgresUserRepository) Delete(ctx context.Context, id int, match VersionMatch) error {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return r.withTx(ctx, tenantID, "delete", func(ctx context.Context, tx pgx.Tx) error {
+		before, err := lockUser(ctx, tx, postgresLockUserQuery, tenantID, id)
+		if err != nil {
+			return err
+		}
+		if !match.Matches(before.Version) {
+			return ErrVersionConflict
+		}
+
+		deleted, err := getRow[User](ctx, tx, postgresDeleteUserQuery, tenantID, id)
+		if err != nil {
+			return err
+		}
+		batch := &pgx.Batch{}
+		queueOutboxEvent(batch, tenantID, OutboxEventUserDeleted, deleted)
+		queueUserHistory(batch, tenantID, id, HistoryOperationDelete, actor.FromContext(ctx), before, nil)
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+// Restore restores a soft deleted user
+func (r *PostgresUserRepository) Restore(ctx context.Context, id int) error {
+	tenantID, err := tenantFromContext(ctx)
 	if err != nil {
 		return err
 	}
-	if noRowsAffected(result) {
-		return ErrUserNotFound
+
+	return r.withTx(ctx, tenantID, "restore", func(ctx context.Context, tx pgx.Tx) error {
+		_, err := lockUser(ctx, tx, postgresLockDeletedUserQuery, tenantID, id)
+		if err != nil {
+			return err
+		}
+
+		after, err := getRow[User](ctx, tx, postgresRestoreUserQuery, tenantID, id)
+		// Another user may have taken the email while this user was deleted
+		if isUniqueViolation(err) {
+			return ErrUserAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+		batch := &pgx.Batch{}
+		queueOutboxEvent(batch, tenantID, OutboxEventUserRestored, after)
+		queueUserHistory(batch, tenantID, id, HistoryOperationRestore, actor.FromContext(ctx), nil, after)
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+// PurgeDeleted permanently deletes users that were soft deleted longer ago than the retention, returning how many were purged.
+// Only the users of the tenant of the context are purged, or the users of every tenant if the context does not carry a tenant.
+// A purge that may have been applied is retried, since the retry only purges users that the first attempt did not
+func (r *PostgresUserRepository) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	tenantID, scoped := tenant.FromContext(ctx)
+	var purged int64
+	err := r.retryPolicy.run(ctx, "purge_deleted", true, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+		defer cancel()
+		// The purge and its history are written by a single statement, so they are committed together
+		return runInTx(ctx, r.router.Primary(), pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+			if !scoped {
+				return tx.QueryRow(ctx, postgresPurgeAllDeletedUsersQuery, retention.Seconds(), HistoryOperationPurge, actor.FromContext(ctx)).Scan(&purged)
+			}
+			if err := setTenant(ctx, tx, tenantID); err != nil {
+				return err
+			}
+			result, err := tx.Exec(ctx, postgresPurgeDeletedUsersQuery, tenantID, retention.Seconds(), HistoryOperationPurge, actor.FromContext(ctx))
+			if err != nil {
+				return err
+			}
+			purged = result.RowsAffected()
+			return nil
+		})
+	})
+	return purged, err
+}
+
+// withTx runs fn with the query timeout in a transaction scoped to the tenant on the primary, or in a savepoint of the transaction carried by the context,
+// that is committed if fn succeeds and rolled back otherwise.
+// A committed transaction pins the reads of the session of the context to the primary.
+// Changes are not idempotent, so the transaction is only retried if the failed attempt was never sent to the database.
+// A value rejected by the database is returned as a *ConstraintError
+func (r *PostgresUserRepository) withTx(ctx context.Context, tenantID, operation string, fn func(ctx context.Context, tx pgx.Tx) error) error {
+	return translatePgError(r.retryPolicy.run(ctx, operation, false, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+		defer cancel()
+		return runInTx(ctx, r.router.Primary(), pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+			if err := setTenant(ctx, tx, tenantID); err != nil {
+				return err
+			}
+			if err := fn(ctx, tx); err != nil {
+				return err
+			}
+			runAfterCommit(ctx, func() {
+				r.router.Wrote(readYourWritesSession(ctx, tenantID))
+			})
+			return nil
+		})
+	}))
+}
+
+// ReadsFromPrimary returns true if the reads of the session of the context go to the primary so that it reads its own writes,
+// reads of a context without a tenant or session never do
+func (r *PostgresUserRepository) ReadsFromPrimary(ctx context.Context) bool {
+	tenantID, err := tenantFromContext(ctx)
+	if err != nil {
+		return false
+	}
+	return r.router.Pinned(readYourWritesSession(ctx, tenantID))
+}
+
+// readYourWritesSession returns the session the router pins to the primary after a write, which is the session of the context within the tenant,
+// or the empty session that is never pinned if the context carries no session. Tenant ids cannot contain a slash, so sessions of different tenants differ
+func readYourWritesSession(ctx context.Context, tenantID string) string {
+	id, ok := session.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return tenantID + "/" + id
+}
+
+// withReadTx runs fn in a read only transaction scoped to the tenant on the database to read from for the session of the context,
+// or in a savepoint of the transaction carried by the context.
+// A timeout applies to the whole transaction, without one fn has to bound each of its statements itself
+func (r *PostgresUserRepository) withReadTx(ctx context.Context, tenantID, operation string, idempotent bool, timeout time.Duration, fn func(ctx context.Context, tx pgx.Tx) error) error {
+	return r.retryPolicy.run(ctx, operation, idempotent, func(ctx context.Context) error {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return runInTx(ctx, r.router.Reader(readYourWritesSession(ctx, tenantID)), pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(ctx context.Context, tx pgx.Tx) error {
+			if err := setTenant(ctx, tx, tenantID); err != nil {
+				return err
+			}
+			return fn(ctx, tx)
+		})
+	})
+}
+
+// createUsersBatchArgs returns the arguments of the insert of a batch of users of the tenant, the users are passed as one array per column
+func createUsersBatchArgs(tenantID string, users []*User, changedBy string) []any {
+	names := make([]string, len(users))
+	emails := make([]string, len(users))
+	ages := make([]int, len(users))
+	for i, user := range users {
+		names[i] = user.Name
+		emails[i] = user.Email
+		ages[i] = user.Age
+	}
+	return []any{tenantID, HistoryOperationCreate, changedBy, OutboxEventUserCreated, names, emails, ages}
+}
+
+// buildPatchUserQuery builds the update of the columns of the non-nil fields of a patch of a user of the tenant, returning false if the patch has no fields
+func buildPatchUserQuery(tenantID string, patch *UserPatch) (string, []any, bool) {
+	builder := &userQueryBuilder{}
+	builder.addArg(tenantID)
+	assignments := []string{}
+	if patch.Name != nil {
+		assignments = append(assignments, "name = "+builder.addArg(*patch.Name))
+	}
+	if patch.Email != nil {
+		assignments = append(assignments, "email = "+builder.addArg(*patch.Email))
+	}
+	if patch.Age != nil {
+		assignments = append(assignments, "age = "+builder.addArg(*patch.Age))
 	}
-	return nil
+	if len(assignments) == 0 {
+		return "", nil, false
+	}
+	query := fmt.Sprintf(postgresPatchUserQuery, strings.Join(assignments, ", "), builder.addArg(patch.ID))
+	return query, builder.args, true
 }
 
-// noRowsAffected returns true if the result of an update or delete query did not affect any rows (i.e. because the row did not exist)
-func noRowsAffected(result sql.Result) bool {
-	rowsAffected, _ := result.RowsAffected()
-	return rowsAffected == 0
+// lockUser gets a user of the tenant with the given lock query, locking the row until the end of the transaction
+func lockUser(ctx context.Context, tx pgx.Tx, lockQuery, tenantID string, id int) (*User, error) {
+	user, err := getRow[User](ctx, tx, lockQuery, tenantID, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
 }
@@ -0,0 +1,23 @@
+//go:build benchbaseline
+
+package repository_test
+
+import (
+	"context"
+
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+)
+
+// benchmarkUpdate updates a user at its version with the Update of the pre-migration baseline, which takes the version from the user.
+func benchmarkUpdate(ctx context.Context, userRepository repository.UserRepository, user *repository.User) error {
+	return userRepository.Update(ctx, user)
+}
+
+// benchmarkPageQuery returns the query for the page of 50 users by name that follows the user, the baseline looks the user up by id.
+func benchmarkPageQuery(after *repository.User) *repository.UserPageQuery {
+	return &repository.UserPageQuery{
+		AfterID: after.ID,
+		Limit:   50,
+		Sort:    []repository.UserSort{{Column: "name"}},
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !benchbaseline
+
+package repository_test
+
+import (
+	"context"
+
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+)
+
+// benchmarkUpdate updates a user at its version, the baseline is benchmarked with the variant built with the benchbaseline tag.
+func benchmarkUpdate(ctx context.Context, userRepository repository.UserRepository, user *repository.User) error {
+	return userRepository.Update(ctx, user, repository.MatchVersion(user.Version))
+}
+
+// benchmarkPageQuery returns the query for the page of 50 users by name that follows the user.
+func benchmarkPageQuery(after *repository.User) *repository.UserPageQuery {
+	return &repository.UserPageQuery{
+		After: repository.NewUserCursor(after),
+		Limit: 50,
+		Sort:  []repository.UserSort{{Column: "name"}},
+	}
+}
@@ -0,0 +1,109 @@
+package repository_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/pkg/test"
+)
+
+// benchmarkUserCount is how many users the read benchmarks read from.
+const benchmarkUserCount = 1000
+
+// benchmarkEmails numbers the emails of users created by benchmarks, so that every email is unique across benchmark runs.
+var benchmarkEmails atomic.Int64
+
+// newBenchmarkUsers returns count users with unique emails.
+func newBenchmarkUsers(count int) []*repository.User {
+	users := make([]*repository.User, count)
+	for i := range users {
+		users[i] = &repository.User{
+			Name:  fmt.Sprintf("Bench User %d", i),
+			Email: fmt.Sprintf("bench%d@email.com", benchmarkEmails.Add(1)),
+			Age:   i % 100,
+		}
+	}
+	return users
+}
+
+// BenchmarkPostgresUserRepository measures the throughput of the user repository on a Postgres database, compare runs with benchstat.
+func BenchmarkPostgresUserRepository(b *testing.B) {
+	db := test.StartDatabase(b)
+	// Close is wrapped, as the *sqlx.DB of the pre-migration baseline returns an error from Close
+	b.Cleanup(func() { db.Close() })
+	userRepository := repository.NewPostgresUserRepository(db, time.Second*5)
+	created, err := userRepository.CreateBatch(tenantContext(), newBenchmarkUsers(benchmarkUserCount), true)
+	require.NoError(b, err)
+
+	b.Run("Get", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				if _, err := userRepository.Get(tenantContext(), created[i%len(created)].ID); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+
+	b.Run("GetPage", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				if _, err := userRepository.GetPage(tenantContext(), benchmarkPageQuery(created[i%len(created)])); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+
+	b.Run("Export", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			err := userRepository.Export(tenantContext(), nil, func(user *repository.User) error {
+				return nil
+			})
+			require.NoError(b, err)
+		}
+		b.ReportMetric(float64(b.N*benchmarkUserCount)/b.Elapsed().Seconds(), "users/s")
+	})
+
+	b.Run("Create", func(b *testing.B) {
+		users := newBenchmarkUsers(b.N)
+		next := atomic.Int64{}
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := userRepository.Create(tenantContext(), users[next.Add(1)-1]); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+
+	b.Run("CreateBatch", func(b *testing.B) {
+		batches := make([][]*repository.User, b.N)
+		for i := range batches {
+			batches[i] = newBenchmarkUsers(100)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, err := userRepository.CreateBatch(tenantContext(), batches[i], true)
+			require.NoError(b, err)
+		}
+		b.ReportMetric(float64(b.N*100)/b.Elapsed().Seconds(), "users/s")
+	})
+
+	b.Run("Update", func(b *testing.B) {
+		user := *created[0]
+		for i := 0; i < b.N; i++ {
+			user.Age = i % 100
+			require.NoError(b, benchmarkUpdate(tenantContext(), userRepository, &user))
+			user.Version++
+		}
+	})
+}
@@ -1,101 +1,374 @@
 package repository_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/pkg/actor"
+	"github.com/tobiassundman/go-demo-app/pkg/database"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
 	"github.com/tobiassundman/go-demo-app/pkg/test"
 )
 
+// Tenants users are created for in tests.
+const (
+	TENANT1 = "tenant1"
+	TENANT2 = "tenant2"
+)
+
 var (
 	USER1 = repository.User{
-		ID:    1,
-		Name:  "Name Name 1",
-		Email: "email1@email.com",
-		Age:   37,
+		ID:      1,
+		Name:    "Name Name 1",
+		Email:   "email1@email.com",
+		Age:     37,
+		Version: 1,
 	}
 	USER2 = repository.User{
-		ID:    2,
-		Name:  "Name Name 2",
-		Email: "email2@email.com",
-		Age:   102,
+		ID:      2,
+		Name:    "Name Name 2",
+		Email:   "email2@email.com",
+		Age:     102,
+		Version: 1,
 	}
 )
 
-func TestGetAll(t *testing.T) {
+// tenantContext returns a context carrying TENANT1.
+func tenantContext() context.Context {
+	return tenant.NewContext(context.Background(), TENANT1)
+}
+
+// newUserRepositoryFunc creates an empty repository for a test.
+type newUserRepositoryFunc func(t *testing.T) repository.UserRepository
+
+// withoutTimestamps returns a copy of the user without its timestamps, to compare it with a user whose timestamps are not known in advance.
+func withoutTimestamps(user *repository.User) *repository.User {
+	copied := *user
+	copied.CreatedAt = time.Time{}
+	copied.UpdatedAt = time.Time{}
+	return &copied
+}
+
+// allWithoutTimestamps returns copies of the users without their timestamps.
+func allWithoutTimestamps(users []*repository.User) []*repository.User {
+	copied := make([]*repository.User, len(users))
+	for i, user := range users {
+		copied[i] = withoutTimestamps(user)
+	}
+	return copied
+}
+
+// testUserRepository runs the conformance suite that every UserRepository implementation must pass.
+func testUserRepository(t *testing.T, newRepository newUserRepositoryFunc) {
+	t.Run("GetAll", func(t *testing.T) { testGetAll(t, newRepository) })
+	t.Run("GetPage", func(t *testing.T) { testGetPage(t, newRepository) })
+	t.Run("Search", func(t *testing.T) { testSearch(t, newRepository) })
+	t.Run("Get", func(t *testing.T) { testGet(t, newRepository) })
+	t.Run("Create", func(t *testing.T) { testCreate(t, newRepository) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, newRepository) })
+	t.Run("Patch", func(t *testing.T) { testPatch(t, newRepository) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newRepository) })
+	t.Run("Restore", func(t *testing.T) { testRestore(t, newRepository) })
+	t.Run("PurgeDeleted", func(t *testing.T) { testPurgeDeleted(t, newRepository) })
+	t.Run("GetHistory", func(t *testing.T) { testGetHistory(t, newRepository) })
+	t.Run("CreateBatch", func(t *testing.T) { testCreateBatch(t, newRepository) })
+	t.Run("Export", func(t *testing.T) { testExport(t, newRepository) })
+	t.Run("Timestamps", func(t *testing.T) { testTimestamps(t, newRepository) })
+	t.Run("Tenants", func(t *testing.T) { testTenants(t, newRepository) })
+	t.Run("Constraints", func(t *testing.T) { testConstraints(t, newRepository) })
+}
+
+func TestPostgresUserRepository(t *testing.T) {
+	t.Parallel()
+	testUserRepository(t, func(t *testing.T) repository.UserRepository {
+		db := test.StartDatabase(t)
+		t.Cleanup(func() { db.Close() })
+		return repository.NewPostgresUserRepository(db, time.Second*2)
+	})
+}
+
+func TestReplicatedPostgresUserRepository(t *testing.T) {
+	t.Parallel()
+	testUserRepository(t, func(t *testing.T) repository.UserRepository {
+		db := test.StartDatabase(t)
+		t.Cleanup(func() { db.Close() })
+		// The database is its own replica without lag, so that reads from the replica see every write
+		router := database.NewReplicaRouter(db, []database.Pool{db}, database.ReplicaRouterConfig{
+			MaxLag:               time.Second,
+			ReadYourWritesWindow: time.Second,
+		})
+		router.CheckReplicas(context.Background())
+		return repository.NewReplicatedPostgresUserRepository(router, time.Second*2)
+	})
+}
+
+func TestInMemoryUserRepository(t *testing.T) {
+	t.Parallel()
+	testUserRepository(t, func(t *testing.T) repository.UserRepository {
+		return repository.NewInMemoryUserRepository()
+	})
+}
+
+func testGetAll(t *testing.T, newRepository newUserRepositoryFunc) {
 	t.Parallel()
 	t.Run("should return all users", func(t *testing.T) {
 		t.Parallel()
 
 		// Arrange
-		db := test.StartDatabase(t)
-		defer db.Close()
-		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		userRepository := newRepository(t)
 
-		_, err := pgRepository.Create(&USER1)
+		_, err := userRepository.Create(tenantContext(), &USER1)
 		require.NoError(t, err)
-		_, err = pgRepository.Create(&USER2)
+		_, err = userRepository.Create(tenantContext(), &USER2)
 		require.NoError(t, err)
 
 		// Act
-		users, err := pgRepository.GetAll()
+		users, err := userRepository.GetAll(tenantContext())
 		require.NoError(t, err)
 
 		// Assert
 		assert.Len(t, users, 2)
-		assert.Contains(t, users, &USER1)
-		assert.Contains(t, users, &USER2)
+		assert.Contains(t, allWithoutTimestamps(users), &USER1)
+		assert.Contains(t, allWithoutTimestamps(users), &USER2)
 	})
 
 	t.Run("empty returns empty array", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
-		db := test.StartDatabase(t)
-		defer db.Close()
-		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		userRepository := newRepository(t)
 
 		// Act
-		users, err := pgRepository.GetAll()
+		users, err := userRepository.GetAll(tenantContext())
 		require.NoError(t, err)
 
 		// Assert
 		assert.Len(t, users, 0)
 	})
+
+	t.Run("cancelled context aborts query", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+
+		ctx, cancel := context.WithCancel(tenantContext())
+		cancel()
+
+		// Act
+		_, err := userRepository.GetAll(ctx)
+		require.Error(t, err)
+
+		// Assert
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func testGetPage(t *testing.T, newRepository newUserRepositoryFunc) {
+	t.Parallel()
+	t.Run("should return users after the given id", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		_, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		_, err = userRepository.Create(tenantContext(), &USER2)
+		require.NoError(t, err)
+
+		// Act
+		firstPage, err := userRepository.GetPage(tenantContext(), &repository.UserPageQuery{Limit: 1})
+		require.NoError(t, err)
+		secondPage, err := userRepository.GetPage(tenantContext(), &repository.UserPageQuery{After: repository.NewUserCursor(firstPage[0]), Limit: 1})
+		require.NoError(t, err)
+		lastPage, err := userRepository.GetPage(tenantContext(), &repository.UserPageQuery{After: repository.NewUserCursor(secondPage[0]), Limit: 1})
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, []*repository.User{&USER1}, allWithoutTimestamps(firstPage))
+		assert.Equal(t, []*repository.User{&USER2}, allWithoutTimestamps(secondPage))
+		assert.Len(t, lastPage, 0)
+	})
+
+	t.Run("should filter users", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		other := repository.User{ID: 3, Name: "Other", Email: "other@other.com", Age: 50, Version: 1}
+		for _, user := range []*repository.User{&USER1, &USER2, &other} {
+			_, err := userRepository.Create(tenantContext(), user)
+			require.NoError(t, err)
+		}
+		minAge := 40
+
+		// Act
+		users, err := userRepository.GetPage(tenantContext(), &repository.UserPageQuery{
+			Limit: 10,
+			Filter: &repository.UserFilter{
+				EmailDomain: "EMAIL.com",
+				NamePrefix:  "name name",
+				MinAge:      &minAge,
+			},
+		})
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, []*repository.User{&USER2}, allWithoutTimestamps(users))
+	})
+
+	t.Run("should sort users and continue from cursor", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		user3 := repository.User{ID: 3, Name: "Name Name 1", Email: "email3@email.com", Age: 20, Version: 1}
+		for _, user := range []*repository.User{&USER1, &USER2, &user3} {
+			_, err := userRepository.Create(tenantContext(), user)
+			require.NoError(t, err)
+		}
+		sort := []repository.UserSort{{Column: "name"}, {Column: "age", Descending: true}}
+
+		// Act
+		firstPage, err := userRepository.GetPage(tenantContext(), &repository.UserPageQuery{Limit: 2, Sort: sort})
+		require.NoError(t, err)
+		secondPage, err := userRepository.GetPage(tenantContext(), &repository.UserPageQuery{After: repository.NewUserCursor(firstPage[1]), Limit: 2, Sort: sort})
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, []*repository.User{&USER1, &user3}, allWithoutTimestamps(firstPage))
+		assert.Equal(t, []*repository.User{&USER2}, allWithoutTimestamps(secondPage))
+	})
+
+	t.Run("should continue from cursor after cursor user changed", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		user3 := repository.User{ID: 3, Name: "Name Name 3", Email: "email3@email.com", Age: 20, Version: 1}
+		for _, user := range []*repository.User{&USER1, &USER2, &user3} {
+			_, err := userRepository.Create(tenantContext(), user)
+			require.NoError(t, err)
+		}
+		sort := []repository.UserSort{{Column: "name"}}
+		firstPage, err := userRepository.GetPage(tenantContext(), &repository.UserPageQuery{Limit: 1, Sort: sort})
+		require.NoError(t, err)
+		renamed := *firstPage[0]
+		renamed.Name = "Renamed"
+		require.NoError(t, userRepository.Update(tenantContext(), &renamed, repository.MatchVersion(renamed.Version)))
+
+		// Act
+		secondPage, err := userRepository.GetPage(tenantContext(), &repository.UserPageQuery{After: repository.NewUserCursor(firstPage[0]), Limit: 1, Sort: sort})
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, []*repository.User{&USER2}, allWithoutTimestamps(secondPage))
+	})
+
+	t.Run("should reject unknown sort column", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		// Act
+		_, err := userRepository.GetPage(tenantContext(), &repository.UserPageQuery{
+			Limit: 10,
+			Sort:  []repository.UserSort{{Column: "name; DROP TABLE config.users"}},
+		})
+
+		// Assert
+		assert.ErrorIs(t, err, repository.ErrInvalidSortColumn)
+	})
+}
+
+func testSearch(t *testing.T, newRepository newUserRepositoryFunc) {
+	t.Parallel()
+	t.Run("should rank similar users first", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		johnSmith := repository.User{ID: 1, Name: "John Smith", Email: "john.smith@email.com", Age: 40, Version: 1}
+		johnSmithson := repository.User{ID: 2, Name: "John Smithson", Email: "jsmithson@email.com", Age: 41, Version: 1}
+		unrelated := repository.User{ID: 3, Name: "Alice Jones", Email: "alice@other.com", Age: 42, Version: 1}
+		for _, user := range []*repository.User{&johnSmith, &johnSmithson, &unrelated} {
+			_, err := userRepository.Create(tenantContext(), user)
+			require.NoError(t, err)
+		}
+
+		// Act
+		results, err := userRepository.Search(tenantContext(), "jon smth", 10)
+		require.NoError(t, err)
+
+		// Assert
+		require.NotEmpty(t, results)
+		assert.Equal(t, johnSmith, *withoutTimestamps(&results[0].User))
+		for i, result := range results {
+			assert.NotEqual(t, unrelated.ID, result.ID)
+			assert.Greater(t, result.Score, 0.0)
+			if i > 0 {
+				assert.LessOrEqual(t, result.Score, results[i-1].Score)
+			}
+		}
+	})
+
+	t.Run("should respect limit", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		_, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		_, err = userRepository.Create(tenantContext(), &USER2)
+		require.NoError(t, err)
+
+		// Act
+		results, err := userRepository.Search(tenantContext(), "Name Name", 1)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Len(t, results, 1)
+	})
 }
 
-func TestGet(t *testing.T) {
+func testGet(t *testing.T, newRepository newUserRepositoryFunc) {
 	t.Parallel()
 	t.Run("should return user", func(t *testing.T) {
 		t.Parallel()
 
 		// Arrange
-		db := test.StartDatabase(t)
-		defer db.Close()
-		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		userRepository := newRepository(t)
 
-		id, err := pgRepository.Create(&USER1)
+		id, err := userRepository.Create(tenantContext(), &USER1)
 		require.NoError(t, err)
 
 		// Act
-		user, err := pgRepository.Get(id)
+		user, err := userRepository.Get(tenantContext(), id)
 		require.NoError(t, err)
 
 		// Assert
-		assert.Equal(t, user, &USER1)
+		assert.Equal(t, withoutTimestamps(user), &USER1)
 	})
 
 	t.Run("user not found", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
-		db := test.StartDatabase(t)
-		defer db.Close()
-		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		userRepository := newRepository(t)
 
 		// Act
-		_, err := pgRepository.Get(24)
+		_, err := userRepository.Get(tenantContext(), 24)
 		require.Error(t, err)
 
 		// Assert
@@ -103,15 +376,13 @@ func TestGet(t *testing.T) {
 	})
 }
 
-func TestCreate(t *testing.T) {
+func testCreate(t *testing.T, newRepository newUserRepositoryFunc) {
 	t.Parallel()
 	t.Run("create user generates id", func(t *testing.T) {
 		t.Parallel()
 
 		// Arrange
-		db := test.StartDatabase(t)
-		defer db.Close()
-		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		userRepository := newRepository(t)
 
 		user := repository.User{
 			ID:    0,
@@ -120,11 +391,11 @@ func TestCreate(t *testing.T) {
 			Age:   37,
 		}
 
-		generatedID, err := pgRepository.Create(&user)
+		generatedID, err := userRepository.Create(tenantContext(), &user)
 		require.NoError(t, err)
 
 		// Act
-		createdUser, err := pgRepository.Get(generatedID)
+		createdUser, err := userRepository.Get(tenantContext(), generatedID)
 		require.NoError(t, err)
 
 		// Assert
@@ -138,15 +409,13 @@ func TestCreate(t *testing.T) {
 	t.Run("user already exists", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
-		db := test.StartDatabase(t)
-		defer db.Close()
-		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		userRepository := newRepository(t)
 
-		_, err := pgRepository.Create(&USER1)
+		_, err := userRepository.Create(tenantContext(), &USER1)
 		require.NoError(t, err)
 
 		// Act
-		_, err = pgRepository.Create(&USER1)
+		_, err = userRepository.Create(tenantContext(), &USER1)
 		require.Error(t, err)
 
 		// Assert
@@ -154,17 +423,15 @@ func TestCreate(t *testing.T) {
 	})
 }
 
-func TestUpdate(t *testing.T) {
+func testUpdate(t *testing.T, newRepository newUserRepositoryFunc) {
 	t.Parallel()
 	t.Run("update existing", func(t *testing.T) {
 		t.Parallel()
 
 		// Arrange
-		db := test.StartDatabase(t)
-		defer db.Close()
-		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		userRepository := newRepository(t)
 
-		id, err := pgRepository.Create(&USER1)
+		id, err := userRepository.Create(tenantContext(), &USER1)
 		require.NoError(t, err)
 
 		modifiedUser := USER1
@@ -173,24 +440,23 @@ func TestUpdate(t *testing.T) {
 		modifiedUser.Age = 99
 
 		// Act
-		err = pgRepository.Update(&modifiedUser)
+		err = userRepository.Update(tenantContext(), &modifiedUser, repository.MatchVersion(modifiedUser.Version))
 		require.NoError(t, err)
-		updatedUser, err := pgRepository.Get(id)
+		updatedUser, err := userRepository.Get(tenantContext(), id)
 		require.NoError(t, err)
 
 		// Assert
-		assert.Equal(t, &modifiedUser, updatedUser)
+		modifiedUser.Version = 2
+		assert.Equal(t, &modifiedUser, withoutTimestamps(updatedUser))
 	})
 
 	t.Run("update non-existing", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
-		db := test.StartDatabase(t)
-		defer db.Close()
-		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		userRepository := newRepository(t)
 
 		// Act
-		err := pgRepository.Update(&USER1)
+		err := userRepository.Update(tenantContext(), &USER1, repository.MatchVersion(USER1.Version))
 		require.Error(t, err)
 
 		// Assert
@@ -200,44 +466,194 @@ func TestUpdate(t *testing.T) {
 	t.Run("cannot use email of another user", func(t *testing.T) {
 		t.Parallel()
 		// Arrange
-		db := test.StartDatabase(t)
-		defer db.Close()
-		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		userRepository := newRepository(t)
 
-		_, err := pgRepository.Create(&USER1)
+		_, err := userRepository.Create(tenantContext(), &USER1)
 		require.NoError(t, err)
-		_, err = pgRepository.Create(&USER2)
+		_, err = userRepository.Create(tenantContext(), &USER2)
 		require.NoError(t, err)
 
 		modifiedUser := USER2
 		modifiedUser.Email = USER1.Email
 
 		// Act
-		err = pgRepository.Update(&modifiedUser)
+		err = userRepository.Update(tenantContext(), &modifiedUser, repository.MatchVersion(modifiedUser.Version))
+		require.Error(t, err)
+
+		// Assert
+		assert.Equal(t, repository.ErrUserAlreadyExists, err)
+	})
+
+	t.Run("update with stale version", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+
+		_, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+
+		firstUpdate := USER1
+		firstUpdate.Name = "First"
+		err = userRepository.Update(tenantContext(), &firstUpdate, repository.MatchVersion(firstUpdate.Version))
+		require.NoError(t, err)
+
+		secondUpdate := USER1
+		secondUpdate.Name = "Second"
+
+		// Act
+		err = userRepository.Update(tenantContext(), &secondUpdate, repository.MatchVersion(secondUpdate.Version))
 		require.Error(t, err)
 
+		// Assert
+		assert.Equal(t, repository.ErrVersionConflict, err)
+	})
+
+	t.Run("update matches current version", func(t *testing.T) {
+		t.Parallel()
+
+		tests := map[string]struct {
+			match repository.VersionMatch
+			err   error
+		}{
+			"any version":               {repository.VersionMatch{Any: true}, nil},
+			"list with current version": {repository.VersionMatch{Versions: []int{1, 2}}, nil},
+			"list without current":      {repository.VersionMatch{Versions: []int{1, 3}}, repository.ErrVersionConflict},
+			"empty list":                {repository.VersionMatch{}, repository.ErrVersionConflict},
+		}
+		for name, testCase := range tests {
+			testCase := testCase
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				// Arrange
+				userRepository := newRepository(t)
+
+				_, err := userRepository.Create(tenantContext(), &USER1)
+				require.NoError(t, err)
+				require.NoError(t, userRepository.Update(tenantContext(), &USER1, repository.MatchVersion(1)))
+
+				modifiedUser := USER1
+				modifiedUser.Name = "Modified"
+
+				// Act
+				err = userRepository.Update(tenantContext(), &modifiedUser, testCase.match)
+
+				// Assert
+				assert.Equal(t, testCase.err, err)
+			})
+		}
+	})
+}
+
+func testPatch(t *testing.T, newRepository newUserRepositoryFunc) {
+	t.Parallel()
+	t.Run("patch changes only given fields", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		name := "Patched Name"
+		age := 0
+
+		// Act
+		patchedUser, err := userRepository.Patch(tenantContext(), &repository.UserPatch{ID: id, Name: &name, Age: &age}, repository.MatchVersion(1))
+		require.NoError(t, err)
+		storedUser, err := userRepository.Get(tenantContext(), id)
+		require.NoError(t, err)
+
+		// Assert
+		expectedUser := USER1
+		expectedUser.Name = name
+		expectedUser.Age = 0
+		expectedUser.Version = 2
+		assert.Equal(t, &expectedUser, withoutTimestamps(patchedUser))
+		assert.Equal(t, &expectedUser, withoutTimestamps(storedUser))
+	})
+
+	t.Run("patch without fields changes nothing", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+
+		// Act
+		patchedUser, err := userRepository.Patch(tenantContext(), &repository.UserPatch{ID: id}, repository.MatchVersion(1))
+		require.NoError(t, err)
+		history, err := userRepository.GetHistory(tenantContext(), &repository.UserHistoryPageQuery{UserID: id, Limit: 10})
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, &USER1, withoutTimestamps(patchedUser))
+		assert.Len(t, history, 1)
+	})
+
+	t.Run("cannot patch email of another user", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+
+		_, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		id, err := userRepository.Create(tenantContext(), &USER2)
+		require.NoError(t, err)
+		email := USER1.Email
+
+		// Act
+		_, err = userRepository.Patch(tenantContext(), &repository.UserPatch{ID: id, Email: &email}, repository.MatchVersion(1))
+
 		// Assert
 		assert.Equal(t, repository.ErrUserAlreadyExists, err)
 	})
+
+	t.Run("patch with stale version", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		name := "Patched Name"
+		_, err = userRepository.Patch(tenantContext(), &repository.UserPatch{ID: id, Name: &name}, repository.MatchVersion(1))
+		require.NoError(t, err)
+
+		// Act
+		_, err = userRepository.Patch(tenantContext(), &repository.UserPatch{ID: id, Name: &name}, repository.MatchVersion(1))
+
+		// Assert
+		assert.Equal(t, repository.ErrVersionConflict, err)
+	})
+
+	t.Run("patch non-existing", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+
+		// Act
+		_, err := userRepository.Patch(tenantContext(), &repository.UserPatch{ID: 1}, repository.MatchVersion(1))
+
+		// Assert
+		assert.Equal(t, repository.ErrUserNotFound, err)
+	})
 }
 
-func TestDelete(t *testing.T) {
+func testDelete(t *testing.T, newRepository newUserRepositoryFunc) {
 	t.Parallel()
 	t.Run("delete existing", func(t *testing.T) {
 		t.Parallel()
 
 		// Arrange
-		db := test.StartDatabase(t)
-		defer db.Close()
-		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		userRepository := newRepository(t)
 
-		id, err := pgRepository.Create(&USER1)
+		id, err := userRepository.Create(tenantContext(), &USER1)
 		require.NoError(t, err)
 
 		// Act
-		err = pgRepository.Delete(id)
+		err = userRepository.Delete(tenantContext(), id, repository.MatchVersion(1))
 		require.NoError(t, err)
-		_, err = pgRepository.Get(id)
+		_, err = userRepository.Get(tenantContext(), id)
 		require.Error(t, err)
 
 		// Assert
@@ -248,15 +664,670 @@ func TestDelete(t *testing.T) {
 		t.Parallel()
 
 		// Arrange
-		db := test.StartDatabase(t)
-		defer db.Close()
-		pgRepository := repository.NewPostgresUserRepository(db, time.Second*2)
+		userRepository := newRepository(t)
 
 		// Act
-		err := pgRepository.Delete(25)
+		err := userRepository.Delete(tenantContext(), 25, repository.MatchVersion(1))
 		require.Error(t, err)
 
 		// Assert
 		assert.Equal(t, err, repository.ErrUserNotFound)
 	})
+
+	t.Run("delete with stale version", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		err = userRepository.Update(tenantContext(), &USER1, repository.MatchVersion(USER1.Version))
+		require.NoError(t, err)
+
+		// Act
+		err = userRepository.Delete(tenantContext(), id, repository.MatchVersion(1))
+		require.Error(t, err)
+
+		// Assert
+		assert.Equal(t, repository.ErrVersionConflict, err)
+	})
+}
+
+func testRestore(t *testing.T, newRepository newUserRepositoryFunc) {
+	t.Parallel()
+	t.Run("restore deleted", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		err = userRepository.Delete(tenantContext(), id, repository.MatchVersion(1))
+		require.NoError(t, err)
+
+		// Act
+		err = userRepository.Restore(tenantContext(), id)
+		require.NoError(t, err)
+		user, err := userRepository.Get(tenantContext(), id)
+		require.NoError(t, err)
+
+		// Assert
+		restoredUser := USER1
+		restoredUser.Version = 3
+		assert.Equal(t, &restoredUser, withoutTimestamps(user))
+	})
+
+	t.Run("restore not deleted", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+
+		// Act
+		err = userRepository.Restore(tenantContext(), id)
+		require.Error(t, err)
+
+		// Assert
+		assert.Equal(t, repository.ErrUserNotFound, err)
+	})
+
+	t.Run("restore when email is taken", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		err = userRepository.Delete(tenantContext(), id, repository.MatchVersion(1))
+		require.NoError(t, err)
+		_, err = userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+
+		// Act
+		err = userRepository.Restore(tenantContext(), id)
+		require.Error(t, err)
+
+		// Assert
+		assert.Equal(t, repository.ErrUserAlreadyExists, err)
+	})
+}
+
+func testPurgeDeleted(t *testing.T, newRepository newUserRepositoryFunc) {
+	t.Parallel()
+	t.Run("purges users deleted longer ago than retention", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		deletedID, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		_, err = userRepository.Create(tenantContext(), &USER2)
+		require.NoError(t, err)
+		err = userRepository.Delete(tenantContext(), deletedID, repository.MatchVersion(1))
+		require.NoError(t, err)
+
+		// Act
+		notPurged, err := userRepository.PurgeDeleted(tenantContext(), time.Hour)
+		require.NoError(t, err)
+		purged, err := userRepository.PurgeDeleted(tenantContext(), 0)
+		require.NoError(t, err)
+		users, err := userRepository.GetAll(tenantContext())
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, int64(0), notPurged)
+		assert.Equal(t, int64(1), purged)
+		assert.Equal(t, []*repository.User{&USER2}, allWithoutTimestamps(users))
+		assert.Equal(t, repository.ErrUserNotFound, userRepository.Restore(tenantContext(), deletedID))
+	})
+}
+
+func testGetHistory(t *testing.T, newRepository newUserRepositoryFunc) {
+	t.Parallel()
+	t.Run("records every change with actor", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+		ctx := actor.NewContext(tenantContext(), "admin")
+
+		id, err := userRepository.Create(ctx, &USER1)
+		require.NoError(t, err)
+		modifiedUser := USER1
+		modifiedUser.Name = "Modified Name"
+		err = userRepository.Update(ctx, &modifiedUser, repository.MatchVersion(modifiedUser.Version))
+		require.NoError(t, err)
+		err = userRepository.Delete(ctx, id, repository.MatchVersion(2))
+		require.NoError(t, err)
+		_, err = userRepository.PurgeDeleted(actor.NewContext(tenantContext(), "purger"), 0)
+		require.NoError(t, err)
+
+		// Act
+		entries, err := userRepository.GetHistory(tenantContext(), &repository.UserHistoryPageQuery{UserID: id, Limit: 10})
+		require.NoError(t, err)
+
+		// Assert
+		require.Len(t, entries, 4)
+		updatedUser := modifiedUser
+		updatedUser.Version = 2
+		deletedUser := updatedUser
+		deletedUser.Version = 3
+
+		assert.Equal(t, repository.HistoryOperationCreate, entries[0].Operation)
+		assert.Equal(t, "admin", entries[0].ChangedBy)
+		assert.Nil(t, entries[0].Before)
+		assert.Equal(t, &USER1, entries[0].After)
+
+		assert.Equal(t, repository.HistoryOperationUpdate, entries[1].Operation)
+		assert.Equal(t, &USER1, entries[1].Before)
+		assert.Equal(t, &updatedUser, entries[1].After)
+
+		assert.Equal(t, repository.HistoryOperationDelete, entries[2].Operation)
+		assert.Equal(t, &updatedUser, entries[2].Before)
+		assert.Nil(t, entries[2].After)
+
+		assert.Equal(t, repository.HistoryOperationPurge, entries[3].Operation)
+		assert.Equal(t, "purger", entries[3].ChangedBy)
+		assert.Equal(t, &deletedUser, entries[3].Before)
+		assert.Nil(t, entries[3].After)
+	})
+
+	t.Run("failed change is not recorded", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		_, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		id, err := userRepository.Create(tenantContext(), &USER2)
+		require.NoError(t, err)
+		modifiedUser := USER2
+		modifiedUser.Email = USER1.Email
+		err = userRepository.Update(tenantContext(), &modifiedUser, repository.MatchVersion(modifiedUser.Version))
+		require.Equal(t, repository.ErrUserAlreadyExists, err)
+
+		// Act
+		entries, err := userRepository.GetHistory(tenantContext(), &repository.UserHistoryPageQuery{UserID: id, Limit: 10})
+		require.NoError(t, err)
+
+		// Assert
+		require.Len(t, entries, 1)
+		assert.Equal(t, repository.HistoryOperationCreate, entries[0].Operation)
+		assert.Equal(t, actor.Unknown, entries[0].ChangedBy)
+	})
+
+	t.Run("paginates after id", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		err = userRepository.Update(tenantContext(), &USER1, repository.MatchVersion(USER1.Version))
+		require.NoError(t, err)
+
+		// Act
+		firstPage, err := userRepository.GetHistory(tenantContext(), &repository.UserHistoryPageQuery{UserID: id, Limit: 1})
+		require.NoError(t, err)
+		secondPage, err := userRepository.GetHistory(tenantContext(), &repository.UserHistoryPageQuery{UserID: id, AfterID: firstPage[0].ID, Limit: 1})
+		require.NoError(t, err)
+
+		// Assert
+		require.Len(t, firstPage, 1)
+		require.Len(t, secondPage, 1)
+		assert.Equal(t, repository.HistoryOperationCreate, firstPage[0].Operation)
+		assert.Equal(t, repository.HistoryOperationUpdate, secondPage[0].Operation)
+	})
+}
+
+func testCreateBatch(t *testing.T, newRepository newUserRepositoryFunc) {
+	t.Parallel()
+	t.Run("partial batch skips existing and repeated emails", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		_, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+
+		// Act
+		created, err := userRepository.CreateBatch(tenantContext(), []*repository.User{&USER1, &USER2, &USER2}, false)
+		require.NoError(t, err)
+
+		// Assert
+		require.Len(t, created, 3)
+		assert.Nil(t, created[0])
+		require.NotNil(t, created[1])
+		assert.Nil(t, created[2])
+		assert.Equal(t, USER2.Email, created[1].Email)
+		assert.Equal(t, 1, created[1].Version)
+
+		storedUser, err := userRepository.Get(tenantContext(), created[1].ID)
+		require.NoError(t, err)
+		assert.Equal(t, created[1], storedUser)
+
+		entries, err := userRepository.GetHistory(tenantContext(), &repository.UserHistoryPageQuery{UserID: created[1].ID, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, repository.HistoryOperationCreate, entries[0].Operation)
+		assert.Equal(t, withoutTimestamps(created[1]), entries[0].After)
+	})
+
+	t.Run("atomic batch creates nothing on conflict", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		_, err := userRepository.Create(tenantContext(), &USER2)
+		require.NoError(t, err)
+
+		// Act
+		_, err = userRepository.CreateBatch(tenantContext(), []*repository.User{&USER1, &USER2}, true)
+
+		// Assert
+		var conflictError *repository.BatchConflictError
+		require.ErrorAs(t, err, &conflictError)
+		assert.Equal(t, []int{1}, conflictError.Indexes)
+		assert.ErrorIs(t, err, repository.ErrUserAlreadyExists)
+
+		users, err := userRepository.GetAll(tenantContext())
+		require.NoError(t, err)
+		assert.Len(t, users, 1)
+	})
+
+	t.Run("atomic batch creates every user", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		// Act
+		created, err := userRepository.CreateBatch(tenantContext(), []*repository.User{&USER1, &USER2}, true)
+		require.NoError(t, err)
+
+		// Assert
+		require.Len(t, created, 2)
+		assert.Equal(t, USER1.Email, created[0].Email)
+		assert.Equal(t, USER2.Email, created[1].Email)
+
+		users, err := userRepository.GetAll(tenantContext())
+		require.NoError(t, err)
+		assert.Len(t, users, 2)
+	})
+}
+
+func testExport(t *testing.T, newRepository newUserRepositoryFunc) {
+	t.Parallel()
+	t.Run("exports every matching user across fetches", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		// More users than are fetched from the cursor at a time
+		users := make([]*repository.User, 1200)
+		for i := range users {
+			domain := "email.com"
+			if i%2 == 1 {
+				domain = "other.com"
+			}
+			users[i] = &repository.User{Name: fmt.Sprintf("Name %d", i), Email: fmt.Sprintf("email%d@%s", i, domain), Age: 37}
+		}
+		_, err := userRepository.CreateBatch(tenantContext(), users, true)
+		require.NoError(t, err)
+
+		// Act
+		exported := []*repository.User{}
+		err = userRepository.Export(tenantContext(), &repository.UserFilter{EmailDomain: "email.com"}, func(user *repository.User) error {
+			exported = append(exported, user)
+			return nil
+		})
+		require.NoError(t, err)
+
+		// Assert
+		require.Len(t, exported, 600)
+		for i, user := range exported {
+			assert.Equal(t, fmt.Sprintf("email%d@email.com", i*2), user.Email)
+		}
+	})
+
+	t.Run("stops at the first error of fn", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepository := newRepository(t)
+
+		_, err := userRepository.CreateBatch(tenantContext(), []*repository.User{&USER1, &USER2}, true)
+		require.NoError(t, err)
+		writeErr := errors.New("broken pipe")
+
+		// Act
+		calls := 0
+		err = userRepository.Export(tenantContext(), nil, func(user *repository.User) error {
+			calls++
+			return writeErr
+		})
+
+		// Assert
+		assert.Equal(t, writeErr, err)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func testTimestamps(t *testing.T, newRepository newUserRepositoryFunc) {
+	t.Parallel()
+	t.Run("create sets created and updated at", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+
+		// Act
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		user, err := userRepository.Get(tenantContext(), id)
+		require.NoError(t, err)
+
+		// Assert
+		assert.WithinDuration(t, time.Now(), user.CreatedAt, time.Minute)
+		assert.True(t, user.UpdatedAt.Equal(user.CreatedAt))
+	})
+
+	t.Run("changes set updated at", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		created, err := userRepository.Get(tenantContext(), id)
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond * 10)
+		name := "Patched Name"
+
+		// Act
+		patched, err := userRepository.Patch(tenantContext(), &repository.UserPatch{ID: id, Name: &name}, repository.MatchVersion(1))
+		require.NoError(t, err)
+
+		// Assert
+		assert.True(t, patched.CreatedAt.Equal(created.CreatedAt))
+		assert.True(t, patched.UpdatedAt.After(created.UpdatedAt))
+	})
+
+	t.Run("filters users updated since", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		_, err = userRepository.Create(tenantContext(), &USER2)
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond * 10)
+		updatedSince := time.Now()
+		time.Sleep(time.Millisecond * 10)
+		modifiedUser := USER1
+		modifiedUser.ID = id
+		modifiedUser.Age = 38
+		require.NoError(t, userRepository.Update(tenantContext(), &modifiedUser, repository.MatchVersion(modifiedUser.Version)))
+
+		// Act
+		users, err := userRepository.GetPage(tenantContext(), &repository.UserPageQuery{
+			Limit:  10,
+			Filter: &repository.UserFilter{UpdatedSince: &updatedSince},
+		})
+		require.NoError(t, err)
+
+		// Assert
+		require.Len(t, users, 1)
+		assert.Equal(t, id, users[0].ID)
+		assert.Equal(t, 38, users[0].Age)
+	})
+}
+
+func testTenants(t *testing.T, newRepository newUserRepositoryFunc) {
+	t.Parallel()
+	t.Run("users of other tenants are not found", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+		otherTenant := tenant.NewContext(context.Background(), TENANT2)
+
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		deletedID, err := userRepository.Create(tenantContext(), &USER2)
+		require.NoError(t, err)
+		require.NoError(t, userRepository.Delete(tenantContext(), deletedID, repository.MatchVersion(1)))
+		name := "Other Name"
+		modifiedUser := USER1
+		modifiedUser.ID = id
+
+		// Act
+		_, getErr := userRepository.Get(otherTenant, id)
+		updateErr := userRepository.Update(otherTenant, &modifiedUser, repository.MatchVersion(modifiedUser.Version))
+		_, patchErr := userRepository.Patch(otherTenant, &repository.UserPatch{ID: id, Name: &name}, repository.MatchVersion(1))
+		deleteErr := userRepository.Delete(otherTenant, id, repository.MatchVersion(1))
+		restoreErr := userRepository.Restore(otherTenant, deletedID)
+		all, err := userRepository.GetAll(otherTenant)
+		require.NoError(t, err)
+		page, err := userRepository.GetPage(otherTenant, &repository.UserPageQuery{Limit: 10})
+		require.NoError(t, err)
+		results, err := userRepository.Search(otherTenant, USER1.Name, 10)
+		require.NoError(t, err)
+		exported := 0
+		err = userRepository.Export(otherTenant, nil, func(user *repository.User) error {
+			exported++
+			return nil
+		})
+		require.NoError(t, err)
+		history, err := userRepository.GetHistory(otherTenant, &repository.UserHistoryPageQuery{UserID: id, Limit: 10})
+		require.NoError(t, err)
+		user, err := userRepository.Get(tenantContext(), id)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, repository.ErrUserNotFound, getErr)
+		assert.Equal(t, repository.ErrUserNotFound, updateErr)
+		assert.Equal(t, repository.ErrUserNotFound, patchErr)
+		assert.Equal(t, repository.ErrUserNotFound, deleteErr)
+		assert.Equal(t, repository.ErrUserNotFound, restoreErr)
+		assert.Empty(t, all)
+		assert.Empty(t, page)
+		assert.Empty(t, results)
+		assert.Equal(t, 0, exported)
+		assert.Empty(t, history)
+		assert.Equal(t, &USER1, withoutTimestamps(user), "the user is unchanged")
+	})
+
+	t.Run("emails are unique per tenant", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+		otherTenant := tenant.NewContext(context.Background(), TENANT2)
+
+		_, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+
+		// Act
+		otherID, otherErr := userRepository.Create(otherTenant, &USER1)
+		_, sameErr := userRepository.Create(tenantContext(), &USER1)
+		created, batchErr := userRepository.CreateBatch(otherTenant, []*repository.User{&USER1, &USER2}, false)
+
+		// Assert
+		assert.NoError(t, otherErr)
+		assert.NotZero(t, otherID)
+		assert.Equal(t, repository.ErrUserAlreadyExists, sameErr)
+		require.NoError(t, batchErr)
+		assert.Nil(t, created[0], "the email is taken in the other tenant")
+		assert.NotNil(t, created[1])
+	})
+
+	t.Run("operations without tenant are rejected", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+
+		// Act
+		_, createErr := userRepository.Create(context.Background(), &USER2)
+		_, getErr := userRepository.Get(context.Background(), id)
+		_, getAllErr := userRepository.GetAll(context.Background())
+
+		// Assert
+		assert.ErrorIs(t, createErr, repository.ErrTenantRequired)
+		assert.ErrorIs(t, getErr, repository.ErrTenantRequired)
+		assert.ErrorIs(t, getAllErr, repository.ErrTenantRequired)
+	})
+
+	t.Run("purge without tenant purges every tenant", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+		otherTenant := tenant.NewContext(context.Background(), TENANT2)
+
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		require.NoError(t, userRepository.Delete(tenantContext(), id, repository.MatchVersion(1)))
+		otherID, err := userRepository.Create(otherTenant, &USER1)
+		require.NoError(t, err)
+		require.NoError(t, userRepository.Delete(otherTenant, otherID, repository.MatchVersion(1)))
+
+		// Act
+		scopedPurged, err := userRepository.PurgeDeleted(otherTenant, 0)
+		require.NoError(t, err)
+		purged, err := userRepository.PurgeDeleted(context.Background(), 0)
+		require.NoError(t, err)
+		history, err := userRepository.GetHistory(tenantContext(), &repository.UserHistoryPageQuery{UserID: id, Limit: 10})
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, int64(1), scopedPurged)
+		assert.Equal(t, int64(1), purged)
+		require.Len(t, history, 3)
+		assert.Equal(t, repository.HistoryOperationPurge, history[2].Operation, "the purge is recorded for the tenant of the user")
+	})
+}
+
+func testConstraints(t *testing.T, newRepository newUserRepositoryFunc) {
+	t.Parallel()
+	t.Run("create rejects invalid values", func(t *testing.T) {
+		t.Parallel()
+
+		tests := map[string]struct {
+			user   repository.User
+			kind   error
+			column string
+		}{
+			"negative age":  {repository.User{Name: "Name Name 1", Email: "email1@email.com", Age: -1}, repository.ErrCheckViolation, "age"},
+			"blank name":    {repository.User{Name: "   ", Email: "email1@email.com", Age: 1}, repository.ErrCheckViolation, "name"},
+			"too long name": {repository.User{Name: strings.Repeat("a", 256), Email: "email1@email.com", Age: 1}, repository.ErrValueTooLong, ""},
+			"too long email": {
+				repository.User{Name: "Name Name 1", Email: strings.Repeat("a", 246) + "@email.com", Age: 1}, repository.ErrValueTooLong, "",
+			},
+		}
+		for name, testCase := range tests {
+			testCase := testCase
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				// Arrange
+				userRepository := newRepository(t)
+
+				// Act
+				_, err := userRepository.Create(tenantContext(), &testCase.user)
+
+				// Assert
+				var constraintErr *repository.ConstraintError
+				require.ErrorAs(t, err, &constraintErr)
+				assert.ErrorIs(t, err, testCase.kind)
+				assert.Equal(t, testCase.column, constraintErr.Column)
+				users, err := userRepository.GetAll(tenantContext())
+				require.NoError(t, err)
+				assert.Empty(t, users)
+			})
+		}
+	})
+
+	t.Run("name and email of 255 characters are allowed", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+		user := repository.User{Name: strings.Repeat("ä", 255), Email: strings.Repeat("a", 245) + "@email.com", Age: 0}
+
+		// Act
+		_, err := userRepository.Create(tenantContext(), &user)
+
+		// Assert
+		assert.NoError(t, err)
+	})
+
+	t.Run("update rejects invalid values", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		user := USER1
+		user.ID = id
+		user.Version = 1
+		user.Age = -1
+
+		// Act
+		err = userRepository.Update(tenantContext(), &user, repository.MatchVersion(user.Version))
+
+		// Assert
+		assert.ErrorIs(t, err, repository.ErrCheckViolation)
+		stored, err := userRepository.Get(tenantContext(), id)
+		require.NoError(t, err)
+		assert.Equal(t, USER1.Age, stored.Age)
+		assert.Equal(t, 1, stored.Version)
+	})
+
+	t.Run("patch rejects invalid values", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+		id, err := userRepository.Create(tenantContext(), &USER1)
+		require.NoError(t, err)
+		blank := " "
+
+		// Act
+		_, err = userRepository.Patch(tenantContext(), &repository.UserPatch{ID: id, Name: &blank}, repository.MatchVersion(1))
+
+		// Assert
+		var constraintErr *repository.ConstraintError
+		require.ErrorAs(t, err, &constraintErr)
+		assert.ErrorIs(t, err, repository.ErrCheckViolation)
+		assert.Equal(t, "name", constraintErr.Column)
+		stored, err := userRepository.Get(tenantContext(), id)
+		require.NoError(t, err)
+		assert.Equal(t, USER1.Name, stored.Name)
+		assert.Equal(t, 1, stored.Version)
+	})
+
+	t.Run("batch with an invalid user creates nothing", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		userRepository := newRepository(t)
+		invalid := USER2
+		invalid.Age = -1
+
+		// Act
+		_, err := userRepository.CreateBatch(tenantContext(), []*repository.User{&USER1, &invalid}, false)
+
+		// Assert
+		assert.ErrorIs(t, err, repository.ErrCheckViolation)
+		users, err := userRepository.GetAll(tenantContext())
+		require.NoError(t, err)
+		assert.Empty(t, users)
+	})
 }
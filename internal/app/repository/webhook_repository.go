@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/tobiassundman/go-demo-app/pkg/database"
+)
+
+// Every query is scoped by the tenant in $1, in addition to the row level security policies scoped by setTenant
+const (
+	postgresGetWebhooksQuery         = `SELECT id, url, secret, event_types, created_at FROM config.webhooks WHERE tenant_id = $1 ORDER BY id`
+	postgresGetWebhookQuery          = `SELECT id, url, secret, event_types, created_at FROM config.webhooks WHERE tenant_id = $1 AND id = $2`
+	postgresGetWebhooksForEventQuery = `SELECT id, url, secret, event_types, created_at FROM config.webhooks WHERE tenant_id = $1 AND event_types @> jsonb_build_array($2::text) ORDER BY id`
+	postgresCreateWebhookQuery       = `INSERT INTO config.webhooks (tenant_id, url, secret, event_types) VALUES ($1, $2, $3, $4::jsonb) RETURNING id`
+	postgresUpdateWebhookQuery       = `UPDATE config.webhooks SET url = $2, secret = $3, event_types = $4::jsonb WHERE tenant_id = $1 AND id = $5`
+	postgresDeleteWebhookQuery       = `DELETE FROM config.webhooks WHERE tenant_id = $1 AND id = $2`
+	postgresCreateDeliveryQuery      = `INSERT INTO config.webhook_deliveries (tenant_id, webhook_id, event_id, event_type, payload, status_code, attempts, error) VALUES ($1, $2, $3, $4, $5::jsonb, $6, $7, $8) RETURNING id`
+	postgresGetDeliveryQuery         = `SELECT id, webhook_id, event_id, event_type, payload::text AS payload, status_code, attempts, error, delivered_at FROM config.webhook_deliveries WHERE tenant_id = $1 AND webhook_id = $2 AND id = $3`
+	postgresGetDeliveriesQuery       = `SELECT id, webhook_id, event_id, event_type, payload::text AS payload, status_code, attempts, error, delivered_at FROM config.webhook_deliveries WHERE tenant_id = $1 AND webhook_id = $2 AND id > $3 ORDER BY id LIMIT $4`
+)
+
+// WebhookRepository is an interface for the webhook repository.
+// Webhooks and their deliveries belong to the tenant of the context they are created with and those of other tenants are not found.
+// Every method fails with ErrTenantRequired if the context does not carry a tenant
+type WebhookRepository interface {
+	// GetWebhooks returns all webhooks ordered by id
+	GetWebhooks(ctx context.Context) ([]*Webhook, error)
+	// GetWebhook returns the webhook with the given id
+	GetWebhook(ctx context.Context, id int) (*Webhook, error)
+	// GetWebhooksForEvent returns the webhooks of the tenant subscribed to the event type ordered by id
+	GetWebhooksForEvent(ctx context.Context, eventType string) ([]*Webhook, error)
+	// CreateWebhook creates a new webhook
+	CreateWebhook(ctx context.Context, webhook *Webhook) (int, error)
+	// UpdateWebhook updates the url, secret and event types of a webhook
+	UpdateWebhook(ctx context.Context, webhook *Webhook) error
+	// DeleteWebhook deletes a webhook together with its deliveries
+	DeleteWebhook(ctx context.Context, id int) error
+	// CreateDelivery records a delivery to a webhook
+	CreateDelivery(ctx context.Context, delivery *WebhookDelivery) (int, error)
+	// GetDelivery returns the delivery with the given id to the webhook with the given id
+	GetDelivery(ctx context.Context, webhookID, id int) (*WebhookDelivery, error)
+	// GetDeliveries returns up to query.Limit deliveries to the webhook with id query.WebhookID that come after the delivery with id query.AfterID, oldest first
+	GetDeliveries(ctx context.Context, query *WebhookDeliveryPageQuery) ([]*WebhookDelivery, error)
+}
+
+// webhookRow is a row of config.webhooks.
+type webhookRow struct {
+	ID         int       `db:"id"`
+	URL        string    `db:"url"`
+	Secret     string    `db:"secret"`
+	EventTypes []string  `db:"event_types"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+// PostgresWebhookRepository is a repository for webhooks and their deliveries in a Postgres database
+type PostgresWebhookRepository struct {
+	db           database.Pool
+	queryTimeout time.Duration
+}
+
+// NewPostgresWebhookRepository creates a new PostgresWebhookRepository, which reads and writes the primary database.
+func NewPostgresWebhookRepository(db database.Pool, queryTimeout time.Duration) *PostgresWebhookRepository {
+	return &PostgresWebhookRepository{
+		db:           db,
+		queryTimeout: queryTimeout,
+	}
+}
+
+// GetWebhooks returns all webhooks ordered by id
+func (r *PostgresWebhookRepository) GetWebhooks(ctx context.Context) ([]*Webhook, error) {
+	return r.selectWebhooks(ctx, postgresGetWebhooksQuery)
+}
+
+// GetWebhook returns the webhook with the given id
+func (r *PostgresWebhookRepository) GetWebhook(ctx context.Context, id int) (*Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	var row *webhookRow
+	err := runInTenantTx(ctx, r.db, func(ctx context.Context, tx pgx.Tx, tenantID string) error {
+		var err error
+		row, err = getRow[webhookRow](ctx, tx, postgresGetWebhookQuery, tenantID, id)
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return webhookFromRow(row), nil
+}
+
+// GetWebhooksForEvent returns the webhooks of the tenant subscribed to the event type ordered by id
+func (r *PostgresWebhookRepository) GetWebhooksForEvent(ctx context.Context, eventType string) ([]*Webhook, error) {
+	return r.selectWebhooks(ctx, postgresGetWebhooksForEventQuery, eventType)
+}
+
+// CreateWebhook creates a new webhook
+func (r *PostgresWebhookRepository) CreateWebhook(ctx context.Context, webhook *Webhook) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	var id int
+	err := runInTenantTx(ctx, r.db, func(ctx context.Context, tx pgx.Tx, tenantID string) error {
+		return tx.QueryRow(ctx, postgresCreateWebhookQuery, tenantID, webhook.URL, webhook.Secret, webhook.EventTypes).Scan(&id)
+	})
+	return id, translatePgError(err)
+}
+
+// UpdateWebhook updates the url, secret and event types of a webhook
+func (r *PostgresWebhookRepository) UpdateWebhook(ctx context.Context, webhook *Webhook) error {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	return runInTenantTx(ctx, r.db, func(ctx context.Context, tx pgx.Tx, tenantID string) error {
+		result, err := tx.Exec(ctx, postgresUpdateWebhookQuery, tenantID, webhook.URL, webhook.Secret, webhook.EventTypes, webhook.ID)
+		if err != nil {
+			return translatePgError(err)
+		}
+		return webhookAffected(result)
+	})
+}
+
+// DeleteWebhook deletes a webhook together with its deliveries
+func (r *PostgresWebhookRepository) DeleteWebhook(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	return runInTenantTx(ctx, r.db, func(ctx context.Context, tx pgx.Tx, tenantID string) error {
+		result, err := tx.Exec(ctx, postgresDeleteWebhookQuery, tenantID, id)
+		if err != nil {
+			return err
+		}
+		return webhookAffected(result)
+	})
+}
+
+// CreateDelivery records a delivery to a webhook
+func (r *PostgresWebhookRepository) CreateDelivery(ctx context.Context, delivery *WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	var id int
+	err := runInTenantTx(ctx, r.db, func(ctx context.Context, tx pgx.Tx, tenantID string) error {
+		return tx.QueryRow(ctx, postgresCreateDeliveryQuery,
+			tenantID, delivery.WebhookID, delivery.EventID, delivery.EventType, delivery.Payload, delivery.StatusCode, delivery.Attempts, delivery.Error).Scan(&id)
+	})
+	// The webhook may have been deleted while the event was delivered, or belong to another tenant
+	if isForeignKeyViolation(err) {
+		return 0, ErrWebhookNotFound
+	}
+	return id, err
+}
+
+// GetDelivery returns the delivery with the given id to the webhook with the given id
+func (r *PostgresWebhookRepository) GetDelivery(ctx context.Context, webhookID, id int) (*WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	var delivery *WebhookDelivery
+	err := runInTenantTx(ctx, r.db, func(ctx context.Context, tx pgx.Tx, tenantID string) error {
+		var err error
+		delivery, err = getRow[WebhookDelivery](ctx, tx, postgresGetDeliveryQuery, tenantID, webhookID, id)
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDeliveryNotFound
+	}
+	return delivery, err
+}
+
+// GetDeliveries returns up to query.Limit deliveries to the webhook with id query.WebhookID that come after the delivery with id query.AfterID, oldest first
+func (r *PostgresWebhookRepository) GetDeliveries(ctx context.Context, query *WebhookDeliveryPageQuery) ([]*WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	var deliveries []*WebhookDelivery
+	err := runInTenantTx(ctx, r.db, func(ctx context.Context, tx pgx.Tx, tenantID string) error {
+		var err error
+		deliveries, err = selectRows[WebhookDelivery](ctx, tx, postgresGetDeliveriesQuery, tenantID, query.WebhookID, query.AfterID, query.Limit)
+		return err
+	})
+	return deliveries, err
+}
+
+// selectWebhooks returns the webhooks of the tenant of the context selected by the query, the tenant is the first argument of the query
+func (r *PostgresWebhookRepository) selectWebhooks(ctx context.Context, query string, args ...any) ([]*Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	var rows []*webhookRow
+	err := runInTenantTx(ctx, r.db, func(ctx context.Context, tx pgx.Tx, tenantID string) error {
+		var err error
+		rows, err = selectRows[webhookRow](ctx, tx, query, append([]any{tenantID}, args...)...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	webhooks := make([]*Webhook, len(rows))
+	for i, row := range rows {
+		webhooks[i] = webhookFromRow(row)
+	}
+	return webhooks, nil
+}
+
+// webhookFromRow converts a row of config.webhooks to a Webhook
+func webhookFromRow(row *webhookRow) *Webhook {
+	return &Webhook{
+		ID:         row.ID,
+		URL:        row.URL,
+		Secret:     row.Secret,
+		EventTypes: row.EventTypes,
+		CreatedAt:  row.CreatedAt,
+	}
+}
+
+// webhookAffected returns ErrWebhookNotFound if the statement did not affect a webhook
+func webhookAffected(result pgconn.CommandTag) error {
+	if result.RowsAffected() == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
+	"github.com/tobiassundman/go-demo-app/pkg/test"
+)
+
+var (
+	WEBHOOK1 = repository.Webhook{
+		URL:        "https://partner1.example.com/hooks",
+		Secret:     "secret-of-partner-1",
+		EventTypes: []string{repository.OutboxEventUserCreated, repository.OutboxEventUserDeleted},
+	}
+	WEBHOOK2 = repository.Webhook{
+		URL:        "https://partner2.example.com/hooks",
+		Secret:     "secret-of-partner-2",
+		EventTypes: []string{repository.OutboxEventUserUpdated},
+	}
+)
+
+// newWebhookRepositoryFunc creates an empty webhook repository for a test.
+type newWebhookRepositoryFunc func(t *testing.T) repository.WebhookRepository
+
+// newDelivery creates a delivery of an event to the webhook.
+func newDelivery(webhookID int, eventID int64) *repository.WebhookDelivery {
+	return &repository.WebhookDelivery{
+		WebhookID:  webhookID,
+		EventID:    eventID,
+		EventType:  repository.OutboxEventUserCreated,
+		Payload:    `{"id": 1}`,
+		StatusCode: 500,
+		Attempts:   3,
+		Error:      "unexpected status 500",
+	}
+}
+
+// testWebhookRepository runs the conformance suite that every WebhookRepository implementation must pass.
+func testWebhookRepository(t *testing.T, newRepository newWebhookRepositoryFunc) {
+	t.Run("creates and gets webhooks", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		webhookRepository := newRepository(t)
+		firstID, err := webhookRepository.CreateWebhook(tenantContext(), &WEBHOOK1)
+		require.NoError(t, err)
+		secondID, err := webhookRepository.CreateWebhook(tenantContext(), &WEBHOOK2)
+		require.NoError(t, err)
+
+		// Act
+		webhook, err := webhookRepository.GetWebhook(tenantContext(), firstID)
+		require.NoError(t, err)
+		webhooks, err := webhookRepository.GetWebhooks(tenantContext())
+		require.NoError(t, err)
+		_, notFoundErr := webhookRepository.GetWebhook(tenantContext(), secondID+1)
+
+		// Assert
+		assert.Equal(t, firstID, webhook.ID)
+		assert.Equal(t, WEBHOOK1.URL, webhook.URL)
+		assert.Equal(t, WEBHOOK1.Secret, webhook.Secret)
+		assert.Equal(t, WEBHOOK1.EventTypes, webhook.EventTypes)
+		assert.WithinDuration(t, time.Now(), webhook.CreatedAt, time.Minute)
+		require.Len(t, webhooks, 2)
+		assert.Equal(t, firstID, webhooks[0].ID)
+		assert.Equal(t, secondID, webhooks[1].ID)
+		assert.ErrorIs(t, notFoundErr, repository.ErrWebhookNotFound)
+	})
+
+	t.Run("gets webhooks subscribed to event type", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		webhookRepository := newRepository(t)
+		firstID, err := webhookRepository.CreateWebhook(tenantContext(), &WEBHOOK1)
+		require.NoError(t, err)
+		_, err = webhookRepository.CreateWebhook(tenantContext(), &WEBHOOK2)
+		require.NoError(t, err)
+
+		// Act
+		webhooks, err := webhookRepository.GetWebhooksForEvent(tenantContext(), repository.OutboxEventUserDeleted)
+		require.NoError(t, err)
+		noWebhooks, err := webhookRepository.GetWebhooksForEvent(tenantContext(), repository.OutboxEventUserRestored)
+		require.NoError(t, err)
+
+		// Assert
+		require.Len(t, webhooks, 1)
+		assert.Equal(t, firstID, webhooks[0].ID)
+		assert.Empty(t, noWebhooks)
+	})
+
+	t.Run("updates webhook", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		webhookRepository := newRepository(t)
+		id, err := webhookRepository.CreateWebhook(tenantContext(), &WEBHOOK1)
+		require.NoError(t, err)
+
+		// Act
+		updated := WEBHOOK2
+		updated.ID = id
+		err = webhookRepository.UpdateWebhook(tenantContext(), &updated)
+		require.NoError(t, err)
+		missing := WEBHOOK2
+		missing.ID = id + 1
+		notFoundErr := webhookRepository.UpdateWebhook(tenantContext(), &missing)
+
+		// Assert
+		webhook, err := webhookRepository.GetWebhook(tenantContext(), id)
+		require.NoError(t, err)
+		assert.Equal(t, WEBHOOK2.URL, webhook.URL)
+		assert.Equal(t, WEBHOOK2.Secret, webhook.Secret)
+		assert.Equal(t, WEBHOOK2.EventTypes, webhook.EventTypes)
+		assert.ErrorIs(t, notFoundErr, repository.ErrWebhookNotFound)
+	})
+
+	t.Run("deletes webhook with its deliveries", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		webhookRepository := newRepository(t)
+		id, err := webhookRepository.CreateWebhook(tenantContext(), &WEBHOOK1)
+		require.NoError(t, err)
+		deliveryID, err := webhookRepository.CreateDelivery(tenantContext(), newDelivery(id, 1))
+		require.NoError(t, err)
+
+		// Act
+		err = webhookRepository.DeleteWebhook(tenantContext(), id)
+		require.NoError(t, err)
+		secondErr := webhookRepository.DeleteWebhook(tenantContext(), id)
+
+		// Assert
+		assert.ErrorIs(t, secondErr, repository.ErrWebhookNotFound)
+		_, err = webhookRepository.GetWebhook(tenantContext(), id)
+		assert.ErrorIs(t, err, repository.ErrWebhookNotFound)
+		_, err = webhookRepository.GetDelivery(tenantContext(), id, deliveryID)
+		assert.ErrorIs(t, err, repository.ErrDeliveryNotFound)
+	})
+
+	t.Run("records and pages deliveries", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		webhookRepository := newRepository(t)
+		firstWebhookID, err := webhookRepository.CreateWebhook(tenantContext(), &WEBHOOK1)
+		require.NoError(t, err)
+		secondWebhookID, err := webhookRepository.CreateWebhook(tenantContext(), &WEBHOOK2)
+		require.NoError(t, err)
+		ids := []int{}
+		for eventID := int64(1); eventID <= 3; eventID++ {
+			id, err := webhookRepository.CreateDelivery(tenantContext(), newDelivery(firstWebhookID, eventID))
+			require.NoError(t, err)
+			ids = append(ids, id)
+		}
+		otherID, err := webhookRepository.CreateDelivery(tenantContext(), newDelivery(secondWebhookID, 1))
+		require.NoError(t, err)
+
+		// Act
+		firstPage, err := webhookRepository.GetDeliveries(tenantContext(), &repository.WebhookDeliveryPageQuery{WebhookID: firstWebhookID, Limit: 2})
+		require.NoError(t, err)
+		secondPage, err := webhookRepository.GetDeliveries(tenantContext(), &repository.WebhookDeliveryPageQuery{WebhookID: firstWebhookID, AfterID: ids[1], Limit: 2})
+		require.NoError(t, err)
+		delivery, err := webhookRepository.GetDelivery(tenantContext(), firstWebhookID, ids[0])
+		require.NoError(t, err)
+		_, otherWebhookErr := webhookRepository.GetDelivery(tenantContext(), firstWebhookID, otherID)
+
+		// Assert
+		require.Len(t, firstPage, 2)
+		assert.Equal(t, ids[0], firstPage[0].ID)
+		assert.Equal(t, ids[1], firstPage[1].ID)
+		require.Len(t, secondPage, 1)
+		assert.Equal(t, ids[2], secondPage[0].ID)
+
+		expected := newDelivery(firstWebhookID, 1)
+		assert.Equal(t, expected.EventID, delivery.EventID)
+		assert.Equal(t, expected.EventType, delivery.EventType)
+		assert.JSONEq(t, expected.Payload, delivery.Payload)
+		assert.Equal(t, expected.StatusCode, delivery.StatusCode)
+		assert.Equal(t, expected.Attempts, delivery.Attempts)
+		assert.Equal(t, expected.Error, delivery.Error)
+		assert.WithinDuration(t, time.Now(), delivery.DeliveredAt, time.Minute)
+		assert.ErrorIs(t, otherWebhookErr, repository.ErrDeliveryNotFound)
+	})
+
+	t.Run("does not record delivery to unknown webhook", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		webhookRepository := newRepository(t)
+
+		// Act
+		_, err := webhookRepository.CreateDelivery(tenantContext(), newDelivery(1, 1))
+
+		// Assert
+		assert.ErrorIs(t, err, repository.ErrWebhookNotFound)
+	})
+
+	t.Run("hides webhooks of other tenants", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		webhookRepository := newRepository(t)
+		otherTenant := tenant.NewContext(context.Background(), TENANT2)
+		id, err := webhookRepository.CreateWebhook(tenantContext(), &WEBHOOK1)
+		require.NoError(t, err)
+		deliveryID, err := webhookRepository.CreateDelivery(tenantContext(), newDelivery(id, 1))
+		require.NoError(t, err)
+		updated := WEBHOOK2
+		updated.ID = id
+
+		// Act
+		webhooks, err := webhookRepository.GetWebhooks(otherTenant)
+		require.NoError(t, err)
+		subscribed, err := webhookRepository.GetWebhooksForEvent(otherTenant, repository.OutboxEventUserCreated)
+		require.NoError(t, err)
+		deliveries, err := webhookRepository.GetDeliveries(otherTenant, &repository.WebhookDeliveryPageQuery{WebhookID: id, Limit: 10})
+		require.NoError(t, err)
+		_, getErr := webhookRepository.GetWebhook(otherTenant, id)
+		updateErr := webhookRepository.UpdateWebhook(otherTenant, &updated)
+		deleteErr := webhookRepository.DeleteWebhook(otherTenant, id)
+		_, getDeliveryErr := webhookRepository.GetDelivery(otherTenant, id, deliveryID)
+		_, createDeliveryErr := webhookRepository.CreateDelivery(otherTenant, newDelivery(id, 2))
+		webhook, err := webhookRepository.GetWebhook(tenantContext(), id)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Empty(t, webhooks)
+		assert.Empty(t, subscribed)
+		assert.Empty(t, deliveries)
+		assert.ErrorIs(t, getErr, repository.ErrWebhookNotFound)
+		assert.ErrorIs(t, updateErr, repository.ErrWebhookNotFound)
+		assert.ErrorIs(t, deleteErr, repository.ErrWebhookNotFound)
+		assert.ErrorIs(t, getDeliveryErr, repository.ErrDeliveryNotFound)
+		assert.ErrorIs(t, createDeliveryErr, repository.ErrWebhookNotFound)
+		assert.Equal(t, WEBHOOK1.URL, webhook.URL)
+	})
+
+	t.Run("requires tenant", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		webhookRepository := newRepository(t)
+
+		// Act
+		_, createErr := webhookRepository.CreateWebhook(context.Background(), &WEBHOOK1)
+		_, getErr := webhookRepository.GetWebhooks(context.Background())
+
+		// Assert
+		assert.ErrorIs(t, createErr, repository.ErrTenantRequired)
+		assert.ErrorIs(t, getErr, repository.ErrTenantRequired)
+	})
+}
+
+func TestPostgresWebhookRepository(t *testing.T) {
+	t.Parallel()
+	testWebhookRepository(t, func(t *testing.T) repository.WebhookRepository {
+		db := test.StartDatabase(t)
+		t.Cleanup(func() { db.Close() })
+		return repository.NewPostgresWebhookRepository(db, time.Second*2)
+	})
+}
+
+func TestInMemoryWebhookRepository(t *testing.T) {
+	t.Parallel()
+	testWebhookRepository(t, func(t *testing.T) repository.WebhookRepository {
+		return repository.NewInMemoryWebhookRepository()
+	})
+}
@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tobiassundman/go-demo-app/pkg/cache"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
+	"golang.org/x/sync/singleflight"
+)
+
+// CachingUserServiceConfig configures a caching UserService.
+type CachingUserServiceConfig struct {
+	// Size is the maximum number of cached users.
+	Size int
+	// TTL is how long a cached user is served before it is read again.
+	TTL time.Duration
+	// ServeStale serves users whose TTL has passed when they cannot be read again, for example while the database is unavailable.
+	ServeStale bool
+	// LoadTimeout bounds reading a user that is not cached, 0 leaves it unbounded.
+	// The read is shared by concurrent gets of the user, so it is not cancelled with the get that started it.
+	LoadTimeout time.Duration
+	// ReadsFromPrimary returns true if the reads of a context go to the primary so that its session reads its own writes.
+	// The cache is bypassed for them, since another session may have cached the user from a lagging replica after it was changed.
+	// Nil never bypasses the cache.
+	ReadsFromPrimary func(ctx context.Context) bool
+	// Registerer registers the cache metrics, nil leaves them unregistered.
+	Registerer prometheus.Registerer
+}
+
+// userCacheKey identifies a cached user, users are cached per tenant so that a tenant is never served a user of another tenant.
+type userCacheKey struct {
+	tenantID string
+	id       int
+}
+
+// String returns the key of the loads of the user.
+func (k userCacheKey) String() string {
+	return k.tenantID + "/" + strconv.Itoa(k.id)
+}
+
+// cachingUserService caches the users got by id, every other method is passed on to the wrapped service.
+type cachingUserService struct {
+	UserService
+	users            *cache.LRU[userCacheKey, *User]
+	serveStale       bool
+	loadTimeout      time.Duration
+	readsFromPrimary func(ctx context.Context) bool
+	loads            singleflight.Group
+	// generation is incremented whenever a user is invalidated, so that a load that started before cannot cache what it read
+	generation atomic.Uint64
+
+	hits      prometheus.Counter
+	misses    prometheus.Counter
+	evictions prometheus.Counter
+	stale     prometheus.Counter
+}
+
+// NewCachingUserService creates a UserService that caches the users got by id from the given service.
+// A user is invalidated whenever it is updated, patched, deleted or restored through the returned service.
+func NewCachingUserService(next UserService, config CachingUserServiceConfig) UserService {
+	factory := promauto.With(config.Registerer)
+	return &cachingUserService{
+		UserService:      next,
+		users:            cache.NewLRU[userCacheKey, *User](config.Size, config.TTL),
+		serveStale:       config.ServeStale,
+		loadTimeout:      config.LoadTimeout,
+		readsFromPrimary: config.ReadsFromPrimary,
+		hits: factory.NewCounter(prometheus.CounterOpts{
+			Name: "user_cache_hits_total",
+			Help: "Number of users got by id that were served from the cache.",
+		}),
+		misses: factory.NewCounter(prometheus.CounterOpts{
+			Name: "user_cache_misses_total",
+			Help: "Number of users got by id that were not cached or whose TTL had passed.",
+		}),
+		evictions: factory.NewCounter(prometheus.CounterOpts{
+			Name: "user_cache_evictions_total",
+			Help: "Number of cached users evicted to make room for other users.",
+		}),
+		stale: factory.NewCounter(prometheus.CounterOpts{
+			Name: "user_cache_stale_served_total",
+			Help: "Number of users served from the cache after their TTL had passed because they could not be read again.",
+		}),
+	}
+}
+
+// Get gets a user by id, from the cache if it was cached for the tenant of the context within the TTL.
+// Concurrent gets of a user that is not cached read it only once, each get stops waiting for the read when its context is done.
+func (s *cachingUserService) Get(ctx context.Context, id int) (*User, error) {
+	if s.readsFromPrimary != nil && s.readsFromPrimary(ctx) {
+		return s.UserService.Get(ctx, id)
+	}
+	key := newUserCacheKey(ctx, id)
+	cached, fresh, ok := s.users.Get(key)
+	if fresh {
+		s.hits.Inc()
+		return copyUser(cached), nil
+	}
+	s.misses.Inc()
+
+	loads := s.loads.DoChan(key.String(), func() (any, error) {
+		loadContext := detach(ctx)
+		if s.loadTimeout > 0 {
+			var cancel context.CancelFunc
+			loadContext, cancel = context.WithTimeout(loadContext, s.loadTimeout)
+			defer cancel()
+		}
+		generation := s.generation.Load()
+		user, err := s.UserService.Get(loadContext, id)
+		if err != nil {
+			return nil, err
+		}
+		if s.generation.Load() == generation && s.users.Add(key, user) {
+			s.evictions.Inc()
+		}
+		return user, nil
+	})
+	var loaded any
+	var err error
+	select {
+	case result := <-loads:
+		loaded, err = result.Val, result.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		if ok && s.serveStale && !errors.Is(err, ErrUserNotFound) {
+			s.stale.Inc()
+			return copyUser(cached), nil
+		}
+		return nil, err
+	}
+	return copyUser(loaded.(*User)), nil
+}
+
+// Update updates a user if its current version matches.
+func (s *cachingUserService) Update(ctx context.Context, user *User, match VersionMatch) error {
+	defer s.invalidate(ctx, user.ID)
+	return s.UserService.Update(ctx, user, match)
+}
+
+// Patch changes the fields present in the patch if the current version of the user matches, returning the patched user.
+func (s *cachingUserService) Patch(ctx context.Context, patch *UserPatch, match VersionMatch) (*User, error) {
+	defer s.invalidate(ctx, patch.ID)
+	return s.UserService.Patch(ctx, patch, match)
+}
+
+// PatchCurrent changes the fields present in the patch that fn makes from the current user if its version matches, returning the patched user.
+// The current user is read by the wrapped service, never from the cache.
+func (s *cachingUserService) PatchCurrent(ctx context.Context, id int, match VersionMatch, fn func(current *User) (*UserPatch, error)) (*User, error) {
+	defer s.invalidate(ctx, id)
+	return s.UserService.PatchCurrent(ctx, id, match, fn)
+}
+
+// Delete deletes a user if its current version matches, it can be restored until it is purged.
+func (s *cachingUserService) Delete(ctx context.Context, id int, match VersionMatch) error {
+	defer s.invalidate(ctx, id)
+	return s.UserService.Delete(ctx, id, match)
+}
+
+// Restore restores a deleted user.
+func (s *cachingUserService) Restore(ctx context.Context, id int) error {
+	defer s.invalidate(ctx, id)
+	return s.UserService.Restore(ctx, id)
+}
+
+// invalidate removes a user of the tenant of the context from the cache and keeps loads that are in flight from caching it.
+// It is called whether or not the change succeeded, since a failed change may still have been applied.
+func (s *cachingUserService) invalidate(ctx context.Context, id int) {
+	key := newUserCacheKey(ctx, id)
+	s.generation.Add(1)
+	s.loads.Forget(key.String())
+	s.users.Remove(key)
+}
+
+// detachedContext carries the values of its parent, such as the tenant, without being cancelled with it.
+// It stands in for context.WithoutCancel, which needs a newer Go version than the module requires.
+type detachedContext struct {
+	parent context.Context
+}
+
+// detach returns a context with the values of the given context that is never cancelled and has no deadline.
+func detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key any) any {
+	return c.parent.Value(key)
+}
+
+// newUserCacheKey returns the key of the user with the given id of the tenant of the context.
+func newUserCacheKey(ctx context.Context, id int) userCacheKey {
+	tenantID, _ := tenant.FromContext(ctx)
+	return userCacheKey{tenantID: tenantID, id: id}
+}
+
+// copyUser copies a cached user, so that callers cannot change the cached user
+func copyUser(user *User) *User {
+	copied := *user
+	return &copied
+}
@@ -0,0 +1,431 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/internal/app/service"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
+)
+
+// newCachingUserService creates a caching service over a service using the given repository, with its metrics registered in the returned registry.
+func newCachingUserService(userRepository repository.UserRepository, config service.CachingUserServiceConfig) (service.UserService, *prometheus.Registry) {
+	registry := prometheus.NewRegistry()
+	config.Registerer = registry
+	return service.NewCachingUserService(service.NewUserService(userRepository, &txManagerMock{}), config), registry
+}
+
+// primaryContextKey marks contexts whose reads go to the primary in tests of the caching service.
+type primaryContextKey struct{}
+
+// counterValue returns the value of the counter with the given name in the registry.
+func counterValue(t *testing.T, registry *prometheus.Registry, name string) float64 {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	t.Fatalf("counter %s not registered", name)
+	return 0
+}
+
+func TestCachingGet(t *testing.T) {
+	t.Parallel()
+	t.Run("should serve cached user until the TTL passes", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		reads := 0
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				reads++
+				return &USER1_REPOSITORY, nil
+			},
+		}
+		userService, registry := newCachingUserService(userRepositoryMock, service.CachingUserServiceConfig{Size: 10, TTL: 50 * time.Millisecond})
+
+		// Act
+		first, err := userService.Get(context.Background(), 1)
+		require.NoError(t, err)
+		second, err := userService.Get(context.Background(), 1)
+		require.NoError(t, err)
+		readsWithinTTL := reads
+		time.Sleep(100 * time.Millisecond)
+		_, err = userService.Get(context.Background(), 1)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, &USER1_SERVICE, first)
+		assert.Equal(t, &USER1_SERVICE, second)
+		assert.Equal(t, 1, readsWithinTTL)
+		assert.Equal(t, 2, reads)
+		assert.Equal(t, float64(1), counterValue(t, registry, "user_cache_hits_total"))
+		assert.Equal(t, float64(2), counterValue(t, registry, "user_cache_misses_total"))
+	})
+
+	t.Run("should not let callers change the cached user", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				user := USER1_REPOSITORY
+				return &user, nil
+			},
+		}
+		userService, _ := newCachingUserService(userRepositoryMock, service.CachingUserServiceConfig{Size: 10, TTL: time.Minute})
+
+		// Act
+		first, err := userService.Get(context.Background(), 1)
+		require.NoError(t, err)
+		first.Name = "Changed"
+		second, err := userService.Get(context.Background(), 1)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, &USER1_SERVICE, second)
+	})
+
+	t.Run("should evict least recently used user", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		reads := map[int]int{}
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				reads[id]++
+				return &repository.User{ID: id}, nil
+			},
+		}
+		userService, registry := newCachingUserService(userRepositoryMock, service.CachingUserServiceConfig{Size: 2, TTL: time.Minute})
+
+		// Act
+		for _, id := range []int{1, 2, 1, 3, 1, 2} {
+			_, err := userService.Get(context.Background(), id)
+			require.NoError(t, err)
+		}
+
+		// Assert
+		assert.Equal(t, map[int]int{1: 1, 2: 2, 3: 1}, reads)
+		assert.Equal(t, float64(2), counterValue(t, registry, "user_cache_evictions_total"))
+	})
+
+	t.Run("should read concurrently missed user once", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		var reads atomic.Int32
+		release := make(chan struct{})
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				reads.Add(1)
+				<-release
+				return &USER1_REPOSITORY, nil
+			},
+		}
+		userService, _ := newCachingUserService(userRepositoryMock, service.CachingUserServiceConfig{Size: 10, TTL: time.Minute})
+
+		// Act
+		waitGroup := sync.WaitGroup{}
+		users := make([]*service.User, 10)
+		for i := range users {
+			waitGroup.Add(1)
+			go func(i int) {
+				defer waitGroup.Done()
+				users[i], _ = userService.Get(context.Background(), 1)
+			}(i)
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		waitGroup.Wait()
+
+		// Assert
+		assert.Equal(t, int32(1), reads.Load())
+		for _, user := range users {
+			assert.Equal(t, &USER1_SERVICE, user)
+		}
+	})
+
+	t.Run("should finish read shared with get whose context is cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		started := make(chan struct{})
+		release := make(chan struct{})
+		var readErr error
+		var readTenant string
+		var readHasDeadline bool
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				close(started)
+				<-release
+				readErr = ctx.Err()
+				readTenant, _ = tenant.FromContext(ctx)
+				_, readHasDeadline = ctx.Deadline()
+				return &USER1_REPOSITORY, nil
+			},
+		}
+		userService, _ := newCachingUserService(userRepositoryMock, service.CachingUserServiceConfig{Size: 10, TTL: time.Minute, LoadTimeout: time.Minute})
+		firstContext, cancelFirst := context.WithCancel(tenant.NewContext(context.Background(), "tenant1"))
+
+		// Act
+		firstErr := make(chan error)
+		go func() {
+			_, err := userService.Get(firstContext, 1)
+			firstErr <- err
+		}()
+		<-started
+		secondUser := make(chan *service.User)
+		go func() {
+			user, _ := userService.Get(tenant.NewContext(context.Background(), "tenant1"), 1)
+			secondUser <- user
+		}()
+		cancelFirst()
+		cancelledErr := <-firstErr
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		user := <-secondUser
+
+		// Assert
+		assert.Equal(t, context.Canceled, cancelledErr)
+		assert.Equal(t, &USER1_SERVICE, user)
+		assert.NoError(t, readErr)
+		assert.Equal(t, "tenant1", readTenant)
+		assert.True(t, readHasDeadline)
+	})
+
+	t.Run("should bypass cache for reads from primary", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		reads := 0
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				reads++
+				return &USER1_REPOSITORY, nil
+			},
+		}
+		userService, registry := newCachingUserService(userRepositoryMock, service.CachingUserServiceConfig{
+			Size: 10,
+			TTL:  time.Minute,
+			ReadsFromPrimary: func(ctx context.Context) bool {
+				return ctx.Value(primaryContextKey{}) != nil
+			},
+		})
+		primaryContext := context.WithValue(context.Background(), primaryContextKey{}, true)
+		_, err := userService.Get(context.Background(), 1)
+		require.NoError(t, err)
+
+		// Act
+		first, err := userService.Get(primaryContext, 1)
+		require.NoError(t, err)
+		_, err = userService.Get(primaryContext, 1)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, &USER1_SERVICE, first)
+		assert.Equal(t, 3, reads)
+		assert.Equal(t, float64(0), counterValue(t, registry, "user_cache_hits_total"))
+	})
+
+	t.Run("should not cache ErrUserNotFound", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		reads := 0
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				reads++
+				return nil, repository.ErrUserNotFound
+			},
+		}
+		userService, _ := newCachingUserService(userRepositoryMock, service.CachingUserServiceConfig{Size: 10, TTL: time.Minute})
+
+		// Act
+		_, firstErr := userService.Get(context.Background(), 1)
+		_, secondErr := userService.Get(context.Background(), 1)
+
+		// Assert
+		assert.Equal(t, service.ErrUserNotFound, firstErr)
+		assert.Equal(t, service.ErrUserNotFound, secondErr)
+		assert.Equal(t, 2, reads)
+	})
+
+	t.Run("should cache users per tenant", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				if tenantID, _ := tenant.FromContext(ctx); tenantID != "tenant1" {
+					return nil, repository.ErrUserNotFound
+				}
+				return &USER1_REPOSITORY, nil
+			},
+		}
+		userService, _ := newCachingUserService(userRepositoryMock, service.CachingUserServiceConfig{Size: 10, TTL: time.Minute})
+		_, err := userService.Get(tenant.NewContext(context.Background(), "tenant1"), 1)
+		require.NoError(t, err)
+
+		// Act
+		_, err = userService.Get(tenant.NewContext(context.Background(), "tenant2"), 1)
+
+		// Assert
+		assert.Equal(t, service.ErrUserNotFound, err)
+	})
+
+	t.Run("should serve stale user while it cannot be read if enabled", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		errDatabase := errors.New("database unavailable")
+		var readErr error
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				if readErr != nil {
+					return nil, readErr
+				}
+				return &USER1_REPOSITORY, nil
+			},
+		}
+		staleService, registry := newCachingUserService(userRepositoryMock, service.CachingUserServiceConfig{Size: 10, TTL: time.Millisecond, ServeStale: true})
+		strictService, _ := newCachingUserService(userRepositoryMock, service.CachingUserServiceConfig{Size: 10, TTL: time.Millisecond})
+		_, err := staleService.Get(context.Background(), 1)
+		require.NoError(t, err)
+		_, err = strictService.Get(context.Background(), 1)
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+
+		// Act
+		readErr = errDatabase
+		staleUser, staleErr := staleService.Get(context.Background(), 1)
+		strictUser, strictErr := strictService.Get(context.Background(), 1)
+		readErr = repository.ErrUserNotFound
+		_, notFoundErr := staleService.Get(context.Background(), 1)
+
+		// Assert
+		assert.NoError(t, staleErr)
+		assert.Equal(t, &USER1_SERVICE, staleUser)
+		assert.Nil(t, strictUser)
+		assert.Equal(t, errDatabase, strictErr)
+		assert.Equal(t, service.ErrUserNotFound, notFoundErr)
+		assert.Equal(t, float64(1), counterValue(t, registry, "user_cache_stale_served_total"))
+	})
+}
+
+func TestCachingInvalidation(t *testing.T) {
+	t.Parallel()
+	changes := map[string]func(userService service.UserService) error{
+		"update": func(userService service.UserService) error {
+			return userService.Update(context.Background(), &USER1_SERVICE, service.MatchVersion(1))
+		},
+		"patch": func(userService service.UserService) error {
+			_, err := userService.Patch(context.Background(), &service.UserPatch{ID: 1}, service.MatchVersion(1))
+			return err
+		},
+		"patch current": func(userService service.UserService) error {
+			_, err := userService.PatchCurrent(context.Background(), 1, service.MatchVersion(1), func(current *service.User) (*service.UserPatch, error) {
+				return &service.UserPatch{ID: 1}, nil
+			})
+			return err
+		},
+		"delete": func(userService service.UserService) error {
+			return userService.Delete(context.Background(), 1, service.MatchVersion(1))
+		},
+		"restore": func(userService service.UserService) error {
+			return userService.Restore(context.Background(), 1)
+		},
+	}
+	for name, change := range changes {
+		change := change
+		for _, changeErr := range []error{nil, repository.ErrVersionConflict} {
+			changeErr := changeErr
+			t.Run(name+" should invalidate user whether or not it succeeds", func(t *testing.T) {
+				t.Parallel()
+
+				// Arrange
+				reads := 0
+				userRepositoryMock := &userRepositoryMock{
+					GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+						// PatchCurrent reads the user it patches within its transaction, only reads of Get are counted
+						if !withinTx(ctx) {
+							reads++
+						}
+						return &USER1_REPOSITORY, nil
+					},
+					UpdateFunc: func(ctx context.Context, user *repository.User, match repository.VersionMatch) error {
+						return changeErr
+					},
+					PatchFunc: func(ctx context.Context, patch *repository.UserPatch, match repository.VersionMatch) (*repository.User, error) {
+						return &USER1_REPOSITORY, changeErr
+					},
+					DeleteFunc: func(ctx context.Context, id int, match repository.VersionMatch) error {
+						return changeErr
+					},
+					RestoreFunc: func(ctx context.Context, id int) error {
+						return changeErr
+					},
+				}
+				userService, _ := newCachingUserService(userRepositoryMock, service.CachingUserServiceConfig{Size: 10, TTL: time.Minute})
+				_, err := userService.Get(context.Background(), 1)
+				require.NoError(t, err)
+
+				// Act
+				_ = change(userService)
+				_, err = userService.Get(context.Background(), 1)
+				require.NoError(t, err)
+
+				// Assert
+				assert.Equal(t, 2, reads)
+			})
+		}
+	}
+
+	t.Run("should not cache user read before it was changed", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		reads := 0
+		release := make(chan struct{})
+		loaded := make(chan struct{})
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				reads++
+				if reads == 1 {
+					<-release
+				}
+				return &USER1_REPOSITORY, nil
+			},
+			UpdateFunc: func(ctx context.Context, user *repository.User, match repository.VersionMatch) error {
+				return nil
+			},
+		}
+		userService, _ := newCachingUserService(userRepositoryMock, service.CachingUserServiceConfig{Size: 10, TTL: time.Minute})
+		go func() {
+			defer close(loaded)
+			_, _ = userService.Get(context.Background(), 1)
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		// Act
+		err := userService.Update(context.Background(), &USER1_SERVICE, service.MatchVersion(1))
+		require.NoError(t, err)
+		close(release)
+		<-loaded
+		_, err = userService.Get(context.Background(), 1)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, 2, reads)
+	})
+}
@@ -1,8 +1,84 @@
 package service
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+)
 
 var (
 	ErrUserNotFound      = errors.New("user not found")
 	ErrUserAlreadyExists = errors.New("user already exists")
+	ErrInvalidPageSize   = errors.New("invalid page size")
+	ErrVersionConflict   = errors.New("version conflict")
+	ErrBatchAborted      = errors.New("batch aborted")
+	ErrWebhookNotFound   = errors.New("webhook not found")
+	ErrDeliveryNotFound  = errors.New("webhook delivery not found")
+	// ErrIdempotencyKeyInUse is returned while another request with the same idempotency key is in flight
+	ErrIdempotencyKeyInUse = errors.New("idempotency key in use")
+	// ErrIdempotencyKeyReused is returned when an idempotency key is used again for a different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused")
+	// ErrUnavailable is matched by the errors of requests that fail fast because the database is unavailable
+	ErrUnavailable = errors.New("service unavailable")
 )
+
+// FieldError is returned when the value of a specific field is invalid.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("invalid value: %s", e.Message)
+	}
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Message)
+}
+
+// UnavailableError is returned when a request fails fast because the database is unavailable.
+type UnavailableError struct {
+	// RetryAfter is how long until the request can be retried.
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrUnavailable, e.RetryAfter)
+}
+
+// Is makes an UnavailableError match ErrUnavailable.
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// unavailableServiceError converts a call that failed fast because the database is unavailable to an *UnavailableError,
+// other errors are returned as they are.
+func unavailableServiceError(err error) error {
+	var unavailableError *repository.UnavailableError
+	if errors.As(err, &unavailableError) {
+		return &UnavailableError{RetryAfter: unavailableError.RetryAfter}
+	}
+	return err
+}
+
+// constraintServiceError converts a value rejected by the database to a *FieldError of its column, other errors are converted by unavailableServiceError.
+// The field is empty if the database does not report the column.
+func constraintServiceError(err error) error {
+	var constraintError *repository.ConstraintError
+	if !errors.As(err, &constraintError) {
+		return unavailableServiceError(err)
+	}
+	var message string
+	switch constraintError.Kind {
+	case repository.ErrNotNullViolation:
+		message = "must not be null"
+	case repository.ErrValueTooLong:
+		message = "is too long"
+	case repository.ErrValueOutOfRange:
+		message = "is out of range"
+	default:
+		message = "is not allowed"
+	}
+	return &FieldError{Field: constraintError.Column, Message: message}
+}
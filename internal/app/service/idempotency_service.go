@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+)
+
+// maxIdempotencyKeyLength is the length of the longest idempotency key that can be stored.
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyServiceConfig configures an IdempotencyService.
+type IdempotencyServiceConfig struct {
+	// TTL is how long the response of a request is replayed for retries of the request.
+	TTL time.Duration
+	// LockTimeout is how long a key stays locked by a request in flight, a request that takes longer can be retried.
+	LockTimeout time.Duration
+}
+
+// IdempotencyService makes retries of a request with the same idempotency key get the response of the first attempt.
+type IdempotencyService interface {
+	// Begin begins a request with an idempotency key and the fingerprint of the request.
+	// It returns nil if the request should be handled and its response completed, and the stored response if the request was already handled.
+	// ErrIdempotencyKeyInUse is returned while another attempt of the request is in flight and ErrIdempotencyKeyReused if the key was used for another request.
+	Begin(ctx context.Context, key, fingerprint string) (*IdempotentResponse, error)
+	// Complete stores the response of a request begun with the key, so that it is replayed for retries.
+	Complete(ctx context.Context, key, fingerprint string, response *IdempotentResponse) error
+	// Abort ends a request begun with the key without storing its response, so that it can be retried.
+	Abort(ctx context.Context, key, fingerprint string) error
+	// PurgeExpired deletes the keys whose TTL or lock timeout has passed, returning how many were purged.
+	PurgeExpired(ctx context.Context) (int64, error)
+}
+
+type idempotencyService struct {
+	idempotencyRepository repository.IdempotencyRepository
+	ttl                   time.Duration
+	lockTimeout           time.Duration
+}
+
+func NewIdempotencyService(repository repository.IdempotencyRepository, config IdempotencyServiceConfig) IdempotencyService {
+	return &idempotencyService{
+		idempotencyRepository: repository,
+		ttl:                   config.TTL,
+		lockTimeout:           config.LockTimeout,
+	}
+}
+
+// Begin begins a request with an idempotency key and the fingerprint of the request.
+func (s *idempotencyService) Begin(ctx context.Context, key, fingerprint string) (*IdempotentResponse, error) {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return nil, &FieldError{Field: "Idempotency-Key", Message: "must be between 1 and 255 characters"}
+	}
+
+	stored, err := s.idempotencyRepository.Lock(ctx, key, fingerprint, s.lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case stored == nil:
+		return nil, nil
+	case stored.Fingerprint != fingerprint:
+		return nil, ErrIdempotencyKeyReused
+	case stored.StatusCode == 0:
+		return nil, ErrIdempotencyKeyInUse
+	}
+	return repositoryIdempotencyKeyToServiceResponse(stored), nil
+}
+
+// Complete stores the response of a request begun with the key, so that it is replayed for retries.
+func (s *idempotencyService) Complete(ctx context.Context, key, fingerprint string, response *IdempotentResponse) error {
+	err := s.idempotencyRepository.Complete(ctx, serviceResponseToRepositoryIdempotencyKey(key, fingerprint, response), s.ttl)
+	// The lock timed out and the key was taken by a retry, which stores its own response
+	if errors.Is(err, repository.ErrIdempotencyKeyNotLocked) {
+		return ErrIdempotencyKeyInUse
+	}
+	return err
+}
+
+// Abort ends a request begun with the key without storing its response, so that it can be retried.
+func (s *idempotencyService) Abort(ctx context.Context, key, fingerprint string) error {
+	return s.idempotencyRepository.Unlock(ctx, key, fingerprint)
+}
+
+// PurgeExpired deletes the keys whose TTL or lock timeout has passed, returning how many were purged.
+func (s *idempotencyService) PurgeExpired(ctx context.Context) (int64, error) {
+	return s.idempotencyRepository.DeleteExpired(ctx)
+}
@@ -0,0 +1,185 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/internal/app/service"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
+)
+
+const (
+	requestFingerprint      = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	otherRequestFingerprint = "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752"
+)
+
+var CREATED_RESPONSE = service.IdempotentResponse{
+	StatusCode:  201,
+	ContentType: "application/json; charset=utf-8",
+	Body:        []byte(`{"id":1}`),
+}
+
+// tenantContext returns a context carrying the tenant of the tests.
+func tenantContext() context.Context {
+	return tenant.NewContext(context.Background(), "tenant1")
+}
+
+// newIdempotencyService creates an IdempotencyService over an empty in-memory repository.
+func newIdempotencyService(lockTimeout time.Duration) service.IdempotencyService {
+	return service.NewIdempotencyService(repository.NewInMemoryIdempotencyRepository(), service.IdempotencyServiceConfig{
+		TTL:         time.Hour,
+		LockTimeout: lockTimeout,
+	})
+}
+
+func TestBeginIdempotentRequest(t *testing.T) {
+	t.Parallel()
+
+	t.Run("begins request with unused key", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyService := newIdempotencyService(time.Minute)
+
+		// Act
+		response, err := idempotencyService.Begin(tenantContext(), "key-1", requestFingerprint)
+
+		// Assert
+		assert.NoError(t, err)
+		assert.Nil(t, response)
+	})
+
+	t.Run("returns stored response of completed request", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyService := newIdempotencyService(time.Minute)
+		_, err := idempotencyService.Begin(tenantContext(), "key-1", requestFingerprint)
+		require.NoError(t, err)
+		err = idempotencyService.Complete(tenantContext(), "key-1", requestFingerprint, &CREATED_RESPONSE)
+		require.NoError(t, err)
+
+		// Act
+		response, err := idempotencyService.Begin(tenantContext(), "key-1", requestFingerprint)
+
+		// Assert
+		assert.NoError(t, err)
+		assert.Equal(t, &CREATED_RESPONSE, response)
+	})
+
+	t.Run("returns error while request is in flight", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyService := newIdempotencyService(time.Minute)
+		_, err := idempotencyService.Begin(tenantContext(), "key-1", requestFingerprint)
+		require.NoError(t, err)
+
+		// Act
+		_, err = idempotencyService.Begin(tenantContext(), "key-1", requestFingerprint)
+
+		// Assert
+		assert.ErrorIs(t, err, service.ErrIdempotencyKeyInUse)
+	})
+
+	t.Run("returns error when key is reused for other request", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyService := newIdempotencyService(time.Minute)
+		_, err := idempotencyService.Begin(tenantContext(), "key-1", requestFingerprint)
+		require.NoError(t, err)
+		err = idempotencyService.Complete(tenantContext(), "key-1", requestFingerprint, &CREATED_RESPONSE)
+		require.NoError(t, err)
+
+		// Act
+		_, completedErr := idempotencyService.Begin(tenantContext(), "key-1", otherRequestFingerprint)
+		_, err = idempotencyService.Begin(tenantContext(), "key-2", requestFingerprint)
+		require.NoError(t, err)
+		_, inFlightErr := idempotencyService.Begin(tenantContext(), "key-2", otherRequestFingerprint)
+
+		// Assert
+		assert.ErrorIs(t, completedErr, service.ErrIdempotencyKeyReused)
+		assert.ErrorIs(t, inFlightErr, service.ErrIdempotencyKeyReused)
+	})
+
+	t.Run("begins aborted request again", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyService := newIdempotencyService(time.Minute)
+		_, err := idempotencyService.Begin(tenantContext(), "key-1", requestFingerprint)
+		require.NoError(t, err)
+		err = idempotencyService.Abort(tenantContext(), "key-1", requestFingerprint)
+		require.NoError(t, err)
+
+		// Act
+		response, err := idempotencyService.Begin(tenantContext(), "key-1", requestFingerprint)
+
+		// Assert
+		assert.NoError(t, err)
+		assert.Nil(t, response)
+	})
+
+	t.Run("returns field error for invalid key", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyService := newIdempotencyService(time.Minute)
+		tooLong := make([]byte, 256)
+		for i := range tooLong {
+			tooLong[i] = 'k'
+		}
+
+		// Act
+		_, emptyErr := idempotencyService.Begin(tenantContext(), "", requestFingerprint)
+		_, tooLongErr := idempotencyService.Begin(tenantContext(), string(tooLong), requestFingerprint)
+
+		// Assert
+		fieldErr := &service.FieldError{}
+		require.ErrorAs(t, emptyErr, &fieldErr)
+		assert.Equal(t, "Idempotency-Key", fieldErr.Field)
+		assert.ErrorAs(t, tooLongErr, &fieldErr)
+	})
+}
+
+func TestCompleteIdempotentRequest(t *testing.T) {
+	t.Parallel()
+
+	t.Run("returns error when lock timed out and key was taken by retry", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyService := newIdempotencyService(-time.Second)
+		_, err := idempotencyService.Begin(tenantContext(), "key-1", requestFingerprint)
+		require.NoError(t, err)
+		_, err = idempotencyService.Begin(tenantContext(), "key-1", otherRequestFingerprint)
+		require.NoError(t, err)
+
+		// Act
+		err = idempotencyService.Complete(tenantContext(), "key-1", requestFingerprint, &CREATED_RESPONSE)
+
+		// Assert
+		assert.ErrorIs(t, err, service.ErrIdempotencyKeyInUse)
+	})
+
+	t.Run("purges expired keys", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		idempotencyService := newIdempotencyService(-time.Second)
+		_, err := idempotencyService.Begin(tenantContext(), "key-1", requestFingerprint)
+		require.NoError(t, err)
+
+		// Act
+		purged, err := idempotencyService.PurgeExpired(context.Background())
+
+		// Assert
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+	})
+}
@@ -1,6 +1,10 @@
 package service
 
-import "github.com/tobiassundman/go-demo-app/internal/app/repository"
+import (
+	"time"
+
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+)
 
 // User is the user model for the service layer.
 type User struct {
@@ -8,24 +12,355 @@ type User struct {
 	Name  string
 	Email string
 	Age   int
+	// Version is incremented on every change, updates must provide the version they are based on.
+	Version int
+	// CreatedAt is when the user was created and UpdatedAt when it was last changed, both are set by the repository.
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// UserPatch changes some of the fields of a user, a nil field is absent from the patch and left unchanged.
+type UserPatch struct {
+	ID    int
+	Name  *string
+	Email *string
+	Age   *int
+}
+
+// VersionMatch is the condition the current version of a user must meet for a change to be made.
+type VersionMatch struct {
+	// Any matches every version.
+	Any bool
+	// Versions are the versions that match.
+	Versions []int
+}
+
+// MatchVersion returns a VersionMatch that matches only the given version.
+func MatchVersion(version int) VersionMatch {
+	return VersionMatch{Versions: []int{version}}
+}
+
+// Matches returns true if the given current version of a user meets the condition.
+func (m VersionMatch) Matches(version int) bool {
+	return serviceVersionMatchToRepositoryVersionMatch(m).Matches(version)
+}
+
+// BatchCreateResult is the result of creating one user of a batch.
+type BatchCreateResult struct {
+	// User is the created user, or nil if it was not created.
+	User *User
+	// Err is why the user was not created.
+	Err error
+}
+
+// UserSearchResult is a user matching a search together with its relevance.
+type UserSearchResult struct {
+	User *User
+	// Score is the relevance of the user from 0 to 1, higher is more relevant.
+	Score float64
+}
+
+// UserPageQuery describes which page of users to get.
+type UserPageQuery struct {
+	// After is the cursor of the last user of the previous page, or nil for the first page.
+	After *UserCursor
+	// Limit is the maximum number of users in the page.
+	Limit int
+	// Filter restricts which users are in the page, nil includes all users.
+	Filter *UserFilter
+	// Sort is the order of the users, ties are ordered by id.
+	Sort []UserSort
+}
+
+// UserCursor is the position of a user in a sort order, the values the user had when the page was read of the fields users are sorted by.
+type UserCursor struct {
+	ID    int
+	Name  string
+	Email string
+	Age   int
+}
+
+// UserFilter restricts which users are returned.
+type UserFilter struct {
+	// EmailDomain matches users whose email domain equals the given domain, ignoring case.
+	EmailDomain string
+	// NamePrefix matches users whose name starts with the given prefix, ignoring case.
+	NamePrefix string
+	// MinAge matches users at least the given age.
+	MinAge *int
+	// MaxAge matches users at most the given age.
+	MaxAge *int
+	// UpdatedSince matches users last changed at or after the given time.
+	UpdatedSince *time.Time
+}
+
+// UserSortField is a field users can be sorted by.
+type UserSortField string
+
+const (
+	UserSortFieldID    UserSortField = "id"
+	UserSortFieldName  UserSortField = "name"
+	UserSortFieldEmail UserSortField = "email"
+	UserSortFieldAge   UserSortField = "age"
+)
+
+// UserSort orders users by a field.
+type UserSort struct {
+	Field      UserSortField
+	Descending bool
+}
+
+// UserPage is a page of users.
+type UserPage struct {
+	Users []*User
+	// Next is the cursor to continue from when getting the next page, or nil if this is the last page.
+	Next *UserCursor
+}
+
+// UserHistoryPageQuery describes which page of the history of a user to get.
+type UserHistoryPageQuery struct {
+	UserID int
+	// AfterID is the id of the last entry of the previous page, or 0 for the first page.
+	AfterID int
+	// Limit is the maximum number of entries in the page.
+	Limit int
+}
+
+// UserHistoryEntry is a recorded change of a user.
+type UserHistoryEntry struct {
+	ID        int
+	UserID    int
+	Operation string
+	ChangedBy string
+	ChangedAt time.Time
+	// Before is the user before the change, nil if the change created or restored the user.
+	Before *User
+	// After is the user after the change, nil if the change deleted or purged the user.
+	After *User
+}
+
+// UserHistoryPage is a page of the history of a user, oldest change first.
+type UserHistoryPage struct {
+	Entries []*UserHistoryEntry
+	// NextAfterID is the id to continue from when getting the next page, or 0 if this is the last page.
+	NextAfterID int
 }
 
 // repositoryUserToServiceUser converts a repository User to a service User.
 func repositoryUserToServiceUser(user *repository.User) *User {
 	return &User{
-		ID:    user.ID,
-		Name:  user.Name,
-		Email: user.Email,
-		Age:   user.Age,
+		ID:        user.ID,
+		Name:      user.Name,
+		Email:     user.Email,
+		Age:       user.Age,
+		Version:   user.Version,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
 	}
 }
 
 // serviceUserToRepositoryUser converts a service User to a repository User.
 func serviceUserToRepositoryUser(user *User) *repository.User {
 	return &repository.User{
-		ID:    user.ID,
-		Name:  user.Name,
-		Email: user.Email,
-		Age:   user.Age,
+		ID:        user.ID,
+		Name:      user.Name,
+		Email:     user.Email,
+		Age:       user.Age,
+		Version:   user.Version,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}
+}
+
+// serviceUserPatchToRepositoryUserPatch converts a service UserPatch to a repository UserPatch.
+func serviceUserPatchToRepositoryUserPatch(patch *UserPatch) *repository.UserPatch {
+	return &repository.UserPatch{
+		ID:    patch.ID,
+		Name:  patch.Name,
+		Email: patch.Email,
+		Age:   patch.Age,
+	}
+}
+
+// serviceVersionMatchToRepositoryVersionMatch converts a service VersionMatch to a repository VersionMatch.
+func serviceVersionMatchToRepositoryVersionMatch(match VersionMatch) repository.VersionMatch {
+	return repository.VersionMatch{
+		Any:      match.Any,
+		Versions: match.Versions,
+	}
+}
+
+// serviceFilterToRepositoryFilter converts a service UserFilter to a repository UserFilter.
+func serviceFilterToRepositoryFilter(filter *UserFilter) *repository.UserFilter {
+	if filter == nil {
+		return nil
+	}
+	return &repository.UserFilter{
+		EmailDomain:  filter.EmailDomain,
+		NamePrefix:   filter.NamePrefix,
+		MinAge:       filter.MinAge,
+		MaxAge:       filter.MaxAge,
+		UpdatedSince: filter.UpdatedSince,
+	}
+}
+
+// serviceCursorToRepositoryCursor converts a service UserCursor to a repository UserCursor.
+func serviceCursorToRepositoryCursor(cursor *UserCursor) *repository.UserCursor {
+	if cursor == nil {
+		return nil
+	}
+	return &repository.UserCursor{
+		ID:    cursor.ID,
+		Name:  cursor.Name,
+		Email: cursor.Email,
+		Age:   cursor.Age,
+	}
+}
+
+// serviceSortToRepositorySort converts a service UserSort slice to a repository UserSort slice.
+func serviceSortToRepositorySort(sort []UserSort) []repository.UserSort {
+	if sort == nil {
+		return nil
+	}
+	repositorySort := make([]repository.UserSort, len(sort))
+	for i, s := range sort {
+		repositorySort[i] = repository.UserSort{
+			Column:     string(s.Field),
+			Descending: s.Descending,
+		}
+	}
+	return repositorySort
+}
+
+// repositoryHistoryEntryToServiceHistoryEntry converts a repository UserHistoryEntry to a service UserHistoryEntry.
+func repositoryHistoryEntryToServiceHistoryEntry(entry *repository.UserHistoryEntry) *UserHistoryEntry {
+	serviceEntry := &UserHistoryEntry{
+		ID:        entry.ID,
+		UserID:    entry.UserID,
+		Operation: entry.Operation,
+		ChangedBy: entry.ChangedBy,
+		ChangedAt: entry.ChangedAt,
+	}
+	if entry.Before != nil {
+		serviceEntry.Before = repositoryUserToServiceUser(entry.Before)
+	}
+	if entry.After != nil {
+		serviceEntry.After = repositoryUserToServiceUser(entry.After)
+	}
+	return serviceEntry
+}
+
+// Webhook is a subscription of a URL to user events.
+type Webhook struct {
+	ID  int
+	URL string
+	// Secret is the key the deliveries are signed with, a secret is generated if none is given when the webhook is created.
+	Secret string
+	// EventTypes are the WebhookEventTypes the webhook is subscribed to.
+	EventTypes []string
+	CreatedAt  time.Time
+}
+
+// WebhookEvent is a change of a user that is delivered to the webhooks subscribed to its type.
+type WebhookEvent struct {
+	// ID identifies the event, an event that is delivered again has the same id.
+	ID         int64
+	Type       string
+	OccurredAt time.Time
+	User       *User
+}
+
+// WebhookDelivery is a recorded delivery of an event to a webhook.
+type WebhookDelivery struct {
+	ID        int
+	WebhookID int
+	EventID   int64
+	EventType string
+	// Payload is the JSON body that was delivered.
+	Payload string
+	// StatusCode is the status of the last response, 0 if there was none.
+	StatusCode int
+	Attempts   int
+	// Error is why the delivery failed, empty if it succeeded.
+	Error       string
+	DeliveredAt time.Time
+}
+
+// WebhookDeliveryPageQuery describes which page of the deliveries of a webhook to get.
+type WebhookDeliveryPageQuery struct {
+	WebhookID int
+	// AfterID is the id of the last delivery of the previous page, or 0 for the first page.
+	AfterID int
+	// Limit is the maximum number of deliveries in the page.
+	Limit int
+}
+
+// WebhookDeliveryPage is a page of the deliveries of a webhook, oldest delivery first.
+type WebhookDeliveryPage struct {
+	Deliveries []*WebhookDelivery
+	// NextAfterID is the id to continue from when getting the next page, or 0 if this is the last page.
+	NextAfterID int
+}
+
+// repositoryWebhookToServiceWebhook converts a repository Webhook to a service Webhook.
+func repositoryWebhookToServiceWebhook(webhook *repository.Webhook) *Webhook {
+	return &Webhook{
+		ID:         webhook.ID,
+		URL:        webhook.URL,
+		Secret:     webhook.Secret,
+		EventTypes: webhook.EventTypes,
+		CreatedAt:  webhook.CreatedAt,
+	}
+}
+
+// serviceWebhookToRepositoryWebhook converts a service Webhook to a repository Webhook.
+func serviceWebhookToRepositoryWebhook(webhook *Webhook) *repository.Webhook {
+	return &repository.Webhook{
+		ID:         webhook.ID,
+		URL:        webhook.URL,
+		Secret:     webhook.Secret,
+		EventTypes: webhook.EventTypes,
+	}
+}
+
+// repositoryDeliveryToServiceDelivery converts a repository WebhookDelivery to a service WebhookDelivery.
+func repositoryDeliveryToServiceDelivery(delivery *repository.WebhookDelivery) *WebhookDelivery {
+	return &WebhookDelivery{
+		ID:          delivery.ID,
+		WebhookID:   delivery.WebhookID,
+		EventID:     delivery.EventID,
+		EventType:   delivery.EventType,
+		Payload:     delivery.Payload,
+		StatusCode:  delivery.StatusCode,
+		Attempts:    delivery.Attempts,
+		Error:       delivery.Error,
+		DeliveredAt: delivery.DeliveredAt,
+	}
+}
+
+// IdempotentResponse is the response to a request with an idempotency key, replayed for retries of the request.
+type IdempotentResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// repositoryIdempotencyKeyToServiceResponse converts the stored response of a repository IdempotencyKey to a service IdempotentResponse.
+func repositoryIdempotencyKeyToServiceResponse(key *repository.IdempotencyKey) *IdempotentResponse {
+	return &IdempotentResponse{
+		StatusCode:  key.StatusCode,
+		ContentType: key.ContentType,
+		Body:        key.Body,
+	}
+}
+
+// serviceResponseToRepositoryIdempotencyKey converts a service IdempotentResponse to a repository IdempotencyKey storing it under the key.
+func serviceResponseToRepositoryIdempotencyKey(key, fingerprint string, response *IdempotentResponse) *repository.IdempotencyKey {
+	return &repository.IdempotencyKey{
+		Key:         key,
+		Fingerprint: fingerprint,
+		StatusCode:  response.StatusCode,
+		ContentType: response.ContentType,
+		Body:        response.Body,
 	}
 }
@@ -1,40 +1,75 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
 
 	"github.com/tobiassundman/go-demo-app/internal/app/repository"
 )
 
+const (
+	// DefaultPageSize is the page size used when none is requested.
+	DefaultPageSize = 20
+	// MaxPageSize is the largest page size that can be requested.
+	MaxPageSize = 100
+	// MaxBatchSize is the largest number of users that can be created in one batch.
+	MaxBatchSize = 1000
+)
+
 // UserService is the service for the user resource.
 type UserService interface {
 	// GetAll gets all users.
-	GetAll() ([]*User, error)
+	GetAll(ctx context.Context) ([]*User, error)
+	// GetPage gets a page of users ordered by id.
+	GetPage(ctx context.Context, query *UserPageQuery) (*UserPage, error)
+	// Export calls fn with every user matching the filter ordered by id, without holding them all in memory.
+	Export(ctx context.Context, filter *UserFilter, fn func(user *User) error) error
+	// Search gets the users whose name or email best match the query, most relevant first.
+	Search(ctx context.Context, query string, limit int) ([]*UserSearchResult, error)
 	// Get gets a user by id.
-	Get(id int) (*User, error)
-	// Create creates a user.
-	Create(user *User) (*User, error)
-	// Update updates a user.
-	Update(user *User) error
-	// Delete deletes a user.
-	Delete(id int) error
+	Get(ctx context.Context, id int) (*User, error)
+	// Create creates a user and returns it as stored.
+	Create(ctx context.Context, user *User) (*User, error)
+	// CreateBatch creates users, returning the result of each user in the given order.
+	// If atomic is true either every user is created or none is.
+	CreateBatch(ctx context.Context, users []*User, atomic bool) ([]*BatchCreateResult, error)
+	// Update updates a user if its current version matches.
+	Update(ctx context.Context, user *User, match VersionMatch) error
+	// Patch changes the fields present in the patch if the current version of the user matches, returning the patched user.
+	Patch(ctx context.Context, patch *UserPatch, match VersionMatch) (*User, error)
+	// PatchCurrent changes the fields present in the patch that fn makes from the current user if its version matches, returning the patched user.
+	PatchCurrent(ctx context.Context, id int, match VersionMatch, fn func(current *User) (*UserPatch, error)) (*User, error)
+	// Delete deletes a user if its current version matches, it can be restored until it is purged.
+	Delete(ctx context.Context, id int, match VersionMatch) error
+	// Restore restores a deleted user.
+	Restore(ctx context.Context, id int) error
+	// PurgeDeleted permanently deletes users that were deleted longer ago than the retention, returning how many were purged.
+	PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error)
+	// GetHistory gets a page of the changes made to a user, oldest change first.
+	GetHistory(ctx context.Context, query *UserHistoryPageQuery) (*UserHistoryPage, error)
 }
 
 type userService struct {
 	userRepository repository.UserRepository
+	txManager      repository.TxManager
 }
 
-func NewUserService(repository repository.UserRepository) UserService {
+func NewUserService(repository repository.UserRepository, txManager repository.TxManager) UserService {
 	return &userService{
 		userRepository: repository,
+		txManager:      txManager,
 	}
 }
 
 // GetAll gets all users.
-func (s *userService) GetAll() ([]*User, error) {
-	users, err := s.userRepository.GetAll()
+func (s *userService) GetAll(ctx context.Context) ([]*User, error) {
+	users, err := s.userRepository.GetAll(ctx)
 	if err != nil {
-		return nil, err
+		return nil, unavailableServiceError(err)
 	}
 	serviceUsers := make([]*User, len(users))
 	for i, user := range users {
@@ -43,54 +78,329 @@ func (s *userService) GetAll() ([]*User, error) {
 	return serviceUsers, nil
 }
 
+// GetPage gets a page of users ordered by id.
+func (s *userService) GetPage(ctx context.Context, query *UserPageQuery) (*UserPage, error) {
+	if query.Limit < 1 || query.Limit > MaxPageSize {
+		return nil, ErrInvalidPageSize
+	}
+	if err := validateFilter(query.Filter); err != nil {
+		return nil, err
+	}
+	if err := validateSort(query.Sort); err != nil {
+		return nil, err
+	}
+
+	// Fetch one extra user to find out if there is a next page
+	users, err := s.userRepository.GetPage(ctx, &repository.UserPageQuery{
+		After:  serviceCursorToRepositoryCursor(query.After),
+		Limit:  query.Limit + 1,
+		Filter: serviceFilterToRepositoryFilter(query.Filter),
+		Sort:   serviceSortToRepositorySort(query.Sort),
+	})
+	if err != nil {
+		return nil, unavailableServiceError(err)
+	}
+
+	page := &UserPage{}
+	if len(users) > query.Limit {
+		users = users[:query.Limit]
+		last := users[len(users)-1]
+		page.Next = &UserCursor{ID: last.ID, Name: last.Name, Email: last.Email, Age: last.Age}
+	}
+	page.Users = make([]*User, len(users))
+	for i, user := range users {
+		page.Users[i] = repositoryUserToServiceUser(user)
+	}
+	return page, nil
+}
+
+// Export calls fn with every user matching the filter ordered by id, without holding them all in memory.
+func (s *userService) Export(ctx context.Context, filter *UserFilter, fn func(user *User) error) error {
+	if err := validateFilter(filter); err != nil {
+		return err
+	}
+	err := s.userRepository.Export(ctx, serviceFilterToRepositoryFilter(filter), func(user *repository.User) error {
+		return fn(repositoryUserToServiceUser(user))
+	})
+	return unavailableServiceError(err)
+}
+
+// Search gets the users whose name or email best match the query, most relevant first.
+func (s *userService) Search(ctx context.Context, query string, limit int) ([]*UserSearchResult, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, &FieldError{Field: "q", Message: "must not be empty"}
+	}
+	if limit < 1 || limit > MaxPageSize {
+		return nil, ErrInvalidPageSize
+	}
+
+	results, err := s.userRepository.Search(ctx, query, limit)
+	if err != nil {
+		return nil, unavailableServiceError(err)
+	}
+	serviceResults := make([]*UserSearchResult, len(results))
+	for i, result := range results {
+		serviceResults[i] = &UserSearchResult{
+			User:  repositoryUserToServiceUser(&result.User),
+			Score: result.Score,
+		}
+	}
+	return serviceResults, nil
+}
+
 // Get gets a user by id.
-func (s *userService) Get(id int) (*User, error) {
-	user, err := s.userRepository.Get(id)
+func (s *userService) Get(ctx context.Context, id int) (*User, error) {
+	user, err := s.userRepository.Get(ctx, id)
 	if err != nil {
 		if errors.Is(err, repository.ErrUserNotFound) {
 			return nil, ErrUserNotFound
 		}
-		return nil, err
+		return nil, unavailableServiceError(err)
 	}
 	return repositoryUserToServiceUser(user), nil
 }
 
-// Create creates a user.
-func (s *userService) Create(user *User) (*User, error) {
-	id, err := s.userRepository.Create(serviceUserToRepositoryUser(user))
+// Create creates a user and returns it as stored.
+func (s *userService) Create(ctx context.Context, user *User) (*User, error) {
+	var created *repository.User
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		id, err := s.userRepository.Create(ctx, serviceUserToRepositoryUser(user))
+		if err != nil {
+			return err
+		}
+		// Read within the transaction, so that the user is read as it was created rather than from a lagging replica
+		created, err = s.userRepository.Get(ctx, id)
+		return err
+	})
 	if err != nil {
 		if errors.Is(err, repository.ErrUserAlreadyExists) {
 			return nil, ErrUserAlreadyExists
 		}
-		return nil, err
+		return nil, constraintServiceError(err)
+	}
+	return repositoryUserToServiceUser(created), nil
+}
+
+// CreateBatch creates users, returning the result of each user in the given order.
+// If atomic is true either every user is created or none is, the users that would have been created get ErrBatchAborted.
+func (s *userService) CreateBatch(ctx context.Context, users []*User, atomic bool) ([]*BatchCreateResult, error) {
+	if len(users) == 0 || len(users) > MaxBatchSize {
+		return nil, &FieldError{Field: "users", Message: fmt.Sprintf("must contain between 1 and %d users", MaxBatchSize)}
+	}
+
+	repositoryUsers := make([]*repository.User, len(users))
+	for i, user := range users {
+		repositoryUsers[i] = serviceUserToRepositoryUser(user)
+	}
+
+	results := make([]*BatchCreateResult, len(users))
+	created, err := s.userRepository.CreateBatch(ctx, repositoryUsers, atomic)
+	var conflictError *repository.BatchConflictError
+	if errors.As(err, &conflictError) {
+		for _, i := range conflictError.Indexes {
+			results[i] = &BatchCreateResult{Err: ErrUserAlreadyExists}
+		}
+		return abortBatch(results), nil
+	}
+	if err != nil {
+		return nil, constraintServiceError(err)
 	}
 
-	createdUser := &User{
-		ID:    id,
-		Name:  user.Name,
-		Email: user.Email,
-		Age:   user.Age,
+	for i, user := range created {
+		if user == nil {
+			results[i] = &BatchCreateResult{Err: ErrUserAlreadyExists}
+			continue
+		}
+		results[i] = &BatchCreateResult{User: repositoryUserToServiceUser(user)}
 	}
-	return createdUser, nil
+	return results, nil
 }
 
-// Update updates a user.
-func (s *userService) Update(user *User) error {
-	err := s.userRepository.Update(serviceUserToRepositoryUser(user))
+// abortBatch sets ErrBatchAborted as the result of every user of an atomic batch that has no result.
+func abortBatch(results []*BatchCreateResult) []*BatchCreateResult {
+	for i, result := range results {
+		if result == nil {
+			results[i] = &BatchCreateResult{Err: ErrBatchAborted}
+		}
+	}
+	return results
+}
+
+// Update updates a user if its current version matches.
+func (s *userService) Update(ctx context.Context, user *User, match VersionMatch) error {
+	err := s.userRepository.Update(ctx, serviceUserToRepositoryUser(user), serviceVersionMatchToRepositoryVersionMatch(match))
 	switch {
 	case errors.Is(err, repository.ErrUserNotFound):
 		return ErrUserNotFound
 	case errors.Is(err, repository.ErrUserAlreadyExists):
 		return ErrUserAlreadyExists
+	case errors.Is(err, repository.ErrVersionConflict):
+		return ErrVersionConflict
+	}
+	return constraintServiceError(err)
+}
+
+// Patch changes the fields present in the patch if the current version of the user matches, returning the patched user.
+// Present fields are validated like the fields of a created user, absent fields are left as they are.
+func (s *userService) Patch(ctx context.Context, patch *UserPatch, match VersionMatch) (*User, error) {
+	if err := validateUserPatch(patch); err != nil {
+		return nil, err
 	}
-	return err
+	patched, err := s.userRepository.Patch(ctx, serviceUserPatchToRepositoryUserPatch(patch), serviceVersionMatchToRepositoryVersionMatch(match))
+	return patchResult(patched, err)
 }
 
-// Delete deletes a user.
-func (s *userService) Delete(id int) error {
-	err := s.userRepository.Delete(id)
-	if errors.Is(err, repository.ErrUserNotFound) {
+// PatchCurrent changes the fields present in the patch that fn makes from the current user if its version matches, returning the patched user.
+// The user is read within the transaction of the patch, so that fn sees the user as it is on the primary rather than on a lagging replica,
+// and it is only patched if it is still at the version fn saw. Errors returned by fn are returned as they are.
+func (s *userService) PatchCurrent(ctx context.Context, id int, match VersionMatch, fn func(current *User) (*UserPatch, error)) (*User, error) {
+	var patched *repository.User
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		current, err := s.userRepository.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !match.Matches(current.Version) {
+			return repository.ErrVersionConflict
+		}
+		patch, err := fn(repositoryUserToServiceUser(current))
+		if err != nil {
+			return err
+		}
+		if err := validateUserPatch(patch); err != nil {
+			return err
+		}
+		patched, err = s.userRepository.Patch(ctx, serviceUserPatchToRepositoryUserPatch(patch), repository.MatchVersion(current.Version))
+		return err
+	})
+	return patchResult(patched, err)
+}
+
+// patchResult converts the result of patching a user in the repository to the result of the service.
+func patchResult(patched *repository.User, err error) (*User, error) {
+	switch {
+	case errors.Is(err, repository.ErrUserNotFound):
+		return nil, ErrUserNotFound
+	case errors.Is(err, repository.ErrUserAlreadyExists):
+		return nil, ErrUserAlreadyExists
+	case errors.Is(err, repository.ErrVersionConflict):
+		return nil, ErrVersionConflict
+	case err != nil:
+		return nil, constraintServiceError(err)
+	}
+	return repositoryUserToServiceUser(patched), nil
+}
+
+// Delete deletes a user if its current version matches, it can be restored until it is purged.
+func (s *userService) Delete(ctx context.Context, id int, match VersionMatch) error {
+	err := s.userRepository.Delete(ctx, id, serviceVersionMatchToRepositoryVersionMatch(match))
+	switch {
+	case errors.Is(err, repository.ErrUserNotFound):
+		return ErrUserNotFound
+	case errors.Is(err, repository.ErrVersionConflict):
+		return ErrVersionConflict
+	}
+	return unavailableServiceError(err)
+}
+
+// Restore restores a deleted user.
+func (s *userService) Restore(ctx context.Context, id int) error {
+	err := s.userRepository.Restore(ctx, id)
+	switch {
+	case errors.Is(err, repository.ErrUserNotFound):
 		return ErrUserNotFound
+	case errors.Is(err, repository.ErrUserAlreadyExists):
+		return ErrUserAlreadyExists
+	}
+	return unavailableServiceError(err)
+}
+
+// PurgeDeleted permanently deletes users that were deleted longer ago than the retention, returning how many were purged.
+func (s *userService) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	purged, err := s.userRepository.PurgeDeleted(ctx, retention)
+	return purged, unavailableServiceError(err)
+}
+
+// GetHistory gets a page of the changes made to a user, oldest change first.
+// The history is kept after the user is deleted, so an unknown user has an empty history rather than not being found.
+func (s *userService) GetHistory(ctx context.Context, query *UserHistoryPageQuery) (*UserHistoryPage, error) {
+	if query.Limit < 1 || query.Limit > MaxPageSize {
+		return nil, ErrInvalidPageSize
+	}
+
+	// Fetch one extra entry to find out if there is a next page
+	entries, err := s.userRepository.GetHistory(ctx, &repository.UserHistoryPageQuery{
+		UserID:  query.UserID,
+		AfterID: query.AfterID,
+		Limit:   query.Limit + 1,
+	})
+	if err != nil {
+		return nil, unavailableServiceError(err)
+	}
+
+	page := &UserHistoryPage{}
+	if len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+		page.NextAfterID = entries[len(entries)-1].ID
+	}
+	page.Entries = make([]*UserHistoryEntry, len(entries))
+	for i, entry := range entries {
+		page.Entries[i] = repositoryHistoryEntryToServiceHistoryEntry(entry)
+	}
+	return page, nil
+}
+
+// validateUserPatch validates the fields present in a user patch.
+func validateUserPatch(patch *UserPatch) error {
+	if patch.Name != nil && strings.TrimSpace(*patch.Name) == "" {
+		return &FieldError{Field: "name", Message: "must not be empty"}
+	}
+	if patch.Email != nil {
+		address, err := mail.ParseAddress(*patch.Email)
+		if err != nil || address.Address != *patch.Email {
+			return &FieldError{Field: "email", Message: "must be an email address"}
+		}
+	}
+	if patch.Age != nil && *patch.Age < 0 {
+		return &FieldError{Field: "age", Message: "must not be negative"}
+	}
+	return nil
+}
+
+// validateFilter validates the values of a user filter.
+func validateFilter(filter *UserFilter) error {
+	if filter == nil {
+		return nil
+	}
+	if strings.Contains(filter.EmailDomain, "@") {
+		return &FieldError{Field: "email_domain", Message: "must not contain @"}
+	}
+	if filter.MinAge != nil && *filter.MinAge < 0 {
+		return &FieldError{Field: "min_age", Message: "must not be negative"}
+	}
+	if filter.MaxAge != nil && *filter.MaxAge < 0 {
+		return &FieldError{Field: "max_age", Message: "must not be negative"}
+	}
+	if filter.MinAge != nil && filter.MaxAge != nil && *filter.MinAge > *filter.MaxAge {
+		return &FieldError{Field: "max_age", Message: "must not be less than min_age"}
+	}
+	return nil
+}
+
+// validateSort validates that users can be sorted by the given fields and that no field is repeated.
+func validateSort(sort []UserSort) error {
+	seen := map[UserSortField]bool{}
+	for _, s := range sort {
+		switch s.Field {
+		case UserSortFieldID, UserSortFieldName, UserSortFieldEmail, UserSortFieldAge:
+		default:
+			return &FieldError{Field: "sort", Message: fmt.Sprintf("cannot sort by %q", s.Field)}
+		}
+		if seen[s.Field] {
+			return &FieldError{Field: "sort", Message: fmt.Sprintf("%q is repeated", s.Field)}
+		}
+		seen[s.Field] = true
 	}
-	return err
+	return nil
 }
@@ -1,20 +1,33 @@
 package service_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tobiassundman/go-demo-app/internal/app/repository"
 	"github.com/tobiassundman/go-demo-app/internal/app/service"
+	"github.com/tobiassundman/go-demo-app/pkg/breaker"
+	"github.com/tobiassundman/go-demo-app/pkg/test"
 )
 
 var (
+	USER1_CREATED_AT = time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	USER1_UPDATED_AT = time.Date(2023, 3, 2, 12, 0, 0, 0, time.UTC)
+
 	USER1_REPOSITORY = repository.User{
-		ID:    1,
-		Name:  "Name Name 1",
-		Email: "email1@email.com",
-		Age:   37,
+		ID:        1,
+		Name:      "Name Name 1",
+		Email:     "email1@email.com",
+		Age:       37,
+		Version:   1,
+		CreatedAt: USER1_CREATED_AT,
+		UpdatedAt: USER1_UPDATED_AT,
 	}
 	USER2_REPOSITORY = repository.User{
 		ID:    2,
@@ -24,10 +37,13 @@ var (
 	}
 
 	USER1_SERVICE = service.User{
-		ID:    1,
-		Name:  "Name Name 1",
-		Email: "email1@email.com",
-		Age:   37,
+		ID:        1,
+		Name:      "Name Name 1",
+		Email:     "email1@email.com",
+		Age:       37,
+		Version:   1,
+		CreatedAt: USER1_CREATED_AT,
+		UpdatedAt: USER1_UPDATED_AT,
 	}
 	USER2_SERVICE = service.User{
 		ID:    2,
@@ -40,31 +56,89 @@ var (
 var _ repository.UserRepository = &userRepositoryMock{}
 
 type userRepositoryMock struct {
-	GetAllFunc func() ([]*repository.User, error)
-	GetFunc    func(id int) (*repository.User, error)
-	CreateFunc func(user *repository.User) (int, error)
-	UpdateFunc func(user *repository.User) error
-	DeleteFunc func(id int) error
+	GetAllFunc  func(ctx context.Context) ([]*repository.User, error)
+	GetPageFunc func(ctx context.Context, query *repository.UserPageQuery) ([]*repository.User, error)
+	SearchFunc  func(ctx context.Context, query string, limit int) ([]*repository.UserSearchResult, error)
+	GetFunc     func(ctx context.Context, id int) (*repository.User, error)
+	CreateFunc  func(ctx context.Context, user *repository.User) (int, error)
+	UpdateFunc  func(ctx context.Context, user *repository.User, match repository.VersionMatch) error
+	PatchFunc   func(ctx context.Context, patch *repository.UserPatch, match repository.VersionMatch) (*repository.User, error)
+	DeleteFunc  func(ctx context.Context, id int, match repository.VersionMatch) error
+
+	RestoreFunc      func(ctx context.Context, id int) error
+	PurgeDeletedFunc func(ctx context.Context, retention time.Duration) (int64, error)
+	GetHistoryFunc   func(ctx context.Context, query *repository.UserHistoryPageQuery) ([]*repository.UserHistoryEntry, error)
+	CreateBatchFunc  func(ctx context.Context, users []*repository.User, atomic bool) ([]*repository.User, error)
+	ExportFunc       func(ctx context.Context, filter *repository.UserFilter, fn func(user *repository.User) error) error
+}
+
+func (m *userRepositoryMock) GetAll(ctx context.Context) ([]*repository.User, error) {
+	return m.GetAllFunc(ctx)
+}
+
+func (m *userRepositoryMock) GetPage(ctx context.Context, query *repository.UserPageQuery) ([]*repository.User, error) {
+	return m.GetPageFunc(ctx, query)
+}
+
+func (m *userRepositoryMock) Search(ctx context.Context, query string, limit int) ([]*repository.UserSearchResult, error) {
+	return m.SearchFunc(ctx, query, limit)
+}
+
+func (m *userRepositoryMock) Get(ctx context.Context, id int) (*repository.User, error) {
+	return m.GetFunc(ctx, id)
+}
+
+func (m *userRepositoryMock) Create(ctx context.Context, user *repository.User) (int, error) {
+	return m.CreateFunc(ctx, user)
+}
+
+func (m *userRepositoryMock) CreateBatch(ctx context.Context, users []*repository.User, atomic bool) ([]*repository.User, error) {
+	return m.CreateBatchFunc(ctx, users, atomic)
+}
+
+func (m *userRepositoryMock) Export(ctx context.Context, filter *repository.UserFilter, fn func(user *repository.User) error) error {
+	return m.ExportFunc(ctx, filter, fn)
 }
 
-func (m *userRepositoryMock) GetAll() ([]*repository.User, error) {
-	return m.GetAllFunc()
+func (m *userRepositoryMock) Update(ctx context.Context, user *repository.User, match repository.VersionMatch) error {
+	return m.UpdateFunc(ctx, user, match)
 }
 
-func (m *userRepositoryMock) Get(id int) (*repository.User, error) {
-	return m.GetFunc(id)
+func (m *userRepositoryMock) Patch(ctx context.Context, patch *repository.UserPatch, match repository.VersionMatch) (*repository.User, error) {
+	return m.PatchFunc(ctx, patch, match)
 }
 
-func (m *userRepositoryMock) Create(user *repository.User) (int, error) {
-	return m.CreateFunc(user)
+func (m *userRepositoryMock) Delete(ctx context.Context, id int, match repository.VersionMatch) error {
+	return m.DeleteFunc(ctx, id, match)
 }
 
-func (m *userRepositoryMock) Update(user *repository.User) error {
-	return m.UpdateFunc(user)
+func (m *userRepositoryMock) Restore(ctx context.Context, id int) error {
+	return m.RestoreFunc(ctx, id)
 }
 
-func (m *userRepositoryMock) Delete(id int) error {
-	return m.DeleteFunc(id)
+func (m *userRepositoryMock) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	return m.PurgeDeletedFunc(ctx, retention)
+}
+
+func (m *userRepositoryMock) GetHistory(ctx context.Context, query *repository.UserHistoryPageQuery) ([]*repository.UserHistoryEntry, error) {
+	return m.GetHistoryFunc(ctx, query)
+}
+
+var _ repository.TxManager = &txManagerMock{}
+
+type txContextKey struct{}
+
+// txManagerMock runs functions directly with a context that marks them as running within a transaction.
+type txManagerMock struct{}
+
+func (m *txManagerMock) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(context.WithValue(ctx, txContextKey{}, true))
+}
+
+// withinTx returns true if the context was passed to a function run by txManagerMock.
+func withinTx(ctx context.Context) bool {
+	inTx, _ := ctx.Value(txContextKey{}).(bool)
+	return inTx
 }
 
 func TestGetAll(t *testing.T) {
@@ -74,14 +148,14 @@ func TestGetAll(t *testing.T) {
 
 		// Arrange
 		userRepositoryMock := &userRepositoryMock{
-			GetAllFunc: func() ([]*repository.User, error) {
+			GetAllFunc: func(ctx context.Context) ([]*repository.User, error) {
 				return []*repository.User{&USER1_REPOSITORY, &USER2_REPOSITORY}, nil
 			},
 		}
-		userService := service.NewUserService(userRepositoryMock)
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
 
 		// Act
-		users, err := userService.GetAll()
+		users, err := userService.GetAll(context.Background())
 		require.NoError(t, err)
 
 		// Assert
@@ -94,14 +168,14 @@ func TestGetAll(t *testing.T) {
 		t.Parallel()
 		// Arrange
 		userRepositoryMock := &userRepositoryMock{
-			GetAllFunc: func() ([]*repository.User, error) {
+			GetAllFunc: func(ctx context.Context) ([]*repository.User, error) {
 				return []*repository.User{}, nil
 			},
 		}
-		userService := service.NewUserService(userRepositoryMock)
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
 
 		// Act
-		users, err := userService.GetAll()
+		users, err := userService.GetAll(context.Background())
 		require.NoError(t, err)
 
 		// Assert
@@ -109,6 +183,224 @@ func TestGetAll(t *testing.T) {
 	})
 }
 
+func TestGetPage(t *testing.T) {
+	t.Parallel()
+	t.Run("should return last page without next cursor", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			GetPageFunc: func(ctx context.Context, query *repository.UserPageQuery) ([]*repository.User, error) {
+				assert.Equal(t, &repository.UserPageQuery{Limit: 3}, query)
+				return []*repository.User{&USER1_REPOSITORY, &USER2_REPOSITORY}, nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		page, err := userService.GetPage(context.Background(), &service.UserPageQuery{Limit: 2})
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, []*service.User{&USER1_SERVICE, &USER2_SERVICE}, page.Users)
+		assert.Nil(t, page.Next)
+	})
+
+	t.Run("should return cursor of last user when there are more users", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			GetPageFunc: func(ctx context.Context, query *repository.UserPageQuery) ([]*repository.User, error) {
+				assert.Equal(t, &repository.UserPageQuery{After: &repository.UserCursor{ID: 5, Name: "Name"}, Limit: 2}, query)
+				return []*repository.User{&USER1_REPOSITORY, &USER2_REPOSITORY}, nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		page, err := userService.GetPage(context.Background(), &service.UserPageQuery{After: &service.UserCursor{ID: 5, Name: "Name"}, Limit: 1})
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, []*service.User{&USER1_SERVICE}, page.Users)
+		assert.Equal(t, &service.UserCursor{ID: USER1_SERVICE.ID, Name: USER1_SERVICE.Name, Email: USER1_SERVICE.Email, Age: USER1_SERVICE.Age}, page.Next)
+	})
+
+	t.Run("should return ErrInvalidPageSize when limit is too large", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userService := service.NewUserService(&userRepositoryMock{}, &txManagerMock{})
+
+		// Act
+		_, err := userService.GetPage(context.Background(), &service.UserPageQuery{Limit: service.MaxPageSize + 1})
+
+		// Assert
+		assert.Equal(t, service.ErrInvalidPageSize, err)
+	})
+
+	t.Run("should pass filter and sort to repository", func(t *testing.T) {
+		t.Parallel()
+
+		minAge := 18
+		maxAge := 65
+		updatedSince := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			GetPageFunc: func(ctx context.Context, query *repository.UserPageQuery) ([]*repository.User, error) {
+				assert.Equal(t, &repository.UserFilter{
+					EmailDomain:  "email.com",
+					NamePrefix:   "Name",
+					MinAge:       &minAge,
+					MaxAge:       &maxAge,
+					UpdatedSince: &updatedSince,
+				}, query.Filter)
+				assert.Equal(t, []repository.UserSort{
+					{Column: "name"},
+					{Column: "age", Descending: true},
+				}, query.Sort)
+				return []*repository.User{}, nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		_, err := userService.GetPage(context.Background(), &service.UserPageQuery{
+			Limit: 10,
+			Filter: &service.UserFilter{
+				EmailDomain:  "email.com",
+				NamePrefix:   "Name",
+				MinAge:       &minAge,
+				MaxAge:       &maxAge,
+				UpdatedSince: &updatedSince,
+			},
+			Sort: []service.UserSort{
+				{Field: service.UserSortFieldName},
+				{Field: service.UserSortFieldAge, Descending: true},
+			},
+		})
+
+		// Assert
+		require.NoError(t, err)
+	})
+
+	t.Run("should return FieldError for invalid filter and sort", func(t *testing.T) {
+		t.Parallel()
+
+		negative := -1
+		minAge := 30
+		maxAge := 20
+
+		testCases := []struct {
+			name          string
+			query         *service.UserPageQuery
+			expectedField string
+		}{
+			{
+				name:          "email domain with @",
+				query:         &service.UserPageQuery{Limit: 1, Filter: &service.UserFilter{EmailDomain: "a@email.com"}},
+				expectedField: "email_domain",
+			},
+			{
+				name:          "negative min age",
+				query:         &service.UserPageQuery{Limit: 1, Filter: &service.UserFilter{MinAge: &negative}},
+				expectedField: "min_age",
+			},
+			{
+				name:          "max age less than min age",
+				query:         &service.UserPageQuery{Limit: 1, Filter: &service.UserFilter{MinAge: &minAge, MaxAge: &maxAge}},
+				expectedField: "max_age",
+			},
+			{
+				name:          "unknown sort field",
+				query:         &service.UserPageQuery{Limit: 1, Sort: []service.UserSort{{Field: "password"}}},
+				expectedField: "sort",
+			},
+			{
+				name:          "repeated sort field",
+				query:         &service.UserPageQuery{Limit: 1, Sort: []service.UserSort{{Field: "age"}, {Field: "age", Descending: true}}},
+				expectedField: "sort",
+			},
+		}
+
+		for _, testCase := range testCases {
+			testCase := testCase
+			t.Run(testCase.name, func(t *testing.T) {
+				t.Parallel()
+
+				// Arrange
+				userService := service.NewUserService(&userRepositoryMock{}, &txManagerMock{})
+
+				// Act
+				_, err := userService.GetPage(context.Background(), testCase.query)
+
+				// Assert
+				var fieldError *service.FieldError
+				require.ErrorAs(t, err, &fieldError)
+				assert.Equal(t, testCase.expectedField, fieldError.Field)
+			})
+		}
+	})
+}
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+	t.Run("should return search results", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			SearchFunc: func(ctx context.Context, query string, limit int) ([]*repository.UserSearchResult, error) {
+				assert.Equal(t, "name nme", query)
+				assert.Equal(t, 10, limit)
+				return []*repository.UserSearchResult{
+					{User: USER1_REPOSITORY, Score: 0.5},
+					{User: USER2_REPOSITORY, Score: 0.25},
+				}, nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		results, err := userService.Search(context.Background(), "  name nme ", 10)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, []*service.UserSearchResult{
+			{User: &USER1_SERVICE, Score: 0.5},
+			{User: &USER2_SERVICE, Score: 0.25},
+		}, results)
+	})
+
+	t.Run("should return FieldError when query is empty", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userService := service.NewUserService(&userRepositoryMock{}, &txManagerMock{})
+
+		// Act
+		_, err := userService.Search(context.Background(), " ", 10)
+
+		// Assert
+		assert.Equal(t, &service.FieldError{Field: "q", Message: "must not be empty"}, err)
+	})
+
+	t.Run("should return ErrInvalidPageSize when limit is too large", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userService := service.NewUserService(&userRepositoryMock{}, &txManagerMock{})
+
+		// Act
+		_, err := userService.Search(context.Background(), "name", service.MaxPageSize+1)
+
+		// Assert
+		assert.Equal(t, service.ErrInvalidPageSize, err)
+	})
+}
+
 func TestGet(t *testing.T) {
 	t.Parallel()
 	t.Run("should return user", func(t *testing.T) {
@@ -116,15 +408,15 @@ func TestGet(t *testing.T) {
 
 		// Arrange
 		userRepositoryMock := &userRepositoryMock{
-			GetFunc: func(id int) (*repository.User, error) {
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
 				assert.Equal(t, 1, id)
 				return &USER1_REPOSITORY, nil
 			},
 		}
-		userService := service.NewUserService(userRepositoryMock)
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
 
 		// Act
-		user, err := userService.Get(1)
+		user, err := userService.Get(context.Background(), 1)
 		require.NoError(t, err)
 
 		// Assert
@@ -136,19 +428,61 @@ func TestGet(t *testing.T) {
 
 		// Arrange
 		userRepositoryMock := &userRepositoryMock{
-			GetFunc: func(id int) (*repository.User, error) {
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
 				return nil, repository.ErrUserNotFound
 			},
 		}
-		userService := service.NewUserService(userRepositoryMock)
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
 
 		// Act
-		user, err := userService.Get(1)
+		user, err := userService.Get(context.Background(), 1)
 
 		// Assert
 		assert.Nil(t, user)
 		assert.Equal(t, service.ErrUserNotFound, err)
 	})
+
+	t.Run("should return UnavailableError when database is unavailable", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				return nil, &repository.UnavailableError{RetryAfter: time.Second * 3}
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		user, err := userService.Get(context.Background(), 1)
+
+		// Assert
+		assert.Nil(t, user)
+		assert.ErrorIs(t, err, service.ErrUnavailable)
+		assert.Equal(t, &service.UnavailableError{RetryAfter: time.Second * 3}, err)
+	})
+
+	t.Run("should pass context to repository", func(t *testing.T) {
+		t.Parallel()
+
+		type contextKey struct{}
+		ctx := context.WithValue(context.Background(), contextKey{}, "value")
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(repositoryCtx context.Context, id int) (*repository.User, error) {
+				assert.Equal(t, "value", repositoryCtx.Value(contextKey{}))
+				return &USER1_REPOSITORY, nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		_, err := userService.Get(ctx, 1)
+
+		// Assert
+		require.NoError(t, err)
+	})
 }
 
 func TestCreate(t *testing.T) {
@@ -158,38 +492,117 @@ func TestCreate(t *testing.T) {
 
 		// Arrange
 		userRepositoryMock := &userRepositoryMock{
-			CreateFunc: func(user *repository.User) (int, error) {
+			CreateFunc: func(ctx context.Context, user *repository.User) (int, error) {
+				assert.True(t, withinTx(ctx))
 				assert.Equal(t, &USER1_REPOSITORY, user)
 				return 1, nil
 			},
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				assert.True(t, withinTx(ctx))
+				assert.Equal(t, 1, id)
+				return &USER1_REPOSITORY, nil
+			},
 		}
-		userService := service.NewUserService(userRepositoryMock)
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
 
 		// Act
-		user, err := userService.Create(&USER1_SERVICE)
+		user, err := userService.Create(context.Background(), &USER1_SERVICE)
 		require.NoError(t, err)
 
 		// Assert
 		assert.Equal(t, &USER1_SERVICE, user)
 	})
 
+	t.Run("should return ErrUnavailable when circuit is open", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		host, port, err := net.SplitHostPort(listener.Addr().String())
+		require.NoError(t, err)
+		require.NoError(t, listener.Close())
+		db := test.Connect(t, fmt.Sprintf("host=%s port=%s user=demo_user password=demo_password dbname=demo_db sslmode=disable", host, port))
+		t.Cleanup(db.Close)
+		circuit := breaker.New(breaker.Config{FailureThreshold: 1, OpenTimeout: time.Minute})
+		txManager := repository.NewPostgresTxManager(db, time.Second*2).WithCircuitBreaker(circuit)
+		userRepositoryMock := &userRepositoryMock{
+			CreateFunc: func(ctx context.Context, user *repository.User) (int, error) {
+				t.Error("user must not be created without a transaction")
+				return 0, nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, txManager)
+		// The refused connection of the first transaction opens the circuit
+		_, err = userService.Create(tenantContext(), &USER1_SERVICE)
+		require.Error(t, err)
+		require.Equal(t, breaker.StateOpen, circuit.State())
+
+		// Act
+		user, err := userService.Create(tenantContext(), &USER1_SERVICE)
+
+		// Assert
+		assert.Nil(t, user)
+		assert.ErrorIs(t, err, service.ErrUnavailable)
+	})
+
 	t.Run("should return ErrUserAlreadyExists", func(t *testing.T) {
 		t.Parallel()
 
 		// Arrange
 		userRepositoryMock := &userRepositoryMock{
-			CreateFunc: func(user *repository.User) (int, error) {
+			CreateFunc: func(ctx context.Context, user *repository.User) (int, error) {
 				return 0, repository.ErrUserAlreadyExists
 			},
 		}
-		userService := service.NewUserService(userRepositoryMock)
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
 
 		// Act
-		_, err := userService.Create(&USER1_SERVICE)
+		_, err := userService.Create(context.Background(), &USER1_SERVICE)
 
 		// Assert
 		assert.Equal(t, service.ErrUserAlreadyExists, err)
 	})
+
+	t.Run("should return error when created user cannot be read", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		repositoryErr := errors.New("connection reset")
+		userRepositoryMock := &userRepositoryMock{
+			CreateFunc: func(ctx context.Context, user *repository.User) (int, error) {
+				return 1, nil
+			},
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				return nil, repositoryErr
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		_, err := userService.Create(context.Background(), &USER1_SERVICE)
+
+		// Assert
+		assert.Equal(t, repositoryErr, err)
+	})
+
+	t.Run("should return FieldError of column rejected by database", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			CreateFunc: func(ctx context.Context, user *repository.User) (int, error) {
+				return 0, &repository.ConstraintError{Kind: repository.ErrCheckViolation, Column: "age", Constraint: "users_age_not_negative"}
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		_, err := userService.Create(context.Background(), &USER1_SERVICE)
+
+		// Assert
+		assert.Equal(t, &service.FieldError{Field: "age", Message: "is not allowed"}, err)
+	})
 }
 
 func TestUpdate(t *testing.T) {
@@ -201,16 +614,17 @@ func TestUpdate(t *testing.T) {
 
 		// Arrange
 		userRepositoryMock := &userRepositoryMock{
-			UpdateFunc: func(user *repository.User) error {
+			UpdateFunc: func(ctx context.Context, user *repository.User, match repository.VersionMatch) error {
 				assert.Equal(t, &USER1_REPOSITORY, user)
+				assert.Equal(t, repository.MatchVersion(1), match)
 				updateCalled = true
 				return nil
 			},
 		}
-		userService := service.NewUserService(userRepositoryMock)
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
 
 		// Act
-		err := userService.Update(&USER1_SERVICE)
+		err := userService.Update(context.Background(), &USER1_SERVICE, service.MatchVersion(1))
 		require.NoError(t, err)
 
 		// Assert
@@ -222,14 +636,14 @@ func TestUpdate(t *testing.T) {
 
 		// Arrange
 		userRepositoryMock := &userRepositoryMock{
-			UpdateFunc: func(user *repository.User) error {
+			UpdateFunc: func(ctx context.Context, user *repository.User, match repository.VersionMatch) error {
 				return repository.ErrUserNotFound
 			},
 		}
-		userService := service.NewUserService(userRepositoryMock)
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
 
 		// Act
-		err := userService.Update(&USER1_SERVICE)
+		err := userService.Update(context.Background(), &USER1_SERVICE, service.MatchVersion(1))
 
 		// Assert
 		assert.Equal(t, service.ErrUserNotFound, err)
@@ -240,60 +654,644 @@ func TestUpdate(t *testing.T) {
 
 		// Arrange
 		userRepositoryMock := &userRepositoryMock{
-			UpdateFunc: func(user *repository.User) error {
+			UpdateFunc: func(ctx context.Context, user *repository.User, match repository.VersionMatch) error {
 				return repository.ErrUserAlreadyExists
 			},
 		}
-		userService := service.NewUserService(userRepositoryMock)
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
 
 		// Act
-		err := userService.Update(&USER1_SERVICE)
+		err := userService.Update(context.Background(), &USER1_SERVICE, service.MatchVersion(1))
 
 		// Assert
 		assert.Equal(t, service.ErrUserAlreadyExists, err)
 	})
+
+	t.Run("should return ErrVersionConflict", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			UpdateFunc: func(ctx context.Context, user *repository.User, match repository.VersionMatch) error {
+				return repository.ErrVersionConflict
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		err := userService.Update(context.Background(), &USER1_SERVICE, service.MatchVersion(1))
+
+		// Assert
+		assert.Equal(t, service.ErrVersionConflict, err)
+	})
+
+	t.Run("should return FieldError when database rejects value", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			UpdateFunc: func(ctx context.Context, user *repository.User, match repository.VersionMatch) error {
+				return fmt.Errorf("failed to update user: %w", &repository.ConstraintError{Kind: repository.ErrValueTooLong})
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		err := userService.Update(context.Background(), &USER1_SERVICE, service.MatchVersion(1))
+
+		// Assert
+		assert.Equal(t, &service.FieldError{Field: "", Message: "is too long"}, err)
+	})
 }
 
-func TestDelete(t *testing.T) {
+func TestPatch(t *testing.T) {
 	t.Parallel()
-	t.Run("should delete user", func(t *testing.T) {
+	t.Run("should patch present fields and return patched user", func(t *testing.T) {
 		t.Parallel()
 
-		deleteCalled := false
-
 		// Arrange
+		email := "patched@email.com"
 		userRepositoryMock := &userRepositoryMock{
-			DeleteFunc: func(id int) error {
-				assert.Equal(t, 1, id)
-				deleteCalled = true
-				return nil
+			PatchFunc: func(ctx context.Context, patch *repository.UserPatch, match repository.VersionMatch) (*repository.User, error) {
+				assert.Equal(t, &repository.UserPatch{ID: 1, Email: &email}, patch)
+				assert.Equal(t, repository.MatchVersion(1), match)
+				patched := USER1_REPOSITORY
+				patched.Email = email
+				patched.Version = 2
+				return &patched, nil
 			},
 		}
-		userService := service.NewUserService(userRepositoryMock)
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
 
 		// Act
-		err := userService.Delete(1)
+		user, err := userService.Patch(context.Background(), &service.UserPatch{ID: 1, Email: &email}, service.MatchVersion(1))
 		require.NoError(t, err)
 
 		// Assert
-		assert.True(t, deleteCalled)
+		expected := USER1_SERVICE
+		expected.Email = email
+		expected.Version = 2
+		assert.Equal(t, &expected, user)
 	})
 
-	t.Run("should return ErrUserNotFound", func(t *testing.T) {
+	t.Run("should patch zero age", func(t *testing.T) {
 		t.Parallel()
 
 		// Arrange
+		zero := 0
 		userRepositoryMock := &userRepositoryMock{
-			DeleteFunc: func(id int) error {
-				return repository.ErrUserNotFound
+			PatchFunc: func(ctx context.Context, patch *repository.UserPatch, match repository.VersionMatch) (*repository.User, error) {
+				assert.Equal(t, &repository.UserPatch{ID: 1, Age: &zero}, patch)
+				patched := USER1_REPOSITORY
+				patched.Age = zero
+				patched.Version = 2
+				return &patched, nil
 			},
 		}
-		userService := service.NewUserService(userRepositoryMock)
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
 
 		// Act
-		err := userService.Delete(1)
+		user, err := userService.Patch(context.Background(), &service.UserPatch{ID: 1, Age: &zero}, service.MatchVersion(1))
+		require.NoError(t, err)
 
 		// Assert
-		assert.Equal(t, service.ErrUserNotFound, err)
+		assert.Equal(t, 0, user.Age)
+	})
+
+	t.Run("should return FieldError with message of negative age", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		negative := -1
+		userService := service.NewUserService(&userRepositoryMock{}, &txManagerMock{})
+
+		// Act
+		_, err := userService.Patch(context.Background(), &service.UserPatch{ID: 1, Age: &negative}, service.MatchVersion(1))
+
+		// Assert
+		assert.Equal(t, &service.FieldError{Field: "age", Message: "must not be negative"}, err)
+	})
+
+	t.Run("should return FieldError for invalid present field", func(t *testing.T) {
+		t.Parallel()
+
+		blank := " "
+		notEmail := "Name <name@email.com>"
+		negative := -1
+		tests := map[string]struct {
+			patch service.UserPatch
+			field string
+		}{
+			"blank name":    {service.UserPatch{ID: 1, Name: &blank}, "name"},
+			"invalid email": {service.UserPatch{ID: 1, Email: &notEmail}, "email"},
+			"negative age":  {service.UserPatch{ID: 1, Age: &negative}, "age"},
+		}
+		for name, test := range tests {
+			test := test
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				// Arrange
+				userService := service.NewUserService(&userRepositoryMock{}, &txManagerMock{})
+
+				// Act
+				_, err := userService.Patch(context.Background(), &test.patch, service.MatchVersion(1))
+
+				// Assert
+				var fieldError *service.FieldError
+				require.ErrorAs(t, err, &fieldError)
+				assert.Equal(t, test.field, fieldError.Field)
+			})
+		}
+	})
+
+	t.Run("should convert repository errors", func(t *testing.T) {
+		t.Parallel()
+
+		tests := map[error]error{
+			repository.ErrUserNotFound:      service.ErrUserNotFound,
+			repository.ErrUserAlreadyExists: service.ErrUserAlreadyExists,
+			repository.ErrVersionConflict:   service.ErrVersionConflict,
+		}
+		for repositoryErr, serviceErr := range tests {
+			repositoryErr, serviceErr := repositoryErr, serviceErr
+			t.Run(repositoryErr.Error(), func(t *testing.T) {
+				t.Parallel()
+
+				// Arrange
+				userRepositoryMock := &userRepositoryMock{
+					PatchFunc: func(ctx context.Context, patch *repository.UserPatch, match repository.VersionMatch) (*repository.User, error) {
+						return nil, repositoryErr
+					},
+				}
+				userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+				// Act
+				_, err := userService.Patch(context.Background(), &service.UserPatch{ID: 1}, service.MatchVersion(1))
+
+				// Assert
+				assert.Equal(t, serviceErr, err)
+			})
+		}
+	})
+}
+
+func TestPatchCurrent(t *testing.T) {
+	t.Parallel()
+	t.Run("should patch user read within transaction at the version it was read", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		name := "Patched"
+		current := USER1_REPOSITORY
+		current.Version = 4
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				assert.True(t, withinTx(ctx))
+				return &current, nil
+			},
+			PatchFunc: func(ctx context.Context, patch *repository.UserPatch, match repository.VersionMatch) (*repository.User, error) {
+				assert.True(t, withinTx(ctx))
+				assert.Equal(t, &repository.UserPatch{ID: 1, Name: &name}, patch)
+				assert.Equal(t, repository.MatchVersion(4), match)
+				patched := current
+				patched.Name = name
+				patched.Version = 5
+				return &patched, nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		user, err := userService.PatchCurrent(context.Background(), 1, service.VersionMatch{Any: true}, func(user *service.User) (*service.UserPatch, error) {
+			assert.Equal(t, 4, user.Version)
+			return &service.UserPatch{ID: user.ID, Name: &name}, nil
+		})
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, name, user.Name)
+		assert.Equal(t, 5, user.Version)
+	})
+
+	t.Run("should return ErrVersionConflict when current version does not match", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				return &USER1_REPOSITORY, nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		_, err := userService.PatchCurrent(context.Background(), 1, service.VersionMatch{Versions: []int{2, 3}}, func(user *service.User) (*service.UserPatch, error) {
+			t.Fatal("patch made from user that does not match")
+			return nil, nil
+		})
+
+		// Assert
+		assert.Equal(t, service.ErrVersionConflict, err)
+	})
+
+	t.Run("should return error of patch", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		errPatch := errors.New("patch failed")
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				return &USER1_REPOSITORY, nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		_, err := userService.PatchCurrent(context.Background(), 1, service.MatchVersion(1), func(user *service.User) (*service.UserPatch, error) {
+			return nil, errPatch
+		})
+
+		// Assert
+		assert.Equal(t, errPatch, err)
+	})
+
+	t.Run("should return ErrUserNotFound", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			GetFunc: func(ctx context.Context, id int) (*repository.User, error) {
+				return nil, repository.ErrUserNotFound
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		_, err := userService.PatchCurrent(context.Background(), 1, service.VersionMatch{Any: true}, func(user *service.User) (*service.UserPatch, error) {
+			return &service.UserPatch{ID: 1}, nil
+		})
+
+		// Assert
+		assert.Equal(t, service.ErrUserNotFound, err)
+	})
+}
+
+func TestDelete(t *testing.T) {
+	t.Parallel()
+	t.Run("should delete user", func(t *testing.T) {
+		t.Parallel()
+
+		deleteCalled := false
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			DeleteFunc: func(ctx context.Context, id int, match repository.VersionMatch) error {
+				assert.Equal(t, 1, id)
+				assert.Equal(t, repository.MatchVersion(3), match)
+				deleteCalled = true
+				return nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		err := userService.Delete(context.Background(), 1, service.MatchVersion(3))
+		require.NoError(t, err)
+
+		// Assert
+		assert.True(t, deleteCalled)
+	})
+
+	t.Run("should return ErrUserNotFound", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			DeleteFunc: func(ctx context.Context, id int, match repository.VersionMatch) error {
+				return repository.ErrUserNotFound
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		err := userService.Delete(context.Background(), 1, service.MatchVersion(3))
+
+		// Assert
+		assert.Equal(t, service.ErrUserNotFound, err)
+	})
+
+	t.Run("should return ErrVersionConflict", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			DeleteFunc: func(ctx context.Context, id int, match repository.VersionMatch) error {
+				return repository.ErrVersionConflict
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		err := userService.Delete(context.Background(), 1, service.MatchVersion(3))
+
+		// Assert
+		assert.Equal(t, service.ErrVersionConflict, err)
+	})
+}
+
+func TestRestore(t *testing.T) {
+	t.Parallel()
+	t.Run("should restore user", func(t *testing.T) {
+		t.Parallel()
+
+		restoreCalled := false
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			RestoreFunc: func(ctx context.Context, id int) error {
+				assert.Equal(t, 1, id)
+				restoreCalled = true
+				return nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		err := userService.Restore(context.Background(), 1)
+		require.NoError(t, err)
+
+		// Assert
+		assert.True(t, restoreCalled)
+	})
+
+	t.Run("should return ErrUserNotFound", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			RestoreFunc: func(ctx context.Context, id int) error {
+				return repository.ErrUserNotFound
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		err := userService.Restore(context.Background(), 1)
+
+		// Assert
+		assert.Equal(t, service.ErrUserNotFound, err)
+	})
+
+	t.Run("should return ErrUserAlreadyExists when email is taken", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			RestoreFunc: func(ctx context.Context, id int) error {
+				return repository.ErrUserAlreadyExists
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		err := userService.Restore(context.Background(), 1)
+
+		// Assert
+		assert.Equal(t, service.ErrUserAlreadyExists, err)
+	})
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	t.Parallel()
+	t.Run("should purge with retention", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			PurgeDeletedFunc: func(ctx context.Context, retention time.Duration) (int64, error) {
+				assert.Equal(t, time.Hour, retention)
+				return 2, nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		purged, err := userService.PurgeDeleted(context.Background(), time.Hour)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, int64(2), purged)
+	})
+}
+
+func TestGetHistory(t *testing.T) {
+	t.Parallel()
+	t.Run("should return history with next id", func(t *testing.T) {
+		t.Parallel()
+
+		changedAt := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			GetHistoryFunc: func(ctx context.Context, query *repository.UserHistoryPageQuery) ([]*repository.UserHistoryEntry, error) {
+				assert.Equal(t, &repository.UserHistoryPageQuery{UserID: 1, AfterID: 0, Limit: 2}, query)
+				return []*repository.UserHistoryEntry{
+					{ID: 10, UserID: 1, Operation: repository.HistoryOperationCreate, ChangedBy: "admin", ChangedAt: changedAt, After: &USER1_REPOSITORY},
+					{ID: 11, UserID: 1, Operation: repository.HistoryOperationDelete, ChangedBy: "admin", ChangedAt: changedAt, Before: &USER1_REPOSITORY},
+				}, nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		page, err := userService.GetHistory(context.Background(), &service.UserHistoryPageQuery{UserID: 1, Limit: 1})
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, []*service.UserHistoryEntry{
+			{ID: 10, UserID: 1, Operation: "create", ChangedBy: "admin", ChangedAt: changedAt, After: &USER1_SERVICE},
+		}, page.Entries)
+		assert.Equal(t, 10, page.NextAfterID)
+	})
+
+	t.Run("should return ErrInvalidPageSize when limit is too large", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userService := service.NewUserService(&userRepositoryMock{}, &txManagerMock{})
+
+		// Act
+		_, err := userService.GetHistory(context.Background(), &service.UserHistoryPageQuery{UserID: 1, Limit: service.MaxPageSize + 1})
+
+		// Assert
+		assert.Equal(t, service.ErrInvalidPageSize, err)
+	})
+}
+
+func TestCreateBatch(t *testing.T) {
+	t.Parallel()
+	t.Run("should return created users and conflicts in order", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			CreateBatchFunc: func(ctx context.Context, users []*repository.User, atomic bool) ([]*repository.User, error) {
+				assert.False(t, atomic)
+				assert.Equal(t, []*repository.User{
+					{Name: USER1_SERVICE.Name, Email: USER1_SERVICE.Email, Age: USER1_SERVICE.Age},
+					{Name: USER1_SERVICE.Name, Email: USER1_SERVICE.Email, Age: USER1_SERVICE.Age},
+				}, users)
+				return []*repository.User{&USER1_REPOSITORY, nil}, nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+		newUser := &service.User{Name: USER1_SERVICE.Name, Email: USER1_SERVICE.Email, Age: USER1_SERVICE.Age}
+
+		// Act
+		results, err := userService.CreateBatch(context.Background(), []*service.User{newUser, newUser}, false)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, []*service.BatchCreateResult{
+			{User: &USER1_SERVICE},
+			{Err: service.ErrUserAlreadyExists},
+		}, results)
+	})
+
+	t.Run("should abort the other users of an atomic batch on conflict", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			CreateBatchFunc: func(ctx context.Context, users []*repository.User, atomic bool) ([]*repository.User, error) {
+				assert.True(t, atomic)
+				return nil, &repository.BatchConflictError{Indexes: []int{1}}
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		results, err := userService.CreateBatch(context.Background(), []*service.User{&USER1_SERVICE, &USER1_SERVICE, &USER1_SERVICE}, true)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, []*service.BatchCreateResult{
+			{Err: service.ErrBatchAborted},
+			{Err: service.ErrUserAlreadyExists},
+			{Err: service.ErrBatchAborted},
+		}, results)
+	})
+
+	t.Run("should return error when repository fails", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		repositoryErr := errors.New("connection refused")
+		userRepositoryMock := &userRepositoryMock{
+			CreateBatchFunc: func(ctx context.Context, users []*repository.User, atomic bool) ([]*repository.User, error) {
+				return nil, repositoryErr
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		_, err := userService.CreateBatch(context.Background(), []*service.User{&USER1_SERVICE}, true)
+
+		// Assert
+		assert.Equal(t, repositoryErr, err)
+	})
+
+	t.Run("should return FieldError when batch is empty", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userService := service.NewUserService(&userRepositoryMock{}, &txManagerMock{})
+
+		// Act
+		_, err := userService.CreateBatch(context.Background(), []*service.User{}, true)
+
+		// Assert
+		var fieldError *service.FieldError
+		require.ErrorAs(t, err, &fieldError)
+		assert.Equal(t, "users", fieldError.Field)
+	})
+}
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+	t.Run("should call fn with every user", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userRepositoryMock := &userRepositoryMock{
+			ExportFunc: func(ctx context.Context, filter *repository.UserFilter, fn func(user *repository.User) error) error {
+				assert.Equal(t, &repository.UserFilter{EmailDomain: "email.com"}, filter)
+				for _, user := range []*repository.User{&USER1_REPOSITORY, &USER2_REPOSITORY} {
+					if err := fn(user); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		exported := []*service.User{}
+		err := userService.Export(context.Background(), &service.UserFilter{EmailDomain: "email.com"}, func(user *service.User) error {
+			exported = append(exported, user)
+			return nil
+		})
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, []*service.User{&USER1_SERVICE, &USER2_SERVICE}, exported)
+	})
+
+	t.Run("should stop at the first error of fn", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		writeErr := errors.New("broken pipe")
+		userRepositoryMock := &userRepositoryMock{
+			ExportFunc: func(ctx context.Context, filter *repository.UserFilter, fn func(user *repository.User) error) error {
+				for _, user := range []*repository.User{&USER1_REPOSITORY, &USER2_REPOSITORY} {
+					if err := fn(user); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		}
+		userService := service.NewUserService(userRepositoryMock, &txManagerMock{})
+
+		// Act
+		calls := 0
+		err := userService.Export(context.Background(), nil, func(user *service.User) error {
+			calls++
+			return writeErr
+		})
+
+		// Assert
+		assert.Equal(t, writeErr, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("should return FieldError when filter is invalid", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		userService := service.NewUserService(&userRepositoryMock{}, &txManagerMock{})
+
+		// Act
+		err := userService.Export(context.Background(), &service.UserFilter{EmailDomain: "a@email.com"}, func(user *service.User) error {
+			return nil
+		})
+
+		// Assert
+		var fieldError *service.FieldError
+		require.ErrorAs(t, err, &fieldError)
+		assert.Equal(t, "email_domain", fieldError.Field)
 	})
 }
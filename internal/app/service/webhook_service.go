@@ -0,0 +1,327 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/pkg/webhook"
+)
+
+const (
+	// minWebhookSecretLength is the shortest secret that can be given to a webhook.
+	minWebhookSecretLength = 16
+	// generatedWebhookSecretBytes is the number of random bytes of a generated secret.
+	generatedWebhookSecretBytes = 32
+)
+
+// WebhookEventTypes are the event types webhooks can subscribe to.
+var WebhookEventTypes = []string{
+	repository.OutboxEventUserCreated,
+	repository.OutboxEventUserUpdated,
+	repository.OutboxEventUserDeleted,
+	repository.OutboxEventUserRestored,
+}
+
+// WebhookService is the service for the webhook resource.
+type WebhookService interface {
+	// GetAll gets all webhooks.
+	GetAll(ctx context.Context) ([]*Webhook, error)
+	// Get gets a webhook by id.
+	Get(ctx context.Context, id int) (*Webhook, error)
+	// Create creates a webhook and returns it as stored, including its secret.
+	Create(ctx context.Context, webhook *Webhook) (*Webhook, error)
+	// Update updates the url, event types and, if one is given, the secret of a webhook.
+	Update(ctx context.Context, webhook *Webhook) error
+	// Delete deletes a webhook together with its deliveries.
+	Delete(ctx context.Context, id int) error
+	// GetDeliveries gets a page of the deliveries to a webhook, oldest delivery first.
+	GetDeliveries(ctx context.Context, query *WebhookDeliveryPageQuery) (*WebhookDeliveryPage, error)
+	// Redeliver delivers the event of a delivery to its webhook again and returns the new delivery, whether or not it succeeded.
+	Redeliver(ctx context.Context, webhookID, deliveryID int) (*WebhookDelivery, error)
+	// Deliver delivers an event to every webhook subscribed to its type, recording each delivery.
+	// A failed delivery is recorded rather than returned, it can be redelivered.
+	Deliver(ctx context.Context, event *WebhookEvent) error
+}
+
+// webhookPayload is the JSON body delivered to webhooks.
+type webhookPayload struct {
+	ID         int64       `json:"id"`
+	Type       string      `json:"type"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	User       webhookUser `json:"user"`
+}
+
+// webhookUser is a user as it is delivered to webhooks.
+type webhookUser struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Age     int    `json:"age"`
+	Version int    `json:"version"`
+}
+
+type webhookService struct {
+	webhookRepository repository.WebhookRepository
+	sender            *webhook.Sender
+}
+
+func NewWebhookService(repository repository.WebhookRepository, sender *webhook.Sender) WebhookService {
+	return &webhookService{
+		webhookRepository: repository,
+		sender:            sender,
+	}
+}
+
+// GetAll gets all webhooks.
+func (s *webhookService) GetAll(ctx context.Context) ([]*Webhook, error) {
+	webhooks, err := s.webhookRepository.GetWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	serviceWebhooks := make([]*Webhook, len(webhooks))
+	for i, webhook := range webhooks {
+		serviceWebhooks[i] = repositoryWebhookToServiceWebhook(webhook)
+	}
+	return serviceWebhooks, nil
+}
+
+// Get gets a webhook by id.
+func (s *webhookService) Get(ctx context.Context, id int) (*Webhook, error) {
+	webhook, err := s.webhookRepository.GetWebhook(ctx, id)
+	if err != nil {
+		return nil, webhookServiceError(err)
+	}
+	return repositoryWebhookToServiceWebhook(webhook), nil
+}
+
+// Create creates a webhook and returns it as stored, including its secret.
+func (s *webhookService) Create(ctx context.Context, webhook *Webhook) (*Webhook, error) {
+	if err := validateWebhook(webhook); err != nil {
+		return nil, err
+	}
+	toCreate := serviceWebhookToRepositoryWebhook(webhook)
+	if toCreate.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+		toCreate.Secret = secret
+	}
+
+	id, err := s.webhookRepository.CreateWebhook(ctx, toCreate)
+	if err != nil {
+		return nil, webhookServiceError(err)
+	}
+	return s.Get(ctx, id)
+}
+
+// Update updates the url, event types and, if one is given, the secret of a webhook.
+func (s *webhookService) Update(ctx context.Context, webhook *Webhook) error {
+	if err := validateWebhook(webhook); err != nil {
+		return err
+	}
+	toUpdate := serviceWebhookToRepositoryWebhook(webhook)
+	if toUpdate.Secret == "" {
+		current, err := s.webhookRepository.GetWebhook(ctx, webhook.ID)
+		if err != nil {
+			return webhookServiceError(err)
+		}
+		toUpdate.Secret = current.Secret
+	}
+	return webhookServiceError(s.webhookRepository.UpdateWebhook(ctx, toUpdate))
+}
+
+// Delete deletes a webhook together with its deliveries.
+func (s *webhookService) Delete(ctx context.Context, id int) error {
+	return webhookServiceError(s.webhookRepository.DeleteWebhook(ctx, id))
+}
+
+// GetDeliveries gets a page of the deliveries to a webhook, oldest delivery first.
+func (s *webhookService) GetDeliveries(ctx context.Context, query *WebhookDeliveryPageQuery) (*WebhookDeliveryPage, error) {
+	if query.Limit < 1 || query.Limit > MaxPageSize {
+		return nil, ErrInvalidPageSize
+	}
+	if _, err := s.webhookRepository.GetWebhook(ctx, query.WebhookID); err != nil {
+		return nil, webhookServiceError(err)
+	}
+
+	// Fetch one extra delivery to find out if there is a next page
+	deliveries, err := s.webhookRepository.GetDeliveries(ctx, &repository.WebhookDeliveryPageQuery{
+		WebhookID: query.WebhookID,
+		AfterID:   query.AfterID,
+		Limit:     query.Limit + 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &WebhookDeliveryPage{}
+	if len(deliveries) > query.Limit {
+		deliveries = deliveries[:query.Limit]
+		page.NextAfterID = deliveries[len(deliveries)-1].ID
+	}
+	page.Deliveries = make([]*WebhookDelivery, len(deliveries))
+	for i, delivery := range deliveries {
+		page.Deliveries[i] = repositoryDeliveryToServiceDelivery(delivery)
+	}
+	return page, nil
+}
+
+// Redeliver delivers the event of a delivery to its webhook again and returns the new delivery, whether or not it succeeded.
+// The event is delivered to the current url of the webhook and signed with its current secret.
+func (s *webhookService) Redeliver(ctx context.Context, webhookID, deliveryID int) (*WebhookDelivery, error) {
+	target, err := s.webhookRepository.GetWebhook(ctx, webhookID)
+	if err != nil {
+		return nil, webhookServiceError(err)
+	}
+	previous, err := s.webhookRepository.GetDelivery(ctx, webhookID, deliveryID)
+	if err != nil {
+		return nil, webhookServiceError(err)
+	}
+
+	delivery, err := s.deliver(ctx, target, previous.EventID, previous.EventType, []byte(previous.Payload))
+	if err != nil {
+		return nil, webhookServiceError(err)
+	}
+	return repositoryDeliveryToServiceDelivery(delivery), nil
+}
+
+// Deliver delivers an event to every webhook subscribed to its type, recording each delivery.
+// A failed delivery is recorded rather than returned, it can be redelivered.
+func (s *webhookService) Deliver(ctx context.Context, event *WebhookEvent) error {
+	webhooks, err := s.webhookRepository.GetWebhooksForEvent(ctx, event.Type)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(webhookPayload{
+		ID:         event.ID,
+		Type:       event.Type,
+		OccurredAt: event.OccurredAt.UTC(),
+		User: webhookUser{
+			ID:      event.User.ID,
+			Name:    event.User.Name,
+			Email:   event.User.Email,
+			Age:     event.User.Age,
+			Version: event.User.Version,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, target := range webhooks {
+		_, err := s.deliver(ctx, target, event.ID, event.Type, payload)
+		// A webhook deleted while the event was delivered no longer wants the event
+		if err != nil && !errors.Is(err, repository.ErrWebhookNotFound) {
+			errs = append(errs, fmt.Errorf("recording delivery to webhook %d: %w", target.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deliver sends the payload of an event to a webhook and records the delivery
+func (s *webhookService) deliver(ctx context.Context, target *repository.Webhook, eventID int64, eventType string, payload []byte) (*repository.WebhookDelivery, error) {
+	result := s.sender.Send(ctx, &webhook.Delivery{
+		URL:       target.URL,
+		Secret:    target.Secret,
+		EventID:   eventID,
+		EventType: eventType,
+		Payload:   payload,
+	})
+	delivery := &repository.WebhookDelivery{
+		WebhookID:  target.ID,
+		EventID:    eventID,
+		EventType:  eventType,
+		Payload:    string(payload),
+		StatusCode: result.StatusCode,
+		Attempts:   result.Attempts,
+	}
+	if result.Err != nil {
+		delivery.Error = result.Err.Error()
+	}
+
+	id, err := s.webhookRepository.CreateDelivery(ctx, delivery)
+	if err != nil {
+		return nil, err
+	}
+	return s.webhookRepository.GetDelivery(ctx, target.ID, id)
+}
+
+// validateWebhook validates the url and event types of a webhook and the secret if one is given.
+// Host names are not resolved, the addresses they resolve to are checked whenever a delivery connects to them.
+func validateWebhook(webhook *Webhook) error {
+	parsed, err := url.Parse(webhook.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return &FieldError{Field: "url", Message: "must be an absolute http or https url"}
+	}
+	if !isPublicWebhookHost(parsed.Hostname()) {
+		return &FieldError{Field: "url", Message: "must not be a loopback, private or link-local address"}
+	}
+	if len(webhook.EventTypes) == 0 {
+		return &FieldError{Field: "event_types", Message: "must contain at least one event type"}
+	}
+	for _, eventType := range webhook.EventTypes {
+		if !isWebhookEventType(eventType) {
+			return &FieldError{Field: "event_types", Message: fmt.Sprintf("unknown event type %q", eventType)}
+		}
+	}
+	if webhook.Secret != "" && len(webhook.Secret) < minWebhookSecretLength {
+		return &FieldError{Field: "secret", Message: fmt.Sprintf("must be at least %d characters", minWebhookSecretLength)}
+	}
+	return nil
+}
+
+// isPublicWebhookHost returns true unless the host is an address that is not public or a name of the local host.
+func isPublicWebhookHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return webhook.IsPublicAddress(ip)
+	}
+	return true
+}
+
+// isWebhookEventType returns true if webhooks can subscribe to the event type.
+func isWebhookEventType(eventType string) bool {
+	for _, known := range WebhookEventTypes {
+		if eventType == known {
+			return true
+		}
+	}
+	return false
+}
+
+// generateWebhookSecret generates a random hex encoded secret.
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, generatedWebhookSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// webhookServiceError converts repository webhook errors to service errors.
+func webhookServiceError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrWebhookNotFound):
+		return ErrWebhookNotFound
+	case errors.Is(err, repository.ErrDeliveryNotFound):
+		return ErrDeliveryNotFound
+	}
+	return constraintServiceError(err)
+}
@@ -0,0 +1,450 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/internal/app/repository"
+	"github.com/tobiassundman/go-demo-app/internal/app/service"
+	"github.com/tobiassundman/go-demo-app/pkg/webhook"
+)
+
+const webhookSecret = "0123456789abcdef"
+
+var USER_CREATED_EVENT = service.WebhookEvent{
+	ID:         11,
+	Type:       repository.OutboxEventUserCreated,
+	OccurredAt: time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC),
+	User:       &USER1_SERVICE,
+}
+
+var _ repository.WebhookRepository = &webhookRepositoryMock{}
+
+type webhookRepositoryMock struct {
+	GetWebhooksFunc         func(ctx context.Context) ([]*repository.Webhook, error)
+	GetWebhookFunc          func(ctx context.Context, id int) (*repository.Webhook, error)
+	GetWebhooksForEventFunc func(ctx context.Context, eventType string) ([]*repository.Webhook, error)
+	CreateWebhookFunc       func(ctx context.Context, webhook *repository.Webhook) (int, error)
+	UpdateWebhookFunc       func(ctx context.Context, webhook *repository.Webhook) error
+	DeleteWebhookFunc       func(ctx context.Context, id int) error
+	CreateDeliveryFunc      func(ctx context.Context, delivery *repository.WebhookDelivery) (int, error)
+	GetDeliveryFunc         func(ctx context.Context, webhookID, id int) (*repository.WebhookDelivery, error)
+	GetDeliveriesFunc       func(ctx context.Context, query *repository.WebhookDeliveryPageQuery) ([]*repository.WebhookDelivery, error)
+}
+
+func (m *webhookRepositoryMock) GetWebhooks(ctx context.Context) ([]*repository.Webhook, error) {
+	return m.GetWebhooksFunc(ctx)
+}
+
+func (m *webhookRepositoryMock) GetWebhook(ctx context.Context, id int) (*repository.Webhook, error) {
+	return m.GetWebhookFunc(ctx, id)
+}
+
+func (m *webhookRepositoryMock) GetWebhooksForEvent(ctx context.Context, eventType string) ([]*repository.Webhook, error) {
+	return m.GetWebhooksForEventFunc(ctx, eventType)
+}
+
+func (m *webhookRepositoryMock) CreateWebhook(ctx context.Context, webhook *repository.Webhook) (int, error) {
+	return m.CreateWebhookFunc(ctx, webhook)
+}
+
+func (m *webhookRepositoryMock) UpdateWebhook(ctx context.Context, webhook *repository.Webhook) error {
+	return m.UpdateWebhookFunc(ctx, webhook)
+}
+
+func (m *webhookRepositoryMock) DeleteWebhook(ctx context.Context, id int) error {
+	return m.DeleteWebhookFunc(ctx, id)
+}
+
+func (m *webhookRepositoryMock) CreateDelivery(ctx context.Context, delivery *repository.WebhookDelivery) (int, error) {
+	return m.CreateDeliveryFunc(ctx, delivery)
+}
+
+func (m *webhookRepositoryMock) GetDelivery(ctx context.Context, webhookID, id int) (*repository.WebhookDelivery, error) {
+	return m.GetDeliveryFunc(ctx, webhookID, id)
+}
+
+func (m *webhookRepositoryMock) GetDeliveries(ctx context.Context, query *repository.WebhookDeliveryPageQuery) ([]*repository.WebhookDelivery, error) {
+	return m.GetDeliveriesFunc(ctx, query)
+}
+
+// receivedDelivery is a delivery received by a webhookReceiver.
+type receivedDelivery struct {
+	Header http.Header
+	Body   []byte
+	// VerifyErr is the result of verifying the signature of the delivery with webhookSecret.
+	VerifyErr error
+}
+
+// webhookReceiver is a webhook endpoint that records the deliveries it receives and answers with the next of its statuses.
+type webhookReceiver struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	received []*receivedDelivery
+}
+
+// newWebhookReceiver starts a webhook endpoint answering with the given statuses in order, and 200 once they are used up.
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	receiver := &webhookReceiver{statuses: statuses}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.mutex.Lock()
+		defer receiver.mutex.Unlock()
+		receiver.received = append(receiver.received, &receivedDelivery{
+			Header:    r.Header,
+			Body:      body,
+			VerifyErr: webhook.Verify(webhookSecret, r.Header.Get(webhook.TimestampHeader), r.Header.Get(webhook.SignatureHeader), body, time.Minute),
+		})
+		status := http.StatusOK
+		if len(receiver.statuses) > 0 {
+			status = receiver.statuses[0]
+			receiver.statuses = receiver.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+// Received returns the deliveries received so far.
+func (r *webhookReceiver) Received() []*receivedDelivery {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]*receivedDelivery{}, r.received...)
+}
+
+// createReceiverWebhook stores a webhook for a receiver in the repository, as the service refuses the loopback address of receivers.
+func createReceiverWebhook(t *testing.T, webhookRepository *repository.InMemoryWebhookRepository, receiver *webhookReceiver, eventType string) int {
+	id, err := webhookRepository.CreateWebhook(tenantContext(), &repository.Webhook{
+		URL:        receiver.URL,
+		Secret:     webhookSecret,
+		EventTypes: []string{eventType},
+	})
+	require.NoError(t, err)
+	return id
+}
+
+// newDeliveringWebhookService creates a webhook service over an in-memory repository that retries deliveries for up to five seconds.
+func newDeliveringWebhookService() (service.WebhookService, *repository.InMemoryWebhookRepository) {
+	webhookRepository := repository.NewInMemoryWebhookRepository()
+	return service.NewWebhookService(webhookRepository, webhook.NewSender(http.DefaultClient, time.Second*5)), webhookRepository
+}
+
+func TestCreateWebhook(t *testing.T) {
+	t.Parallel()
+	t.Run("should create webhook with generated secret", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		var created *repository.Webhook
+		webhookRepositoryMock := &webhookRepositoryMock{
+			CreateWebhookFunc: func(ctx context.Context, webhook *repository.Webhook) (int, error) {
+				created = webhook
+				return 3, nil
+			},
+			GetWebhookFunc: func(ctx context.Context, id int) (*repository.Webhook, error) {
+				assert.Equal(t, 3, id)
+				stored := *created
+				stored.ID = id
+				return &stored, nil
+			},
+		}
+		webhookService := service.NewWebhookService(webhookRepositoryMock, nil)
+
+		// Act
+		webhook, err := webhookService.Create(tenantContext(), &service.Webhook{
+			URL:        "https://partner.example.com/hooks",
+			EventTypes: []string{repository.OutboxEventUserCreated},
+		})
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, 3, webhook.ID)
+		assert.Equal(t, "https://partner.example.com/hooks", webhook.URL)
+		assert.Equal(t, []string{repository.OutboxEventUserCreated}, webhook.EventTypes)
+		assert.Len(t, webhook.Secret, 64)
+	})
+
+	t.Run("should return FieldError for invalid webhook", func(t *testing.T) {
+		t.Parallel()
+
+		tests := map[string]struct {
+			webhook service.Webhook
+			field   string
+		}{
+			"relative url":       {service.Webhook{URL: "/hooks", EventTypes: []string{repository.OutboxEventUserCreated}}, "url"},
+			"unsupported scheme": {service.Webhook{URL: "ftp://partner.example.com", EventTypes: []string{repository.OutboxEventUserCreated}}, "url"},
+			"loopback address":   {service.Webhook{URL: "http://127.0.0.1:8080/hooks", EventTypes: []string{repository.OutboxEventUserCreated}}, "url"},
+			"localhost":          {service.Webhook{URL: "http://localhost/hooks", EventTypes: []string{repository.OutboxEventUserCreated}}, "url"},
+			"private address":    {service.Webhook{URL: "https://[fd00::1]/hooks", EventTypes: []string{repository.OutboxEventUserCreated}}, "url"},
+			"metadata endpoint":  {service.Webhook{URL: "http://169.254.169.254/latest/meta-data", EventTypes: []string{repository.OutboxEventUserCreated}}, "url"},
+			"no event types":     {service.Webhook{URL: "https://partner.example.com"}, "event_types"},
+			"unknown event type": {service.Webhook{URL: "https://partner.example.com", EventTypes: []string{"user.purged"}}, "event_types"},
+			"short secret":       {service.Webhook{URL: "https://partner.example.com", EventTypes: []string{repository.OutboxEventUserCreated}, Secret: "short"}, "secret"},
+		}
+		for name, test := range tests {
+			test := test
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				// Arrange
+				webhookService := service.NewWebhookService(&webhookRepositoryMock{}, nil)
+
+				// Act
+				_, err := webhookService.Create(tenantContext(), &test.webhook)
+
+				// Assert
+				var fieldError *service.FieldError
+				require.ErrorAs(t, err, &fieldError)
+				assert.Equal(t, test.field, fieldError.Field)
+			})
+		}
+	})
+}
+
+func TestUpdateWebhook(t *testing.T) {
+	t.Parallel()
+	t.Run("should keep secret if none is given", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		var updated *repository.Webhook
+		webhookRepositoryMock := &webhookRepositoryMock{
+			GetWebhookFunc: func(ctx context.Context, id int) (*repository.Webhook, error) {
+				return &repository.Webhook{ID: id, Secret: webhookSecret}, nil
+			},
+			UpdateWebhookFunc: func(ctx context.Context, webhook *repository.Webhook) error {
+				updated = webhook
+				return nil
+			},
+		}
+		webhookService := service.NewWebhookService(webhookRepositoryMock, nil)
+
+		// Act
+		err := webhookService.Update(tenantContext(), &service.Webhook{
+			ID:         2,
+			URL:        "https://partner.example.com/hooks",
+			EventTypes: []string{repository.OutboxEventUserDeleted},
+		})
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, &repository.Webhook{
+			ID:         2,
+			URL:        "https://partner.example.com/hooks",
+			Secret:     webhookSecret,
+			EventTypes: []string{repository.OutboxEventUserDeleted},
+		}, updated)
+	})
+
+	t.Run("should return ErrWebhookNotFound", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		webhookRepositoryMock := &webhookRepositoryMock{
+			UpdateWebhookFunc: func(ctx context.Context, webhook *repository.Webhook) error {
+				return repository.ErrWebhookNotFound
+			},
+		}
+		webhookService := service.NewWebhookService(webhookRepositoryMock, nil)
+
+		// Act
+		err := webhookService.Update(tenantContext(), &service.Webhook{
+			ID:         2,
+			URL:        "https://partner.example.com/hooks",
+			Secret:     webhookSecret,
+			EventTypes: []string{repository.OutboxEventUserDeleted},
+		})
+
+		// Assert
+		assert.Equal(t, service.ErrWebhookNotFound, err)
+	})
+}
+
+func TestGetDeliveries(t *testing.T) {
+	t.Parallel()
+	t.Run("should return ErrWebhookNotFound for unknown webhook", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		webhookRepositoryMock := &webhookRepositoryMock{
+			GetWebhookFunc: func(ctx context.Context, id int) (*repository.Webhook, error) {
+				return nil, repository.ErrWebhookNotFound
+			},
+		}
+		webhookService := service.NewWebhookService(webhookRepositoryMock, nil)
+
+		// Act
+		_, err := webhookService.GetDeliveries(tenantContext(), &service.WebhookDeliveryPageQuery{WebhookID: 1, Limit: 10})
+
+		// Assert
+		assert.Equal(t, service.ErrWebhookNotFound, err)
+	})
+
+	t.Run("should return next cursor if there are more deliveries", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		webhookRepositoryMock := &webhookRepositoryMock{
+			GetWebhookFunc: func(ctx context.Context, id int) (*repository.Webhook, error) {
+				return &repository.Webhook{ID: id}, nil
+			},
+			GetDeliveriesFunc: func(ctx context.Context, query *repository.WebhookDeliveryPageQuery) ([]*repository.WebhookDelivery, error) {
+				assert.Equal(t, &repository.WebhookDeliveryPageQuery{WebhookID: 1, AfterID: 4, Limit: 3}, query)
+				return []*repository.WebhookDelivery{{ID: 5}, {ID: 6}, {ID: 7}}, nil
+			},
+		}
+		webhookService := service.NewWebhookService(webhookRepositoryMock, nil)
+
+		// Act
+		page, err := webhookService.GetDeliveries(tenantContext(), &service.WebhookDeliveryPageQuery{WebhookID: 1, AfterID: 4, Limit: 2})
+		require.NoError(t, err)
+
+		// Assert
+		require.Len(t, page.Deliveries, 2)
+		assert.Equal(t, 6, page.NextAfterID)
+	})
+}
+
+func TestDeliver(t *testing.T) {
+	t.Parallel()
+	t.Run("should deliver signed event to subscribed webhooks and record deliveries", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		subscribed := newWebhookReceiver(t)
+		unsubscribed := newWebhookReceiver(t)
+		webhookService, webhookRepository := newDeliveringWebhookService()
+		createdID := createReceiverWebhook(t, webhookRepository, subscribed, repository.OutboxEventUserCreated)
+		createReceiverWebhook(t, webhookRepository, unsubscribed, repository.OutboxEventUserDeleted)
+
+		// Act
+		err := webhookService.Deliver(tenantContext(), &USER_CREATED_EVENT)
+		require.NoError(t, err)
+
+		// Assert
+		received := subscribed.Received()
+		require.Len(t, received, 1)
+		assert.Empty(t, unsubscribed.Received())
+		assert.NoError(t, received[0].VerifyErr)
+		assert.Equal(t, repository.OutboxEventUserCreated, received[0].Header.Get(webhook.EventHeader))
+		assert.Equal(t, "11", received[0].Header.Get(webhook.EventIDHeader))
+		assert.JSONEq(t, `{
+			"id": 11,
+			"type": "user.created",
+			"occurred_at": "2023-04-01T12:00:00Z",
+			"user": {"id": 1, "name": "Name Name 1", "email": "email1@email.com", "age": 37, "version": 1}
+		}`, string(received[0].Body))
+
+		deliveries, err := webhookRepository.GetDeliveries(tenantContext(), &repository.WebhookDeliveryPageQuery{WebhookID: createdID, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, int64(11), deliveries[0].EventID)
+		assert.Equal(t, http.StatusOK, deliveries[0].StatusCode)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		assert.Empty(t, deliveries[0].Error)
+	})
+
+	t.Run("should retry failed delivery and record attempts", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		receiver := newWebhookReceiver(t, http.StatusServiceUnavailable)
+		webhookService, webhookRepository := newDeliveringWebhookService()
+		createdID := createReceiverWebhook(t, webhookRepository, receiver, repository.OutboxEventUserCreated)
+
+		// Act
+		err := webhookService.Deliver(tenantContext(), &USER_CREATED_EVENT)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Len(t, receiver.Received(), 2)
+		deliveries, err := webhookRepository.GetDeliveries(tenantContext(), &repository.WebhookDeliveryPageQuery{WebhookID: createdID, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, http.StatusOK, deliveries[0].StatusCode)
+		assert.Equal(t, 2, deliveries[0].Attempts)
+	})
+
+	t.Run("should record failed delivery without returning error", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		receiver := newWebhookReceiver(t, http.StatusNotFound)
+		webhookService, webhookRepository := newDeliveringWebhookService()
+		createdID := createReceiverWebhook(t, webhookRepository, receiver, repository.OutboxEventUserCreated)
+
+		// Act
+		err := webhookService.Deliver(tenantContext(), &USER_CREATED_EVENT)
+
+		// Assert
+		require.NoError(t, err)
+		deliveries, err := webhookRepository.GetDeliveries(tenantContext(), &repository.WebhookDeliveryPageQuery{WebhookID: createdID, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, http.StatusNotFound, deliveries[0].StatusCode)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		assert.Equal(t, "unexpected status 404", deliveries[0].Error)
+	})
+}
+
+func TestRedeliver(t *testing.T) {
+	t.Parallel()
+	t.Run("should deliver recorded payload again as new delivery", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		receiver := newWebhookReceiver(t, http.StatusNotFound)
+		webhookService, webhookRepository := newDeliveringWebhookService()
+		createdID := createReceiverWebhook(t, webhookRepository, receiver, repository.OutboxEventUserCreated)
+		err := webhookService.Deliver(tenantContext(), &USER_CREATED_EVENT)
+		require.NoError(t, err)
+		failed, err := webhookRepository.GetDeliveries(tenantContext(), &repository.WebhookDeliveryPageQuery{WebhookID: createdID, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, failed, 1)
+
+		// Act
+		delivery, err := webhookService.Redeliver(tenantContext(), createdID, failed[0].ID)
+		require.NoError(t, err)
+
+		// Assert
+		assert.NotEqual(t, failed[0].ID, delivery.ID)
+		assert.Equal(t, int64(11), delivery.EventID)
+		assert.Equal(t, http.StatusOK, delivery.StatusCode)
+		assert.Empty(t, delivery.Error)
+		received := receiver.Received()
+		require.Len(t, received, 2)
+		assert.NoError(t, received[1].VerifyErr)
+		assert.Equal(t, received[0].Body, received[1].Body)
+		var payload map[string]any
+		require.NoError(t, json.Unmarshal(received[1].Body, &payload))
+		assert.Equal(t, float64(11), payload["id"])
+	})
+
+	t.Run("should return ErrDeliveryNotFound", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		webhookService, _ := newDeliveringWebhookService()
+		created, err := webhookService.Create(tenantContext(), &service.Webhook{
+			URL:        "https://partner.example.com/hooks",
+			EventTypes: []string{repository.OutboxEventUserCreated},
+		})
+		require.NoError(t, err)
+
+		// Act
+		_, err = webhookService.Redeliver(tenantContext(), created.ID, 1)
+
+		// Assert
+		assert.Equal(t, service.ErrDeliveryNotFound, err)
+	})
+}
@@ -0,0 +1,21 @@
+package actor
+
+import "context"
+
+// Unknown is the actor used when no actor has been set in the context.
+const Unknown = "unknown"
+
+type contextKey struct{}
+
+// NewContext returns a copy of the context carrying the name of who is performing the operation.
+func NewContext(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, contextKey{}, actor)
+}
+
+// FromContext returns the name of who is performing the operation, or Unknown if the context does not carry an actor.
+func FromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(contextKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return Unknown
+}
@@ -0,0 +1,193 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+// States of a circuit breaker, in order of how much they reject.
+const (
+	// StateClosed lets every call through and counts the consecutive failures.
+	StateClosed State = iota
+	// StateHalfOpen lets a limited number of trial calls through to find out if the dependency has recovered.
+	StateHalfOpen
+	// StateOpen rejects every call until the open timeout has passed.
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return fmt.Sprintf("unknown state %d", int(s))
+	}
+}
+
+// ErrOpen is matched by the errors of calls rejected by an open circuit.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// OpenError is returned for a call rejected by an open circuit, RetryAfter is how long until the circuit lets a trial call through.
+type OpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrOpen, e.RetryAfter)
+}
+
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen
+}
+
+// Config configures a Breaker.
+type Config struct {
+	// FailureThreshold is how many consecutive failures open the circuit.
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before it lets trial calls through.
+	OpenTimeout time.Duration
+	// HalfOpenMaxCalls is how many trial calls are let through while the circuit is half-open, the circuit closes when all of them succeed.
+	HalfOpenMaxCalls int
+	// IsFailure tells whether the error of a call is a failure of the dependency, nil counts every error.
+	IsFailure func(err error) bool
+	// OnStateChange is called with the old and new state whenever the state changes, while the breaker is locked.
+	OnStateChange func(from, to State)
+}
+
+// Breaker is a thread-safe circuit breaker, which fails calls fast while a dependency is failing instead of letting every call wait for it.
+type Breaker struct {
+	mutex  sync.Mutex
+	config Config
+	state  State
+	// generation is incremented on every state change, so that calls let through in an earlier state are not counted in the current state
+	generation uint64
+	failures   int
+	// trials and successes count the trial calls let through and succeeded while the circuit is half-open
+	trials    int
+	successes int
+	openedAt  time.Time
+}
+
+// New creates a closed Breaker.
+func New(config Config) *Breaker {
+	if config.FailureThreshold < 1 {
+		config.FailureThreshold = 1
+	}
+	if config.HalfOpenMaxCalls < 1 {
+		config.HalfOpenMaxCalls = 1
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = func(err error) bool { return true }
+	}
+	return &Breaker{config: config}
+}
+
+// Execute runs fn if the circuit lets the call through and counts its error, otherwise it returns an *OpenError without running fn.
+// A panicking fn is counted as a failure.
+func (b *Breaker) Execute(fn func() error) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	failed := true
+	defer func() { done(failed) }()
+	err = fn()
+	failed = err != nil && b.config.IsFailure(err)
+	return err
+}
+
+// Allow returns an *OpenError if the circuit rejects a call.
+// Otherwise the call is let through and must report whether it failed with the returned done func, exactly once.
+func (b *Breaker) Allow() (done func(failed bool), err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	b.halfOpenIfTimedOut(now)
+	switch b.state {
+	case StateOpen:
+		return nil, &OpenError{RetryAfter: b.openedAt.Add(b.config.OpenTimeout).Sub(now)}
+	case StateHalfOpen:
+		if b.trials >= b.config.HalfOpenMaxCalls {
+			// The trial calls are still in flight, the circuit opens again if any of them fails
+			return nil, &OpenError{RetryAfter: b.config.OpenTimeout}
+		}
+		b.trials++
+	}
+
+	generation := b.generation
+	return func(failed bool) { b.done(generation, failed) }, nil
+}
+
+// State returns the current state of the circuit.
+func (b *Breaker) State() State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.halfOpenIfTimedOut(time.Now())
+	return b.state
+}
+
+// done counts the outcome of a call let through in the given generation.
+func (b *Breaker) done(generation uint64, failed bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case StateClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.config.FailureThreshold {
+			b.open(time.Now())
+		}
+	case StateHalfOpen:
+		if failed {
+			b.open(time.Now())
+			return
+		}
+		b.successes++
+		if b.successes >= b.config.HalfOpenMaxCalls {
+			b.setState(StateClosed)
+		}
+	}
+}
+
+// halfOpenIfTimedOut lets trial calls through once the circuit has been open for the open timeout.
+func (b *Breaker) halfOpenIfTimedOut(now time.Time) {
+	if b.state == StateOpen && !now.Before(b.openedAt.Add(b.config.OpenTimeout)) {
+		b.setState(StateHalfOpen)
+	}
+}
+
+// open opens the circuit at the given time.
+func (b *Breaker) open(now time.Time) {
+	b.setState(StateOpen)
+	b.openedAt = now
+}
+
+// setState changes the state and starts counting calls anew.
+func (b *Breaker) setState(state State) {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.failures = 0
+	b.trials = 0
+	b.successes = 0
+	if b.config.OnStateChange != nil && from != state {
+		b.config.OnStateChange(from, state)
+	}
+}
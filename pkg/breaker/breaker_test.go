@@ -0,0 +1,169 @@
+package breaker_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/pkg/breaker"
+)
+
+var errFailed = errors.New("failed")
+
+func fail() error {
+	return errFailed
+}
+
+func succeed() error {
+	return nil
+}
+
+func TestBreaker(t *testing.T) {
+	t.Parallel()
+
+	t.Run("opens after consecutive failures", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		circuit := breaker.New(breaker.Config{FailureThreshold: 3, OpenTimeout: time.Minute})
+		calls := 0
+
+		// Act
+		for i := 0; i < 3; i++ {
+			_ = circuit.Execute(func() error {
+				calls++
+				return fail()
+			})
+		}
+		err := circuit.Execute(func() error {
+			calls++
+			return nil
+		})
+
+		// Assert
+		assert.Equal(t, breaker.StateOpen, circuit.State())
+		assert.Equal(t, 3, calls)
+		assert.ErrorIs(t, err, breaker.ErrOpen)
+		openErr := &breaker.OpenError{}
+		require.ErrorAs(t, err, &openErr)
+		assert.InDelta(t, time.Minute, openErr.RetryAfter, float64(time.Second))
+	})
+
+	t.Run("success resets failures", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		circuit := breaker.New(breaker.Config{FailureThreshold: 2, OpenTimeout: time.Minute})
+
+		// Act
+		_ = circuit.Execute(fail)
+		_ = circuit.Execute(succeed)
+		_ = circuit.Execute(fail)
+
+		// Assert
+		assert.Equal(t, breaker.StateClosed, circuit.State())
+	})
+
+	t.Run("does not count errors that are not failures", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		errNotFound := errors.New("not found")
+		circuit := breaker.New(breaker.Config{
+			FailureThreshold: 1,
+			OpenTimeout:      time.Minute,
+			IsFailure:        func(err error) bool { return !errors.Is(err, errNotFound) },
+		})
+
+		// Act
+		err := circuit.Execute(func() error { return errNotFound })
+
+		// Assert
+		assert.Equal(t, errNotFound, err)
+		assert.Equal(t, breaker.StateClosed, circuit.State())
+	})
+
+	t.Run("closes when trial calls succeed", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		changes := []breaker.State{}
+		circuit := breaker.New(breaker.Config{
+			FailureThreshold: 1,
+			OpenTimeout:      time.Millisecond * 20,
+			HalfOpenMaxCalls: 2,
+			OnStateChange:    func(from, to breaker.State) { changes = append(changes, to) },
+		})
+		_ = circuit.Execute(fail)
+		time.Sleep(time.Millisecond * 30)
+
+		// Act
+		firstDone, firstErr := circuit.Allow()
+		secondDone, secondErr := circuit.Allow()
+		_, rejectedErr := circuit.Allow()
+		require.NoError(t, firstErr)
+		require.NoError(t, secondErr)
+		firstDone(false)
+		halfOpen := circuit.State()
+		secondDone(false)
+
+		// Assert
+		assert.ErrorIs(t, rejectedErr, breaker.ErrOpen, "only HalfOpenMaxCalls trial calls are let through")
+		assert.Equal(t, breaker.StateHalfOpen, halfOpen)
+		assert.Equal(t, breaker.StateClosed, circuit.State())
+		assert.Equal(t, []breaker.State{breaker.StateOpen, breaker.StateHalfOpen, breaker.StateClosed}, changes)
+	})
+
+	t.Run("opens again when trial call fails", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		circuit := breaker.New(breaker.Config{FailureThreshold: 1, OpenTimeout: time.Millisecond * 20})
+		_ = circuit.Execute(fail)
+		time.Sleep(time.Millisecond * 30)
+
+		// Act
+		err := circuit.Execute(fail)
+
+		// Assert
+		assert.Equal(t, errFailed, err)
+		assert.Equal(t, breaker.StateOpen, circuit.State())
+	})
+
+	t.Run("ignores calls let through before state changed", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		circuit := breaker.New(breaker.Config{FailureThreshold: 1, OpenTimeout: time.Millisecond * 20})
+		slowDone, err := circuit.Allow()
+		require.NoError(t, err)
+		_ = circuit.Execute(fail)
+		time.Sleep(time.Millisecond * 30)
+		trialDone, err := circuit.Allow()
+		require.NoError(t, err)
+
+		// Act
+		slowDone(true)
+		trialDone(false)
+
+		// Assert
+		assert.Equal(t, breaker.StateClosed, circuit.State())
+	})
+
+	t.Run("counts panic as failure", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		circuit := breaker.New(breaker.Config{FailureThreshold: 1, OpenTimeout: time.Minute})
+
+		// Act
+		assert.Panics(t, func() {
+			_ = circuit.Execute(func() error { panic("boom") })
+		})
+
+		// Assert
+		assert.Equal(t, breaker.StateOpen, circuit.State())
+	})
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// entry is a cached value together with when it stops being fresh
+type entry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+// LRU is a thread-safe cache holding up to a fixed number of values, evicting the least recently used value when it is full.
+// Values stop being fresh after the TTL but are kept until they are evicted, so that they can still be served when they cannot be refreshed.
+type LRU[K comparable, V any] struct {
+	mutex    sync.Mutex
+	capacity int
+	ttl      time.Duration
+	// order holds the entries from most to least recently used
+	order *list.List
+	items map[K]*list.Element
+}
+
+// NewLRU creates a new LRU holding up to capacity values that are fresh for the TTL.
+func NewLRU[K comparable, V any](capacity int, ttl time.Duration) *LRU[K, V] {
+	return &LRU[K, V]{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		items:    map[K]*list.Element{},
+	}
+}
+
+// Get returns the value cached for the key, whether it is still fresh and whether there is a value at all.
+func (c *LRU[K, V]) Get(key K) (value V, fresh bool, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return value, false, false
+	}
+	c.order.MoveToFront(element)
+	cached := element.Value.(*entry[K, V])
+	return cached.value, time.Now().Before(cached.expiresAt), true
+}
+
+// Add caches a fresh value for the key, returning true if the least recently used value was evicted to make room for it.
+func (c *LRU[K, V]) Add(key K, value V) (evicted bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	expiresAt := time.Now().Add(c.ttl)
+	if element, ok := c.items[key]; ok {
+		c.order.MoveToFront(element)
+		cached := element.Value.(*entry[K, V])
+		cached.value = value
+		cached.expiresAt = expiresAt
+		return false
+	}
+
+	c.items[key] = c.order.PushFront(&entry[K, V]{key: key, value: value, expiresAt: expiresAt})
+	if c.order.Len() <= c.capacity {
+		return false
+	}
+	oldest := c.order.Back()
+	c.order.Remove(oldest)
+	delete(c.items, oldest.Value.(*entry[K, V]).key)
+	return true
+}
+
+// Remove removes the value cached for the key, if any.
+func (c *LRU[K, V]) Remove(key K) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.order.Remove(element)
+		delete(c.items, key)
+	}
+}
+
+// Len returns the number of cached values, fresh or not.
+func (c *LRU[K, V]) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,101 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobiassundman/go-demo-app/pkg/cache"
+)
+
+func TestLRU(t *testing.T) {
+	t.Parallel()
+	t.Run("returns fresh value", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		lru := cache.NewLRU[string, int](2, time.Minute)
+		lru.Add("a", 1)
+
+		// Act
+		value, fresh, ok := lru.Get("a")
+		_, _, missingOk := lru.Get("b")
+
+		// Assert
+		assert.Equal(t, 1, value)
+		assert.True(t, fresh)
+		assert.True(t, ok)
+		assert.False(t, missingOk)
+	})
+
+	t.Run("keeps value after TTL as not fresh", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		lru := cache.NewLRU[string, int](2, time.Millisecond)
+		lru.Add("a", 1)
+		time.Sleep(10 * time.Millisecond)
+
+		// Act
+		value, fresh, ok := lru.Get("a")
+
+		// Assert
+		assert.Equal(t, 1, value)
+		assert.False(t, fresh)
+		assert.True(t, ok)
+	})
+
+	t.Run("evicts least recently used value", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		lru := cache.NewLRU[string, int](2, time.Minute)
+		lru.Add("a", 1)
+		lru.Add("b", 2)
+		lru.Get("a")
+
+		// Act
+		evicted := lru.Add("c", 3)
+
+		// Assert
+		assert.True(t, evicted)
+		assert.Equal(t, 2, lru.Len())
+		_, _, aOk := lru.Get("a")
+		_, _, bOk := lru.Get("b")
+		assert.True(t, aOk)
+		assert.False(t, bOk)
+	})
+
+	t.Run("replaces value without evicting", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		lru := cache.NewLRU[string, int](1, time.Minute)
+		lru.Add("a", 1)
+
+		// Act
+		evicted := lru.Add("a", 2)
+
+		// Assert
+		value, _, _ := lru.Get("a")
+		assert.False(t, evicted)
+		assert.Equal(t, 2, value)
+	})
+
+	t.Run("removes value", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		lru := cache.NewLRU[string, int](2, time.Minute)
+		lru.Add("a", 1)
+
+		// Act
+		lru.Remove("a")
+		lru.Remove("b")
+
+		// Assert
+		_, _, ok := lru.Get("a")
+		assert.False(t, ok)
+		assert.Equal(t, 0, lru.Len())
+	})
+}
@@ -1,31 +1,99 @@
 package database
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
-	"github.com/jackc/pgx"
-	"github.com/jackc/pgx/stdlib"
-	"github.com/jmoiron/sqlx"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/tobiassundman/go-demo-app/pkg/retry"
 )
 
-// UserDatabaseConnection creates a connection to the user database and retries ping until it succeeds or times out
-func UserDatabaseConnection(host, port, user, password, name string) (*sqlx.DB, error) {
+// statementCacheCapacity is how many prepared statements each connection keeps, the queries built from filters and patches are cached too
+const statementCacheCapacity = 512
+
+// ErrInvalidPoolConfig is returned for a PoolConfig that cannot be used.
+var ErrInvalidPoolConfig = errors.New("invalid pool config")
+
+// PoolConfig configures the connection pool of a database.
+type PoolConfig struct {
+	// MaxConns is the most connections the pool opens, every instance of the app together must stay below max_connections of Postgres.
+	MaxConns int32
+	// MinConns is how many connections the pool keeps open while idle, idle connections above it are closed after MaxConnIdleTime.
+	MinConns int32
+	// MaxConnLifetime is how long a connection is used before it is closed and replaced.
+	MaxConnLifetime time.Duration
+	// MaxConnIdleTime is how long a connection above MinConns is kept open without being used.
+	MaxConnIdleTime time.Duration
+}
+
+// DefaultPoolConfig returns the pool configuration used when none is configured.
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		MaxConns:        10,
+		MinConns:        0,
+		MaxConnLifetime: time.Hour,
+		MaxConnIdleTime: time.Minute * 30,
+	}
+}
+
+// Validate returns an error wrapping ErrInvalidPoolConfig if the configuration cannot be used.
+func (c PoolConfig) Validate() error {
+	switch {
+	case c.MaxConns < 1:
+		return fmt.Errorf("%w: max conns must be at least 1, got %d", ErrInvalidPoolConfig, c.MaxConns)
+	case c.MinConns < 0 || c.MinConns > c.MaxConns:
+		return fmt.Errorf("%w: min conns must be between 0 and max conns %d, got %d", ErrInvalidPoolConfig, c.MaxConns, c.MinConns)
+	case c.MaxConnLifetime <= 0:
+		return fmt.Errorf("%w: max conn lifetime must be positive, got %s", ErrInvalidPoolConfig, c.MaxConnLifetime)
+	case c.MaxConnIdleTime <= 0:
+		return fmt.Errorf("%w: max conn idle time must be positive, got %s", ErrInvalidPoolConfig, c.MaxConnIdleTime)
+	}
+	return nil
+}
+
+// Pool is a pool of connections to a database, implemented by *pgxpool.Pool
+type Pool interface {
+	BeginTx(ctx context.Context, options pgx.TxOptions) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// UserDatabaseConnection creates a connection pool to the user database and retries ping until it succeeds or times out.
+// Every connection of the pool prepares the statements it runs and caches them for later executions.
+// Connections use TLS as configured by the certificates, nil certificates connect without TLS
+func UserDatabaseConnection(host, port, user, password, name string, poolConfig PoolConfig, certificates *Certificates) (*pgxpool.Pool, error) {
+	if err := poolConfig.Validate(); err != nil {
+		return nil, err
+	}
 	configString := fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, name,
 	)
-	connectionString, err := pgx.ParseConnectionString(configString)
+	config, err := pgxpool.ParseConfig(configString)
 	if err != nil {
 		return nil, err
 	}
-	connection := stdlib.OpenDB(connectionString)
+	// The TLS configuration replaces the one pgx would build from the sslmode of the connection string, which cannot reload certificates
+	config.ConnConfig.TLSConfig = certificates.tlsConfig(host)
+	config.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+	config.ConnConfig.StatementCacheCapacity = statementCacheCapacity
+	config.MaxConns = poolConfig.MaxConns
+	config.MinConns = poolConfig.MinConns
+	config.MaxConnLifetime = poolConfig.MaxConnLifetime
+	config.MaxConnIdleTime = poolConfig.MaxConnIdleTime
 
-	sqlDB := sqlx.NewDb(connection, "pgx")
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		return nil, err
+	}
 
 	err = retry.Retry(time.Minute, func() error {
-		return sqlDB.Ping()
+		return pool.Ping(context.Background())
 	})
-	return sqlDB, err
+	return pool, err
 }
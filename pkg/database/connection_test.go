@@ -0,0 +1,45 @@
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobiassundman/go-demo-app/pkg/database"
+)
+
+func TestPoolConfigValidate(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		modify  func(config *database.PoolConfig)
+		wantErr bool
+	}{
+		{name: "default", modify: func(config *database.PoolConfig) {}},
+		{name: "min conns equal to max conns", modify: func(config *database.PoolConfig) { config.MinConns = config.MaxConns }},
+		{name: "no max conns", modify: func(config *database.PoolConfig) { config.MaxConns = 0 }, wantErr: true},
+		{name: "negative min conns", modify: func(config *database.PoolConfig) { config.MinConns = -1 }, wantErr: true},
+		{name: "min conns above max conns", modify: func(config *database.PoolConfig) { config.MinConns = config.MaxConns + 1 }, wantErr: true},
+		{name: "no max conn lifetime", modify: func(config *database.PoolConfig) { config.MaxConnLifetime = 0 }, wantErr: true},
+		{name: "negative max conn idle time", modify: func(config *database.PoolConfig) { config.MaxConnIdleTime = -time.Second }, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			// Arrange
+			config := database.DefaultPoolConfig()
+			tt.modify(&config)
+
+			// Act
+			err := config.Validate()
+
+			// Assert
+			if tt.wantErr {
+				assert.ErrorIs(t, err, database.ErrInvalidPoolConfig)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
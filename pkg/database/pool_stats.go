@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// PoolStatsCollector is a prometheus.Collector of the statistics of connection pools, labelled with the name of each pool.
+type PoolStatsCollector struct {
+	pools map[string]*pgxpool.Pool
+
+	acquiredConns   *prometheus.Desc
+	idleConns       *prometheus.Desc
+	totalConns      *prometheus.Desc
+	maxConns        *prometheus.Desc
+	acquires        *prometheus.Desc
+	emptyAcquires   *prometheus.Desc
+	acquireDuration *prometheus.Desc
+}
+
+// NewPoolStatsCollector creates a PoolStatsCollector of the pools keyed by name.
+func NewPoolStatsCollector(pools map[string]*pgxpool.Pool) *PoolStatsCollector {
+	labels := []string{"pool"}
+	return &PoolStatsCollector{
+		pools: pools,
+		acquiredConns: prometheus.NewDesc("db_pool_acquired_connections",
+			"Number of connections currently in use.", labels, nil),
+		idleConns: prometheus.NewDesc("db_pool_idle_connections",
+			"Number of idle connections.", labels, nil),
+		totalConns: prometheus.NewDesc("db_pool_total_connections",
+			"Number of open connections, including connections being opened.", labels, nil),
+		maxConns: prometheus.NewDesc("db_pool_max_connections",
+			"Maximum number of open connections.", labels, nil),
+		acquires: prometheus.NewDesc("db_pool_acquires_total",
+			"Number of connections acquired from the pool.", labels, nil),
+		emptyAcquires: prometheus.NewDesc("db_pool_empty_acquires_total",
+			"Number of acquires that waited for a connection to be opened or released because no idle connection was available.", labels, nil),
+		acquireDuration: prometheus.NewDesc("db_pool_acquire_duration_seconds_total",
+			"Total time spent acquiring connections from the pool.", labels, nil),
+	}
+}
+
+// Describe sends the descriptions of the pool statistics.
+func (c *PoolStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquiredConns
+	ch <- c.idleConns
+	ch <- c.totalConns
+	ch <- c.maxConns
+	ch <- c.acquires
+	ch <- c.emptyAcquires
+	ch <- c.acquireDuration
+}
+
+// Collect sends the current statistics of every pool.
+func (c *PoolStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, pool := range c.pools {
+		stat := pool.Stat()
+		ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()), name)
+		ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stat.IdleConns()), name)
+		ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stat.TotalConns()), name)
+		ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(stat.MaxConns()), name)
+		ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(stat.AcquireCount()), name)
+		ch <- prometheus.MustNewConstMetric(c.emptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()), name)
+		ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds(), name)
+	}
+}
+
+// poolWaits is what a PoolWaitMonitor last saw of the acquires of a pool
+type poolWaits struct {
+	emptyAcquires   int64
+	acquireDuration time.Duration
+}
+
+// PoolWaitMonitor warns when acquiring connections from a pool takes long, a sign that the pool is too small for the load.
+type PoolWaitMonitor struct {
+	pools     map[string]*pgxpool.Pool
+	threshold time.Duration
+	logger    *zap.Logger
+	last      map[string]poolWaits
+}
+
+// NewPoolWaitMonitor creates a PoolWaitMonitor of the pools keyed by name,
+// warning when the acquires that waited since the previous check waited longer than the threshold on average.
+func NewPoolWaitMonitor(pools map[string]*pgxpool.Pool, threshold time.Duration, logger *zap.Logger) *PoolWaitMonitor {
+	return &PoolWaitMonitor{
+		pools:     pools,
+		threshold: threshold,
+		logger:    logger,
+		last:      map[string]poolWaits{},
+	}
+}
+
+// Check compares the acquires of every pool with the previous check and logs a warning for the pools whose acquires waited too long.
+// Acquires that found an idle connection take no time, so the time spent acquiring is attributed to the acquires that waited
+func (m *PoolWaitMonitor) Check() {
+	for name, pool := range m.pools {
+		stat := pool.Stat()
+		current := poolWaits{emptyAcquires: stat.EmptyAcquireCount(), acquireDuration: stat.AcquireDuration()}
+		last := m.last[name]
+		m.last[name] = current
+
+		waited := current.emptyAcquires - last.emptyAcquires
+		if waited <= 0 {
+			continue
+		}
+		averageWait := (current.acquireDuration - last.acquireDuration) / time.Duration(waited)
+		if averageWait > m.threshold {
+			m.logger.Warn("Waiting long for database connections, the pool may be too small",
+				zap.String("pool", name), zap.Duration("averageWait", averageWait), zap.Int64("waited", waited),
+				zap.Int32("acquiredConns", stat.AcquiredConns()), zap.Int32("maxConns", stat.MaxConns()))
+		}
+	}
+}
+
+// Run checks the pools every interval until the context is cancelled.
+func (m *PoolWaitMonitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Check()
+		}
+	}
+}
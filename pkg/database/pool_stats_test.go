@@ -0,0 +1,44 @@
+package database_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/pkg/database"
+)
+
+func TestPoolStatsCollector(t *testing.T) {
+	t.Parallel()
+	// Arrange
+	config, err := pgxpool.ParseConfig("host=localhost port=1 user=demo_user dbname=demo_db sslmode=disable")
+	require.NoError(t, err)
+	config.MaxConns = 7
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	collector := database.NewPoolStatsCollector(map[string]*pgxpool.Pool{"primary": pool})
+
+	// Act
+	err = testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP db_pool_acquired_connections Number of connections currently in use.
+# TYPE db_pool_acquired_connections gauge
+db_pool_acquired_connections{pool="primary"} 0
+# HELP db_pool_idle_connections Number of idle connections.
+# TYPE db_pool_idle_connections gauge
+db_pool_idle_connections{pool="primary"} 0
+# HELP db_pool_max_connections Maximum number of open connections.
+# TYPE db_pool_max_connections gauge
+db_pool_max_connections{pool="primary"} 7
+# HELP db_pool_acquires_total Number of connections acquired from the pool.
+# TYPE db_pool_acquires_total counter
+db_pool_acquires_total{pool="primary"} 0
+`), "db_pool_acquired_connections", "db_pool_idle_connections", "db_pool_max_connections", "db_pool_acquires_total")
+
+	// Assert
+	assert.NoError(t, err)
+}
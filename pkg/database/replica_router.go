@@ -0,0 +1,187 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// replicationLagQuery returns how many seconds a replica is behind its primary, a replica that has replayed everything it received has no lag
+// even if the primary has not written for a while, and the primary itself has no lag
+const replicationLagQuery = `SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0 ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END::float8`
+
+// maxPins is the most sessions whose reads are pinned to the primary at a time, so that sessions that write once do not grow the pins without bound
+const maxPins = 100000
+
+// ReplicaRouterConfig configures a ReplicaRouter.
+type ReplicaRouterConfig struct {
+	// MaxLag is the replication lag above which a replica is removed from rotation.
+	MaxLag time.Duration
+	// ReadYourWritesWindow is how long reads of a session go to the primary after it writes, 0 disables read-your-writes.
+	ReadYourWritesWindow time.Duration
+	// Logger logs replicas entering and leaving rotation, nil disables logging.
+	Logger *zap.Logger
+}
+
+// replica is a replica pool that is only read from while it is healthy
+type replica struct {
+	db      Pool
+	healthy atomic.Bool
+}
+
+// ReplicaRouter routes writes to a primary pool and spreads reads over the healthy replica pools.
+// Replicas are unhealthy until they have passed a health check, reads go to the primary while no replica is healthy.
+type ReplicaRouter struct {
+	primary  Pool
+	replicas []*replica
+	next     atomic.Uint32
+	config   ReplicaRouterConfig
+	logger   *zap.Logger
+
+	pinsMutex sync.Mutex
+	// pins holds until when the reads of each session that wrote recently go to the primary, expired pins are removed when they are looked up
+	// and by the health checks
+	pins map[string]time.Time
+}
+
+// NewReplicaRouter creates a new ReplicaRouter, without replicas every read goes to the primary.
+func NewReplicaRouter(primary Pool, replicas []Pool, config ReplicaRouterConfig) *ReplicaRouter {
+	logger := config.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	router := &ReplicaRouter{
+		primary: primary,
+		config:  config,
+		logger:  logger,
+		pins:    map[string]time.Time{},
+	}
+	for _, db := range replicas {
+		router.replicas = append(router.replicas, &replica{db: db})
+	}
+	return router
+}
+
+// Primary returns the primary pool.
+func (r *ReplicaRouter) Primary() Pool {
+	return r.primary
+}
+
+// Reader returns the pool the given session should read from, which is the primary if the session wrote within the read-your-writes window
+// or if no replica is healthy, and otherwise the next healthy replica. The empty session is never pinned to the primary.
+func (r *ReplicaRouter) Reader(session string) Pool {
+	if len(r.replicas) == 0 || r.Pinned(session) {
+		return r.primary
+	}
+	start := r.next.Add(1)
+	for i := range r.replicas {
+		replica := r.replicas[(int(start)+i)%len(r.replicas)]
+		if replica.healthy.Load() {
+			return replica.db
+		}
+	}
+	return r.primary
+}
+
+// Wrote records that the given session wrote to the primary, pinning its reads to the primary for the read-your-writes window.
+// The session must identify a single client, the empty session is not pinned.
+// While the most sessions are pinned and none of their windows has passed, further sessions are not pinned and may read their writes late.
+func (r *ReplicaRouter) Wrote(session string) {
+	if len(r.replicas) == 0 || r.config.ReadYourWritesWindow <= 0 || session == "" {
+		return
+	}
+	r.pinsMutex.Lock()
+	defer r.pinsMutex.Unlock()
+	now := time.Now()
+	if _, ok := r.pins[session]; !ok && len(r.pins) >= maxPins {
+		r.removeExpiredPinsLocked(now)
+		if len(r.pins) >= maxPins {
+			r.logger.Warn("Too many sessions pinned to the primary, reads of the session may not see its writes", zap.Int("pins", len(r.pins)))
+			return
+		}
+	}
+	r.pins[session] = now.Add(r.config.ReadYourWritesWindow)
+}
+
+// Pinned returns true if the reads of the session go to the primary because it wrote within the read-your-writes window,
+// removing its pin if the window has passed.
+func (r *ReplicaRouter) Pinned(session string) bool {
+	if session == "" {
+		return false
+	}
+	r.pinsMutex.Lock()
+	defer r.pinsMutex.Unlock()
+	until, ok := r.pins[session]
+	if !ok {
+		return false
+	}
+	if !time.Now().Before(until) {
+		delete(r.pins, session)
+		return false
+	}
+	return true
+}
+
+// CheckReplicas measures the replication lag of every replica, removing replicas that cannot be reached or lag more than the maximum lag
+// from rotation until a later check finds them healthy again.
+func (r *ReplicaRouter) CheckReplicas(ctx context.Context) {
+	for i, replica := range r.replicas {
+		lag, err := replicationLag(ctx, replica.db)
+		healthy := err == nil && lag <= r.config.MaxLag
+		if replica.healthy.Swap(healthy) == healthy {
+			continue
+		}
+		if healthy {
+			r.logger.Info("Replica added to rotation", zap.Int("replica", i), zap.Duration("lag", lag))
+		} else {
+			r.logger.Warn("Replica removed from rotation", zap.Int("replica", i), zap.Duration("lag", lag), zap.Error(err))
+		}
+	}
+	r.removeExpiredPins()
+}
+
+// RunHealthChecks checks the replicas right away and then every interval until the context is cancelled.
+func (r *ReplicaRouter) RunHealthChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		checkContext, cancel := context.WithTimeout(ctx, interval)
+		r.CheckReplicas(checkContext)
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// removeExpiredPins forgets the sessions whose read-your-writes window has passed
+func (r *ReplicaRouter) removeExpiredPins() {
+	r.pinsMutex.Lock()
+	defer r.pinsMutex.Unlock()
+	r.removeExpiredPinsLocked(time.Now())
+}
+
+// removeExpiredPinsLocked forgets the sessions whose read-your-writes window has passed by now, the caller must hold the pins mutex
+func (r *ReplicaRouter) removeExpiredPinsLocked(now time.Time) {
+	for session, until := range r.pins {
+		if !now.Before(until) {
+			delete(r.pins, session)
+		}
+	}
+}
+
+// replicationLag returns how far behind its primary the database is
+func replicationLag(ctx context.Context, db Pool) (time.Duration, error) {
+	var seconds float64
+	if err := db.QueryRow(ctx, replicationLagQuery).Scan(&seconds); err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
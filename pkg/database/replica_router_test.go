@@ -0,0 +1,182 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/tobiassundman/go-demo-app/pkg/database"
+)
+
+// unreachable is the lag of a fake database that cannot be reached.
+const unreachable = time.Duration(-1)
+
+// fakeDatabase is a database that answers every query with its replication lag in seconds.
+type fakeDatabase struct {
+	lag atomic.Int64
+}
+
+func newFakeDatabase(lag time.Duration) *fakeDatabase {
+	fake := &fakeDatabase{}
+	fake.lag.Store(int64(lag))
+	return fake
+}
+
+func (d *fakeDatabase) BeginTx(ctx context.Context, options pgx.TxOptions) (pgx.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (d *fakeDatabase) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, errors.New("not supported")
+}
+
+func (d *fakeDatabase) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func (d *fakeDatabase) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return &fakeRow{lag: time.Duration(d.lag.Load())}
+}
+
+// fakeRow is a row with the replication lag in seconds as its only column.
+type fakeRow struct {
+	lag time.Duration
+}
+
+func (r *fakeRow) Scan(dest ...any) error {
+	if r.lag == unreachable {
+		return errors.New("connection refused")
+	}
+	*dest[0].(*float64) = r.lag.Seconds()
+	return nil
+}
+
+func TestReader(t *testing.T) {
+	t.Parallel()
+	t.Run("reads from primary without replicas", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		primary := newFakeDatabase(0)
+		router := database.NewReplicaRouter(primary, nil, database.ReplicaRouterConfig{MaxLag: time.Second})
+
+		// Act
+		reader := router.Reader("admin")
+
+		// Assert
+		assert.Same(t, primary, reader)
+	})
+
+	t.Run("reads from primary until replicas are checked", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		primary := newFakeDatabase(0)
+		replica := newFakeDatabase(0)
+		router := database.NewReplicaRouter(primary, []database.Pool{replica}, database.ReplicaRouterConfig{MaxLag: time.Second})
+
+		// Act
+		reader := router.Reader("admin")
+
+		// Assert
+		assert.Same(t, primary, reader)
+	})
+
+	t.Run("spreads reads over healthy replicas", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		primary := newFakeDatabase(0)
+		firstReplica := newFakeDatabase(0)
+		secondReplica := newFakeDatabase(500 * time.Millisecond)
+		router := database.NewReplicaRouter(primary, []database.Pool{firstReplica, secondReplica}, database.ReplicaRouterConfig{MaxLag: time.Second})
+		router.CheckReplicas(context.Background())
+
+		// Act
+		readers := []database.Pool{router.Reader("admin"), router.Reader("admin")}
+
+		// Assert
+		assert.ElementsMatch(t, []database.Pool{firstReplica, secondReplica}, readers)
+	})
+
+	t.Run("removes lagging and unreachable replicas from rotation until they recover", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		primary := newFakeDatabase(0)
+		laggingReplica := newFakeDatabase(0)
+		unreachableReplica := newFakeDatabase(0)
+		router := database.NewReplicaRouter(primary, []database.Pool{laggingReplica, unreachableReplica}, database.ReplicaRouterConfig{MaxLag: time.Second})
+		router.CheckReplicas(context.Background())
+
+		// Act
+		laggingReplica.lag.Store(int64(time.Minute))
+		unreachableReplica.lag.Store(int64(unreachable))
+		router.CheckReplicas(context.Background())
+		readerWhileUnhealthy := router.Reader("admin")
+
+		laggingReplica.lag.Store(0)
+		router.CheckReplicas(context.Background())
+		readerAfterRecovery := router.Reader("admin")
+
+		// Assert
+		assert.Same(t, primary, readerWhileUnhealthy)
+		assert.Same(t, laggingReplica, readerAfterRecovery)
+	})
+
+	t.Run("reads of a session that wrote go to primary within the window", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		primary := newFakeDatabase(0)
+		replica := newFakeDatabase(0)
+		router := database.NewReplicaRouter(primary, []database.Pool{replica}, database.ReplicaRouterConfig{
+			MaxLag:               time.Second,
+			ReadYourWritesWindow: 50 * time.Millisecond,
+		})
+		router.CheckReplicas(context.Background())
+
+		// Act
+		router.Wrote("writer")
+		writerReader := router.Reader("writer")
+		writerPinned := router.Pinned("writer")
+		otherReader := router.Reader("other")
+		otherPinned := router.Pinned("other")
+		time.Sleep(100 * time.Millisecond)
+		writerReaderAfterWindow := router.Reader("writer")
+		writerPinnedAfterWindow := router.Pinned("writer")
+
+		// Assert
+		assert.Same(t, primary, writerReader)
+		assert.True(t, writerPinned)
+		assert.Same(t, replica, otherReader)
+		assert.False(t, otherPinned)
+		assert.Same(t, replica, writerReaderAfterWindow)
+		assert.False(t, writerPinnedAfterWindow)
+	})
+
+	t.Run("reads without a session are never pinned to primary", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		primary := newFakeDatabase(0)
+		replica := newFakeDatabase(0)
+		router := database.NewReplicaRouter(primary, []database.Pool{replica}, database.ReplicaRouterConfig{
+			MaxLag:               time.Second,
+			ReadYourWritesWindow: time.Minute,
+		})
+		router.CheckReplicas(context.Background())
+
+		// Act
+		router.Wrote("")
+		reader := router.Reader("")
+
+		// Assert
+		assert.Same(t, replica, reader)
+	})
+}
@@ -0,0 +1,234 @@
+package database
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SSL modes of the connections to a database, with the same meaning as the sslmode of libpq.
+const (
+	// SSLModeDisable connects without TLS.
+	SSLModeDisable = "disable"
+	// SSLModeRequire connects with TLS without verifying the certificate of the server.
+	SSLModeRequire = "require"
+	// SSLModeVerifyCA connects with TLS and verifies that the certificate of the server is signed by a trusted CA.
+	SSLModeVerifyCA = "verify-ca"
+	// SSLModeVerifyFull connects with TLS and verifies that the certificate of the server is signed by a trusted CA and issued for the host.
+	SSLModeVerifyFull = "verify-full"
+)
+
+// ErrInvalidTLSConfig is returned for a TLSConfig that cannot be used.
+var ErrInvalidTLSConfig = errors.New("invalid tls config")
+
+// TLSConfig configures TLS of the connections to a database.
+type TLSConfig struct {
+	// SSLMode is one of SSLModeDisable, SSLModeRequire, SSLModeVerifyCA and SSLModeVerifyFull.
+	SSLMode string
+	// RootCertFile is a PEM bundle of the CAs trusted to sign the certificate of the server, required to verify it.
+	RootCertFile string
+	// CertFile is a PEM certificate the client authenticates with, set together with KeyFile.
+	CertFile string
+	// KeyFile is the PEM private key of CertFile.
+	KeyFile string
+}
+
+// DefaultTLSConfig returns the TLS configuration used when none is configured.
+func DefaultTLSConfig() TLSConfig {
+	return TLSConfig{
+		SSLMode: SSLModeDisable,
+	}
+}
+
+// Validate returns an error wrapping ErrInvalidTLSConfig if the configuration is inconsistent.
+// It does not read the files, LoadCertificates does.
+func (c TLSConfig) Validate() error {
+	switch c.SSLMode {
+	case SSLModeDisable:
+		if c.RootCertFile != "" || c.CertFile != "" || c.KeyFile != "" {
+			return fmt.Errorf("%w: certificates are configured but ssl mode is %s", ErrInvalidTLSConfig, c.SSLMode)
+		}
+		return nil
+	case SSLModeRequire:
+		if c.RootCertFile != "" {
+			return fmt.Errorf("%w: a root cert is only used by ssl mode %s or %s, got %s", ErrInvalidTLSConfig, SSLModeVerifyCA, SSLModeVerifyFull, c.SSLMode)
+		}
+	case SSLModeVerifyCA, SSLModeVerifyFull:
+		if c.RootCertFile == "" {
+			return fmt.Errorf("%w: ssl mode %s needs a root cert to verify the server with", ErrInvalidTLSConfig, c.SSLMode)
+		}
+	default:
+		return fmt.Errorf("%w: unknown ssl mode %q, must be one of %s, %s, %s and %s",
+			ErrInvalidTLSConfig, c.SSLMode, SSLModeDisable, SSLModeRequire, SSLModeVerifyCA, SSLModeVerifyFull)
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("%w: a client cert and key must be configured together", ErrInvalidTLSConfig)
+	}
+	return nil
+}
+
+// files returns the files of the configuration that are set
+func (c TLSConfig) files() []string {
+	files := []string{}
+	for _, file := range []string{c.RootCertFile, c.CertFile, c.KeyFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// Certificates are the certificates of a TLSConfig, which are reloaded when their files change.
+// They are shared by every connection made with them, a reload applies to the connections made after it
+type Certificates struct {
+	config TLSConfig
+
+	mu         sync.RWMutex
+	rootCAs    *x509.CertPool
+	clientCert *tls.Certificate
+	modTimes   map[string]time.Time
+}
+
+// LoadCertificates validates the configuration and loads its certificates.
+// The error wraps ErrInvalidTLSConfig if the configuration is inconsistent or a file cannot be read.
+func LoadCertificates(config TLSConfig) (*Certificates, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	certificates := &Certificates{
+		config: config,
+	}
+	if _, err := certificates.Reload(); err != nil {
+		return nil, err
+	}
+	return certificates, nil
+}
+
+// Reload reads the files of the certificates again if any of them changed since they were last read, returning whether they were reloaded.
+// The certificates are left unchanged if the files cannot be read, such as while only some of them have been replaced
+func (c *Certificates) Reload() (bool, error) {
+	modTimes := map[string]time.Time{}
+	for _, file := range c.config.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, fmt.Errorf("%w: %v", ErrInvalidTLSConfig, err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+	if !c.changed(modTimes) {
+		return false, nil
+	}
+
+	var rootCAs *x509.CertPool
+	if c.config.RootCertFile != "" {
+		pem, err := os.ReadFile(c.config.RootCertFile)
+		if err != nil {
+			return false, fmt.Errorf("%w: %v", ErrInvalidTLSConfig, err)
+		}
+		rootCAs = x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return false, fmt.Errorf("%w: no certificates found in root cert %s", ErrInvalidTLSConfig, c.config.RootCertFile)
+		}
+	}
+	var clientCert *tls.Certificate
+	if c.config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.config.CertFile, c.config.KeyFile)
+		if err != nil {
+			return false, fmt.Errorf("%w: client cert %s and key %s: %v", ErrInvalidTLSConfig, c.config.CertFile, c.config.KeyFile, err)
+		}
+		clientCert = &cert
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rootCAs = rootCAs
+	c.clientCert = clientCert
+	c.modTimes = modTimes
+	return true, nil
+}
+
+// changed returns whether the modification times differ from those of the files when they were last read
+func (c *Certificates) changed(modTimes map[string]time.Time) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.modTimes == nil {
+		return true
+	}
+	for file, modTime := range modTimes {
+		if !c.modTimes[file].Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// RunReloads reloads the certificates every interval until the context is cancelled, logging reloads and files that cannot be read.
+func (c *Certificates) RunReloads(ctx context.Context, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := c.Reload()
+			if err != nil {
+				logger.Error("Failed to reload database certificates, keeping the previous ones", zap.Error(err))
+			} else if reloaded {
+				logger.Info("Reloaded database certificates")
+			}
+		}
+	}
+}
+
+// tlsConfig returns the TLS configuration of connections to the host, nil if TLS is disabled.
+// The server is verified against the current root CAs instead of tls.Config.RootCAs so that reloaded CAs apply to new connections
+func (c *Certificates) tlsConfig(host string) *tls.Config {
+	if c == nil || c.config.SSLMode == SSLModeDisable {
+		return nil
+	}
+	config := &tls.Config{
+		ServerName: host,
+		// The certificate of the server is verified by VerifyConnection according to the ssl mode
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+			if c.clientCert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return c.clientCert, nil
+		},
+	}
+	if c.config.SSLMode == SSLModeRequire {
+		return config
+	}
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("server sent no certificate")
+		}
+		c.mu.RLock()
+		options := x509.VerifyOptions{
+			Roots:         c.rootCAs,
+			Intermediates: x509.NewCertPool(),
+		}
+		c.mu.RUnlock()
+		for _, cert := range state.PeerCertificates[1:] {
+			options.Intermediates.AddCert(cert)
+		}
+		if c.config.SSLMode == SSLModeVerifyFull {
+			options.DNSName = host
+		}
+		_, err := state.PeerCertificates[0].Verify(options)
+		return err
+	}
+	return config
+}
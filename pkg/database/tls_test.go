@@ -0,0 +1,239 @@
+package database_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/pkg/database"
+	"github.com/tobiassundman/go-demo-app/pkg/test"
+)
+
+// replaceFile replaces the content of the file with that of the source and moves its modification time forward, as a rotation of the file would.
+func replaceFile(t *testing.T, file, source string) {
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	content, err := os.ReadFile(source)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(file, content, 0o600))
+	modTime := info.ModTime().Add(time.Second)
+	require.NoError(t, os.Chtimes(file, modTime, modTime))
+}
+
+// copyCertificates copies the client files of the certificates to a new directory, returning a configuration of the copies.
+func copyCertificates(t *testing.T, certificates test.Certificates) database.TLSConfig {
+	dir := t.TempDir()
+	config := database.TLSConfig{
+		SSLMode:      database.SSLModeVerifyFull,
+		RootCertFile: filepath.Join(dir, "ca.crt"),
+		CertFile:     filepath.Join(dir, "client.crt"),
+		KeyFile:      filepath.Join(dir, "client.key"),
+	}
+	for file, source := range map[string]string{
+		config.RootCertFile: certificates.CACert,
+		config.CertFile:     certificates.ClientCert,
+		config.KeyFile:      certificates.ClientKey,
+	} {
+		content, err := os.ReadFile(source)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(file, content, 0o600))
+	}
+	return config
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		config  database.TLSConfig
+		wantErr bool
+	}{
+		{name: "default", config: database.DefaultTLSConfig()},
+		{name: "require", config: database.TLSConfig{SSLMode: database.SSLModeRequire}},
+		{name: "require with client cert", config: database.TLSConfig{SSLMode: database.SSLModeRequire, CertFile: "client.crt", KeyFile: "client.key"}},
+		{name: "verify full", config: database.TLSConfig{SSLMode: database.SSLModeVerifyFull, RootCertFile: "ca.crt"}},
+		{name: "verify ca with client cert", config: database.TLSConfig{SSLMode: database.SSLModeVerifyCA, RootCertFile: "ca.crt", CertFile: "client.crt", KeyFile: "client.key"}},
+		{name: "unknown ssl mode", config: database.TLSConfig{SSLMode: "prefer"}, wantErr: true},
+		{name: "no ssl mode", config: database.TLSConfig{}, wantErr: true},
+		{name: "disable with root cert", config: database.TLSConfig{SSLMode: database.SSLModeDisable, RootCertFile: "ca.crt"}, wantErr: true},
+		{name: "disable with client cert", config: database.TLSConfig{SSLMode: database.SSLModeDisable, CertFile: "client.crt", KeyFile: "client.key"}, wantErr: true},
+		{name: "require with root cert", config: database.TLSConfig{SSLMode: database.SSLModeRequire, RootCertFile: "ca.crt"}, wantErr: true},
+		{name: "verify full without root cert", config: database.TLSConfig{SSLMode: database.SSLModeVerifyFull}, wantErr: true},
+		{name: "client cert without key", config: database.TLSConfig{SSLMode: database.SSLModeVerifyFull, RootCertFile: "ca.crt", CertFile: "client.crt"}, wantErr: true},
+		{name: "client key without cert", config: database.TLSConfig{SSLMode: database.SSLModeVerifyFull, RootCertFile: "ca.crt", KeyFile: "client.key"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			// Act
+			err := tt.config.Validate()
+
+			// Assert
+			if tt.wantErr {
+				assert.ErrorIs(t, err, database.ErrInvalidTLSConfig)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLoadCertificates(t *testing.T) {
+	t.Parallel()
+	certificates := test.GenerateCertificates(t, t.TempDir())
+	other := test.GenerateCertificates(t, t.TempDir())
+	tests := []struct {
+		name    string
+		config  database.TLSConfig
+		wantErr bool
+	}{
+		{name: "disable", config: database.DefaultTLSConfig()},
+		{name: "verify full", config: database.TLSConfig{
+			SSLMode: database.SSLModeVerifyFull, RootCertFile: certificates.CACert, CertFile: certificates.ClientCert, KeyFile: certificates.ClientKey,
+		}},
+		{name: "inconsistent", config: database.TLSConfig{SSLMode: database.SSLModeVerifyFull}, wantErr: true},
+		{name: "missing root cert", config: database.TLSConfig{
+			SSLMode: database.SSLModeVerifyFull, RootCertFile: filepath.Join(certificates.Dir, "missing.crt"),
+		}, wantErr: true},
+		{name: "root cert without certificates", config: database.TLSConfig{
+			SSLMode: database.SSLModeVerifyFull, RootCertFile: certificates.ClientKey,
+		}, wantErr: true},
+		{name: "client key of another cert", config: database.TLSConfig{
+			SSLMode: database.SSLModeVerifyFull, RootCertFile: certificates.CACert, CertFile: certificates.ClientCert, KeyFile: other.ClientKey,
+		}, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			// Act
+			_, err := database.LoadCertificates(tt.config)
+
+			// Assert
+			if tt.wantErr {
+				assert.ErrorIs(t, err, database.ErrInvalidTLSConfig)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCertificatesReload(t *testing.T) {
+	t.Parallel()
+	t.Run("unchanged files are not reloaded", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		config := copyCertificates(t, test.GenerateCertificates(t, t.TempDir()))
+		certificates, err := database.LoadCertificates(config)
+		require.NoError(t, err)
+
+		// Act
+		reloaded, err := certificates.Reload()
+
+		// Assert
+		assert.NoError(t, err)
+		assert.False(t, reloaded)
+	})
+	t.Run("changed files are reloaded", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		config := copyCertificates(t, test.GenerateCertificates(t, t.TempDir()))
+		certificates, err := database.LoadCertificates(config)
+		require.NoError(t, err)
+		rotated := test.GenerateCertificates(t, t.TempDir())
+		replaceFile(t, config.CertFile, rotated.ClientCert)
+		replaceFile(t, config.KeyFile, rotated.ClientKey)
+
+		// Act
+		reloaded, err := certificates.Reload()
+
+		// Assert
+		assert.NoError(t, err)
+		assert.True(t, reloaded)
+	})
+	t.Run("files that cannot be loaded keep the previous certificates", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		config := copyCertificates(t, test.GenerateCertificates(t, t.TempDir()))
+		certificates, err := database.LoadCertificates(config)
+		require.NoError(t, err)
+		rotated := test.GenerateCertificates(t, t.TempDir())
+		replaceFile(t, config.CertFile, rotated.ClientCert)
+
+		// Act
+		reloaded, err := certificates.Reload()
+
+		// Assert
+		assert.ErrorIs(t, err, database.ErrInvalidTLSConfig)
+		assert.False(t, reloaded)
+	})
+}
+
+func TestUserDatabaseConnectionTLS(t *testing.T) {
+	t.Parallel()
+	container := test.StartDatabaseContainer(t)
+
+	t.Run("connections use TLS", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		certificates, err := database.LoadCertificates(container.TLSConfig)
+		require.NoError(t, err)
+		db, err := database.UserDatabaseConnection(container.Host, container.Port, "demo_user", "demo_password", "demo_db", database.DefaultPoolConfig(), certificates)
+		require.NoError(t, err)
+		t.Cleanup(db.Close)
+
+		// Act
+		var ssl bool
+		err = db.QueryRow(context.Background(), "SELECT ssl FROM pg_stat_ssl WHERE pid = pg_backend_pid()").Scan(&ssl)
+
+		// Assert
+		assert.NoError(t, err)
+		assert.True(t, ssl)
+	})
+	t.Run("connections without TLS are rejected", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		db := test.Connect(t, fmt.Sprintf("host=%s port=%s user=demo_user password=demo_password dbname=demo_db sslmode=disable", container.Host, container.Port))
+		t.Cleanup(db.Close)
+
+		// Act
+		err := db.Ping(context.Background())
+
+		// Assert
+		assert.Error(t, err)
+	})
+	t.Run("connections use reloaded certificates", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		config := copyCertificates(t, container.Certificates)
+		certificates, err := database.LoadCertificates(config)
+		require.NoError(t, err)
+		db, err := database.UserDatabaseConnection(container.Host, container.Port, "demo_user", "demo_password", "demo_db", database.DefaultPoolConfig(), certificates)
+		require.NoError(t, err)
+		t.Cleanup(db.Close)
+		untrusted := test.GenerateCertificates(t, t.TempDir())
+		replaceFile(t, config.RootCertFile, untrusted.CACert)
+
+		// Act
+		_, err = certificates.Reload()
+		require.NoError(t, err)
+		db.Reset()
+		untrustedErr := db.Ping(context.Background())
+		replaceFile(t, config.RootCertFile, container.Certificates.CACert)
+		_, err = certificates.Reload()
+		require.NoError(t, err)
+		db.Reset()
+		trustedErr := db.Ping(context.Background())
+
+		// Assert
+		assert.Error(t, untrustedErr)
+		assert.NoError(t, trustedErr)
+	})
+}
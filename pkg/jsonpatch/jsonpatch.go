@@ -0,0 +1,356 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Operations of a JSON Patch.
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+	OpMove    = "move"
+	OpCopy    = "copy"
+	OpTest    = "test"
+)
+
+var (
+	// ErrInvalidPatch is returned when a patch is not a valid JSON Patch document.
+	ErrInvalidPatch = errors.New("invalid json patch")
+	// ErrPathNotFound is returned when an operation refers to a location that does not exist in the document.
+	ErrPathNotFound = errors.New("path not found")
+	// ErrTestFailed is returned when the value at the path of a test operation is not the value of the operation.
+	ErrTestFailed = errors.New("test failed")
+)
+
+// Operation is a single operation of a JSON Patch.
+type Operation struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	// From is the source location of move and copy operations.
+	From string `json:"from,omitempty"`
+	// Value is the value of add, replace and test operations, it is nil if the member is absent and null if it is null.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Patch is a JSON Patch (RFC 6902), a sequence of operations applied to a JSON document in order.
+type Patch []Operation
+
+// decodedOperation is an operation as it is decoded, to tell absent members from empty pointers.
+type decodedOperation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Decode decodes and validates a JSON Patch document.
+func Decode(data []byte) (Patch, error) {
+	decoded := []decodedOperation{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	patch := make(Patch, len(decoded))
+	for i, operation := range decoded {
+		if operation.Path == nil {
+			return nil, fmt.Errorf("%w: operation %d is missing path", ErrInvalidPatch, i)
+		}
+		if (operation.Op == OpMove || operation.Op == OpCopy) && operation.From == nil {
+			return nil, fmt.Errorf("%w: operation %d is missing from", ErrInvalidPatch, i)
+		}
+		patch[i] = Operation{Op: operation.Op, Path: *operation.Path, Value: operation.Value}
+		if operation.From != nil {
+			patch[i].From = *operation.From
+		}
+		if err := patch[i].validate(); err != nil {
+			return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+		}
+	}
+	return patch, nil
+}
+
+// Apply applies the patch to a JSON document and returns the patched document.
+// The patch is applied atomically, if any operation fails the error is returned and no document.
+func (p Patch) Apply(document []byte) ([]byte, error) {
+	var node any
+	if err := json.Unmarshal(document, &node); err != nil {
+		return nil, err
+	}
+	for i, operation := range p {
+		var err error
+		node, err = operation.apply(node)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, operation.Op, operation.Path, err)
+		}
+	}
+	return json.Marshal(node)
+}
+
+// validate checks that the operation is known and has the members it needs.
+func (o *Operation) validate() error {
+	if _, err := parsePointer(o.Path); err != nil {
+		return err
+	}
+	switch o.Op {
+	case OpAdd, OpReplace, OpTest:
+		if o.Value == nil {
+			return fmt.Errorf("%s operation is missing value", o.Op)
+		}
+	case OpMove, OpCopy:
+		if _, err := parsePointer(o.From); err != nil {
+			return fmt.Errorf("%s operation has invalid from: %v", o.Op, err)
+		}
+	case OpRemove:
+	default:
+		return fmt.Errorf("unknown operation %q", o.Op)
+	}
+	return nil
+}
+
+// apply applies the operation to a decoded document and returns the changed document.
+func (o *Operation) apply(node any) (any, error) {
+	path, err := parsePointer(o.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch o.Op {
+	case OpAdd:
+		value, err := o.value()
+		if err != nil {
+			return nil, err
+		}
+		return add(node, path, value)
+	case OpRemove:
+		return remove(node, path)
+	case OpReplace:
+		value, err := o.value()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := get(node, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		return update(node, path, func(parent any, last string) (any, error) {
+			return setChild(parent, last, value)
+		})
+	case OpMove:
+		from, err := parsePointer(o.From)
+		if err != nil {
+			return nil, err
+		}
+		if o.From == o.Path {
+			return node, nil
+		}
+		// A location cannot be moved into one of its own children
+		if strings.HasPrefix(o.Path, o.From+"/") {
+			return nil, fmt.Errorf("cannot move %s into itself", o.From)
+		}
+		value, err := get(node, from)
+		if err != nil {
+			return nil, err
+		}
+		node, err = remove(node, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(node, path, value)
+	case OpCopy:
+		from, err := parsePointer(o.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(node, from)
+		if err != nil {
+			return nil, err
+		}
+		copied, err := deepCopy(value)
+		if err != nil {
+			return nil, err
+		}
+		return add(node, path, copied)
+	case OpTest:
+		expected, err := o.value()
+		if err != nil {
+			return nil, err
+		}
+		actual, err := get(node, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			return nil, ErrTestFailed
+		}
+		return node, nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q", o.Op)
+	}
+}
+
+// value decodes the value of the operation.
+func (o *Operation) value() (any, error) {
+	var value any
+	if err := json.Unmarshal(o.Value, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// parsePointer parses a JSON Pointer (RFC 6901) into its unescaped reference tokens, the empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("pointer %q does not start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// get returns the value at the path.
+func get(node any, path []string) (any, error) {
+	for _, token := range path {
+		switch container := node.(type) {
+		case map[string]any:
+			child, ok := container[token]
+			if !ok {
+				return nil, ErrPathNotFound
+			}
+			node = child
+		case []any:
+			index, err := parseIndex(token, len(container)-1)
+			if err != nil {
+				return nil, err
+			}
+			node = container[index]
+		default:
+			return nil, ErrPathNotFound
+		}
+	}
+	return node, nil
+}
+
+// add adds the value at the path, replacing an object member or inserting into an array.
+func add(node any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(node, path, func(parent any, last string) (any, error) {
+		switch container := parent.(type) {
+		case map[string]any:
+			container[last] = value
+			return container, nil
+		case []any:
+			if last == "-" {
+				return append(container, value), nil
+			}
+			index, err := parseIndex(last, len(container))
+			if err != nil {
+				return nil, err
+			}
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+			return container, nil
+		default:
+			return nil, ErrPathNotFound
+		}
+	})
+}
+
+// remove removes the value at the path.
+func remove(node any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+	return update(node, path, func(parent any, last string) (any, error) {
+		switch container := parent.(type) {
+		case map[string]any:
+			if _, ok := container[last]; !ok {
+				return nil, ErrPathNotFound
+			}
+			delete(container, last)
+			return container, nil
+		case []any:
+			index, err := parseIndex(last, len(container)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(container[:index], container[index+1:]...), nil
+		default:
+			return nil, ErrPathNotFound
+		}
+	})
+}
+
+// update replaces the parent container of the path with what fn returns for it and the last token of the path.
+// Arrays may be reallocated by fn, so every container on the path is set again in its own parent.
+func update(node any, path []string, fn func(parent any, last string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(node, path[0])
+	}
+	child, err := get(node, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	changed, err := update(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	return setChild(node, path[0], changed)
+}
+
+// setChild replaces the existing child of a container.
+func setChild(parent any, token string, value any) (any, error) {
+	switch container := parent.(type) {
+	case map[string]any:
+		if _, ok := container[token]; !ok {
+			return nil, ErrPathNotFound
+		}
+		container[token] = value
+		return container, nil
+	case []any:
+		index, err := parseIndex(token, len(container)-1)
+		if err != nil {
+			return nil, err
+		}
+		container[index] = value
+		return container, nil
+	default:
+		return nil, ErrPathNotFound
+	}
+}
+
+// parseIndex parses an array index token, which must not have leading zeros and must be at most max.
+func parseIndex(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, ErrPathNotFound
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > max {
+		return 0, ErrPathNotFound
+	}
+	return index, nil
+}
+
+// deepCopy copies a decoded value, so that a copied value does not change with its source.
+func deepCopy(value any) (any, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var copied any
+	err = json.Unmarshal(encoded, &copied)
+	return copied, err
+}
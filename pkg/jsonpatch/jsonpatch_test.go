@@ -0,0 +1,183 @@
+package jsonpatch_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/pkg/jsonpatch"
+)
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+	// The examples of appendix A of RFC 6902
+	tests := map[string]struct {
+		document string
+		patch    string
+		expected string
+	}{
+		"adds object member": {
+			`{"foo": "bar"}`,
+			`[{"op": "add", "path": "/baz", "value": "qux"}]`,
+			`{"baz": "qux", "foo": "bar"}`,
+		},
+		"adds array element": {
+			`{"foo": ["bar", "baz"]}`,
+			`[{"op": "add", "path": "/foo/1", "value": "qux"}]`,
+			`{"foo": ["bar", "qux", "baz"]}`,
+		},
+		"appends array element": {
+			`{"foo": ["bar"]}`,
+			`[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`,
+			`{"foo": ["bar", ["abc", "def"]]}`,
+		},
+		"removes object member": {
+			`{"baz": "qux", "foo": "bar"}`,
+			`[{"op": "remove", "path": "/baz"}]`,
+			`{"foo": "bar"}`,
+		},
+		"removes array element": {
+			`{"foo": ["bar", "qux", "baz"]}`,
+			`[{"op": "remove", "path": "/foo/1"}]`,
+			`{"foo": ["bar", "baz"]}`,
+		},
+		"replaces value": {
+			`{"baz": "qux", "foo": "bar"}`,
+			`[{"op": "replace", "path": "/baz", "value": "boo"}]`,
+			`{"baz": "boo", "foo": "bar"}`,
+		},
+		"moves value": {
+			`{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			`[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			`{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`,
+		},
+		"moves array element": {
+			`{"foo": ["all", "grass", "cows", "eat"]}`,
+			`[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`,
+			`{"foo": ["all", "cows", "eat", "grass"]}`,
+		},
+		"copies value": {
+			`{"foo": {"bar": 1}}`,
+			`[{"op": "copy", "from": "/foo", "path": "/baz"}, {"op": "replace", "path": "/baz/bar", "value": 2}]`,
+			`{"foo": {"bar": 1}, "baz": {"bar": 2}}`,
+		},
+		"passes test": {
+			`{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			`[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`,
+			`{"baz": "qux", "foo": ["a", 2, "c"]}`,
+		},
+		"unescapes pointer": {
+			`{"/": 9, "~1": 10}`,
+			`[{"op": "test", "path": "/~01", "value": 10}, {"op": "replace", "path": "/~1", "value": 11}]`,
+			`{"/": 11, "~1": 10}`,
+		},
+		"adds null value": {
+			`{"foo": "bar"}`,
+			`[{"op": "add", "path": "/baz", "value": null}]`,
+			`{"foo": "bar", "baz": null}`,
+		},
+		"replaces whole document": {
+			`{"foo": "bar"}`,
+			`[{"op": "replace", "path": "", "value": {"baz": "qux"}}]`,
+			`{"baz": "qux"}`,
+		},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// Arrange
+			patch, err := jsonpatch.Decode([]byte(test.patch))
+			require.NoError(t, err)
+
+			// Act
+			patched, err := patch.Apply([]byte(test.document))
+			require.NoError(t, err)
+
+			// Assert
+			assert.JSONEq(t, test.expected, string(patched))
+		})
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		document string
+		patch    string
+		expected error
+	}{
+		"failed test": {
+			`{"baz": "qux"}`,
+			`[{"op": "test", "path": "/baz", "value": "bar"}]`,
+			jsonpatch.ErrTestFailed,
+		},
+		"add to missing parent": {
+			`{"foo": "bar"}`,
+			`[{"op": "add", "path": "/baz/bat", "value": "qux"}]`,
+			jsonpatch.ErrPathNotFound,
+		},
+		"remove missing member": {
+			`{"foo": "bar"}`,
+			`[{"op": "remove", "path": "/baz"}]`,
+			jsonpatch.ErrPathNotFound,
+		},
+		"replace missing member": {
+			`{"foo": "bar"}`,
+			`[{"op": "replace", "path": "/baz", "value": 1}]`,
+			jsonpatch.ErrPathNotFound,
+		},
+		"array index out of bounds": {
+			`{"foo": ["bar"]}`,
+			`[{"op": "add", "path": "/foo/2", "value": 1}]`,
+			jsonpatch.ErrPathNotFound,
+		},
+		"array index with leading zero": {
+			`{"foo": ["bar", "baz"]}`,
+			`[{"op": "remove", "path": "/foo/01"}]`,
+			jsonpatch.ErrPathNotFound,
+		},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// Arrange
+			patch, err := jsonpatch.Decode([]byte(test.patch))
+			require.NoError(t, err)
+
+			// Act
+			_, err = patch.Apply([]byte(test.document))
+
+			// Assert
+			assert.ErrorIs(t, err, test.expected)
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+	tests := map[string]string{
+		"not an array":         `{"op": "add", "path": "/foo", "value": 1}`,
+		"unknown operation":    `[{"op": "merge", "path": "/foo", "value": 1}]`,
+		"missing value":        `[{"op": "add", "path": "/foo"}]`,
+		"missing from":         `[{"op": "copy", "path": "/foo"}]`,
+		"missing path":         `[{"op": "remove"}]`,
+		"relative path":        `[{"op": "remove", "path": "foo"}]`,
+		"value of wrong types": `[{"op": 1, "path": "/foo"}]`,
+	}
+	for name, patch := range tests {
+		patch := patch
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// Act
+			_, err := jsonpatch.Decode([]byte(patch))
+
+			// Assert
+			assert.ErrorIs(t, err, jsonpatch.ErrInvalidPatch)
+		})
+	}
+}
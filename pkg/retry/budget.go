@@ -0,0 +1,48 @@
+package retry
+
+import "sync"
+
+// Budget limits the retries of many operations to a ratio of the operations, so that retries cannot multiply the load on a failing dependency.
+// Every operation deposits ratio tokens and every retry withdraws a whole token, a full budget holds enough tokens for a burst of retries.
+type Budget struct {
+	mutex  sync.Mutex
+	ratio  float64
+	max    float64
+	tokens float64
+}
+
+// NewBudget creates a full budget that allows ratio retries per operation and at most burst retries in a row.
+func NewBudget(ratio float64, burst int) *Budget {
+	return &Budget{
+		ratio:  ratio,
+		max:    float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// deposit adds the tokens of an operation, a nil budget is unlimited.
+func (b *Budget) deposit() {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+// withdraw takes the token of a retry, returning false if the budget has no token left, a nil budget is unlimited.
+func (b *Budget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -1,16 +1,93 @@
 package retry
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
 )
 
+// ErrBudgetExhausted is returned together with the error of an operation that was not retried because the budget of its policy had no retries left.
+var ErrBudgetExhausted = errors.New("retry budget exhausted")
+
+// Policy configures how Do retries an operation.
+type Policy struct {
+	// Timeout is how long the operation is retried, 0 retries it until MaxRetries is reached.
+	Timeout time.Duration
+	// MaxRetries is the most times the operation is retried, 0 retries it until the timeout.
+	MaxRetries int
+	// InitialInterval is the delay before the first retry, later delays grow exponentially. 0 uses the default of the exponential backoff.
+	InitialInterval time.Duration
+	// Budget is shared by the operations retried with the policy to limit their retries, nil does not limit them.
+	Budget *Budget
+	// OnRetry is called with the error of the failed attempt and the delay before every retry.
+	OnRetry func(err error, delay time.Duration)
+}
+
 // Retry retries the given operation until it succeeds or times out.
 func Retry(timeout time.Duration, operation func() error) error {
+	return backoff.Retry(operation, newExponentialBackOff(timeout))
+}
+
+// RetryContext retries the given operation until it succeeds, times out, returns a permanent error or the context is done.
+func RetryContext(ctx context.Context, timeout time.Duration, operation func() error) error {
+	return backoff.Retry(operation, backoff.WithContext(newExponentialBackOff(timeout), ctx))
+}
+
+// Do runs the operation and retries it as configured by the policy until it succeeds, returns a permanent error or the context is done.
+// The error of the last attempt is returned, wrapped with ErrBudgetExhausted if the budget stopped the retries.
+func Do(ctx context.Context, policy Policy, operation func(ctx context.Context) error) error {
+	exponentialBackoff := newExponentialBackOff(policy.Timeout)
+	if policy.InitialInterval > 0 {
+		exponentialBackoff.InitialInterval = policy.InitialInterval
+	}
+	var delays backoff.BackOff = exponentialBackoff
+	if policy.MaxRetries > 0 {
+		delays = backoff.WithMaxRetries(delays, uint64(policy.MaxRetries))
+	}
+	delays.Reset()
+	policy.Budget.deposit()
+
+	for {
+		err := operation(ctx)
+		if err == nil {
+			return nil
+		}
+		var permanent *backoff.PermanentError
+		if errors.As(err, &permanent) {
+			return permanent.Err
+		}
+		delay := delays.NextBackOff()
+		if delay == backoff.Stop || ctx.Err() != nil {
+			return err
+		}
+		if !policy.Budget.withdraw() {
+			return fmt.Errorf("%w: %w", ErrBudgetExhausted, err)
+		}
+		if policy.OnRetry != nil {
+			policy.OnRetry(err, delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// Permanent wraps an error so that the operation returning it is not retried, the error is returned unwrapped.
+func Permanent(err error) error {
+	return backoff.Permanent(err)
+}
+
+func newExponentialBackOff(timeout time.Duration) *backoff.ExponentialBackOff {
 	exponentialBackoff := backoff.NewExponentialBackOff()
 	exponentialBackoff.MaxElapsedTime = timeout
 	exponentialBackoff.MaxInterval = time.Second * 5
-
-	return backoff.Retry(operation, exponentialBackoff)
+	return exponentialBackoff
 }
@@ -0,0 +1,165 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobiassundman/go-demo-app/pkg/retry"
+)
+
+func TestRetryContext(t *testing.T) {
+	t.Parallel()
+	t.Run("retries until operation succeeds", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		attempts := 0
+
+		// Act
+		err := retry.RetryContext(context.Background(), time.Minute, func() error {
+			attempts++
+			if attempts < 3 {
+				return errors.New("failed")
+			}
+			return nil
+		})
+
+		// Assert
+		assert.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("does not retry permanent error", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		errPermanent := errors.New("permanent")
+		attempts := 0
+
+		// Act
+		err := retry.RetryContext(context.Background(), time.Minute, func() error {
+			attempts++
+			return retry.Permanent(errPermanent)
+		})
+
+		// Assert
+		assert.Equal(t, errPermanent, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("stops when context is done", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		attempts := 0
+
+		// Act
+		err := retry.RetryContext(ctx, time.Minute, func() error {
+			attempts++
+			return errors.New("failed")
+		})
+
+		// Assert
+		assert.Error(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+}
+
+func TestDo(t *testing.T) {
+	t.Parallel()
+	t.Run("retries until max retries", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		errFailed := errors.New("failed")
+		attempts := 0
+		retries := []error{}
+
+		// Act
+		err := retry.Do(context.Background(), retry.Policy{
+			MaxRetries:      2,
+			InitialInterval: time.Millisecond,
+			OnRetry:         func(err error, delay time.Duration) { retries = append(retries, err) },
+		}, func(ctx context.Context) error {
+			attempts++
+			return errFailed
+		})
+
+		// Assert
+		assert.Equal(t, errFailed, err)
+		assert.Equal(t, 3, attempts)
+		assert.Equal(t, []error{errFailed, errFailed}, retries)
+	})
+
+	t.Run("does not retry permanent error", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		errPermanent := errors.New("permanent")
+		attempts := 0
+
+		// Act
+		err := retry.Do(context.Background(), retry.Policy{MaxRetries: 2, InitialInterval: time.Millisecond}, func(ctx context.Context) error {
+			attempts++
+			return retry.Permanent(errPermanent)
+		})
+
+		// Assert
+		assert.Equal(t, errPermanent, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("stops retrying when budget is exhausted", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		errFailed := errors.New("failed")
+		policy := retry.Policy{
+			MaxRetries:      5,
+			InitialInterval: time.Millisecond,
+			Budget:          retry.NewBudget(0.5, 2),
+		}
+		attempts := 0
+		operation := func(ctx context.Context) error {
+			attempts++
+			return errFailed
+		}
+
+		// Act
+		firstErr := retry.Do(context.Background(), policy, operation)
+		firstAttempts := attempts
+		attempts = 0
+		secondErr := retry.Do(context.Background(), policy, operation)
+
+		// Assert
+		assert.ErrorIs(t, firstErr, retry.ErrBudgetExhausted)
+		assert.ErrorIs(t, firstErr, errFailed)
+		assert.Equal(t, 3, firstAttempts, "the full budget allows a burst of 2 retries")
+		assert.ErrorIs(t, secondErr, retry.ErrBudgetExhausted)
+		assert.Equal(t, 1, attempts, "the deposit of one operation is not a whole retry")
+	})
+
+	t.Run("passes context to operation and stops when it is done", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		ctx, cancel := context.WithCancel(context.Background())
+		attempts := 0
+
+		// Act
+		err := retry.Do(ctx, retry.Policy{MaxRetries: 5, InitialInterval: time.Millisecond}, func(ctx context.Context) error {
+			attempts++
+			cancel()
+			return ctx.Err()
+		})
+
+		// Assert
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, attempts)
+	})
+}
@@ -0,0 +1,41 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+)
+
+// newIDBytes is the number of random bytes of a new session id.
+const newIDBytes = 16
+
+// validID matches 1 to 64 letters, digits, dots, underscores and hyphens starting with a letter or digit.
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+type contextKey struct{}
+
+// NewContext returns a copy of the context carrying the id of the session of the client performing the operation.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the id of the session of the client performing the operation, or false if the context does not carry a session.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok && id != ""
+}
+
+// NewID generates a random hex encoded session id.
+func NewID() (string, error) {
+	id := make([]byte, newIDBytes)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// IsValid returns true if the id can identify a session, which is 1 to 64 letters, digits, dots, underscores and hyphens starting with a letter or digit.
+func IsValid(id string) bool {
+	return validID.MatchString(id)
+}
@@ -0,0 +1,54 @@
+package session_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/pkg/session"
+)
+
+func TestContext(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	ctx := session.NewContext(context.Background(), "session1")
+
+	// Act
+	id, ok := session.FromContext(ctx)
+	_, missingOk := session.FromContext(context.Background())
+	_, emptyOk := session.FromContext(session.NewContext(context.Background(), ""))
+
+	// Assert
+	assert.Equal(t, "session1", id)
+	assert.True(t, ok)
+	assert.False(t, missingOk)
+	assert.False(t, emptyOk)
+}
+
+func TestNewID(t *testing.T) {
+	t.Parallel()
+
+	// Act
+	id, err := session.NewID()
+	require.NoError(t, err)
+	other, err := session.NewID()
+	require.NoError(t, err)
+
+	// Assert
+	assert.True(t, session.IsValid(id))
+	assert.NotEqual(t, id, other)
+}
+
+func TestIsValid(t *testing.T) {
+	t.Parallel()
+
+	for _, id := range []string{"session1", "3f2a9c", "browser.tab_1-a", strings.Repeat("a", 64)} {
+		assert.True(t, session.IsValid(id), id)
+	}
+	for _, id := range []string{"", "-session", "session 1", "session/1", strings.Repeat("a", 65)} {
+		assert.False(t, session.IsValid(id), id)
+	}
+}
@@ -0,0 +1,27 @@
+package tenant
+
+import (
+	"context"
+	"regexp"
+)
+
+// validID matches 1 to 64 letters, digits, dots, underscores and hyphens starting with a letter or digit.
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+type contextKey struct{}
+
+// NewContext returns a copy of the context carrying the id of the tenant the operation is performed for.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the id of the tenant the operation is performed for, or false if the context does not carry a tenant.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok && id != ""
+}
+
+// IsValid returns true if the id can identify a tenant, which is 1 to 64 letters, digits, dots, underscores and hyphens starting with a letter or digit.
+func IsValid(id string) bool {
+	return validID.MatchString(id)
+}
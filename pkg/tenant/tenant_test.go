@@ -0,0 +1,39 @@
+package tenant_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobiassundman/go-demo-app/pkg/tenant"
+)
+
+func TestContext(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	ctx := tenant.NewContext(context.Background(), "tenant1")
+
+	// Act
+	id, ok := tenant.FromContext(ctx)
+	_, missingOk := tenant.FromContext(context.Background())
+	_, emptyOk := tenant.FromContext(tenant.NewContext(context.Background(), ""))
+
+	// Assert
+	assert.Equal(t, "tenant1", id)
+	assert.True(t, ok)
+	assert.False(t, missingOk)
+	assert.False(t, emptyOk)
+}
+
+func TestIsValid(t *testing.T) {
+	t.Parallel()
+
+	for _, id := range []string{"tenant1", "Acme-Corp", "eu.acme_1", strings.Repeat("a", 64)} {
+		assert.True(t, tenant.IsValid(id), id)
+	}
+	for _, id := range []string{"", "-tenant", "tenant 1", "tenant/1", "*", strings.Repeat("a", 65)} {
+		assert.False(t, tenant.IsValid(id), id)
+	}
+}
@@ -0,0 +1,114 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Certificates are the PEM files of a CA and of a server and client certificate signed by it, generated for tests.
+type Certificates struct {
+	// Dir is the directory of the files.
+	Dir string
+	// CACert is the certificate of the CA.
+	CACert string
+	// ServerCert is the certificate of the server, issued for localhost and 127.0.0.1.
+	ServerCert string
+	// ServerKey is the private key of ServerCert.
+	ServerKey string
+	// ClientCert is the certificate of the client, issued for the common name demo_user and trusted by the test database for every role.
+	ClientCert string
+	// ClientKey is the private key of ClientCert.
+	ClientKey string
+}
+
+// GenerateCertificates generates a new CA and server and client certificates signed by it in the directory, replacing existing files.
+func GenerateCertificates(t testing.TB, dir string) Certificates {
+	certificates := Certificates{
+		Dir:        dir,
+		CACert:     filepath.Join(dir, "ca.crt"),
+		ServerCert: filepath.Join(dir, "server.crt"),
+		ServerKey:  filepath.Join(dir, "server.key"),
+		ClientCert: filepath.Join(dir, "client.crt"),
+		ClientKey:  filepath.Join(dir, "client.key"),
+	}
+
+	caKey := generateKey(t)
+	ca := &x509.Certificate{
+		SerialNumber:          serialNumber(t),
+		Subject:               pkix.Name{CommonName: "demo-app test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	writePEM(t, certificates.CACert, "CERTIFICATE", caDER)
+
+	server := &x509.Certificate{
+		SerialNumber: serialNumber(t),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour * 24),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	signCertificate(t, server, ca, caKey, certificates.ServerCert, certificates.ServerKey)
+
+	client := &x509.Certificate{
+		SerialNumber: serialNumber(t),
+		Subject:      pkix.Name{CommonName: "demo_user"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour * 24),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	signCertificate(t, client, ca, caKey, certificates.ClientCert, certificates.ClientKey)
+
+	return certificates
+}
+
+// signCertificate creates the certificate signed by the CA with a new key and writes both to files
+func signCertificate(t testing.TB, template, ca *x509.Certificate, caKey *ecdsa.PrivateKey, certFile, keyFile string) {
+	key := generateKey(t)
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	writePEM(t, certFile, "CERTIFICATE", der)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	writePEM(t, keyFile, "PRIVATE KEY", keyDER)
+}
+
+func generateKey(t testing.TB) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+func serialNumber(t testing.TB) *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	require.NoError(t, err)
+	return serial
+}
+
+// writePEM writes the block to the file readable only by its owner, as libpq and Postgres require of private keys
+func writePEM(t testing.TB, file, blockType string, der []byte) {
+	err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600)
+	require.NoError(t, err)
+}
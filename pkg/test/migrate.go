@@ -2,15 +2,30 @@ package test
 
 import (
 	"fmt"
+	"net/url"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/tobiassundman/go-demo-app/pkg/database"
 )
 
 // Migrate runs the database migrations in the given directory during tests.
-func Migrate(dbUser, dbPassword, dbHost, dbPort, dbName, migrationsDir string) error {
-	connectionString := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", dbUser, dbPassword, dbHost, dbPort, dbName)
+// Migrations connect with TLS as configured, the certificates are read once since migrations are short-lived.
+func Migrate(dbUser, dbPassword, dbHost, dbPort, dbName, migrationsDir string, tlsConfig database.TLSConfig) error {
+	if _, err := database.LoadCertificates(tlsConfig); err != nil {
+		return err
+	}
+	query := url.Values{}
+	query.Set("sslmode", tlsConfig.SSLMode)
+	if tlsConfig.RootCertFile != "" {
+		query.Set("sslrootcert", tlsConfig.RootCertFile)
+	}
+	if tlsConfig.CertFile != "" {
+		query.Set("sslcert", tlsConfig.CertFile)
+		query.Set("sslkey", tlsConfig.KeyFile)
+	}
+	connectionString := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?%s", dbUser, dbPassword, dbHost, dbPort, dbName, query.Encode())
 
 	m, err := migrate.New(
 		fmt.Sprintf("file://%s", migrationsDir),
@@ -1,20 +1,56 @@
 package test
 
 import (
-	"database/sql"
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
-	"github.com/jackc/pgx"
-	"github.com/jackc/pgx/stdlib"
-	"github.com/jmoiron/sqlx"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/ory/dockertest"
 	"github.com/ory/dockertest/docker"
 	"github.com/stretchr/testify/require"
 	"github.com/tobiassundman/go-demo-app/pkg/database"
 )
 
-// StartDatabase starts a Postgres database in a Docker container returning a connection string.
-func StartDatabase(t testing.TB) *sqlx.DB {
+// hbaConf only accepts TLS connections from outside the container, authenticated with both a password and a client certificate signed by the CA.
+// Connections on the local socket are trusted for the initialization scripts of the image
+const hbaConf = `local all all trust
+hostssl all all all scram-sha-256 clientcert=verify-ca
+`
+
+// appRoleLoginQuery lets the role the migrations create for the app log in, it is not a superuser and is subject to row level security
+const appRoleLoginQuery = `ALTER ROLE demo_app LOGIN PASSWORD 'demo_app_password'`
+
+// startScript copies the certificates mounted in /certs to where Postgres accepts their ownership and permissions and starts Postgres with TLS.
+const startScript = `mkdir -p /etc/postgresql/certs &&
+cp /certs/* /etc/postgresql/certs/ &&
+chown -R postgres:postgres /etc/postgresql/certs &&
+chmod 600 /etc/postgresql/certs/server.key &&
+exec docker-entrypoint.sh postgres \
+	-c ssl=on \
+	-c ssl_cert_file=/etc/postgresql/certs/server.crt \
+	-c ssl_key_file=/etc/postgresql/certs/server.key \
+	-c ssl_ca_file=/etc/postgresql/certs/ca.crt \
+	-c hba_file=/etc/postgresql/certs/pg_hba.conf`
+
+// Database is a Postgres database started in a Docker container.
+type Database struct {
+	// Host is the host the database is reachable on.
+	Host string
+	// Port is the port the database is reachable on.
+	Port string
+	// Certificates are the certificates of the server and of a client trusted by it.
+	Certificates Certificates
+	// TLSConfig configures connections that verify the server and authenticate with the client certificate.
+	TLSConfig database.TLSConfig
+}
+
+// StartDatabaseContainer starts a Postgres database in a Docker container that only accepts TLS connections with a client certificate.
+func StartDatabaseContainer(t testing.TB) Database {
+	certificates := GenerateCertificates(t, t.TempDir())
+	err := os.WriteFile(filepath.Join(certificates.Dir, "pg_hba.conf"), []byte(hbaConf), 0o600)
+	require.NoError(t, err)
 
 	env := []string{
 		"POSTGRES_USER=demo_user",
@@ -29,6 +65,8 @@ func StartDatabase(t testing.TB) *sqlx.DB {
 		Repository: "postgres",
 		Tag:        "15",
 		Env:        env,
+		Mounts:     []string{certificates.Dir + ":/certs"},
+		Entrypoint: []string{"sh", "-c", startScript},
 	}, func(config *docker.HostConfig) {
 		config.AutoRemove = true
 		config.RestartPolicy = docker.RestartPolicy{
@@ -44,21 +82,45 @@ func StartDatabase(t testing.TB) *sqlx.DB {
 		require.NoError(t, err)
 	})
 
-	exposedPort := resource.GetPort("5432/tcp")
+	return Database{
+		Host:         "localhost",
+		Port:         resource.GetPort("5432/tcp"),
+		Certificates: certificates,
+		TLSConfig: database.TLSConfig{
+			SSLMode:      database.SSLModeVerifyFull,
+			RootCertFile: certificates.CACert,
+			CertFile:     certificates.ClientCert,
+			KeyFile:      certificates.ClientKey,
+		},
+	}
+}
+
+// StartDatabase starts a Postgres database in a Docker container, applies the migrations and connects to it with TLS as the app role demo_app,
+// like the app does.
+func StartDatabase(t testing.TB) *pgxpool.Pool {
+	container := StartDatabaseContainer(t)
+
+	certificates, err := database.LoadCertificates(container.TLSConfig)
+	require.NoError(t, err)
+	superuser, err := database.UserDatabaseConnection(container.Host, container.Port, "demo_user", "demo_password", "demo_db", database.DefaultPoolConfig(), certificates)
+	require.NoError(t, err)
+	defer superuser.Close()
 
-	db, err := database.UserDatabaseConnection("localhost", exposedPort, "demo_user", "demo_password", "demo_db")
+	err = Migrate("demo_user", "demo_password", container.Host, container.Port, "demo_db", "../../../db/migrations", container.TLSConfig)
+	require.NoError(t, err)
+	_, err = superuser.Exec(context.Background(), appRoleLoginQuery)
 	require.NoError(t, err)
 
-	err = Migrate("demo_user", "demo_password", "localhost", exposedPort, "demo_db", "../../../db/migrations")
+	db, err := database.UserDatabaseConnection(container.Host, container.Port, "demo_app", "demo_app_password", "demo_db", database.DefaultPoolConfig(), certificates)
 	require.NoError(t, err)
 
 	return db
 }
 
-// Connect creates a connection to the database using the provided connection string.
-func Connect(t testing.TB, connectString string) *sql.DB {
-	connectConfig, err := pgx.ParseConnectionString(connectString)
+// Connect creates a connection pool to the database using the provided connection string.
+func Connect(t testing.TB, connectString string) *pgxpool.Pool {
+	pool, err := pgxpool.New(context.Background(), connectString)
 	require.NoError(t, err)
 
-	return stdlib.OpenDB(connectConfig)
+	return pool
 }
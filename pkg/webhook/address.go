@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// dialTimeout is how long connecting to a webhook may take.
+const dialTimeout = 10 * time.Second
+
+// ErrForbiddenAddress is returned when a webhook is or resolves to an address that webhooks are not delivered to.
+var ErrForbiddenAddress = errors.New("webhook address is not public")
+
+// nonPublicNetworks are the networks that are not reachable from the internet but are not covered by the methods of net.IP.
+var nonPublicNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	// Shared address space of carrier-grade NAT, some clouds serve metadata from it
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("192.0.0.0/24"),
+	mustParseCIDR("198.18.0.0/15"),
+	mustParseCIDR("240.0.0.0/4"),
+	// NAT64 addresses embed IPv4 addresses, which may be private
+	mustParseCIDR("64:ff9b::/96"),
+}
+
+// IsPublicAddress returns true if webhooks can be delivered to the address.
+// Loopback, private, link-local, multicast and unspecified addresses are not public, so that webhooks cannot reach the services
+// on the network of the server, such as the cloud metadata endpoint at 169.254.169.254.
+func IsPublicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewClient creates a client for delivering webhooks that only connects to public addresses.
+// The address is checked when the connection is made, after the host name was resolved, so that neither a host name that resolves
+// to another address than when the webhook was validated nor a redirect can reach a service on the network of the server.
+// Proxies from the environment are not used, since the client would check the address of the proxy instead of the webhook.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: dialTimeout,
+		Control: checkPublicAddress,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
+
+// checkPublicAddress is the control of the dialer of NewClient, it is called with the resolved address of every connection before it is made.
+func checkPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !IsPublicAddress(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return nil
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
@@ -0,0 +1,89 @@
+package webhook_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobiassundman/go-demo-app/pkg/webhook"
+)
+
+func TestIsPublicAddress(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"0.0.0.0":          false,
+		"100.100.100.200":  false,
+		"224.0.0.1":        false,
+		"::ffff:127.0.0.1": false,
+		"64:ff9b::a00:1":   false,
+	}
+	for address, public := range tests {
+		address, public := address, public
+		t.Run(address, func(t *testing.T) {
+			t.Parallel()
+
+			// Act
+			isPublic := webhook.IsPublicAddress(net.ParseIP(address))
+
+			// Assert
+			assert.Equal(t, public, isPublic)
+		})
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	t.Parallel()
+	t.Run("refuses to connect to loopback address without retrying", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+		sender := webhook.NewSender(webhook.NewClient(time.Second), 10*time.Second)
+
+		// Act
+		result := sender.Send(context.Background(), newDelivery(server.URL))
+
+		// Assert
+		assert.ErrorIs(t, result.Err, webhook.ErrForbiddenAddress)
+		assert.Equal(t, 1, result.Attempts)
+		assert.Equal(t, 0, requests)
+	})
+
+	t.Run("refuses host name resolving to loopback address", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+		_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+		sender := webhook.NewSender(webhook.NewClient(time.Second), 10*time.Second)
+
+		// Act
+		result := sender.Send(context.Background(), newDelivery("http://localhost:"+port))
+
+		// Assert
+		assert.ErrorIs(t, result.Err, webhook.ErrForbiddenAddress)
+	})
+}
@@ -0,0 +1,97 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tobiassundman/go-demo-app/pkg/retry"
+)
+
+// Delivery is an event to deliver to a webhook.
+type Delivery struct {
+	URL       string
+	Secret    string
+	EventID   int64
+	EventType string
+	// Payload is the JSON body that is posted and signed.
+	Payload []byte
+}
+
+// Result is the outcome of a delivery.
+type Result struct {
+	// StatusCode is the status of the last response, 0 if there was none.
+	StatusCode int
+	// Attempts is the number of times the payload was posted.
+	Attempts int
+	// Err is why the delivery failed, nil if it was delivered.
+	Err error
+}
+
+// Sender posts signed payloads to webhooks, retrying failed posts with exponential backoff.
+type Sender struct {
+	client       *http.Client
+	retryTimeout time.Duration
+}
+
+// NewSender creates a new Sender posting with the client, a failed delivery is retried until the retry timeout.
+func NewSender(client *http.Client, retryTimeout time.Duration) *Sender {
+	return &Sender{
+		client:       client,
+		retryTimeout: retryTimeout,
+	}
+}
+
+// Send posts the payload of the delivery until it gets a 2xx response, the retry timeout passes or the context is done.
+// Client errors other than 408 and 429 and addresses the client refuses to connect to are not retried.
+// Every attempt is signed with the time it is made.
+func (s *Sender) Send(ctx context.Context, delivery *Delivery) *Result {
+	result := &Result{}
+	result.Err = retry.RetryContext(ctx, s.retryTimeout, func() error {
+		result.Attempts++
+		statusCode, err := s.post(ctx, delivery)
+		result.StatusCode = statusCode
+		return err
+	})
+	return result
+}
+
+// post posts the payload once, returning the status of the response
+func (s *Sender) post(ctx context.Context, delivery *Delivery) (int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, retry.Permanent(err)
+	}
+	now := time.Now()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, delivery.EventType)
+	request.Header.Set(EventIDHeader, strconv.FormatInt(delivery.EventID, 10))
+	request.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	request.Header.Set(SignatureHeader, Sign(delivery.Secret, now, delivery.Payload))
+
+	response, err := s.client.Do(request)
+	if errors.Is(err, ErrForbiddenAddress) {
+		return 0, retry.Permanent(err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	// Drain the body so that the connection can be reused
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response.StatusCode, nil
+	}
+	err = fmt.Errorf("unexpected status %d", response.StatusCode)
+	if response.StatusCode >= 400 && response.StatusCode < 500 &&
+		response.StatusCode != http.StatusRequestTimeout && response.StatusCode != http.StatusTooManyRequests {
+		return response.StatusCode, retry.Permanent(err)
+	}
+	return response.StatusCode, err
+}
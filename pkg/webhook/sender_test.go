@@ -0,0 +1,119 @@
+package webhook_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobiassundman/go-demo-app/pkg/webhook"
+)
+
+func newDelivery(url string) *webhook.Delivery {
+	return &webhook.Delivery{
+		URL:       url,
+		Secret:    "secret",
+		EventID:   42,
+		EventType: "user.created",
+		Payload:   []byte(`{"id":42}`),
+	}
+}
+
+func TestSend(t *testing.T) {
+	t.Parallel()
+	t.Run("posts signed payload", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		var verifyErr error
+		var header http.Header
+		var body []byte
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			body, _ = io.ReadAll(r.Body)
+			verifyErr = webhook.Verify("secret", r.Header.Get(webhook.TimestampHeader), r.Header.Get(webhook.SignatureHeader), body, time.Minute)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+		sender := webhook.NewSender(server.Client(), time.Second)
+
+		// Act
+		result := sender.Send(context.Background(), newDelivery(server.URL))
+
+		// Assert
+		require.NoError(t, result.Err)
+		assert.Equal(t, http.StatusNoContent, result.StatusCode)
+		assert.Equal(t, 1, result.Attempts)
+		assert.NoError(t, verifyErr)
+		assert.Equal(t, `{"id":42}`, string(body))
+		assert.Equal(t, "user.created", header.Get(webhook.EventHeader))
+		assert.Equal(t, "42", header.Get(webhook.EventIDHeader))
+		assert.Equal(t, "application/json", header.Get("Content-Type"))
+	})
+
+	t.Run("retries server errors with backoff", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if attempts.Add(1) < 3 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+		sender := webhook.NewSender(server.Client(), 10*time.Second)
+
+		// Act
+		result := sender.Send(context.Background(), newDelivery(server.URL))
+
+		// Assert
+		require.NoError(t, result.Err)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, 3, result.Attempts)
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusGone)
+		}))
+		defer server.Close()
+		sender := webhook.NewSender(server.Client(), 10*time.Second)
+
+		// Act
+		result := sender.Send(context.Background(), newDelivery(server.URL))
+
+		// Assert
+		assert.EqualError(t, result.Err, "unexpected status 410")
+		assert.Equal(t, http.StatusGone, result.StatusCode)
+		assert.Equal(t, 1, result.Attempts)
+	})
+
+	t.Run("gives up on unreachable webhook after retry timeout", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		url := server.URL
+		server.Close()
+		sender := webhook.NewSender(http.DefaultClient, 200*time.Millisecond)
+
+		// Act
+		result := sender.Send(context.Background(), newDelivery(url))
+
+		// Assert
+		assert.Error(t, result.Err)
+		assert.Equal(t, 0, result.StatusCode)
+		assert.GreaterOrEqual(t, result.Attempts, 1)
+	})
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader carries the signature of the payload, see Sign.
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader carries the unix time in seconds at which the payload was signed.
+	TimestampHeader = "X-Webhook-Timestamp"
+	// EventHeader carries the type of the delivered event.
+	EventHeader = "X-Webhook-Event"
+	// EventIDHeader carries the id of the delivered event, which is the same when an event is delivered again.
+	EventIDHeader = "X-Webhook-Event-Id"
+
+	signaturePrefix = "sha256="
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrExpiredTimestamp = errors.New("webhook timestamp outside tolerance")
+)
+
+// Sign returns the signature of a payload signed at the given time: the hex encoded HMAC-SHA256 of the unix timestamp in seconds,
+// a dot and the payload, keyed with the secret and prefixed with "sha256=".
+func Sign(secret string, timestamp time.Time, payload []byte) string {
+	return signaturePrefix + hex.EncodeToString(mac(secret, strconv.FormatInt(timestamp.Unix(), 10), payload))
+}
+
+// Verify checks the signature and timestamp header values of a received payload, rejecting payloads signed more than the tolerance ago
+// so that captured deliveries cannot be replayed.
+func Verify(secret, timestamp, signature string, payload []byte, tolerance time.Duration) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	encoded, found := strings.CutPrefix(signature, signaturePrefix)
+	if !found {
+		return ErrInvalidSignature
+	}
+	decoded, err := hex.DecodeString(encoded)
+	if err != nil || !hmac.Equal(decoded, mac(secret, timestamp, payload)) {
+		return ErrInvalidSignature
+	}
+	age := time.Since(time.Unix(seconds, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrExpiredTimestamp
+	}
+	return nil
+}
+
+func mac(secret, timestamp string, payload []byte) []byte {
+	hash := hmac.New(sha256.New, []byte(secret))
+	hash.Write([]byte(timestamp))
+	hash.Write([]byte("."))
+	hash.Write(payload)
+	return hash.Sum(nil)
+}
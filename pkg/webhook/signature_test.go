@@ -0,0 +1,64 @@
+package webhook_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobiassundman/go-demo-app/pkg/webhook"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+	payload := []byte(`{"id":1}`)
+
+	t.Run("accepts signature of payload", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		now := time.Now()
+		signature := webhook.Sign("secret", now, payload)
+
+		// Act
+		err := webhook.Verify("secret", strconv.FormatInt(now.Unix(), 10), signature, payload, time.Minute)
+
+		// Assert
+		assert.NoError(t, err)
+	})
+
+	t.Run("rejects other secret, payload and timestamp", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		now := time.Now()
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		signature := webhook.Sign("secret", now, payload)
+
+		// Act
+		otherSecretErr := webhook.Verify("other", timestamp, signature, payload, time.Minute)
+		otherPayloadErr := webhook.Verify("secret", timestamp, signature, []byte(`{"id":2}`), time.Minute)
+		otherTimestampErr := webhook.Verify("secret", strconv.FormatInt(now.Unix()+1, 10), signature, payload, time.Minute)
+		malformedErr := webhook.Verify("secret", timestamp, "md5=abc", payload, time.Minute)
+
+		// Assert
+		assert.ErrorIs(t, otherSecretErr, webhook.ErrInvalidSignature)
+		assert.ErrorIs(t, otherPayloadErr, webhook.ErrInvalidSignature)
+		assert.ErrorIs(t, otherTimestampErr, webhook.ErrInvalidSignature)
+		assert.ErrorIs(t, malformedErr, webhook.ErrInvalidSignature)
+	})
+
+	t.Run("rejects signature older than tolerance", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		signedAt := time.Now().Add(-time.Hour)
+		signature := webhook.Sign("secret", signedAt, payload)
+
+		// Act
+		err := webhook.Verify("secret", strconv.FormatInt(signedAt.Unix(), 10), signature, payload, time.Minute)
+
+		// Assert
+		assert.ErrorIs(t, err, webhook.ErrExpiredTimestamp)
+	})
+}